@@ -2,8 +2,15 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Iam54r1n4/Gordafarid/internal/activation"
 	"github.com/Iam54r1n4/Gordafarid/internal/client"
 	"github.com/Iam54r1n4/Gordafarid/internal/config"
 	"github.com/Iam54r1n4/Gordafarid/internal/flags" // Check its init function
@@ -19,15 +26,57 @@ func main() {
 	// Get the client configuration using the path specified in the flags.
 	cfg := config.GetClientCofig(flags.CfgPathFlag)
 
+	// Set the logger up as configured, the logs are written to stdout until then
+	logConfig := cfg.Log.LoggerConfig("gordafarid-client")
+	// The failures of the log output itself (e.g. a failed rotation of the log file) can't be logged to it
+	logConfig.ErrorHandler = func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := logger.Setup(logConfig); err != nil {
+		logger.Fatal(err)
+	}
+	defer logger.Close()
+
 	// Create a new client instance with the obtained configuration.
 	client := client.NewClient(cfg)
 
-	// Start listening for incoming socks5 connections.
+	// Start listening for incoming socks5 connections, on the socket passed by systemd socket activation if any.
 	// If an error occurs during listening, log a fatal error and exit.
-	if err := client.Listen(); err != nil {
+	ln, err := activation.Listener()
+	if err != nil {
+		logger.Fatal(errors.Join(shared_error.ErrClientListenFailed, err))
+	}
+	if ln != nil {
+		logger.Info("Using the listening socket passed by systemd socket activation")
+		err = client.ListenOn(ln)
+	} else {
+		err = client.Listen()
+	}
+	if err != nil {
 		logger.Fatal(errors.Join(shared_error.ErrClientListenFailed, err))
 	}
 
-	// Begin the client's main operation.
-	client.Start()
+	// Shut down gracefully on SIGINT/SIGTERM: stop accepting, and drain the active connections up to the shutdown timeout.
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-signalCtx.Done()
+		// A second signal terminates the process right away.
+		stop()
+		logger.Info("Shutting down the client, draining the active connections...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeout.ShutdownTimeout)*time.Second)
+		defer cancel()
+		if err := client.Shutdown(shutdownCtx); err != nil {
+			logger.Warn(err)
+		}
+	}()
+
+	// Begin the client's main operation, it returns once the client is shut down.
+	if err := client.Start(); err != nil && !errors.Is(err, shared_error.ErrClientClosed) {
+		logger.Fatal(err)
+	}
+	<-shutdownDone
+	logger.Info("The client is shut down")
 }
@@ -0,0 +1,95 @@
+// Package main is the entry point for the Gordafarid key generator.
+// It derives the key material of an account from a passphrase with scrypt (or generates a random key),
+// and prints it as the pre-derived key accepted by the server's credentials,
+// so the server's config file doesn't hold human passwords, not even in a reversible form.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
+)
+
+var (
+	errEmptyUsername    = errors.New("the -username flag is required")
+	errSaltWithoutPass  = errors.New("the -salt flag is only used with -password")
+	errInvalidSaltFlag  = errors.New("the -salt flag is not valid base64")
+	errRandomGeneration = errors.New("failed to generate the random bytes")
+)
+
+// main is the entry point of the key generator.
+// It prints the server-side credential entry and the matching client-side account section.
+func main() {
+	username := flag.String("username", "", "the account username")
+	password := flag.String("password", "", "the account passphrase the key is derived from (a random key is generated if empty)")
+	encodedSalt := flag.String("salt", "", "the base64-encoded salt of the passphrase, to re-derive an existing key (a random salt is generated if empty)")
+	algorithm := flag.String("algorithm", "chacha20-poly1305", "the crypto algorithm the key is used with")
+	flag.Parse()
+
+	if len(*username) < 1 {
+		logger.Fatal(errEmptyUsername)
+	}
+	if len(*encodedSalt) > 0 && len(*password) < 1 {
+		logger.Fatal(errSaltWithoutPass)
+	}
+
+	keySize, err := aead.GetAlgorithmKeySize(*algorithm)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// Derive the key from the given passphrase, or generate a random key
+	var key, salt []byte
+	if len(*password) > 0 {
+		if salt, err = passphraseSalt(*encodedSalt); err != nil {
+			logger.Fatal(err)
+		}
+		if key, err = gordafarid.DeriveKey(*password, salt, keySize); err != nil {
+			logger.Fatal(err)
+		}
+	} else {
+		key = make([]byte, keySize)
+		if _, err = rand.Read(key); err != nil {
+			logger.Fatal(errors.Join(errRandomGeneration, err))
+		}
+	}
+
+	keyID := gordafarid.DeriveKeyID(*username, string(key))
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+	encodedKeyID := base64.StdEncoding.EncodeToString(keyID[:])
+
+	fmt.Println("# Server-side entry (credentials)")
+	fmt.Printf("{ username = %q, keyId = %q, key = %q },\n", *username, encodedKeyID, encodedKey)
+	fmt.Println()
+	fmt.Println("# Client-side entry")
+	fmt.Println("[account]")
+	fmt.Printf("username = %q\n", *username)
+	fmt.Printf("key = %q\n", encodedKey)
+	if salt != nil {
+		fmt.Println("# Or keep the passphrase instead of the key, it's derived the same way on startup:")
+		fmt.Println("# passphrase = \"<the -password value>\"")
+		fmt.Printf("# salt = %q\n", base64.StdEncoding.EncodeToString(salt))
+	}
+}
+
+// passphraseSalt returns the decoded salt, or a random one of gordafarid.KeySaltSize bytes if it's empty.
+func passphraseSalt(encodedSalt string) ([]byte, error) {
+	if len(encodedSalt) > 0 {
+		salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+		if err != nil || len(salt) < 1 {
+			return nil, errors.Join(errInvalidSaltFlag, err)
+		}
+		return salt, nil
+	}
+	salt := make([]byte, gordafarid.KeySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Join(errRandomGeneration, err)
+	}
+	return salt, nil
+}
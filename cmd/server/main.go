@@ -2,13 +2,21 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Iam54r1n4/Gordafarid/internal/activation"
 	"github.com/Iam54r1n4/Gordafarid/internal/config"
 	"github.com/Iam54r1n4/Gordafarid/internal/flags" // Check its init function
 	"github.com/Iam54r1n4/Gordafarid/internal/logger"
 	"github.com/Iam54r1n4/Gordafarid/internal/server"
 	"github.com/Iam54r1n4/Gordafarid/internal/shared_error"
+	"github.com/Iam54r1n4/Gordafarid/internal/upgrade"
 )
 
 // main is the entry point of the application.
@@ -17,11 +25,78 @@ func main() {
 
 	cfg := config.GetServerConfig(flags.CfgPathFlag)
 
+	// Set the logger up as configured, the logs are written to stdout until then
+	logConfig := cfg.Log.LoggerConfig("gordafarid-server")
+	// The failures of the log output itself (e.g. a failed rotation of the log file) can't be logged to it
+	logConfig.ErrorHandler = func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := logger.Setup(logConfig); err != nil {
+		logger.Fatal(err)
+	}
+	defer logger.Close()
+
 	server := server.NewServer(cfg)
 
-	if err := server.Listen(); err != nil {
-		logger.Fatal(errors.Join(shared_error.ErrClientListenFailed, err))
+	// Use the listening socket passed by systemd socket activation if any, otherwise bind the configured address
+	ln, err := activation.Listener()
+	if err != nil {
+		logger.Fatal(errors.Join(shared_error.ErrServerListenFailed, err))
+	}
+	if ln != nil {
+		logger.Info("Using the listening socket passed by systemd socket activation")
+		err = server.ListenOn(ln)
+	} else {
+		err = server.Listen()
+	}
+	if err != nil {
+		logger.Fatal(errors.Join(shared_error.ErrServerListenFailed, err))
+	}
+
+	// Tell the old process we're ready, if we're spawned by an upgrade
+	if err := upgrade.Ready(); err != nil {
+		logger.Fatal(err)
 	}
 
-	server.Start()
+	// Upgrade on SIGUSR2: hand the listening socket off to a new server process, then shut down
+	upgraded := make(chan struct{})
+	upgradeSignals := make(chan os.Signal, 1)
+	upgrade.Notify(upgradeSignals)
+	go func() {
+		for range upgradeSignals {
+			logger.Info("Upgrading, spawning a new server process...")
+			if err := server.Upgrade(); err != nil {
+				logger.Error(err)
+				continue
+			}
+			close(upgraded)
+			return
+		}
+	}()
+
+	// Shut down gracefully on SIGINT/SIGTERM (or once upgraded): stop accepting, and drain the active connections up to the shutdown timeout
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		select {
+		case <-signalCtx.Done():
+		case <-upgraded:
+		}
+		// A second signal terminates the process right away
+		stop()
+		logger.Info("Shutting down the server, draining the active connections...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeout.ShutdownTimeout)*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Warn(err)
+		}
+	}()
+
+	if err := server.Start(); err != nil && !errors.Is(err, shared_error.ErrServerClosed) {
+		logger.Fatal(err)
+	}
+	<-shutdownDone
+	logger.Info("The server is shut down")
 }
@@ -0,0 +1,210 @@
+// Package accesslog provides the access log of the Gordafarid server and client.
+//
+// A record is written for every proxied connection once it ends, as a JSON object or a logfmt line,
+// to a file of its own, apart from the debug logger, e.g. for abuse handling and billing.
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the access log.
+const (
+	FormatJSON   = "json"   // One JSON object per line
+	FormatLogfmt = "logfmt" // One logfmt line (key=value pairs) per record
+)
+
+// Close reasons of the connections, the values of Record.Reason.
+const (
+	ReasonCompleted     = "completed"      // The relay ended normally, both sides closed the connection
+	ReasonError         = "error"          // The relay ended with an error
+	ReasonKilled        = "killed"         // The connection was killed through the admin API
+	ReasonExpired       = "expired"        // The account expired or left its allowed hours during the connection
+	ReasonShutdown      = "shutdown"       // The connection was closed at the shutdown deadline
+	ReasonNotAllowed    = "not_allowed"    // The destination is denied by the account's policy
+	ReasonQuotaExceeded = "quota_exceeded" // The account exceeded its traffic quota
+	ReasonLimitExceeded = "limit_exceeded" // A concurrent connection limit is reached
+	ReasonDialFailed    = "dial_failed"    // The destination (or the server, on the client side) couldn't be dialed
+	ReasonFailed        = "failed"         // The request failed before the relay, e.g. an invalid account attribute
+)
+
+// Record is the access log record of a proxied connection.
+type Record struct {
+	Time          time.Time     // When the connection ended
+	Session       string        // ID of the session, 16 hex digits
+	ClientSession string        // ID of the session on the client side, if the client sent it (OPTIONAL)
+	Client        string        // Address of the client
+	InitKey       string        // ID of the init key of the account on the server side (OPTIONAL)
+	User          string        // The authenticated Gordafarid account
+	SocksUser     string        // The authenticated SOCKS5 username on the client side (OPTIONAL)
+	Destination   string        // The requested destination, host:port
+	ResolvedIP    string        // The dialed IP address of the destination (OPTIONAL)
+	Upload        int64         // Bytes sent by the client
+	Download      int64         // Bytes sent to the client
+	Duration      time.Duration // How long the connection lasted
+	Reason        string        // Why the connection ended, one of the Reason* values
+}
+
+// jsonRecord is the JSON representation of a Record.
+type jsonRecord struct {
+	Time          string  `json:"time"`
+	Session       string  `json:"session"`
+	ClientSession string  `json:"clientSession,omitempty"`
+	Client        string  `json:"client"`
+	InitKey       string  `json:"initKey,omitempty"`
+	User          string  `json:"user"`
+	SocksUser     string  `json:"socksUser,omitempty"`
+	Destination   string  `json:"destination"`
+	ResolvedIP    string  `json:"resolvedIP,omitempty"`
+	Upload        int64   `json:"upload"`
+	Download      int64   `json:"download"`
+	Duration      float64 `json:"duration"` // In seconds
+	Reason        string  `json:"reason"`
+}
+
+// Logger writes the access log records to a file. It's safe for concurrent use.
+type Logger struct {
+	path   string
+	format string
+	mu     sync.Mutex
+	file   *os.File
+}
+
+// Open opens (or creates) the access log file for appending.
+//
+// Parameters:
+//   - path: The access log file.
+//   - format: FormatJSON or FormatLogfmt, empty means FormatJSON.
+//
+// Returns:
+//   - *Logger: The opened access log.
+//   - error: If the format is unknown, or the file can't be opened.
+func Open(path, format string) (*Logger, error) {
+	if len(format) < 1 {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatLogfmt {
+		return nil, errUnknownFormat
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, errors.Join(errFailedToOpen, err)
+	}
+	return &Logger{
+		path:   path,
+		format: format,
+		file:   file,
+	}, nil
+}
+
+// Log writes the record as a single line.
+// The line is written by a single write to a file opened for appending, so the records of several processes
+// sharing the file (e.g. during an upgrade) don't interleave.
+func (l *Logger) Log(r Record) error {
+	var line []byte
+	if l.format == FormatLogfmt {
+		line = r.logfmt()
+	} else {
+		var err error
+		if line, err = json.Marshal(r.json()); err != nil {
+			return errors.Join(errFailedToWrite, err)
+		}
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return errClosed
+	}
+	if _, err := l.file.Write(line); err != nil {
+		return errors.Join(errFailedToWrite, err)
+	}
+	return nil
+}
+
+// Close closes the access log file, the later records aren't written.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// json returns the JSON representation of the record.
+func (r Record) json() jsonRecord {
+	return jsonRecord{
+		Time:          r.Time.UTC().Format(time.RFC3339Nano),
+		Session:       r.Session,
+		ClientSession: r.ClientSession,
+		Client:        r.Client,
+		InitKey:       r.InitKey,
+		User:          r.User,
+		SocksUser:     r.SocksUser,
+		Destination:   r.Destination,
+		ResolvedIP:    r.ResolvedIP,
+		Upload:        r.Upload,
+		Download:      r.Download,
+		Duration:      r.Duration.Seconds(),
+		Reason:        r.Reason,
+	}
+}
+
+// logfmt returns the logfmt line of the record, without the trailing line feed.
+// The optional fields are omitted if they're empty.
+func (r Record) logfmt() []byte {
+	var b strings.Builder
+	writePair := func(key, value string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(value))
+	}
+	writePair("time", r.Time.UTC().Format(time.RFC3339Nano))
+	writePair("session", r.Session)
+	if len(r.ClientSession) > 0 {
+		writePair("clientSession", r.ClientSession)
+	}
+	writePair("client", r.Client)
+	if len(r.InitKey) > 0 {
+		writePair("initKey", r.InitKey)
+	}
+	writePair("user", r.User)
+	if len(r.SocksUser) > 0 {
+		writePair("socksUser", r.SocksUser)
+	}
+	writePair("destination", r.Destination)
+	if len(r.ResolvedIP) > 0 {
+		writePair("resolvedIP", r.ResolvedIP)
+	}
+	writePair("upload", strconv.FormatInt(r.Upload, 10))
+	writePair("download", strconv.FormatInt(r.Download, 10))
+	writePair("duration", strconv.FormatFloat(r.Duration.Seconds(), 'f', -1, 64))
+	writePair("reason", r.Reason)
+	return []byte(b.String())
+}
+
+// logfmtValue quotes the value if it's empty or contains spaces, quotes, equal signs or control characters.
+func logfmtValue(value string) string {
+	if len(value) < 1 {
+		return `""`
+	}
+	for _, c := range value {
+		if c <= ' ' || c == '"' || c == '=' || c == '\\' || c == 0x7f {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
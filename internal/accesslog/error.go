@@ -0,0 +1,10 @@
+package accesslog
+
+import "errors"
+
+var (
+	errUnknownFormat = errors.New("unknown access log format, the supported formats are json and logfmt")
+	errFailedToOpen  = errors.New("failed to open the access log file")
+	errFailedToWrite = errors.New("failed to write the access log record")
+	errClosed        = errors.New("the access log is closed")
+)
@@ -0,0 +1,286 @@
+// Package acl provides the per-account destination access control lists of the Gordafarid server.
+//
+// A Policy is an ordered list of allow/deny rules, evaluated against the destination of a request
+// after the Gordafarid handshake. The special-purpose IP ranges (see IsSpecialPurpose) are denied first,
+// unless the policy explicitly allows them. Then the first matching rule decides, and the policy's default action
+// applies if no rule matches. A rule matches if the destination satisfies all of its non-empty criteria:
+//   - CIDRs: the destination IP is in one of the ranges
+//   - Domains: the requested domain equals one of the domains, ends with one of the suffixes, or matches one of the regexes
+//   - Ports: the destination port is in one of the port ranges
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Actions of the rules
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// PolicyAttribute is the credential attribute naming the account's policy.
+const PolicyAttribute = "policy"
+
+// Destination is the target of a request, as it's evaluated against the rules.
+type Destination struct {
+	Domain string     // The requested domain, empty if the request was for an IP address
+	IP     netip.Addr // The (resolved) destination IP address
+	Port   uint16     // The destination port
+}
+
+// portRange is an inclusive range of ports.
+type portRange struct {
+	from, to uint16
+}
+
+// Rule is a single allow/deny rule of a Policy.
+type Rule struct {
+	allow          bool
+	cidrs          []netip.Prefix
+	domains        []string
+	domainSuffixes []string
+	domainRegexes  []*regexp.Regexp
+	ports          []portRange
+}
+
+// NewRule creates a new Rule from its textual representation.
+//
+// Parameters:
+//   - action: "allow" or "deny".
+//   - cidrs: IP ranges like "10.0.0.0/8"; a plain IP address matches only itself.
+//   - domains: exact domain names, matched case-insensitively.
+//   - domainSuffixes: domain suffixes like ".example.com", which also match "example.com" itself.
+//   - domainRegexes: regular expressions matched against the whole (lower-cased) domain, they're implicitly anchored,
+//     e.g. `example\.com` doesn't match "notexample.com", use `(.+\.)?example\.com` to match the subdomains.
+//   - ports: single ports like "25", or ranges like "8000-9000".
+//
+// Returns:
+//   - *Rule: The created rule.
+//   - error: If any of the criteria is invalid, or the rule has no criteria at all.
+func NewRule(action string, cidrs, domains, domainSuffixes, domainRegexes, ports []string) (*Rule, error) {
+	r := &Rule{}
+	switch action {
+	case ActionAllow:
+		r.allow = true
+	case ActionDeny:
+		r.allow = false
+	default:
+		return nil, errInvalidAction
+	}
+
+	for _, cidr := range cidrs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, errors.Join(errInvalidCIDR, err)
+		}
+		r.cidrs = append(r.cidrs, prefix)
+	}
+	for _, domain := range domains {
+		r.domains = append(r.domains, normalizeDomain(domain))
+	}
+	for _, suffix := range domainSuffixes {
+		r.domainSuffixes = append(r.domainSuffixes, strings.TrimPrefix(normalizeDomain(suffix), "."))
+	}
+	for _, expr := range domainRegexes {
+		// Anchor the expression, so it has to match the whole domain rather than a part of it
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, errors.Join(errInvalidDomainRegex, err)
+		}
+		r.domainRegexes = append(r.domainRegexes, re)
+	}
+	for _, port := range ports {
+		pr, err := parsePortRange(port)
+		if err != nil {
+			return nil, errors.Join(errInvalidPortRange, fmt.Errorf("port: %q", port), err)
+		}
+		r.ports = append(r.ports, pr)
+	}
+
+	if len(r.cidrs) < 1 && !r.hasDomainCriteria() && len(r.ports) < 1 {
+		return nil, errEmptyRule
+	}
+	return r, nil
+}
+
+// parsePrefix parses a CIDR, or a plain IP address as a single-address prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// parsePortRange parses a single port, or an inclusive "from-to" port range.
+func parsePortRange(s string) (portRange, error) {
+	from, to, isRange := strings.Cut(s, "-")
+	fromPort, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+	if err != nil {
+		return portRange{}, err
+	}
+	toPort := fromPort
+	if isRange {
+		if toPort, err = strconv.ParseUint(strings.TrimSpace(to), 10, 16); err != nil {
+			return portRange{}, err
+		}
+	}
+	if toPort < fromPort {
+		return portRange{}, fmt.Errorf("the range end is less than its start")
+	}
+	return portRange{from: uint16(fromPort), to: uint16(toPort)}, nil
+}
+
+// normalizeDomain lower-cases the domain and removes its trailing dot.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// hasDomainCriteria reports whether the rule has any domain criteria.
+func (r *Rule) hasDomainCriteria() bool {
+	return len(r.domains) > 0 || len(r.domainSuffixes) > 0 || len(r.domainRegexes) > 0
+}
+
+// Matches reports whether the destination satisfies all the criteria of the rule.
+func (r *Rule) Matches(dst Destination) bool {
+	if len(r.cidrs) > 0 && !r.matchCIDRs(dst.IP) {
+		return false
+	}
+	if r.hasDomainCriteria() && !r.matchDomain(dst.Domain) {
+		return false
+	}
+	if len(r.ports) > 0 && !r.matchPorts(dst.Port) {
+		return false
+	}
+	return true
+}
+
+// matchCIDRs reports whether the IP is in one of the rule's ranges.
+func (r *Rule) matchCIDRs(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range r.cidrs {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain reports whether the domain matches one of the rule's domains, suffixes or regexes.
+func (r *Rule) matchDomain(domain string) bool {
+	if len(domain) < 1 {
+		return false
+	}
+	domain = normalizeDomain(domain)
+	for _, d := range r.domains {
+		if domain == d {
+			return true
+		}
+	}
+	for _, suffix := range r.domainSuffixes {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	for _, re := range r.domainRegexes {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPorts reports whether the port is in one of the rule's port ranges.
+func (r *Rule) matchPorts(port uint16) bool {
+	for _, pr := range r.ports {
+		if port >= pr.from && port <= pr.to {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is an ordered list of rules, along with the action applied when no rule matches.
+type Policy struct {
+	Name               string
+	rules              []*Rule
+	defaultAllow       bool
+	allowSpecialRanges []netip.Prefix // The special-purpose ranges the policy may reach
+}
+
+// NewPolicy creates a new Policy with the given default action ("allow" or "deny") and rules.
+// The allowSpecialRanges are CIDRs of the special-purpose ranges the policy may reach,
+// e.g. "10.1.0.0/16" for an internal network, or "0.0.0.0/0" and "::/0" to disable the protection.
+// The destinations in these ranges are still evaluated against the rules.
+func NewPolicy(name, defaultAction string, allowSpecialRanges []string, rules ...*Rule) (*Policy, error) {
+	p := &Policy{
+		Name:  name,
+		rules: rules,
+	}
+	for _, cidr := range allowSpecialRanges {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, errors.Join(errInvalidCIDR, err)
+		}
+		p.allowSpecialRanges = append(p.allowSpecialRanges, prefix)
+	}
+	switch defaultAction {
+	case ActionAllow:
+		p.defaultAllow = true
+	case ActionDeny:
+		p.defaultAllow = false
+	default:
+		return nil, errInvalidAction
+	}
+	return p, nil
+}
+
+// NewAllowAllPolicy creates a new Policy allowing all the destinations, except the special-purpose ranges.
+func NewAllowAllPolicy(name string) *Policy {
+	return &Policy{
+		Name:         name,
+		defaultAllow: true,
+	}
+}
+
+// Allowed reports whether the policy allows the destination.
+// The special-purpose ranges are denied unless the policy allows them,
+// then the first matching rule decides, otherwise the default action applies.
+func (p *Policy) Allowed(dst Destination) bool {
+	if IsSpecialPurpose(dst.IP) && !p.allowsSpecialRange(dst.IP) {
+		return false
+	}
+	for _, r := range p.rules {
+		if r.Matches(dst) {
+			return r.allow
+		}
+	}
+	return p.defaultAllow
+}
+
+// allowsSpecialRange reports whether the policy may reach the given special-purpose IP.
+func (p *Policy) allowsSpecialRange(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range p.allowSpecialRanges {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package acl
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// mustRule creates a rule, failing the test if it's invalid.
+func mustRule(t *testing.T, action string, cidrs, domains, domainSuffixes, domainRegexes, ports []string) *Rule {
+	t.Helper()
+	r, err := NewRule(action, cidrs, domains, domainSuffixes, domainRegexes, ports)
+	if err != nil {
+		t.Fatalf("NewRule: %v", err)
+	}
+	return r
+}
+
+// mustPolicy creates a policy, failing the test if it's invalid.
+func mustPolicy(t *testing.T, defaultAction string, rules ...*Rule) *Policy {
+	t.Helper()
+	p, err := NewPolicy("test", defaultAction, nil, rules...)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return p
+}
+
+// publicIP is a destination IP outside the special-purpose ranges.
+var publicIP = netip.MustParseAddr("93.184.216.34")
+
+func TestDomainRegexDenyMatchesWholeDomain(t *testing.T) {
+	p := mustPolicy(t, ActionAllow, mustRule(t, ActionDeny, nil, nil, nil, []string{`example\.com`}, nil))
+
+	tests := []struct {
+		domain  string
+		allowed bool
+	}{
+		{"example.com", false},
+		{"EXAMPLE.com.", false},
+		{"example.com.evil.net", true},
+		{"notexample.com", true},
+		{"www.example.com", true},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(Destination{Domain: tt.domain, IP: publicIP, Port: 443}); got != tt.allowed {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.domain, got, tt.allowed)
+		}
+	}
+}
+
+func TestDomainRegexAllowMatchesWholeDomain(t *testing.T) {
+	p := mustPolicy(t, ActionDeny, mustRule(t, ActionAllow, nil, nil, nil, []string{`(.+\.)?example\.com`, `api\d+\.example\.org`}, nil))
+
+	tests := []struct {
+		domain  string
+		allowed bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"api1.example.org", true},
+		{"example.com.evil.net", false},
+		{"notexample.com", false},
+		{"evil-api1.example.org", false},
+		{"api1.example.org.evil.net", false},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(Destination{Domain: tt.domain, IP: publicIP, Port: 443}); got != tt.allowed {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.domain, got, tt.allowed)
+		}
+	}
+}
+
+func TestDomainRegexAlternationIsAnchored(t *testing.T) {
+	// Without the group around the expression, the anchors would only bind to the first and last alternatives
+	r := mustRule(t, ActionDeny, nil, nil, nil, []string{`a\.com|b\.com`}, nil)
+	for domain, want := range map[string]bool{"a.com": true, "b.com": true, "a.com.evil.net": false, "evil-b.com": false} {
+		if got := r.Matches(Destination{Domain: domain, IP: publicIP}); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", domain, got, want)
+		}
+	}
+}
+
+func TestPolicyFirstMatchingRuleDecides(t *testing.T) {
+	p := mustPolicy(t, ActionAllow,
+		mustRule(t, ActionDeny, nil, nil, nil, nil, []string{"25"}),
+		mustRule(t, ActionAllow, nil, nil, []string{".example.com"}, nil, nil),
+		mustRule(t, ActionDeny, []string{"93.184.0.0/16"}, nil, nil, nil, nil),
+	)
+
+	tests := []struct {
+		name    string
+		dst     Destination
+		allowed bool
+	}{
+		{"denied port", Destination{Domain: "mail.example.com", IP: publicIP, Port: 25}, false},
+		{"allowed suffix before the denied range", Destination{Domain: "www.example.com", IP: publicIP, Port: 443}, true},
+		{"denied range", Destination{IP: publicIP, Port: 443}, false},
+		{"default action", Destination{IP: netip.MustParseAddr("1.1.1.1"), Port: 443}, true},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(tt.dst); got != tt.allowed {
+			t.Errorf("%s: Allowed = %v, want %v", tt.name, got, tt.allowed)
+		}
+	}
+}
+
+func TestPolicyDeniesSpecialRanges(t *testing.T) {
+	p := NewAllowAllPolicy("test")
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "169.254.169.254", "::1", "::ffff:192.168.1.1"} {
+		if p.Allowed(Destination{IP: netip.MustParseAddr(ip), Port: 80}) {
+			t.Errorf("Allowed(%s) = true, want the special-purpose range denied", ip)
+		}
+	}
+
+	p, err := NewPolicy("internal", ActionAllow, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Allowed(Destination{IP: netip.MustParseAddr("10.1.2.3"), Port: 80}) {
+		t.Error("the explicitly allowed special-purpose range is denied")
+	}
+	if p.Allowed(Destination{IP: netip.MustParseAddr("10.2.0.1"), Port: 80}) {
+		t.Error("a special-purpose IP outside the allowed range is allowed")
+	}
+}
@@ -0,0 +1,11 @@
+package acl
+
+import "errors"
+
+var (
+	errInvalidAction      = errors.New("the ACL rule action must be \"allow\" or \"deny\"")
+	errInvalidCIDR        = errors.New("invalid ACL rule CIDR")
+	errInvalidDomainRegex = errors.New("invalid ACL rule domain regex")
+	errInvalidPortRange   = errors.New("invalid ACL rule port range")
+	errEmptyRule          = errors.New("the ACL rule has no cidrs, domains or ports")
+)
@@ -0,0 +1,62 @@
+package acl
+
+import "net/netip"
+
+// specialPurposeRanges are the special-purpose IP ranges (private, loopback, link-local, multicast, documentation, ...),
+// which are not reachable through the server unless a policy explicitly allows them.
+// It keeps an authenticated client from reaching the server's own services, the cloud metadata endpoint,
+// or the networks behind the server (SSRF).
+var specialPurposeRanges = mustParsePrefixes(
+	// IPv4
+	"0.0.0.0/8",       // "This" network
+	"10.0.0.0/8",      // Private-use
+	"100.64.0.0/10",   // Shared address space (CGNAT)
+	"127.0.0.0/8",     // Loopback
+	"169.254.0.0/16",  // Link-local, including the cloud metadata endpoint
+	"172.16.0.0/12",   // Private-use
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // Documentation (TEST-NET-1)
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"192.168.0.0/16",  // Private-use
+	"198.18.0.0/15",   // Benchmarking
+	"198.51.100.0/24", // Documentation (TEST-NET-2)
+	"203.0.113.0/24",  // Documentation (TEST-NET-3)
+	"224.0.0.0/4",     // Multicast
+	"240.0.0.0/4",     // Reserved, including the limited broadcast address
+	// IPv6
+	"::/128",         // Unspecified
+	"::1/128",        // Loopback
+	"::ffff:0:0/96",  // IPv4-mapped
+	"64:ff9b::/96",   // IPv4/IPv6 translation, could reach the IPv4 ranges above
+	"64:ff9b:1::/48", // Local-use IPv4/IPv6 translation
+	"100::/64",       // Discard-only
+	"2001::/23",      // IETF protocol assignments
+	"2001:db8::/32",  // Documentation
+	"2002::/16",      // 6to4, could reach the IPv4 ranges above
+	"fc00::/7",       // Unique local
+	"fe80::/10",      // Link-local
+	"ff00::/8",       // Multicast
+)
+
+// mustParsePrefixes parses the given CIDRs, and panics if any of them is invalid.
+func mustParsePrefixes(cidrs ...string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+	return prefixes
+}
+
+// IsSpecialPurpose reports whether the IP is in one of the special-purpose ranges.
+func IsSpecialPurpose(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return true
+	}
+	ip = ip.Unmap()
+	for _, prefix := range specialPurposeRanges {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
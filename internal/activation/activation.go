@@ -0,0 +1,16 @@
+// Package activation provides the systemd socket activation of the Gordafarid server and client.
+//
+// With socket activation, systemd binds the listening socket (e.g. a privileged port) and starts the process
+// on demand, passing the socket as file descriptor 3 along with the LISTEN_PID and LISTEN_FDS environment variables.
+// It's only supported on Unix-like systems.
+package activation
+
+const (
+	// Environment variables set by systemd, see sd_listen_fds(3)
+	listenPIDEnv     = "LISTEN_PID"
+	listenFDsEnv     = "LISTEN_FDS"
+	listenFDNamesEnv = "LISTEN_FDNAMES"
+
+	// listenFDsStart is the first passed file descriptor (SD_LISTEN_FDS_START)
+	listenFDsStart = 3
+)
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package activation
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Listener returns the listening socket passed by systemd socket activation, or nil if the process isn't socket-activated.
+// Exactly one socket must be passed. The environment variables are removed, so they aren't passed on to the child processes.
+func Listener() (net.Listener, error) {
+	fds, exists := os.LookupEnv(listenFDsEnv)
+	if !exists {
+		return nil, nil
+	}
+	pid := os.Getenv(listenPIDEnv)
+	os.Unsetenv(listenPIDEnv)
+	os.Unsetenv(listenFDsEnv)
+	os.Unsetenv(listenFDNamesEnv)
+
+	// The sockets are meant for another process, e.g. our parent
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, errors.Join(errInvalidListenFDs, fmt.Errorf("%s: %q", listenFDsEnv, fds))
+	}
+	if n > 1 {
+		return nil, errors.Join(errTooManyListenFDs, fmt.Errorf("%s: %d", listenFDsEnv, n))
+	}
+
+	syscall.CloseOnExec(listenFDsStart)
+	f := os.NewFile(listenFDsStart, "systemd-socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, errors.Join(errFailedToUseListenFD, err)
+	}
+	return ln, nil
+}
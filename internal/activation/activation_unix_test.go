@@ -0,0 +1,172 @@
+//go:build !windows
+
+package activation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperEnv selects the mode of the re-executed test binary, see TestHelperProcess.
+const helperEnv = "GORDAFARID_ACTIVATION_HELPER"
+
+// selfPID is the LISTEN_PID value the helper process replaces with its own pid, as systemd sets it after forking.
+const selfPID = "self"
+
+// TestHelperProcess isn't a real test, it's the process started by runHelper.
+// In the "listener" mode, it calls Listener, prints its result and the environment its child processes get,
+// and serves a greeting on the returned listener. In the "env" mode, it prints the socket activation environment.
+func TestHelperProcess(t *testing.T) {
+	switch os.Getenv(helperEnv) {
+	case "listener":
+	case "env":
+		fmt.Println(activationEnv())
+		os.Exit(0)
+	default:
+		return
+	}
+	if os.Getenv(listenPIDEnv) == selfPID {
+		os.Setenv(listenPIDEnv, strconv.Itoa(os.Getpid()))
+	}
+
+	ln, err := Listener()
+	switch {
+	case errors.Is(err, errInvalidListenFDs):
+		fmt.Println("invalid")
+	case errors.Is(err, errTooManyListenFDs):
+		fmt.Println("too many")
+	case err != nil:
+		fmt.Println("error:", err)
+	case ln == nil:
+		fmt.Println("none")
+	default:
+		fmt.Println("listener")
+	}
+
+	// The environment of a child process
+	child := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	child.Env = append(os.Environ(), helperEnv+"=env")
+	out, err := child.Output()
+	if err != nil {
+		fmt.Println("env error:", err)
+	} else {
+		fmt.Print(string(out))
+	}
+
+	if ln != nil {
+		conn, err := ln.Accept()
+		if err == nil {
+			io.WriteString(conn, "hello\n")
+			conn.Close()
+		}
+		ln.Close()
+	}
+	os.Exit(0)
+}
+
+// activationEnv returns the socket activation environment variables that are set, e.g. "LISTEN_FDS=1", or "clean".
+func activationEnv() string {
+	var set []string
+	for _, name := range []string{listenPIDEnv, listenFDsEnv, listenFDNamesEnv} {
+		if value, ok := os.LookupEnv(name); ok {
+			set = append(set, name+"="+value)
+		}
+	}
+	if len(set) < 1 {
+		return "clean"
+	}
+	return strings.Join(set, " ")
+}
+
+// runHelper starts the test binary in the "listener" mode with the environment, passing the file as fd 3 if it isn't nil,
+// and returns the lines it prints: Listener's result, then its child's environment.
+// If a listener is returned, the connect function is called to check it accepts connections.
+func runHelper(t *testing.T, env []string, file *os.File, connect func()) []string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), append(env, helperEnv+"=listener")...)
+	if file != nil {
+		cmd.ExtraFiles = []*os.File{file}
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting the helper process: %v", err)
+	}
+	defer cmd.Wait()
+	scanner := bufio.NewScanner(stdout)
+	var lines []string
+	for len(lines) < 2 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) < 2 {
+		cmd.Process.Kill()
+		t.Fatalf("the helper process printed %q", lines)
+	}
+	if lines[0] == "listener" && connect != nil {
+		connect()
+	}
+	return lines
+}
+
+func TestListener(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer tcpListener.Close()
+	file, err := tcpListener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File: %v", err)
+	}
+	defer file.Close()
+
+	connect := func() {
+		conn, err := net.DialTimeout("tcp", tcpListener.Addr().String(), 2*time.Second)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		greeting, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || greeting != "hello\n" {
+			t.Fatalf("the socket-activated listener served %q (%v), want the greeting", greeting, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		env  []string
+		want string
+	}{
+		{"activated", []string{listenPIDEnv + "=" + selfPID, listenFDsEnv + "=1", listenFDNamesEnv + "=gordafarid"}, "listener"},
+		{"not activated", nil, "none"},
+		// The sockets are meant for another process
+		{"pid mismatch", []string{listenPIDEnv + "=1", listenFDsEnv + "=1"}, "none"},
+		{"no socket", []string{listenPIDEnv + "=" + selfPID, listenFDsEnv + "=0"}, "invalid"},
+		{"malformed count", []string{listenPIDEnv + "=" + selfPID, listenFDsEnv + "=one"}, "invalid"},
+		{"several sockets", []string{listenPIDEnv + "=" + selfPID, listenFDsEnv + "=2"}, "too many"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lines := runHelper(t, tc.env, file, connect)
+			if lines[0] != tc.want {
+				t.Fatalf("Listener returned %q, want %q", lines[0], tc.want)
+			}
+			// The variables aren't passed on to the child processes, whether they were meant for the process or not
+			if lines[1] != "clean" {
+				t.Fatalf("the child process got the socket activation environment: %s", lines[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package activation
+
+import "net"
+
+// Listener returns nil, the socket activation isn't supported on Windows.
+func Listener() (net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,9 @@
+package activation
+
+import "errors"
+
+var (
+	errInvalidListenFDs    = errors.New("invalid systemd socket activation file descriptor count")
+	errTooManyListenFDs    = errors.New("systemd socket activation passed more than one socket, exactly one is expected")
+	errFailedToUseListenFD = errors.New("failed to use the systemd socket activation file descriptor as a listener")
+)
@@ -0,0 +1,309 @@
+// Package ban provides the per-client IP handshake failure counting and temporary bans of the Gordafarid server.
+//
+// The clients failing too many handshakes in a time window (e.g. probing the server, or guessing the keys)
+// are banned for a while, and their connections are closed at accept time, before any handshake.
+// The bans are persisted to a JSON state file, so they survive restarts.
+package ban
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ban is a temporary ban of a client IP.
+type Ban struct {
+	IP     netip.Addr `json:"ip"`
+	Reason string     `json:"reason"` // Why the IP is banned, e.g. the last handshake error
+	Since  time.Time  `json:"since"`
+	Until  time.Time  `json:"until"`
+}
+
+// failureCounter counts the handshake failures of a client IP in the current window.
+type failureCounter struct {
+	count int
+	since time.Time // Start of the window
+}
+
+// stateFile is the content of the state file.
+type stateFile struct {
+	Bans []Ban `json:"bans"`
+}
+
+// List counts the handshake failures of the client IPs, and keeps track of their bans.
+// It's safe for concurrent use.
+type List struct {
+	path          string         // The state file, empty means the bans are only kept in memory
+	maxFailures   int            // Failures in the window to ban the IP
+	failureWindow time.Duration  // Window of the failures
+	banDuration   time.Duration  // How long the IPs are banned
+	exempt        []netip.Prefix // Ranges never banned
+
+	mu       sync.Mutex
+	bans     map[netip.Addr]Ban
+	failures map[netip.Addr]*failureCounter
+	dirty    bool // The bans have changed since the last save
+}
+
+// NewList creates a new List, and loads the state file if it exists.
+// A client IP is banned for banDuration once it fails maxFailures handshakes within failureWindow.
+// The IPs in the exempt ranges (CIDRs or plain IPs) are never banned. If path is empty, the bans are only kept in memory.
+func NewList(path string, maxFailures int, failureWindow, banDuration time.Duration, exempt []string) (*List, error) {
+	l := &List{
+		path:          path,
+		maxFailures:   maxFailures,
+		failureWindow: failureWindow,
+		banDuration:   banDuration,
+		bans:          make(map[netip.Addr]Ban),
+		failures:      make(map[netip.Addr]*failureCounter),
+	}
+	for _, s := range exempt {
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			return nil, errors.Join(errInvalidExemptRange, fmt.Errorf("range: %q", s), err)
+		}
+		l.exempt = append(l.exempt, prefix)
+	}
+	if len(path) < 1 {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return l, nil
+		}
+		return nil, errors.Join(errFailedToLoadStateFile, err)
+	}
+	var state stateFile
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Join(errFailedToLoadStateFile, err)
+	}
+	now := time.Now()
+	for _, ban := range state.Bans {
+		if ban.IP.IsValid() && now.Before(ban.Until) {
+			ban.IP = ban.IP.Unmap()
+			l.bans[ban.IP] = ban
+		}
+	}
+	return l, nil
+}
+
+// parsePrefix parses a CIDR, or a plain IP address as a single-address prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// isExempt reports whether the IP is in the exempt ranges.
+func (l *List) isExempt(ip netip.Addr) bool {
+	for _, prefix := range l.exempt {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Banned reports whether the IP is banned at this time.
+func (l *List) Banned(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ban, exists := l.bans[ip]
+	return exists && time.Now().Before(ban.Until)
+}
+
+// Failure counts a handshake failure of the IP for the given reason.
+// If the IP reaches the maximum failures in the window, it's banned, and the ban is returned.
+func (l *List) Failure(ip netip.Addr, reason string) (Ban, bool) {
+	ip = ip.Unmap()
+	if l.maxFailures < 1 || l.isExempt(ip) {
+		return Ban{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	counter, exists := l.failures[ip]
+	if !exists || now.Sub(counter.since) >= l.failureWindow {
+		counter = &failureCounter{since: now}
+		l.failures[ip] = counter
+	}
+	counter.count++
+	if counter.count < l.maxFailures {
+		return Ban{}, false
+	}
+	delete(l.failures, ip)
+	return l.ban(ip, l.banDuration, reason, now), true
+}
+
+// Ban bans the IP for the given duration (the list's ban duration if zero) right away, e.g. on a replay attack,
+// and returns the ban. The IPs in the exempt ranges aren't banned.
+func (l *List) Ban(ip netip.Addr, duration time.Duration, reason string) (Ban, bool) {
+	ip = ip.Unmap()
+	if l.isExempt(ip) {
+		return Ban{}, false
+	}
+	if duration <= 0 {
+		duration = l.banDuration
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, ip)
+	return l.ban(ip, duration, reason, time.Now()), true
+}
+
+// ban adds the ban of the IP, the caller must hold the lock.
+// An existing ban is only extended, never shortened.
+func (l *List) ban(ip netip.Addr, duration time.Duration, reason string, now time.Time) Ban {
+	ban := Ban{IP: ip, Reason: reason, Since: now, Until: now.Add(duration)}
+	if existing, exists := l.bans[ip]; exists && existing.Until.After(ban.Until) {
+		ban.Until = existing.Until
+	}
+	l.bans[ip] = ban
+	l.dirty = true
+	return ban
+}
+
+// Unban lifts the ban of the IP, and reports whether it was banned.
+func (l *List) Unban(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, ip)
+	if _, exists := l.bans[ip]; !exists {
+		return false
+	}
+	delete(l.bans, ip)
+	l.dirty = true
+	return true
+}
+
+// Bans returns the current bans, sorted by IP.
+func (l *List) Bans() []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	bans := make([]Ban, 0, len(l.bans))
+	for _, ban := range l.bans {
+		if now.Before(ban.Until) {
+			bans = append(bans, ban)
+		}
+	}
+	slices.SortFunc(bans, func(a, b Ban) int {
+		return a.IP.Compare(b.IP)
+	})
+	return bans
+}
+
+// prune removes the expired bans and the failure counters of the past windows, and returns the expired bans.
+func (l *List) prune() []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var expired []Ban
+	for ip, ban := range l.bans {
+		if !now.Before(ban.Until) {
+			expired = append(expired, ban)
+			delete(l.bans, ip)
+			l.dirty = true
+		}
+	}
+	for ip, counter := range l.failures {
+		if now.Sub(counter.since) >= l.failureWindow {
+			delete(l.failures, ip)
+		}
+	}
+	return expired
+}
+
+// Save writes the bans to the state file, if they have changed since the last save.
+// The file is replaced atomically, so a crash doesn't leave a truncated state behind.
+func (l *List) Save() error {
+	if len(l.path) < 1 {
+		return nil
+	}
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	l.dirty = false
+	l.mu.Unlock()
+	data, err := json.MarshalIndent(stateFile{Bans: l.Bans()}, "", "  ")
+	if err != nil {
+		return l.saveFailed(err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return l.saveFailed(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return l.saveFailed(err)
+	}
+	if err = tmpFile.Close(); err != nil {
+		return l.saveFailed(err)
+	}
+	if err = os.Rename(tmpFile.Name(), l.path); err != nil {
+		return l.saveFailed(err)
+	}
+	return nil
+}
+
+// saveFailed marks the bans as changed again, so the next save retries, and returns the wrapped error.
+func (l *List) saveFailed(err error) error {
+	l.mu.Lock()
+	l.dirty = true
+	l.mu.Unlock()
+	return errors.Join(errFailedToSaveStateFile, err)
+}
+
+// StartCleanupRoutine starts a background routine to remove the expired bans and save the bans to the state file every interval.
+// The expired bans are passed to onExpire, and the save errors to onError (if not nil).
+// The routine saves once more and stops when the context is cancelled.
+func (l *List) StartCleanupRoutine(ctx context.Context, interval time.Duration, onExpire func(Ban), onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, ban := range l.prune() {
+					if onExpire != nil {
+						onExpire(ban)
+					}
+				}
+				if err := l.Save(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-ctx.Done():
+				// Save the last changes, and stop the goroutine when the context is cancelled
+				if err := l.Save(); err != nil && onError != nil {
+					onError(err)
+				}
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,300 @@
+package ban
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	clientIP      = netip.MustParseAddr("203.0.113.7")
+	otherClientIP = netip.MustParseAddr("203.0.113.8")
+)
+
+// newList creates a List kept in memory, failing the test on error.
+func newList(t *testing.T, maxFailures int, failureWindow, banDuration time.Duration, exempt ...string) *List {
+	t.Helper()
+	l, err := NewList("", maxFailures, failureWindow, banDuration, exempt)
+	if err != nil {
+		t.Fatalf("NewList: %v", err)
+	}
+	return l
+}
+
+func TestListFailureWindow(t *testing.T) {
+	l := newList(t, 3, 100*time.Millisecond, time.Hour)
+
+	// The failures of a past window aren't counted
+	l.Failure(clientIP, "bad key")
+	l.Failure(clientIP, "bad key")
+	time.Sleep(150 * time.Millisecond)
+	if _, banned := l.Failure(clientIP, "bad key"); banned || l.Banned(clientIP) {
+		t.Fatal("the IP is banned for the failures of different windows")
+	}
+
+	l.Failure(clientIP, "bad key")
+	b, banned := l.Failure(clientIP, "replayed greeting")
+	if !banned || !l.Banned(clientIP) {
+		t.Fatal("the IP isn't banned once it reached the maximum failures in the window")
+	}
+	if b.IP != clientIP || b.Reason != "replayed greeting" || b.Until.Sub(b.Since) != time.Hour {
+		t.Fatalf("Failure returned the ban %+v", b)
+	}
+	if l.Banned(otherClientIP) {
+		t.Fatal("another IP is banned")
+	}
+
+	// The IPv4-mapped IPv6 addresses are the same clients
+	if !l.Banned(netip.AddrFrom16(clientIP.As16())) {
+		t.Fatal("the IPv4-mapped address of the banned IP isn't banned")
+	}
+}
+
+func TestListFailuresDisabled(t *testing.T) {
+	l := newList(t, 0, time.Minute, time.Hour)
+	for range 10 {
+		if _, banned := l.Failure(clientIP, "bad key"); banned {
+			t.Fatal("the IP is banned while the failures aren't counted")
+		}
+	}
+	if l.Banned(clientIP) {
+		t.Fatal("the IP is banned while the failures aren't counted")
+	}
+}
+
+func TestListExempt(t *testing.T) {
+	if _, err := NewList("", 1, time.Minute, time.Hour, []string{"not-a-range"}); !errors.Is(err, errInvalidExemptRange) {
+		t.Fatalf("NewList with an invalid exempt range: err = %v, want %v", err, errInvalidExemptRange)
+	}
+
+	l := newList(t, 1, time.Minute, time.Hour, "203.0.113.0/24", "::1")
+	for _, ip := range []netip.Addr{clientIP, netip.MustParseAddr("::1")} {
+		if _, banned := l.Failure(ip, "bad key"); banned {
+			t.Fatalf("the exempt IP %s is banned on failures", ip)
+		}
+		if _, banned := l.Ban(ip, time.Hour, "manual"); banned {
+			t.Fatalf("the exempt IP %s is banned", ip)
+		}
+		if l.Banned(ip) {
+			t.Fatalf("the exempt IP %s is banned", ip)
+		}
+	}
+	if _, banned := l.Failure(netip.MustParseAddr("198.51.100.1"), "bad key"); !banned {
+		t.Fatal("the IP out of the exempt ranges isn't banned")
+	}
+}
+
+func TestListBanExpiryAndExtension(t *testing.T) {
+	l := newList(t, 3, time.Minute, time.Hour)
+
+	// A zero duration is the list's ban duration
+	b, _ := l.Ban(clientIP, 0, "manual")
+	if b.Until.Sub(b.Since) != time.Hour {
+		t.Fatalf("the ban of a zero duration lasts %v, want %v", b.Until.Sub(b.Since), time.Hour)
+	}
+
+	// A ban is extended, never shortened
+	shorter, _ := l.Ban(clientIP, time.Minute, "manual")
+	if !shorter.Until.Equal(b.Until) {
+		t.Fatalf("the ban is shortened to %v, want %v", shorter.Until, b.Until)
+	}
+	longer, _ := l.Ban(clientIP, 2*time.Hour, "manual")
+	if !longer.Until.After(b.Until) {
+		t.Fatalf("the ban isn't extended: until %v, want after %v", longer.Until, b.Until)
+	}
+
+	// An expired ban is neither active nor listed, and it's pruned
+	l.Ban(otherClientIP, 50*time.Millisecond, "short")
+	if !l.Banned(otherClientIP) {
+		t.Fatal("the IP isn't banned")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if l.Banned(otherClientIP) {
+		t.Fatal("the IP is still banned once its ban expired")
+	}
+	if bans := l.Bans(); len(bans) != 1 || bans[0].IP != clientIP {
+		t.Fatalf("Bans = %+v, want the active ban only", bans)
+	}
+	if expired := l.prune(); len(expired) != 1 || expired[0].IP != otherClientIP {
+		t.Fatalf("prune = %+v, want the expired ban", expired)
+	}
+
+	if !l.Unban(clientIP) || l.Banned(clientIP) {
+		t.Fatal("the IP isn't unbanned")
+	}
+	if l.Unban(clientIP) {
+		t.Fatal("Unban of an IP that isn't banned reported a ban")
+	}
+}
+
+func TestListSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	l, err := NewList(path, 3, time.Minute, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("NewList: %v", err)
+	}
+	b, _ := l.Ban(clientIP, time.Hour, "manual")
+	l.Ban(otherClientIP, 50*time.Millisecond, "short")
+	if err = l.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// An unchanged list isn't saved again
+	if err = os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the unchanged list is saved: %v", err)
+	}
+	l.Unban(otherClientIP)
+	l.Ban(otherClientIP, 50*time.Millisecond, "short")
+	if err = l.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// The bans expired by the time the state file is loaded are dropped
+	time.Sleep(100 * time.Millisecond)
+	loaded, err := NewList(path, 3, time.Minute, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("NewList: %v", err)
+	}
+	bans := loaded.Bans()
+	if len(bans) != 1 || bans[0].IP != clientIP || bans[0].Reason != b.Reason || !bans[0].Until.Equal(b.Until) {
+		t.Fatalf("the loaded bans = %+v, want %+v", bans, b)
+	}
+	if loaded.Banned(otherClientIP) {
+		t.Fatal("the expired ban is loaded")
+	}
+
+	if err = os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewList(path, 3, time.Minute, time.Hour, nil); !errors.Is(err, errFailedToLoadStateFile) {
+		t.Fatalf("NewList of an invalid state file: err = %v, want %v", err, errFailedToLoadStateFile)
+	}
+}
+
+func TestListSaveRetriesAfterFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	path := filepath.Join(dir, "bans.json")
+	l, err := NewList(path, 3, time.Minute, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("NewList: %v", err)
+	}
+	l.Ban(clientIP, time.Hour, "manual")
+	if err = l.Save(); !errors.Is(err, errFailedToSaveStateFile) {
+		t.Fatalf("Save to a missing directory: err = %v, want %v", err, errFailedToSaveStateFile)
+	}
+
+	// The bans are still marked as changed, so the next save writes them
+	if err = os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err = os.Stat(path); err != nil {
+		t.Fatalf("the state file isn't saved after the failure: %v", err)
+	}
+}
+
+func TestListCleanupRoutine(t *testing.T) {
+	l := newList(t, 3, time.Minute, time.Hour)
+	l.Ban(clientIP, 50*time.Millisecond, "short")
+	expired := make(chan Ban, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l.StartCleanupRoutine(ctx, 20*time.Millisecond, func(b Ban) { expired <- b }, nil)
+
+	select {
+	case b := <-expired:
+		if b.IP != clientIP {
+			t.Fatalf("the expired ban is %+v", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the expired ban isn't reported")
+	}
+}
+
+func TestListenerRefusesBannedIP(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	l := newList(t, 3, time.Minute, time.Hour)
+	ln := NewListener(tcpListener, l)
+	defer ln.Close()
+	localhost := netip.MustParseAddr("127.0.0.1")
+	l.Ban(localhost, time.Hour, "manual")
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	// The banned client's connection is closed right away, it's never returned by Accept
+	banned, err := net.Dial("tcp", tcpListener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer banned.Close()
+	banned.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err = banned.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Fatalf("the banned client's connection isn't closed: %v", err)
+	}
+	select {
+	case conn := <-accepted:
+		conn.Close()
+		t.Fatal("the banned client's connection is accepted")
+	default:
+	}
+
+	// Once unbanned, the client is accepted
+	l.Unban(localhost)
+	client, err := net.Dial("tcp", tcpListener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+		if conn.RemoteAddr().String() != client.LocalAddr().String() {
+			t.Fatalf("accepted %s, want the unbanned client %s", conn.RemoteAddr(), client.LocalAddr())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the unbanned client isn't accepted")
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	for _, tc := range []struct {
+		addr net.Addr
+		want netip.Addr
+		ok   bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 1000}, clientIP, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:203.0.113.7"), Port: 1000}, clientIP, true},
+		{&net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 1000}, clientIP, true},
+		{&net.UnixAddr{Name: "/run/gordafarid.sock", Net: "unix"}, netip.Addr{}, false},
+	} {
+		if got, ok := RemoteIP(tc.addr); got != tc.want || ok != tc.ok {
+			t.Errorf("RemoteIP(%v) = %v, %v, want %v, %v", tc.addr, got, ok, tc.want, tc.ok)
+		}
+	}
+}
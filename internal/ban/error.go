@@ -0,0 +1,9 @@
+package ban
+
+import "errors"
+
+var (
+	errInvalidExemptRange    = errors.New("invalid ban exempt range")
+	errFailedToLoadStateFile = errors.New("failed to load the ban state file")
+	errFailedToSaveStateFile = errors.New("failed to save the ban state file")
+)
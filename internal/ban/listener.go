@@ -0,0 +1,48 @@
+package ban
+
+import (
+	"net"
+	"net/netip"
+)
+
+// Listener wraps a net.Listener, and closes the connections of the banned client IPs right after accepting them.
+type Listener struct {
+	net.Listener
+	list *List
+}
+
+// NewListener creates a new Listener wrapping the given net.Listener.
+func NewListener(ln net.Listener, list *List) *Listener {
+	return &Listener{
+		Listener: ln,
+		list:     list,
+	}
+}
+
+// Accept waits for and returns the next connection of a client IP that isn't banned.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if ip, ok := RemoteIP(c.RemoteAddr()); ok && l.list.Banned(ip) {
+			c.Close()
+			continue
+		}
+		return c, nil
+	}
+}
+
+// RemoteIP returns the IP address of the given remote address, if it has one.
+func RemoteIP(addr net.Addr) (netip.Addr, bool) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+		return ip.Unmap(), ok
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/accesslog"
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+// reasonHandshakeFailed is the close reason of the connections whose Gordafarid handshake with the server failed.
+const reasonHandshakeFailed = "handshake_failed"
+
+// accessRecord is the state of a connection being handled, written to the access log once it ends.
+type accessRecord struct {
+	session     session.ID
+	client      string // The application's address
+	socksUser   string // The authenticated SOCKS5 username, empty if the SOCKS5 authentication is disabled
+	destination string // The requested destination, host:port
+	start       time.Time
+	upload      int64  // Bytes relayed from the application to the server
+	download    int64  // Bytes relayed from the server to the application
+	reason      string // Why the connection ended, one of the accesslog.Reason* values
+}
+
+// openAccessLog opens the access log file, if it's enabled.
+func (c *Client) openAccessLog() error {
+	if !c.cfg.AccessLog.IsEnabled() {
+		return nil
+	}
+	var err error
+	if c.accessLog, err = accesslog.Open(c.cfg.AccessLog.Path, c.cfg.AccessLog.Format); err != nil {
+		return err
+	}
+	logger.Info("Access log is written to: ", c.cfg.AccessLog.Path)
+	return nil
+}
+
+// closeAccessLog closes the access log file, if it's open.
+func (c *Client) closeAccessLog() error {
+	if c.accessLog == nil {
+		return nil
+	}
+	return c.accessLog.Close()
+}
+
+// logAccess writes the access log record of the finished connection, if the access log is enabled.
+func (c *Client) logAccess(r *accessRecord) {
+	if c.accessLog == nil {
+		return
+	}
+	now := time.Now()
+	err := c.accessLog.Log(accesslog.Record{
+		Time:        now,
+		Session:     r.session.String(),
+		Client:      r.client,
+		User:        c.cfg.Account.Username,
+		SocksUser:   r.socksUser,
+		Destination: r.destination,
+		Upload:      r.upload,
+		Download:    r.download,
+		Duration:    now.Sub(r.start),
+		Reason:      r.reason,
+	})
+	if err != nil {
+		logger.Warn(err)
+	}
+}
+
+// dialCloseReason returns the close reason of a connection whose Gordafarid dial to the server failed,
+// either by the server's failure reply, the handshake or the TCP dial.
+func dialCloseReason(err error) string {
+	switch {
+	case errors.Is(err, gordafarid.ErrReplyNotAllowed):
+		return accesslog.ReasonNotAllowed
+	case errors.Is(err, gordafarid.ErrReplyQuotaExceeded):
+		return accesslog.ReasonQuotaExceeded
+	case errors.Is(err, gordafarid.ErrReplyLimitExceeded):
+		return accesslog.ReasonLimitExceeded
+	case errors.Is(err, gordafarid.ErrReplyFailed):
+		return accesslog.ReasonFailed
+	case errors.Is(err, gordafarid.ErrHandshakeFailed):
+		return reasonHandshakeFailed
+	default:
+		return accesslog.ReasonDialFailed
+	}
+}
+
+// relayCloseReason returns why the relay of a connection ended.
+// The shutdown (ctx is cancelled at the shutdown deadline) is checked first, as it ends the relay with "use of closed connection" errors.
+func relayCloseReason(ctx context.Context, relayErr error) string {
+	switch {
+	case ctx.Err() != nil:
+		return accesslog.ReasonShutdown
+	case relayErr != nil:
+		return accesslog.ReasonError
+	default:
+		return accesslog.ReasonCompleted
+	}
+}
@@ -4,28 +4,28 @@ package client
 import (
 	"context"
 	"errors"
-	"fmt"
-	"io"
-	"sync"
+	"log/slog"
+	"net"
 	"time"
 
+	"github.com/Iam54r1n4/Gordafarid/internal/accesslog"
 	"github.com/Iam54r1n4/Gordafarid/internal/config"
 	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/internal/metrics"
 	"github.com/Iam54r1n4/Gordafarid/internal/shared_error"
-	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/socks"
-	"github.com/Iam54r1n4/Gordafarid/pkg/net/utils"
+	proxy_client "github.com/Iam54r1n4/Gordafarid/pkg/proxy/client"
 )
 
-// errUnableToGetSocks5HandshakeResult is an error returned when the SOCKS5 handshake result cannot be obtained.
-var errUnableToGetSocks5HandshakeResult = errors.New("failed to get SOCKS5 handshake result")
-
 // Client represents the client-side of the proxy.
 type Client struct {
-	cfg              *config.ClientConfig // Configuration for the client
-	socks5Listener   *socks.Listener      // Socks5 listener for incoming connections
-	gordafaridDialer *gordafarid.Dialer   // Gordafarid dialer for outgoing connections
+	cfg           *config.ClientConfig // Configuration for the client
+	proxy         *proxy_client.Client // Accepts the SOCKS5 connections and relays them through the server, extended by the hooks
+	ln            net.Listener         // The listening socket wrapped with the metrics, served by the proxy
+	metrics       *clientMetrics       // Counters and histograms of the client
+	metricsServer *metrics.Server      // The metrics endpoint, nil if disabled
+	accessLog     *accesslog.Logger    // A record per proxied connection, nil if disabled
 }
 
 // NewClient creates and returns a new Client instance.
@@ -50,7 +50,8 @@ type Client struct {
 //	client := NewClient(cfg)
 func NewClient(cfg *config.ClientConfig) *Client {
 	return &Client{
-		cfg: cfg,
+		cfg:     cfg,
+		metrics: newClientMetrics(),
 	}
 }
 
@@ -66,9 +67,24 @@ func NewClient(cfg *config.ClientConfig) *Client {
 //		log.Fatal("Failed to start listener:", err)
 //	}
 func (c *Client) Listen() error {
+	ln, err := net.Listen("tcp", c.cfg.Client.Address)
+	if err != nil {
+		return err
+	}
+	return c.ListenOn(ln)
+}
+
+// ListenOn is like Listen, but it uses the given listening socket (e.g. passed by systemd socket activation)
+// instead of binding the client.address.
+//
+// Parameters:
+//   - ln: The listening socket for the incoming SOCKS5 connections.
+//
+// Returns:
+//   - An error if the listener can't be set up, nil otherwise.
+func (c *Client) ListenOn(ln net.Listener) error {
 	// Create a new SOCKS5 server configuration
 	// Convert the credentials map to a ServerCredentials map
-	var err error
 	var socks5Credentials socks.ServerCredentials
 	if c.cfg.Socks5Credentials != nil {
 		socks5Credentials = make(socks.ServerCredentials)
@@ -76,132 +92,68 @@ func (c *Client) Listen() error {
 			socks5Credentials[u] = p
 		}
 	}
-	socksConfig := socks.NewServerConfig(socks5Credentials, c.cfg.Timeout.Socks5HandshakeTimeout)
+	socksConfig := socks.NewServerConfig(socks5Credentials, c.cfg.Timeout.Socks5HandshakeTimeout).SetMaxHandshakes(c.cfg.Client.MaxHandshakes)
 
-	// Create a new SOCKS5 listener with the specified address and configuration
-	c.socks5Listener, err = socks.NewListener(c.cfg.Client.Address, socksConfig)
-	if err != nil {
+	// Create a Gordafarid dialer
+	key := c.cfg.Account.KeyMaterial()
+	credential := gordafarid.NewDerivedCredential(c.cfg.Account.Username, gordafarid.DeriveKeyID(c.cfg.Account.Username, string(key)), key)
+	accountConfig := gordafarid.NewDialAccountConfig(credential, c.cfg.Client.InitPassword, c.cfg.Client.InitCryptoAlgorithm, c.cfg.CryptoAlgorithm)
+
+	var err error
+	if c.proxy, err = proxy_client.NewClient(proxy_client.Options{
+		Dialer:           gordafarid.NewDialer(accountConfig, nil),
+		ServerAddress:    c.cfg.Server.Address,
+		Socks:            socksConfig,
+		HandshakeTimeout: time.Duration(c.cfg.Timeout.GordafaridHandshakeTimeout) * time.Second,
+		SendSessionID:    c.cfg.Client.SendSessionID,
+		Logger:           slog.New(logger.Handler()),
+		Hooks:            c.hooks(),
+	}); err != nil {
 		return err
 	}
-	logger.Info("Client is listening for socks5 connections on: ", c.cfg.Client.Address)
-	return nil
+	c.ln = metrics.NewListener(ln, c.metrics.connectionsAccepted)
+	logger.Info("Client is listening for socks5 connections on: ", ln.Addr())
+	if err := c.openAccessLog(); err != nil {
+		return err
+	}
+	return c.startMetrics()
 }
 
 // Start begins accepting and handling incoming connections.
-// This method runs indefinitely and should be called after Listen().
+// This method runs until the client is shut down by Shutdown, and should be called after Listen().
 //
 // Returns:
+//   - shared_error.ErrClientClosed once the client is shut down.
 //   - An error if the listener is not initialized or if there's an error during execution.
 func (c *Client) Start() error {
-	if c.socks5Listener == nil {
+	if c.proxy == nil {
 		return shared_error.ErrListenerIsNotInitialized
 	}
-
-	// Create a Gordafarid dialer
-	credential := gordafarid.NewCredential(c.cfg.Account.Username, c.cfg.Account.Password)
-	accountConfig := gordafarid.NewDialAccountConfig(credential, c.cfg.Client.InitPassword, c.cfg.CryptoAlgorithm)
-	c.gordafaridDialer = gordafarid.NewDialer(accountConfig, nil)
-
-	for {
-		// Accept incoming SOCKS5 connections, the socks5 handshake will be performed automatically
-		conn, err := c.socks5Listener.Accept()
-		if err != nil {
-			logger.Warn(errors.Join(shared_error.ErrConnectionAccepting, err))
-			continue
-		}
-		logger.Info("Accepted SOCKS5 connection from:", conn.RemoteAddr())
-
-		// Create a context with a 1-hour timeout for each connection
-		ctx, cancel := context.WithTimeout(context.Background(), time.Hour*1)
-		go func() {
-			defer cancel() // Ensure the context is canceled when the goroutine exits
-			c.handleConnection(ctx, conn)
-		}()
-	}
+	return c.proxy.Serve(context.Background(), c.ln)
 }
 
-// handleConnection manages an individual client connection.
-//
-// This function is responsible for handling a single SOCKS5 client connection.
-// It performs the following steps:
-// 1. Retrieves the SOCKS5 handshake result.
-// 2. Creates a dialer connection configuration based on the handshake result.
-// 3. Establishes a connection to the remote server using the Gordafarid protocol.
-// 4. Initiates bidirectional data transfer between the client and the remote server.
-// 5. Handles and logs any errors that occur during the process.
-//
-// The function uses goroutines to perform concurrent data transfer in both directions
-// (client to remote and remote to client). It also utilizes a wait group and an error
-// channel to manage these goroutines and collect any errors that may occur during
-// the data transfer process.
+// Shutdown gracefully shuts down the client.
+// It closes the listener, so no new connections are accepted (Start returns shared_error.ErrClientClosed), stops the metrics endpoint,
+// waits for the active connections to finish, and closes them once the context is done.
 //
 // Parameters:
-//   - ctx: context.Context - The context for the connection, used for cancellation and timeouts.
-//   - conn: *socks.Conn - The SOCKS5 connection to handle.
+//   - ctx: The deadline of the active connections.
 //
-// The function doesn't return any values, but it logs errors and manages the lifecycle
-// of the connection, including closing it when the function exits.
-//
-// Error handling:
-//   - If there's an error getting the SOCKS5 handshake result, it logs the error and returns.
-//   - If there's an error dialing to the remote server, it logs the error and returns.
-//   - Any errors during data transfer are logged, except for io.EOF which is expected and ignored.
-//
-// Concurrency:
-// - The function uses goroutines and a WaitGroup to handle bidirectional data transfer concurrently.
-// - It creates an error channel to collect errors from the data transfer goroutines.
-// Note: This function is designed to be run as a goroutine for each incoming connection.
-func (c *Client) handleConnection(ctx context.Context, conn *socks.Conn) {
-	// Close the incoming SOCKS5(TCP) connection when the function returns
-	defer conn.Close()
-
-	// Get SOCKS5 handshake result from the SOCKS5 connection
-	logger.Debug("Getting the SOCKS5 handshake result...")
-	handshakeResult, err := conn.GetHandshakeResult()
-	if err != nil {
-		logger.Error(errUnableToGetSocks5HandshakeResult, err)
-		return
-	}
-	logger.Debug("The SOCKS5 handshake result received")
-
-	// Create dialer connection config
-	dialerConnConfig := gordafarid.NewDialConnConfig(protocol.NewAddressHeader(handshakeResult.Atyp, handshakeResult.DstAddr, handshakeResult.DstPort))
-
-	// Dial to remote server using Gordafarid protocol
-	gordafaridHandshakeCtx, cancel := context.WithTimeout(ctx, time.Duration(c.cfg.Timeout.GordafaridHandshakeTimeout)*time.Second)
-	defer cancel()
-	logger.Debug("Dialing to remote server using Gordafarid protocol...")
-	grc, err := c.gordafaridDialer.DialContext(gordafaridHandshakeCtx, dialerConnConfig, c.cfg.Server.Address)
-	if err != nil {
-		logger.Warn(errors.Join(shared_error.ErrClientToServerDialFailed, err))
-		return
+// Returns:
+//   - The context's error if the active connections were closed before finishing.
+func (c *Client) Shutdown(ctx context.Context) error {
+	var errs []error
+	if err := c.stopMetrics(); err != nil {
+		errs = append(errs, err)
 	}
-	logger.Debug("Connection established with remote server using Gordafarid protocol")
-
-	// Set up bidirectional data transfer
-	wg := sync.WaitGroup{}
-	wg.Add(2)
-	errChan := make(chan error, 2)
-
-	// Log the proxying information
-	logger.Debug(fmt.Sprintf("Proxying between %s/%s", conn.RemoteAddr(), grc.RemoteAddr()))
-
-	// Goroutine to copy data from client to remote
-	go utils.DataTransfering(&wg, errChan, grc, conn)
-	// Goroutine to copy data from remote to client
-	go utils.DataTransfering(&wg, errChan, conn, grc)
-
-	// Close the errChan after the dataTransfering goroutines are finished
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
-
-	// Handle and log errors from the data transfer goroutines
-	for err := range errChan {
-		// Ignore EOF errors as they are expected when connections close
-		if !errors.Is(err, io.EOF) {
-			logger.Error(err)
+	if c.proxy != nil {
+		if err := c.proxy.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	// The connections are closed now, so are their access log records written
+	if err := c.closeAccessLog(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/accesslog"
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/internal/metrics"
+	proxy_client "github.com/Iam54r1n4/Gordafarid/pkg/proxy/client"
+)
+
+// accessRecordKey is the context key of the accessRecord of a request.
+type accessRecordKey struct{}
+
+// hooks returns the hooks of the proxy client, which add the metrics and the access log of the client.
+func (c *Client) hooks() proxy_client.Hooks {
+	return proxy_client.Hooks{
+		OnHandshakeError: c.metrics.socks5HandshakeFailed,
+		Authorize:        c.authorize,
+		Wrap:             c.wrap,
+		OnClose:          c.onClose,
+	}
+}
+
+// authorize starts the access log record of the request, and attaches the account to every line logged for it.
+// All the requests are authorized, the SOCKS5 users are authenticated by the handshake.
+func (c *Client) authorize(ctx context.Context, req *proxy_client.Request) (context.Context, error) {
+	c.metrics.socks5Handshakes.Inc()
+	record := &accessRecord{
+		session:     req.Conn.SessionID(),
+		client:      req.Conn.RemoteAddr().String(),
+		socksUser:   req.Conn.Username(),
+		destination: req.Destination.String(),
+		start:       time.Now(),
+	}
+	ctx = context.WithValue(ctx, accessRecordKey{}, record)
+	ctx = logger.WithAttrs(ctx, "user", c.cfg.Account.Username, "client", record.client)
+	if len(record.socksUser) > 0 {
+		ctx = logger.WithAttrs(ctx, "socksUser", record.socksUser)
+	}
+	return ctx, nil
+}
+
+// wrap counts the relayed bytes by the reads of both sides, the application's reads are the uploads and the server's reads are the downloads.
+func (c *Client) wrap(ctx context.Context, req *proxy_client.Request, app, server net.Conn) (net.Conn, net.Conn) {
+	// Wrap is called once the server is dialed
+	c.metrics.dialSucceeded(server)
+	c.metrics.activeTunnels.Inc()
+	appConn := metrics.NewConn(app, c.metrics.bytesRelayed.With(c.cfg.Account.Username, directionUpload))
+	serverConn := metrics.NewConn(server, c.metrics.bytesRelayed.With(c.cfg.Account.Username, directionDownload))
+	return appConn, serverConn
+}
+
+// onClose records the failed dials in the metrics, and writes the access log record of the request.
+func (c *Client) onClose(ctx context.Context, req *proxy_client.Request, result proxy_client.Result) {
+	record, ok := ctx.Value(accessRecordKey{}).(*accessRecord)
+	if !ok {
+		return
+	}
+	record.upload, record.download = result.Upload, result.Download
+	switch {
+	case errors.Is(result.Err, proxy_client.ErrDialFailed):
+		c.metrics.dialFailed(result.Err)
+		record.reason = dialCloseReason(result.Err)
+	case result.Server != nil:
+		c.metrics.activeTunnels.Dec()
+		record.reason = relayCloseReason(ctx, result.Err)
+	default:
+		record.reason = accesslog.ReasonFailed
+	}
+	c.logAccess(record)
+}
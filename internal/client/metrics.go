@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/internal/metrics"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/cipher_conn"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/socks"
+)
+
+// Directions of the relayed bytes, the values of the "direction" label.
+const (
+	directionUpload   = "upload"   // From the local application to the server
+	directionDownload = "download" // From the server to the local application
+)
+
+// metricsShutdownTimeout is how long the metrics endpoint waits for its in-flight scrapes on shutdown.
+const metricsShutdownTimeout = 5 * time.Second
+
+// clientMetrics holds the metrics of the client, exposed on the metrics.address in the Prometheus text format.
+// They're collected even if the endpoint is disabled, as they're cheap atomic counters.
+type clientMetrics struct {
+	registry                *metrics.Registry
+	connectionsAccepted     *metrics.Counter    // TCP connections accepted from the local applications
+	socks5Handshakes        *metrics.Counter    // Successful SOCKS5 handshakes
+	socks5HandshakeFailures *metrics.CounterVec // Failed SOCKS5 handshakes by reason
+	handshakes              *metrics.Counter    // Successful Gordafarid handshakes with the server
+	handshakeFailures       *metrics.CounterVec // Failed Gordafarid handshakes with the server by reason
+	handshakeDuration       *metrics.Histogram  // Duration of the successful Gordafarid handshakes
+	requestFailures         *metrics.CounterVec // Requests the server replied a failure to, by reply status
+	bytesRelayed            *metrics.CounterVec // Relayed bytes by user and direction
+	activeTunnels           *metrics.Gauge      // Tunnels relaying data
+	dialErrors              *metrics.CounterVec // Failed server dials by type
+}
+
+// newClientMetrics creates and registers the metrics of the client.
+func newClientMetrics() *clientMetrics {
+	r := metrics.NewRegistry()
+	m := &clientMetrics{
+		registry:                r,
+		connectionsAccepted:     r.NewCounter("gordafarid_client_connections_accepted_total", "TCP connections accepted by the client.", nil),
+		socks5Handshakes:        r.NewCounter("gordafarid_client_socks5_handshakes_total", "Successful SOCKS5 handshakes.", nil),
+		socks5HandshakeFailures: r.NewCounterVec("gordafarid_client_socks5_handshake_failures_total", "Failed SOCKS5 handshakes by reason.", "reason"),
+		handshakes:              r.NewCounter("gordafarid_client_handshakes_total", "Successful Gordafarid handshakes with the server.", nil),
+		handshakeFailures:       r.NewCounterVec("gordafarid_client_handshake_failures_total", "Failed Gordafarid handshakes with the server by reason.", "reason"),
+		handshakeDuration:       r.NewHistogram("gordafarid_client_handshake_duration_seconds", "Duration of the successful Gordafarid handshakes with the server.", metrics.DefaultLatencyBuckets),
+		requestFailures:         r.NewCounterVec("gordafarid_client_request_failures_total", "Requests the server replied a failure to, by reply status.", "reply"),
+		bytesRelayed:            r.NewCounterVec("gordafarid_client_relayed_bytes_total", "Bytes relayed by user and direction (upload: application to server, download: server to application).", "user", "direction"),
+		activeTunnels:           r.NewGauge("gordafarid_client_active_tunnels", "Tunnels relaying data.", nil),
+		dialErrors:              r.NewCounterVec("gordafarid_client_dial_errors_total", "Failed server dials by type.", "type"),
+	}
+	r.NewGaugeFunc("gordafarid_client_nonce_cache_size", "Nonces kept to detect the replays.", metrics.Labels{"cache": "greeting"}, func() float64 {
+		return float64(aead.NonceCacheSize())
+	})
+	r.NewGaugeFunc("gordafarid_client_nonce_cache_size", "Nonces kept to detect the replays.", metrics.Labels{"cache": "data"}, func() float64 {
+		return float64(cipher_conn.NonceCacheSize())
+	})
+	return m
+}
+
+// socks5HandshakeFailed records a failed SOCKS5 handshake.
+func (m *clientMetrics) socks5HandshakeFailed(err error) {
+	m.socks5HandshakeFailures.With(socks.HandshakeFailureReason(err)).Inc()
+}
+
+// dialSucceeded records a successful Gordafarid dial to the server.
+func (m *clientMetrics) dialSucceeded(grc net.Conn) {
+	m.handshakes.Inc()
+	if gc, ok := grc.(*gordafarid.Conn); ok {
+		m.handshakeDuration.Observe(gc.HandshakeDuration().Seconds())
+	}
+}
+
+// dialFailed records a failed Gordafarid dial to the server, either the TCP dial, the handshake or the server's failure reply.
+func (m *clientMetrics) dialFailed(err error) {
+	if reply := replyFailure(err); len(reply) > 0 {
+		m.requestFailures.With(reply).Inc()
+		return
+	}
+	if errors.Is(err, gordafarid.ErrHandshakeFailed) {
+		m.handshakeFailures.With(gordafarid.HandshakeFailureReason(err)).Inc()
+		return
+	}
+	m.dialErrors.With(metrics.DialErrorType(err)).Inc()
+}
+
+// replyFailure returns the "reply" label of the server's failure reply, or an empty string if the error isn't a failure reply.
+func replyFailure(err error) string {
+	switch {
+	case errors.Is(err, gordafarid.ErrReplyNotAllowed):
+		return "not_allowed"
+	case errors.Is(err, gordafarid.ErrReplyQuotaExceeded):
+		return "quota_exceeded"
+	case errors.Is(err, gordafarid.ErrReplyLimitExceeded):
+		return "limit_exceeded"
+	case errors.Is(err, gordafarid.ErrReplyFailed):
+		return "failed"
+	default:
+		return ""
+	}
+}
+
+// startMetrics starts serving the metrics on the metrics.address, if it's enabled.
+func (c *Client) startMetrics() error {
+	if !c.cfg.Metrics.IsEnabled() {
+		return nil
+	}
+	c.metricsServer = metrics.NewServer(c.cfg.Metrics.Address, c.metrics.registry)
+	addr, err := c.metricsServer.Listen(func(err error) {
+		logger.Error(err)
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Metrics are served on: http://", addr, "/metrics")
+	return nil
+}
+
+// stopMetrics stops serving the metrics, if it's running.
+func (c *Client) stopMetrics() error {
+	if c.metricsServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+	return c.metricsServer.Shutdown(ctx)
+}
@@ -2,18 +2,55 @@
 package config
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
-	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aes_gcm"
 )
 
 // clientAddr holds the configuration for the client
 type clientAddr struct {
-	Address      string `toml:"address"`      // The address for the client to connect to
-	InitPassword string `toml:"initPassword"` // The password used for sending client's initial greeting (in the server we decrypt it)
+	Address             string `toml:"address"`             // The address for the client to connect to
+	InitPassword        string `toml:"initPassword"`        // The password used for sending client's initial greeting (in the server we decrypt it)
+	InitCryptoAlgorithm string `toml:"initCryptoAlgorithm"` // The AEAD algorithm used for encrypting the client's initial greeting
+	SendSessionID       bool   `toml:"sendSessionID"`       // Send the session ID in the greeting, so the server logs it too (OPTIONAL, requires a server supporting it)
+	MaxHandshakes       int    `toml:"maxHandshakes"`       // SOCKS5 handshakes in flight, the excess connections are closed (OPTIONAL, 0 means unlimited)
+}
+
+// clientAccount holds the client's account, the key material is either the password, the key,
+// or a passphrase the key is derived from along with its salt (see the keygen command).
+type clientAccount struct {
+	Account
+	Passphrase string `toml:"passphrase"` // The passphrase the key material is derived from with scrypt, an alternative to the password and the key
+	Salt       string `toml:"salt"`       // Base64-encoded salt of the passphrase, required with it
+
+	key []byte // The key material, set by the validation
+}
+
+// KeyMaterial returns the key material of the account, validated against the crypto algorithm.
+func (ca *clientAccount) KeyMaterial() []byte {
+	return ca.key
+}
+
+// deriveKeyMaterial returns the key material of the account for the crypto algorithm.
+// It is derived from the passphrase if set, otherwise it's the decoded key or the raw password.
+func (ca *clientAccount) deriveKeyMaterial(cryptoAlgorithm string) ([]byte, error) {
+	if len(ca.Passphrase) < 1 {
+		return ca.Account.KeyMaterial()
+	}
+	salt, err := base64.StdEncoding.DecodeString(ca.Salt)
+	if err != nil {
+		return nil, errors.Join(errInvalidBase64Salt, err)
+	}
+	keySize, err := aead.GetAlgorithmKeySize(cryptoAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return gordafarid.DeriveKey(ca.Passphrase, salt, keySize)
 }
 
 // socks5credentialsConfig is a map of usernames to passwords for SOCKS5 authentication
@@ -24,9 +61,12 @@ type ClientConfig struct {
 	Server            serverAddr              `toml:"server"`            // Server configuration
 	Client            clientAddr              `toml:"client"`            // Client configuration
 	CryptoAlgorithm   string                  `toml:"cryptoAlgorithm"`   // Encryption algorithm to use
-	Account           Account                 `toml:"account"`           // User account information
+	Account           clientAccount           `toml:"account"`           // User account information
 	Timeout           timeoutConfig           `toml:"timeout"`           // Timeout settings
 	Socks5Credentials socks5credentialsConfig `toml:"socks5Credentials"` // SOCKS5 authentication credentials for client side
+	Metrics           metricsConfig           `toml:"metrics"`           // Prometheus metrics endpoint (OPTIONAL)
+	AccessLog         accessLogConfig         `toml:"accessLog"`         // Access log of the proxied connections (OPTIONAL)
+	Log               logConfig               `toml:"log"`               // Level, format and output of the logs (OPTIONAL)
 }
 
 // loadClientConfig reads and parses the client configuration from a TOML file
@@ -39,12 +79,12 @@ func loadClientConfig(path string) (*ClientConfig, error) {
 	if _, err = toml.DecodeFile(path, &config); err != nil {
 		return nil, err
 	}
+	// Apply default values for any unspecified fields
+	config.applyDefaultValues()
 	// Validate the configuration
 	if err = config.validate(); err != nil {
 		return nil, err
 	}
-	// Apply default values for any unspecified fields
-	config.applyDefaultValues()
 	return &config, nil
 }
 
@@ -75,28 +115,60 @@ func (cc *ClientConfig) validate() error {
 	if len(cc.Account.Username) < 1 {
 		missingFields = append(missingFields, "account.username")
 	}
-	if len(cc.Account.Password) < 1 {
-		missingFields = append(missingFields, "account.password")
+	if len(cc.Account.Password) < 1 && len(cc.Account.Key) < 1 && len(cc.Account.Passphrase) < 1 {
+		missingFields = append(missingFields, "account.password (or account.key, or account.passphrase)")
 	}
-	// Check if InitPassword is supported by AES algorithm
-	if !aes_gcm.IsAESPasswordSupported(cc.Client.InitPassword) {
-		return fmt.Errorf("the client.initPassword must be 32 bytes")
+	if len(cc.Account.Passphrase) > 0 && len(cc.Account.Salt) < 1 {
+		missingFields = append(missingFields, "account.salt")
 	}
 	// If any required fields are missing, return an error
 	if len(missingFields) > 0 {
 		return fmt.Errorf("missing fields: %s", strings.Join(missingFields, ", "))
 	}
+	// Check if InitPassword is supported by the init crypto algorithm
+	if err := aead.IsCryptoSupported(cc.Client.InitCryptoAlgorithm, cc.Client.InitPassword); err != nil {
+		keyLength, _ := aead.GetAlgorithmKeySize(cc.Client.InitCryptoAlgorithm)
+		return fmt.Errorf("the client.initPassword doesn't match the client.initCryptoAlgorithm, the required length is %d: %w", keyLength, err)
+	}
 
-	// Validate the crypto algorithm and password
-	if err := aead.IsCryptoSupported(cc.CryptoAlgorithm, cc.Account.Password); err != nil {
+	keyMaterials := 0
+	for _, field := range []string{cc.Account.Password, cc.Account.Key, cc.Account.Passphrase} {
+		if len(field) > 0 {
+			keyMaterials++
+		}
+	}
+	if keyMaterials > 1 {
+		return fmt.Errorf("only one of account.password, account.key and account.passphrase is allowed")
+	}
+	if cc.Client.MaxHandshakes < 0 {
+		return fmt.Errorf("the client.maxHandshakes must not be negative")
+	}
+
+	// Validate the crypto algorithm and key material
+	key, err := cc.Account.deriveKeyMaterial(cc.CryptoAlgorithm)
+	if err != nil {
+		return err
+	}
+	if err := aead.IsCryptoSupported(cc.CryptoAlgorithm, string(key)); err != nil {
+		return err
+	}
+	cc.Account.key = key
+	if err := cc.AccessLog.validate(); err != nil {
+		return err
+	}
+	if err := cc.Log.validate(); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// applyDefaultValues sets default timeout values if they are not specified in the configuration
+// applyDefaultValues sets default values if they are not specified in the configuration
 func (cc *ClientConfig) applyDefaultValues() {
+	// Set default init crypto algorithm to AES-256-GCM if not specified
+	if len(cc.Client.InitCryptoAlgorithm) < 1 {
+		cc.Client.InitCryptoAlgorithm = defaultInitCryptoAlgorithm
+	}
 	// Set default dial timeout to 10 seconds if not specified
 	if cc.Timeout.DialTimeout == 0 {
 		cc.Timeout.DialTimeout = 10
@@ -109,4 +181,8 @@ func (cc *ClientConfig) applyDefaultValues() {
 	if cc.Timeout.GordafaridHandshakeTimeout == 0 {
 		cc.Timeout.GordafaridHandshakeTimeout = 10
 	}
+	// Set default shutdown timeout to 30 seconds if not specified
+	if cc.Timeout.ShutdownTimeout == 0 {
+		cc.Timeout.ShutdownTimeout = 30
+	}
 }
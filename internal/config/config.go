@@ -2,23 +2,124 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/Iam54r1n4/Gordafarid/internal/logger"
 )
 
+// defaultInitCryptoAlgorithm is the AEAD algorithm used for the client's initial greeting if none is configured.
+const defaultInitCryptoAlgorithm = "aes-256-gcm"
+
 // timeoutConfig holds various timeout settings for the application.
 type timeoutConfig struct {
 	DialTimeout                int `toml:"dialTimeout"`                // Dial timeout in seconds
 	Socks5HandshakeTimeout     int `toml:"socks5HandshakeTimeout"`     // SOCKS5 handshake timeout in seconds
 	GordafaridHandshakeTimeout int `toml:"gordafaridHandshakeTimeout"` // Gordafarid handshake timeout in seconds
+	ShutdownTimeout            int `toml:"shutdownTimeout"`            // How long the active connections are drained on shutdown in seconds
+}
+
+// metricsConfig holds the settings of the Prometheus metrics endpoint.
+type metricsConfig struct {
+	Address string `toml:"address"` // The address the metrics are served on at /metrics, e.g. "127.0.0.1:9100" (OPTIONAL, disabled if empty)
+}
+
+// IsEnabled reports whether the metrics endpoint is enabled.
+func (mc *metricsConfig) IsEnabled() bool {
+	return len(mc.Address) > 0
+}
+
+// accessLogConfig holds the settings of the access log, a record per proxied connection.
+type accessLogConfig struct {
+	Path   string `toml:"path"`   // The access log file, appended to (OPTIONAL, disabled if empty)
+	Format string `toml:"format"` // The format of the records, "json" or "logfmt" (OPTIONAL, default "json")
+}
+
+// IsEnabled reports whether the access log is enabled.
+func (ac *accessLogConfig) IsEnabled() bool {
+	return len(ac.Path) > 0
+}
+
+// validate checks the format of the access log.
+func (ac *accessLogConfig) validate() error {
+	if len(ac.Format) > 0 && ac.Format != "json" && ac.Format != "logfmt" {
+		return fmt.Errorf("the accessLog.format must be json or logfmt: %q", ac.Format)
+	}
+	return nil
+}
+
+// logConfig holds the settings of the logger.
+type logConfig struct {
+	Level         string `toml:"level"`         // debug, info, warn or error (OPTIONAL, default: "info")
+	Format        string `toml:"format"`        // "text" or "json" (OPTIONAL, default: "text")
+	Output        string `toml:"output"`        // "stdout", "file" or "syslog" (OPTIONAL, default: "stdout")
+	File          string `toml:"file"`          // The log file, required by the file output
+	MaxSize       int    `toml:"maxSize"`       // The size the log file is rotated at, in megabytes (OPTIONAL, default: 100)
+	MaxBackups    int    `toml:"maxBackups"`    // How many rotated log files are kept (OPTIONAL, default: 5)
+	SyslogAddress string `toml:"syslogAddress"` // The unix socket of the local syslog daemon (OPTIONAL, default: the well-known sockets, e.g. /dev/log)
+}
+
+// validate checks the level, format and output of the logger.
+func (lc *logConfig) validate() error {
+	if len(lc.Level) > 0 {
+		if _, err := logger.ParseLevel(lc.Level); err != nil {
+			return fmt.Errorf("the log.level must be debug, info, warn or error: %q", lc.Level)
+		}
+	}
+	switch lc.Format {
+	case "", logger.FormatText, logger.FormatJSON:
+	default:
+		return fmt.Errorf("the log.format must be text or json: %q", lc.Format)
+	}
+	switch lc.Output {
+	case "", logger.OutputStdout, logger.OutputSyslog:
+	case logger.OutputFile:
+		if len(lc.File) < 1 {
+			return fmt.Errorf("the log.file is required by the file output")
+		}
+	default:
+		return fmt.Errorf("the log.output must be stdout, file or syslog: %q", lc.Output)
+	}
+	if lc.MaxSize < 0 || lc.MaxBackups < 0 {
+		return fmt.Errorf("the log.maxSize and log.maxBackups must not be negative")
+	}
+	return nil
+}
+
+// LoggerConfig returns the settings of the logger, the syslog messages are tagged with the tag.
+func (lc *logConfig) LoggerConfig(tag string) logger.Config {
+	return logger.Config{
+		Level:         lc.Level,
+		Format:        lc.Format,
+		Output:        lc.Output,
+		File:          lc.File,
+		MaxSize:       lc.MaxSize,
+		MaxBackups:    lc.MaxBackups,
+		SyslogAddress: lc.SyslogAddress,
+		SyslogTag:     tag,
+	}
 }
 
 // Account holds the account information for authentication.
 type Account struct {
 	Username string `toml:"username"` // Username for authentication
 	Password string `toml:"password"` // Password for authentication
+	Key      string `toml:"key"`      // Base64-encoded key material, an alternative to the password
+}
+
+// KeyMaterial returns the key material of the account.
+// It is the decoded Key if set, otherwise the raw Password.
+func (a *Account) KeyMaterial() ([]byte, error) {
+	if len(a.Key) < 1 {
+		return []byte(a.Password), nil
+	}
+	key, err := base64.StdEncoding.DecodeString(a.Key)
+	if err != nil {
+		return nil, errors.Join(errInvalidBase64Key, err)
+	}
+	return key, nil
 }
 
 var (
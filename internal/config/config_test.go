@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+// writeConfig writes the config file to a temporary directory and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSampleConfigs(t *testing.T) {
+	sc, err := loadServerConfig("../../cmd/server/config.toml")
+	if err != nil {
+		t.Fatalf("loading the sample server config: %v", err)
+	}
+	for _, cred := range sc.Credentials {
+		if len(cred.Password) > 0 {
+			t.Errorf("the sample server config holds the plaintext password of %q", cred.Username)
+		}
+	}
+
+	cc, err := loadClientConfig("../../cmd/client/config.toml")
+	if err != nil {
+		t.Fatalf("loading the sample client config: %v", err)
+	}
+	// The sample client's account must be one of the sample server's
+	for _, cred := range sc.Credentials {
+		if cred.Username != cc.Account.Username {
+			continue
+		}
+		key, err := cred.KeyMaterial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, cc.Account.KeyMaterial()) {
+			t.Fatalf("the sample client's key doesn't match the sample server's %q", cred.Username)
+		}
+		return
+	}
+	t.Fatalf("the sample client's account %q isn't in the sample server config", cc.Account.Username)
+}
+
+func TestServerConfigRejectsMismatchedKeyID(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	otherKeyID := gordafarid.DeriveKeyID("bob", string(key))
+	path := writeConfig(t, `
+cryptoAlgorithm = "chacha20-poly1305"
+credentials = [
+    { username = "alice", keyId = "`+base64.StdEncoding.EncodeToString(otherKeyID[:])+`", key = "`+base64.StdEncoding.EncodeToString(key)+`" },
+]
+[server]
+address = "127.0.0.1:9090"
+initPassword = "00000000000000000000000000000000"
+`)
+	if _, err := loadServerConfig(path); err == nil || !strings.Contains(err.Error(), "keyId not matching") {
+		t.Fatalf("err = %v, want the keyId mismatch", err)
+	}
+}
+
+func TestServerConfigRejectsReservedInitKeyID(t *testing.T) {
+	path := writeConfig(t, `
+cryptoAlgorithm = "chacha20-poly1305"
+[server]
+address = "127.0.0.1:9090"
+[[initKeys]]
+id = "`+gordafarid.DefaultInitKeyID+`"
+initPassword = "11111111111111111111111111111111"
+credentials = [{ username = "alice", password = "alice000000000000000000000000000" }]
+`)
+	if _, err := loadServerConfig(path); err == nil || !strings.Contains(err.Error(), "reserved id") {
+		t.Fatalf("err = %v, want the reserved init key id", err)
+	}
+}
+
+func TestServerConfigRejectsCredentialsWithoutInitPassword(t *testing.T) {
+	for name, field := range map[string]string{
+		"credentials":     `credentials = [{ username = "alice", password = "alice000000000000000000000000000" }]`,
+		"credentialsFile": `credentialsFile = "users.json"`,
+		"authorizer":      "[authorizer]\nurl = \"http://127.0.0.1:8080/authorize\"",
+	} {
+		path := writeConfig(t, `
+cryptoAlgorithm = "chacha20-poly1305"
+`+field+`
+[server]
+address = "127.0.0.1:9090"
+[[initKeys]]
+id = "tenant-b"
+initPassword = "11111111111111111111111111111111"
+credentials = [{ username = "alice", password = "alice000000000000000000000000000" }]
+`)
+		if _, err := loadServerConfig(path); err == nil || !strings.Contains(err.Error(), "require the server.initPassword") {
+			t.Fatalf("%s: err = %v, want the missing server.initPassword", name, err)
+		}
+	}
+}
+
+func TestClientConfigDerivesPassphrase(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	path := writeConfig(t, `
+cryptoAlgorithm = "chacha20-poly1305"
+[account]
+username = "alice"
+passphrase = "correct horse battery staple"
+salt = "`+base64.StdEncoding.EncodeToString(salt)+`"
+[client]
+address = "127.0.0.1:8080"
+initPassword = "00000000000000000000000000000000"
+[server]
+address = "127.0.0.1:9090"
+`)
+	cc, err := loadClientConfig(path)
+	if err != nil {
+		t.Fatalf("loadClientConfig: %v", err)
+	}
+	want, err := gordafarid.DeriveKey("correct horse battery staple", salt, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cc.Account.KeyMaterial(), want) {
+		t.Fatal("the client's key isn't derived from the passphrase and salt")
+	}
+}
@@ -5,4 +5,8 @@ import "errors"
 var (
 	errInvalidConfigFile      = errors.New("invalid config file")
 	errEmptyServerCredentials = errors.New("server.credentials is empty")
+	errInvalidBase64Key       = errors.New("the key is not valid base64")
+	errInvalidBase64KeyID     = errors.New("the keyId is not valid base64")
+	errInvalidBase64Salt      = errors.New("the salt is not valid base64")
+	errInvalidKeyIDLength     = errors.New("the keyId must decode to 32 bytes")
 )
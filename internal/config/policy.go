@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/acl"
+)
+
+// aclRuleConfig holds an allow/deny rule of a policy.
+// The rule matches a destination if the destination satisfies all of its non-empty criteria.
+type aclRuleConfig struct {
+	Action         string   `toml:"action"`         // "allow" or "deny"
+	CIDRs          []string `toml:"cidrs"`          // IP ranges like "10.0.0.0/8", or plain IP addresses
+	Domains        []string `toml:"domains"`        // Exact domain names
+	DomainSuffixes []string `toml:"domainSuffixes"` // Domain suffixes like ".example.com", also matching "example.com" itself
+	DomainRegexes  []string `toml:"domainRegexes"`  // Regular expressions matched against the whole domain
+	Ports          []string `toml:"ports"`          // Single ports like "25", or ranges like "8000-9000"
+}
+
+// PolicyConfig holds a named destination access control list, which the accounts refer to by the "policy" attribute.
+type PolicyConfig struct {
+	DefaultAction      string          `toml:"defaultAction"`      // The action applied when no rule matches, "allow" or "deny"
+	AllowSpecialRanges []string        `toml:"allowSpecialRanges"` // The special-purpose ranges (private, loopback, ...) the accounts may reach (OPTIONAL)
+	Rules              []aclRuleConfig `toml:"rules"`              // The rules, the first matching rule decides
+}
+
+// Build converts the PolicyConfig into an acl.Policy with the given name.
+func (pc *PolicyConfig) Build(name string) (*acl.Policy, error) {
+	rules := make([]*acl.Rule, 0, len(pc.Rules))
+	for i, rc := range pc.Rules {
+		rule, err := acl.NewRule(rc.Action, rc.CIDRs, rc.Domains, rc.DomainSuffixes, rc.DomainRegexes, rc.Ports)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("element at index %d is invalid in policies.%s.rules", i, name), err)
+		}
+		rules = append(rules, rule)
+	}
+	policy, err := acl.NewPolicy(name, pc.DefaultAction, pc.AllowSpecialRanges, rules...)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("the policies.%s is invalid", name), err)
+	}
+	return policy, nil
+}
+
+// applyDefaultValues sets default values if they are not specified in the configuration.
+func (pc *PolicyConfig) applyDefaultValues() {
+	// Set default DefaultAction to allow if not specified
+	if len(pc.DefaultAction) < 1 {
+		pc.DefaultAction = acl.ActionAllow
+	}
+}
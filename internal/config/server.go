@@ -2,25 +2,189 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/netip"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/Iam54r1n4/Gordafarid/internal/acl"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
 )
 
 // serverAddr holds the configuration for the server
 type serverAddr struct {
-	Address      string `toml:"address"`      // The address for the server to listen on
-	InitPassword string `toml:"initPassword"` // The password used for sending client's initial greeting (in the server we decrypt it)
+	Address             string `toml:"address"`             // The address for the server to listen on
+	InitPassword        string `toml:"initPassword"`        // The password used for sending client's initial greeting (in the server we decrypt it)
+	InitCryptoAlgorithm string `toml:"initCryptoAlgorithm"` // The AEAD algorithm used for decrypting the client's initial greeting
+}
+
+// Credential represents a server-side account entry.
+// The key material is either the plaintext password or a base64-encoded key
+// (along with its optional keyId), so the config file doesn't need to hold human passwords.
+type Credential struct {
+	Account
+	KeyID             string            `toml:"keyId"`             // Base64-encoded account hash, derived from the username and key if empty, must match them otherwise
+	Attributes        map[string]string `toml:"attributes"`        // Policy attributes of the account, e.g. { policy = "restricted" } (OPTIONAL)
+	UploadRateLimit   int64             `toml:"uploadRateLimit"`   // Upload bandwidth limit of the account in bytes per second (OPTIONAL, 0 means unlimited)
+	DownloadRateLimit int64             `toml:"downloadRateLimit"` // Download bandwidth limit of the account in bytes per second (OPTIONAL, 0 means unlimited)
+	DailyQuota        int64             `toml:"dailyQuota"`        // Traffic quota of the account per day (UTC) in bytes (OPTIONAL, 0 means unlimited)
+	MonthlyQuota      int64             `toml:"monthlyQuota"`      // Traffic quota of the account per month (UTC) in bytes (OPTIONAL, 0 means unlimited)
+	TotalQuota        int64             `toml:"totalQuota"`        // Total traffic quota of the account in bytes (OPTIONAL, 0 means unlimited)
+	MaxConnections    int               `toml:"maxConnections"`    // Concurrent connections of the account, overrides limits.maxConnectionsPerUser (OPTIONAL)
+	MaxIPs            int               `toml:"maxIPs"`            // Distinct client IPs of the account, overrides limits.maxIPsPerUser (OPTIONAL)
+	NotBefore         time.Time         `toml:"notBefore"`         // The account can't authenticate before this time (OPTIONAL)
+	ExpiresAt         time.Time         `toml:"expiresAt"`         // The account can't authenticate after this time (OPTIONAL)
+	Disabled          bool              `toml:"disabled"`          // The account can't authenticate (OPTIONAL)
+	AllowedHours      []string          `toml:"allowedHours"`      // "HH:MM-HH:MM" windows the account can authenticate in, e.g. ["09:00-18:00"] (OPTIONAL)
+	Timezone          string            `toml:"timezone"`          // IANA time zone of the allowedHours (OPTIONAL, default: the server's local time zone)
+}
+
+// limitsConfig holds the server-wide concurrent connection limits, 0 means unlimited.
+type limitsConfig struct {
+	MaxConnectionsPerUser int `toml:"maxConnectionsPerUser"` // Concurrent connections per account
+	MaxConnectionsPerIP   int `toml:"maxConnectionsPerIP"`   // Concurrent connections per client IP
+	MaxIPsPerUser         int `toml:"maxIPsPerUser"`         // Distinct client IPs of the concurrent connections per account
+	MaxHandshakes         int `toml:"maxHandshakes"`         // Handshakes in flight, the excess connections are closed
+}
+
+// bansConfig holds the settings of the client IP bans on repeated handshake failures.
+type bansConfig struct {
+	MaxFailures       int      `toml:"maxFailures"`       // Failed handshakes of a client IP within failureWindow to ban it (OPTIONAL, 0 disables the bans)
+	FailureWindow     int      `toml:"failureWindow"`     // Window of the counted failures in seconds
+	BanDuration       int      `toml:"banDuration"`       // How long the client IPs are banned in seconds
+	ReplayBanDuration int      `toml:"replayBanDuration"` // How long the client IPs replaying a greeting are banned in seconds, right away (OPTIONAL, default: banDuration)
+	Exempt            []string `toml:"exempt"`            // CIDRs or IPs never banned (OPTIONAL)
+	StateFile         string   `toml:"stateFile"`         // The JSON file the bans are persisted to (OPTIONAL, kept in memory if empty)
+}
+
+// IsEnabled reports whether the client IP bans are enabled.
+func (bc *bansConfig) IsEnabled() bool {
+	return bc.MaxFailures > 0
+}
+
+// adminConfig holds the settings of the admin HTTP API.
+type adminConfig struct {
+	Address string `toml:"address"` // The address of the admin API, e.g. "127.0.0.1:9091" (OPTIONAL, disabled if empty)
+	Token   string `toml:"token"`   // Bearer token of the admin API requests, required if the admin API is enabled
+}
+
+// IsEnabled reports whether the admin API is enabled.
+func (ac *adminConfig) IsEnabled() bool {
+	return len(ac.Address) > 0
+}
+
+// quotaConfig holds the settings of the traffic accounting.
+type quotaConfig struct {
+	StateFile    string `toml:"stateFile"`    // The JSON file the traffic counters are persisted to (OPTIONAL, kept in memory if empty)
+	SaveInterval int    `toml:"saveInterval"` // How often the traffic counters are saved in seconds
+}
+
+// rateLimitConfig holds the server-wide bandwidth limits, shared by all the connections.
+type rateLimitConfig struct {
+	Upload   int64 `toml:"upload"`   // Upload limit in bytes per second (OPTIONAL, 0 means unlimited)
+	Download int64 `toml:"download"` // Download limit in bytes per second (OPTIONAL, 0 means unlimited)
+}
+
+// DecodeKeyID returns the decoded KeyID, or nil if it is not set.
+func (c *Credential) DecodeKeyID() ([]byte, error) {
+	if len(c.KeyID) < 1 {
+		return nil, nil
+	}
+	keyID, err := base64.StdEncoding.DecodeString(c.KeyID)
+	if err != nil {
+		return nil, errors.Join(errInvalidBase64KeyID, err)
+	}
+	if len(keyID) != sha256.Size {
+		return nil, errInvalidKeyIDLength
+	}
+	return keyID, nil
+}
+
+// AuthorizerConfig holds the settings of an external authorizer, which looks up the accounts
+// by the account hash (key ID), either by running a local executable or by calling an HTTP endpoint.
+type AuthorizerConfig struct {
+	Exec     []string `toml:"exec"`     // The executable and its arguments, the key ID is appended
+	URL      string   `toml:"url"`      // The HTTP endpoint, the key ID is sent in the "keyId" query parameter
+	Token    string   `toml:"token"`    // Bearer token sent to the HTTP endpoint (OPTIONAL)
+	CacheTTL int      `toml:"cacheTTL"` // How long the lookup results are cached in seconds
+}
+
+// IsEnabled checks if the external authorizer is configured.
+func (ac *AuthorizerConfig) IsEnabled() bool {
+	return len(ac.Exec) > 0 || len(ac.URL) > 0
+}
+
+// validate checks the AuthorizerConfig for any invalid fields.
+// The field parameter is the name of the config section, used in the returned error.
+func (ac *AuthorizerConfig) validate(field string) error {
+	if len(ac.Exec) > 0 && len(ac.URL) > 0 {
+		return fmt.Errorf("only one of %s.exec and %s.url is allowed", field, field)
+	}
+	if len(ac.Exec) > 0 && len(ac.Exec[0]) < 1 {
+		return fmt.Errorf("the %s.exec executable is empty", field)
+	}
+	if len(ac.URL) > 0 {
+		u, err := url.Parse(ac.URL)
+		if err != nil {
+			return fmt.Errorf("the %s.url is invalid: %w", field, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("the %s.url scheme must be http or https", field)
+		}
+	}
+	if ac.CacheTTL < 0 {
+		return fmt.Errorf("the %s.cacheTTL must not be negative", field)
+	}
+	return nil
+}
+
+// applyDefaultValues sets default values if they are not specified in the configuration.
+func (ac *AuthorizerConfig) applyDefaultValues() {
+	// Set default CacheTTL to 60 seconds if not specified
+	if ac.CacheTTL == 0 {
+		ac.CacheTTL = 60
+	}
+}
+
+// initKeyConfig holds an additional init key, along with the accounts that can authenticate through it.
+type initKeyConfig struct {
+	ID                  string           `toml:"id"`                  // The key identifier, used for logging
+	InitPassword        string           `toml:"initPassword"`        // The password used for decrypting the client's initial greeting
+	InitCryptoAlgorithm string           `toml:"initCryptoAlgorithm"` // The AEAD algorithm used for decrypting the client's initial greeting
+	NotBefore           time.Time        `toml:"notBefore"`           // The key is not accepted before this time (OPTIONAL)
+	NotAfter            time.Time        `toml:"notAfter"`            // The key is not accepted after this time (OPTIONAL)
+	Credentials         []Credential     `toml:"credentials"`         // List of user accounts that can authenticate through this key
+	CredentialsFile     string           `toml:"credentialsFile"`     // TOML/JSON user file, watched for changes (OPTIONAL)
+	Authorizer          AuthorizerConfig `toml:"authorizer"`          // External authorizer for this key (OPTIONAL)
 }
 
 // ServerConfig represents the main configuration structure for the Gordafarid server.
 type ServerConfig struct {
-	Server          serverAddr    `toml:"server"`          // Server address configuration
-	CryptoAlgorithm string        `toml:"cryptoAlgorithm"` // Cryptographic algorithm to be used
-	Credentials     []Account     `toml:"credentials"`     // List of user accounts for the Gordafarid authentication
-	Timeout         timeoutConfig `toml:"timeout"`         // Timeout settings
+	Server                       serverAddr              `toml:"server"`                       // Server address configuration
+	CryptoAlgorithm              string                  `toml:"cryptoAlgorithm"`              // Cryptographic algorithm to be used
+	Credentials                  []Credential            `toml:"credentials"`                  // List of user accounts for the Gordafarid authentication through server.initPassword
+	CredentialsFile              string                  `toml:"credentialsFile"`              // TOML/JSON user file for server.initPassword, watched for changes (OPTIONAL)
+	CredentialsFileCheckInterval int                     `toml:"credentialsFileCheckInterval"` // How often the user files are checked for changes in seconds
+	Authorizer                   AuthorizerConfig        `toml:"authorizer"`                   // External authorizer for server.initPassword (OPTIONAL)
+	InitKeys                     []initKeyConfig         `toml:"initKeys"`                     // Additional init keys, tried in order after server.initPassword
+	Policies                     map[string]PolicyConfig `toml:"policies"`                     // Named destination access control lists (OPTIONAL)
+	DefaultPolicy                string                  `toml:"defaultPolicy"`                // The policy of the accounts without a "policy" attribute (OPTIONAL, default: allow all)
+	RateLimit                    rateLimitConfig         `toml:"rateLimit"`                    // Server-wide bandwidth limits (OPTIONAL)
+	Quota                        quotaConfig             `toml:"quota"`                        // Traffic accounting settings (OPTIONAL)
+	Limits                       limitsConfig            `toml:"limits"`                       // Concurrent connection limits (OPTIONAL)
+	Bans                         bansConfig              `toml:"bans"`                         // Client IP bans on repeated handshake failures (OPTIONAL)
+	Admin                        adminConfig             `toml:"admin"`                        // Admin HTTP API (OPTIONAL)
+	Metrics                      metricsConfig           `toml:"metrics"`                      // Prometheus metrics endpoint (OPTIONAL)
+	AccessLog                    accessLogConfig         `toml:"accessLog"`                    // Access log of the proxied connections (OPTIONAL)
+	Log                          logConfig               `toml:"log"`                          // Level, format and output of the logs (OPTIONAL)
+	Timeout                      timeoutConfig           `toml:"timeout"`                      // Timeout settings
 }
 
 // loadServerConfig reads and parses the server configuration from a TOML file.
@@ -34,14 +198,14 @@ func loadServerConfig(path string) (*ServerConfig, error) {
 		return nil, err
 	}
 
+	// Apply default values for any unspecified fields
+	config.applyDefaultValues()
+
 	// Validate the configuration
 	if err = config.validate(); err != nil {
 		return nil, err
 	}
 
-	// Apply default values for any unspecified fields
-	config.applyDefaultValues()
-
 	return &config, nil
 }
 
@@ -50,9 +214,9 @@ func loadServerConfig(path string) (*ServerConfig, error) {
 func (sc *ServerConfig) validate() error {
 	var missingFields []string
 
-	// Check for missing required fields
-	if len(sc.Server.InitPassword) < 1 {
-		missingFields = append(missingFields, "server.initPassword")
+	// Check for missing required fields, the server.initPassword is optional if initKeys is set
+	if len(sc.Server.InitPassword) < 1 && len(sc.InitKeys) < 1 {
+		missingFields = append(missingFields, "server.initPassword (or initKeys)")
 	}
 	if len(sc.Server.Address) < 1 {
 		missingFields = append(missingFields, "server.address")
@@ -65,34 +229,212 @@ func (sc *ServerConfig) validate() error {
 	if len(missingFields) > 0 {
 		return fmt.Errorf("missing fields: %s", strings.Join(missingFields, ", "))
 	}
-	// Check if InitPassword is 32 bytes
-	if len(sc.Server.InitPassword) != 32 {
-		return fmt.Errorf("the server.initPassword must be 32 bytes")
+
+	if len(sc.Server.InitPassword) > 0 {
+		// Check if InitPassword is supported by the init crypto algorithm
+		if err := aead.IsCryptoSupported(sc.Server.InitCryptoAlgorithm, sc.Server.InitPassword); err != nil {
+			keyLength, _ := aead.GetAlgorithmKeySize(sc.Server.InitCryptoAlgorithm)
+			return fmt.Errorf("the server.initPassword doesn't match the server.initCryptoAlgorithm, the required length is %d: %w", keyLength, err)
+		}
+		// Validate the server credentials
+		if err := sc.Authorizer.validate("authorizer"); err != nil {
+			return err
+		}
+		if err := sc.validateCredentials(sc.Credentials, len(sc.CredentialsFile) > 0 || sc.Authorizer.IsEnabled(), "credentials"); err != nil {
+			return err
+		}
+	} else if len(sc.Credentials) > 0 || len(sc.CredentialsFile) > 0 || sc.Authorizer.IsEnabled() {
+		// The top-level accounts belong to the server.initPassword's key, they'd never be used without it
+		return fmt.Errorf("the credentials, credentialsFile and authorizer require the server.initPassword, set them in initKeys instead")
+	}
+
+	// Validate the policies, the accounts' policy attributes are checked along with the credentials
+	for name, pc := range sc.Policies {
+		if _, err := pc.Build(name); err != nil {
+			return err
+		}
+	}
+	if len(sc.DefaultPolicy) > 0 {
+		if _, exists := sc.Policies[sc.DefaultPolicy]; !exists {
+			return fmt.Errorf("the defaultPolicy %q is not defined in policies", sc.DefaultPolicy)
+		}
+	}
+
+	if sc.RateLimit.Upload < 0 || sc.RateLimit.Download < 0 {
+		return fmt.Errorf("the rateLimit.upload and rateLimit.download must not be negative")
+	}
+
+	if sc.Limits.MaxConnectionsPerUser < 0 || sc.Limits.MaxConnectionsPerIP < 0 || sc.Limits.MaxIPsPerUser < 0 || sc.Limits.MaxHandshakes < 0 {
+		return fmt.Errorf("the limits must not be negative")
+	}
+
+	if sc.Bans.MaxFailures < 0 || sc.Bans.FailureWindow < 0 || sc.Bans.BanDuration < 0 || sc.Bans.ReplayBanDuration < 0 {
+		return fmt.Errorf("the bans settings must not be negative")
+	}
+	for i, exempt := range sc.Bans.Exempt {
+		if _, err := netip.ParsePrefix(exempt); err != nil {
+			if _, err = netip.ParseAddr(exempt); err != nil {
+				return fmt.Errorf("element at index %d is not a valid CIDR or IP in bans.exempt: %q", i, exempt)
+			}
+		}
+	}
+
+	if sc.Admin.IsEnabled() && len(sc.Admin.Token) < 1 {
+		return fmt.Errorf("the admin.token is required if the admin API is enabled")
+	}
+	if err := sc.AccessLog.validate(); err != nil {
+		return err
 	}
-	// Validate the server credentials
-	if len(sc.Credentials) < 1 {
-		return errEmptyServerCredentials
+	if err := sc.Log.validate(); err != nil {
+		return err
 	}
-	// Validate each credential
-	for i, cred := range sc.Credentials {
+
+	// Validate each init key
+	initKeyIDs := make(map[string]struct{}, len(sc.InitKeys))
+	for i, ik := range sc.InitKeys {
+		if len(ik.ID) < 1 {
+			return fmt.Errorf("element at index %d has empty id in initKeys", i)
+		}
+		// The reserved ID is the server.initPassword's key, e.g. in the metrics, the access log and the admin API
+		if ik.ID == gordafarid.DefaultInitKeyID {
+			return fmt.Errorf("element at index %d has the reserved id %q in initKeys", i, ik.ID)
+		}
+		if _, exists := initKeyIDs[ik.ID]; exists {
+			return fmt.Errorf("element at index %d has duplicated id %q in initKeys", i, ik.ID)
+		}
+		initKeyIDs[ik.ID] = struct{}{}
+
+		if err := aead.IsCryptoSupported(ik.InitCryptoAlgorithm, ik.InitPassword); err != nil {
+			keyLength, _ := aead.GetAlgorithmKeySize(ik.InitCryptoAlgorithm)
+			return fmt.Errorf("element at index %d has initPassword that doesn't match its initCryptoAlgorithm in initKeys, the required length is %d: %w", i, keyLength, err)
+		}
+		if !ik.NotBefore.IsZero() && !ik.NotAfter.IsZero() && !ik.NotAfter.After(ik.NotBefore) {
+			return fmt.Errorf("element at index %d has notAfter before its notBefore in initKeys", i)
+		}
+		if err := ik.Authorizer.validate(fmt.Sprintf("initKeys[%d].authorizer", i)); err != nil {
+			return err
+		}
+		if err := sc.validateCredentials(ik.Credentials, len(ik.CredentialsFile) > 0 || ik.Authorizer.IsEnabled(), fmt.Sprintf("initKeys[%d].credentials", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCredentials checks each credential of a credentials list for any missing or invalid fields.
+// The list may only be empty if the accounts come from other sources (a user file or an external authorizer).
+// The field parameter is the name of the list, used in the returned error.
+func (sc *ServerConfig) validateCredentials(credentials []Credential, hasOtherSources bool, field string) error {
+	if len(credentials) < 1 && !hasOtherSources {
+		return errors.Join(errEmptyServerCredentials, fmt.Errorf("field: %s", field))
+	}
+	for i, cred := range credentials {
 		if len(cred.Username) < 1 {
-			return fmt.Errorf("element at index %d has empty username in credentials", i)
+			return fmt.Errorf("element at index %d has empty username in %s", i, field)
 		}
-		if len(cred.Password) < 1 {
-			return fmt.Errorf("element at index %d has empty password in credentials", i)
+		if len(cred.Password) < 1 && len(cred.Key) < 1 {
+			return fmt.Errorf("element at index %d has neither password nor key in %s", i, field)
+		}
+		if len(cred.Password) > 0 && len(cred.Key) > 0 {
+			return fmt.Errorf("element at index %d has both password and key in %s, only one is allowed", i, field)
+		}
+		keyID, err := cred.DecodeKeyID()
+		if err != nil {
+			return fmt.Errorf("element at index %d has invalid keyId in %s: %w", i, field, err)
+		}
+		key, err := cred.KeyMaterial()
+		if err != nil {
+			return fmt.Errorf("element at index %d has invalid key in %s: %w", i, field, err)
+		}
+		// The clients always send the account hash of their username and key, so a different keyId can never match
+		if derivedKeyID := gordafarid.DeriveKeyID(cred.Username, string(key)); keyID != nil && !bytes.Equal(keyID, derivedKeyID[:]) {
+			return fmt.Errorf("element at index %d has a keyId not matching its username and key in %s, regenerate it with the keygen command", i, field)
 		}
 
-		// Check if the crypto algorithm is supported and the password meets the requirements
-		if err := aead.IsCryptoSupported(sc.CryptoAlgorithm, cred.Password); err != nil {
+		// Check if the crypto algorithm is supported and the key material meets the requirements
+		if err := aead.IsCryptoSupported(sc.CryptoAlgorithm, string(key)); err != nil {
 			keyLength, _ := aead.GetAlgorithmKeySize(sc.CryptoAlgorithm)
-			return fmt.Errorf("element at index %d has invalid password in credentials, the required length is %d", i, keyLength)
+			return fmt.Errorf("element at index %d has invalid password in %s, the required length is %d", i, field, keyLength)
+		}
+
+		if cred.UploadRateLimit < 0 || cred.DownloadRateLimit < 0 {
+			return fmt.Errorf("element at index %d has negative uploadRateLimit or downloadRateLimit in %s", i, field)
+		}
+
+		if cred.DailyQuota < 0 || cred.MonthlyQuota < 0 || cred.TotalQuota < 0 {
+			return fmt.Errorf("element at index %d has negative dailyQuota, monthlyQuota or totalQuota in %s", i, field)
+		}
+
+		if cred.MaxConnections < 0 || cred.MaxIPs < 0 {
+			return fmt.Errorf("element at index %d has negative maxConnections or maxIPs in %s", i, field)
+		}
+
+		if !cred.NotBefore.IsZero() && !cred.ExpiresAt.IsZero() && !cred.NotBefore.Before(cred.ExpiresAt) {
+			return fmt.Errorf("element at index %d has notBefore after its expiresAt in %s", i, field)
+		}
+
+		if _, err := gordafarid.ParseAccessWindows(cred.AllowedHours, cred.Timezone); err != nil {
+			return errors.Join(fmt.Errorf("element at index %d has invalid allowedHours or timezone in %s", i, field), err)
+		}
+
+		// Check if the account's policy is defined
+		if policy, exists := cred.Attributes[acl.PolicyAttribute]; exists {
+			if _, exists = sc.Policies[policy]; !exists {
+				return fmt.Errorf("element at index %d has undefined policy %q in %s", i, policy, field)
+			}
 		}
 	}
 	return nil
 }
 
-// applyDefaultValues sets default timeout values if they are not specified in the configuration.
+// ValidateCredential checks a single account entry the same way as the configured ones, e.g. an account added through the admin API.
+func (sc *ServerConfig) ValidateCredential(cred Credential) error {
+	return sc.validateCredentials([]Credential{cred}, false, "credential")
+}
+
+// applyDefaultValues sets default values if they are not specified in the configuration.
 func (sc *ServerConfig) applyDefaultValues() {
+	// Set default init crypto algorithm to AES-256-GCM if not specified
+	if len(sc.Server.InitCryptoAlgorithm) < 1 {
+		sc.Server.InitCryptoAlgorithm = defaultInitCryptoAlgorithm
+	}
+	for i := range sc.InitKeys {
+		if len(sc.InitKeys[i].InitCryptoAlgorithm) < 1 {
+			sc.InitKeys[i].InitCryptoAlgorithm = defaultInitCryptoAlgorithm
+		}
+		sc.InitKeys[i].Authorizer.applyDefaultValues()
+	}
+	sc.Authorizer.applyDefaultValues()
+	for name, pc := range sc.Policies {
+		pc.applyDefaultValues()
+		sc.Policies[name] = pc
+	}
+
+	// Set default CredentialsFileCheckInterval to 5 seconds if not specified
+	if sc.CredentialsFileCheckInterval == 0 {
+		sc.CredentialsFileCheckInterval = 5
+	}
+
+	// Set default Quota.SaveInterval to 30 seconds if not specified
+	if sc.Quota.SaveInterval == 0 {
+		sc.Quota.SaveInterval = 30
+	}
+
+	// Set default Bans.FailureWindow to 10 minutes if not specified
+	if sc.Bans.FailureWindow == 0 {
+		sc.Bans.FailureWindow = 600
+	}
+
+	// Set default Bans.BanDuration to 1 hour if not specified
+	if sc.Bans.BanDuration == 0 {
+		sc.Bans.BanDuration = 3600
+	}
+
+	// Set default Bans.ReplayBanDuration to the Bans.BanDuration if not specified
+	if sc.Bans.ReplayBanDuration == 0 {
+		sc.Bans.ReplayBanDuration = sc.Bans.BanDuration
+	}
+
 	// Set default DialTimeout to 10 seconds if not specified
 	if sc.Timeout.DialTimeout == 0 {
 		sc.Timeout.DialTimeout = 10
@@ -107,4 +449,9 @@ func (sc *ServerConfig) applyDefaultValues() {
 	if sc.Timeout.GordafaridHandshakeTimeout == 0 {
 		sc.Timeout.GordafaridHandshakeTimeout = 10
 	}
+
+	// Set default ShutdownTimeout to 30 seconds if not specified
+	if sc.Timeout.ShutdownTimeout == 0 {
+		sc.Timeout.ShutdownTimeout = 30
+	}
 }
@@ -0,0 +1,117 @@
+// Package drain keeps track of the connections being handled, so they can be drained on shutdown:
+// the shutdown waits for the handlers to finish, and closes their connections once its deadline is reached.
+package drain
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Group tracks the handlers of the connections, and the connections they use.
+// The zero value is ready to use. It's safe for concurrent use.
+type Group struct {
+	mu       sync.Mutex
+	conns    map[io.Closer]struct{} // Connections of the active handlers
+	draining bool                   // No new handlers are accepted
+	closing  bool                   // The connections are being closed, new ones are closed right away
+	handlers sync.WaitGroup
+
+	ctx    context.Context // Cancelled once the connections are being closed
+	cancel context.CancelFunc
+}
+
+// init initializes the context of the zero value, the caller must hold the lock.
+func (g *Group) init() {
+	if g.ctx == nil {
+		g.ctx, g.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// Context returns a context that's cancelled once the drain deadline is reached,
+// so the blocking operations of the handlers (e.g. dialing) are cancelled along with their connections.
+func (g *Group) Context() context.Context {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.init()
+	return g.ctx
+}
+
+// Enter registers a new handler, and reports whether it's accepted.
+// If the group is draining, the handler isn't accepted, and it must not run.
+// Every accepted handler must call Leave once it's finished.
+func (g *Group) Enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	g.handlers.Add(1)
+	return true
+}
+
+// Leave marks a handler registered by Enter as finished.
+func (g *Group) Leave() {
+	g.handlers.Done()
+}
+
+// Track adds the connection of a handler, so it's closed if the drain deadline is reached.
+// If the deadline is already reached, the connection is closed right away.
+func (g *Group) Track(c io.Closer) {
+	g.mu.Lock()
+	if g.closing {
+		g.mu.Unlock()
+		c.Close()
+		return
+	}
+	if g.conns == nil {
+		g.conns = make(map[io.Closer]struct{})
+	}
+	g.conns[c] = struct{}{}
+	g.mu.Unlock()
+}
+
+// Untrack removes the connection added by Track, e.g. once it's closed by its handler.
+func (g *Group) Untrack(c io.Closer) {
+	g.mu.Lock()
+	delete(g.conns, c)
+	g.mu.Unlock()
+}
+
+// Drain stops accepting new handlers, and waits for the active ones to finish.
+// If the context is done first, the tracked connections are closed, so the handlers return,
+// and the context's error is returned once they've finished.
+func (g *Group) Drain(ctx context.Context) error {
+	g.mu.Lock()
+	g.draining = true
+	g.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		g.handlers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// The deadline is reached, close the connections of the remaining handlers
+	g.mu.Lock()
+	g.closing = true
+	g.init()
+	g.cancel()
+	conns := make([]io.Closer, 0, len(g.conns))
+	for c := range g.conns {
+		conns = append(conns, c)
+	}
+	g.conns = nil
+	g.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	<-finished
+	return ctx.Err()
+}
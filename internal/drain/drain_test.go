@@ -0,0 +1,127 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startRelay starts a handler relaying between the connections until either end is closed, like the proxy's relays.
+// It returns the channel closed once the handler has finished, or nil if the group didn't accept it.
+func startRelay(g *Group, a, b net.Conn) chan struct{} {
+	if !g.Enter() {
+		return nil
+	}
+	g.Track(a)
+	g.Track(b)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer g.Leave()
+		go func() {
+			io.Copy(a, b)
+			a.Close()
+		}()
+		io.Copy(b, a)
+		b.Close()
+		g.Untrack(a)
+		g.Untrack(b)
+	}()
+	return done
+}
+
+// newRelay starts a relay between two pipes, and returns the client's and the destination's ends.
+func newRelay(t *testing.T, g *Group) (client, destination net.Conn, done chan struct{}) {
+	t.Helper()
+	client, serverSide := net.Pipe()
+	destinationSide, destination := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		destination.Close()
+	})
+	done = startRelay(g, serverSide, destinationSide)
+	if done == nil {
+		t.Fatal("the group didn't accept the relay")
+	}
+	return client, destination, done
+}
+
+func TestDrainWaitsForHandlers(t *testing.T) {
+	var g Group
+	client, destination, done := newRelay(t, &g)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- g.Drain(context.Background())
+	}()
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned %v with an active relay", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if startRelay(&g, nil, nil) != nil {
+		t.Fatal("a new handler was accepted while draining")
+	}
+
+	// The active relay keeps working while draining
+	go client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	destination.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(destination, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("the draining relay relayed %q (%v)", buf, err)
+	}
+
+	// Once the relay ends, the drain is done
+	client.Close()
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Drain returned %v after the relay ended", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Drain didn't return after the relay ended")
+	}
+	<-done
+}
+
+func TestDrainClosesConnectionsAtDeadline(t *testing.T) {
+	var g Group
+	_, destination, done := newRelay(t, &g)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := g.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Drain returned %v after its deadline", elapsed)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("Drain returned before the relay's handler finished")
+	}
+
+	// The relay's connections were closed
+	destination.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := destination.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("reading from the relay's destination returned %v, want %v", err, io.EOF)
+	}
+	select {
+	case <-g.Context().Done():
+	default:
+		t.Fatal("the group's context isn't cancelled after the deadline")
+	}
+
+	// A connection tracked late is closed right away
+	late, peer := net.Pipe()
+	defer peer.Close()
+	g.Track(late)
+	if _, err := late.Write([]byte{0}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("writing to the late connection returned %v, want %v", err, io.ErrClosedPipe)
+	}
+}
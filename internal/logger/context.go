@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+// attrsKey is the context key of the attributes attached by WithAttrs.
+type attrsKey struct{}
+
+// WithAttrs returns a copy of the context carrying the attributes (key-value pairs or slog.Attrs, like slog.Logger.With),
+// in addition to the ones it already carries. They're attached to every line logged with the context, e.g.
+//
+//	ctx = logger.WithAttrs(ctx, "user", username)
+//	logger.WarnContext(ctx, err) // level=WARN msg=... user=bob
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	if len(args) < 1 {
+		return ctx
+	}
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	attrs := slices.Clone(contextAttrs(ctx))
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// contextAttrs returns the attributes attached to the context by WithAttrs.
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// sessionKey is the key of the session ID attribute.
+const sessionKey = "session"
+
+// contextHandler wraps a slog.Handler, and adds the session ID and the attributes of the context to each record.
+type contextHandler struct {
+	slog.Handler
+	sessionBound bool // The session ID is already bound to the handler (e.g. by slog.Logger.With), it isn't added again
+}
+
+// newContextHandler wraps the handler, so the attributes attached by WithAttrs are logged.
+func newContextHandler(h slog.Handler) slog.Handler {
+	return &contextHandler{Handler: h}
+}
+
+// Handle adds the session ID (see session.NewContext) and the attributes of the context to the record, and handles it.
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	id, hasSession := session.FromContext(ctx)
+	hasSession = hasSession && !h.sessionBound
+	attrs := contextAttrs(ctx)
+	if hasSession || len(attrs) > 0 {
+		r = r.Clone()
+		if hasSession {
+			r.AddAttrs(slog.String(sessionKey, id.String()))
+		}
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler with the attributes, keeping the context's ones.
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sessionBound := h.sessionBound || slices.ContainsFunc(attrs, func(a slog.Attr) bool {
+		return a.Key == sessionKey
+	})
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs), sessionBound: sessionBound}
+}
+
+// WithGroup returns a handler with the group, keeping the context's attributes.
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name), sessionBound: h.sessionBound}
+}
+
+// forwardHandler is a slog.Handler writing through the current logger of the package, see Handler.
+// Its attributes and groups are applied to the current logger's handler on each record, so it follows the Setup calls.
+type forwardHandler struct {
+	apply []func(slog.Handler) slog.Handler // The WithAttrs and WithGroup calls, in order
+}
+
+// Handler returns a slog.Handler writing through the logger of the package, e.g. for the libraries taking a *slog.Logger:
+//
+//	srv, err := server.NewServer(server.Options{Logger: slog.New(logger.Handler()), ...})
+//
+// The records are written as configured by Setup, even if it's called after Handler.
+func Handler() slog.Handler {
+	return &forwardHandler{}
+}
+
+// Enabled reports whether the current logger handles the records of the level.
+func (h *forwardHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return instance.Load().Handler().Enabled(ctx, l)
+}
+
+// Handle handles the record with the current logger, with the attributes and groups of the handler.
+func (h *forwardHandler) Handle(ctx context.Context, r slog.Record) error {
+	handler := instance.Load().Handler()
+	for _, apply := range h.apply {
+		handler = apply(handler)
+	}
+	return handler.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler with the attributes.
+func (h *forwardHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &forwardHandler{apply: append(slices.Clip(h.apply), func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})}
+}
+
+// WithGroup returns a handler with the group.
+func (h *forwardHandler) WithGroup(name string) slog.Handler {
+	return &forwardHandler{apply: append(slices.Clip(h.apply), func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})}
+}
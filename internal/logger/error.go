@@ -0,0 +1,14 @@
+package logger
+
+import "errors"
+
+var (
+	errUnknownLevel          = errors.New("unknown log level, the supported levels are debug, info, warn and error")
+	errUnknownFormat         = errors.New("unknown log format, the supported formats are text and json")
+	errUnknownOutput         = errors.New("unknown log output, the supported outputs are stdout, file and syslog")
+	errEmptyLogFile          = errors.New("the log file is required for the file output")
+	errFailedToOpenLogFile   = errors.New("failed to open the log file")
+	errFailedToRotateLogFile = errors.New("failed to rotate the log file")
+	errFailedToOpenSyslog    = errors.New("failed to connect to the local syslog daemon")
+	errSyslogUnsupported     = errors.New("the syslog output isn't supported on this platform")
+)
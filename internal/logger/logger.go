@@ -1,115 +1,241 @@
+// Package logger provides the leveled logger of the Gordafarid server and client, built on log/slog.
+//
+// The messages are logged through the package functions (Debug, Info, Warn, Error and Fatal), or their Context variants
+// attaching the session ID (see session.NewContext) and the attributes of the context (e.g. the user, see WithAttrs) to the line.
+// Until Setup is called, the messages of INFO level and above are written to stdout in the text format.
 package logger
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Log levels
+// LevelFatal is the level of the messages logged by Fatal, right before the program exits.
+const LevelFatal = slog.LevelError + 4
+
+// Formats of the log lines.
 const (
-	DEBUG = iota
-	INFO
-	WARN
-	ERROR
-	FATAL
+	FormatText = "text" // key=value pairs, e.g. time=... level=INFO msg=...
+	FormatJSON = "json" // One JSON object per line
 )
 
-// ANSI color codes
+// Outputs of the log lines.
 const (
-	ColorReset  = "\033[0m"
-	ColorRed    = "\033[31m"
-	ColorGreen  = "\033[32m"
-	ColorYellow = "\033[33m"
-	ColorBlue   = "\033[34m"
-	ColorPurple = "\033[35m"
-	ColorCyan   = "\033[36m"
+	OutputStdout = "stdout" // The standard output
+	OutputFile   = "file"   // A file, rotated by size
+	OutputSyslog = "syslog" // The local syslog daemon, through its unix socket (Unix-like systems only)
 )
 
-// Logger struct
-type Logger struct {
-	level    int
-	log      *log.Logger
-	logLevel map[int]string
-	colors   map[int]string
+// Default settings of the file output.
+const (
+	DefaultMaxSize    = 100 // In megabytes
+	DefaultMaxBackups = 5
+)
+
+// Config holds the settings of the logger.
+type Config struct {
+	Level         string // debug, info, warn or error (OPTIONAL, default: info)
+	Format        string // FormatText or FormatJSON (OPTIONAL, default: FormatText)
+	Output        string // OutputStdout, OutputFile or OutputSyslog (OPTIONAL, default: OutputStdout)
+	File          string // The log file of OutputFile
+	MaxSize       int    // The size a log file is rotated at, in megabytes (OPTIONAL, default: DefaultMaxSize)
+	MaxBackups    int    // How many rotated log files are kept (OPTIONAL, default: DefaultMaxBackups)
+	SyslogAddress string // The unix socket of the syslog daemon (OPTIONAL, default: the well-known local sockets, e.g. /dev/log)
+	SyslogTag     string // The tag of the syslog messages, e.g. the program name
+
+	// ErrorHandler is called with the errors of the output that can't be logged to it, e.g. a failed rotation of the log file.
+	// It's called with the output's lock held, so it must not log (OPTIONAL).
+	ErrorHandler func(err error)
 }
 
 var (
-	instance *Logger
-	once     = &sync.Once{}
+	level    = new(slog.LevelVar)        // Minimum level of the logged messages
+	instance atomic.Pointer[slog.Logger] // The logger used by the package functions
+	closerMu sync.Mutex                  // Guards closer
+	closer   io.Closer                   // The output opened by Setup, closed by Close
 )
 
-// Initialize logger once (singleton)
+// Initialize the default logger, writing to stdout in the text format until Setup is called
 func init() {
-	once.Do(func() {
-		instance = NewLogger(DEBUG, os.Stdout)
-	})
-}
-
-// NewLogger initializes a new logger with the specified minimum level
-func NewLogger(level int, output io.Writer) *Logger {
-	return &Logger{
-		level: level,
-		log:   log.New(output, "", 0),
-		logLevel: map[int]string{
-			DEBUG: "DEBUG",
-			INFO:  "INFO",
-			WARN:  "WARN",
-			ERROR: "ERROR",
-			FATAL: "FATAL",
-		},
-		colors: map[int]string{
-			DEBUG: ColorBlue,   // Blue for DEBUG
-			INFO:  ColorGreen,  // Green for INFO
-			WARN:  ColorYellow, // Yellow for WARN
-			ERROR: ColorRed,    // Red for ERROR
-			FATAL: ColorPurple, // Purple for FATAL
-		},
+	instance.Store(slog.New(newContextHandler(newHandler(os.Stdout, FormatText, false))))
+}
+
+// ParseLevel parses the name of a level: debug, info, warn or error (case-insensitive).
+func ParseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return 0, errors.Join(errUnknownLevel, fmt.Errorf("level: %q", name))
+	}
+	return l, nil
+}
+
+// Setup replaces the logger with the one described by the config, and closes the output of the previous Setup.
+// On error, the current logger is kept.
+func Setup(cfg Config) error {
+	l := slog.LevelInfo
+	if len(cfg.Level) > 0 {
+		var err error
+		if l, err = ParseLevel(cfg.Level); err != nil {
+			return err
+		}
+	}
+	format := cfg.Format
+	if len(format) < 1 {
+		format = FormatText
+	}
+	if format != FormatText && format != FormatJSON {
+		return errors.Join(errUnknownFormat, fmt.Errorf("format: %q", format))
+	}
+
+	var handler slog.Handler
+	var out io.Closer
+	switch cfg.Output {
+	case "", OutputStdout:
+		handler = newHandler(os.Stdout, format, false)
+	case OutputFile:
+		maxSize, maxBackups := cfg.MaxSize, cfg.MaxBackups
+		if maxSize <= 0 {
+			maxSize = DefaultMaxSize
+		}
+		if maxBackups <= 0 {
+			maxBackups = DefaultMaxBackups
+		}
+		file, err := openRotatingFile(cfg.File, int64(maxSize)<<20, maxBackups, cfg.ErrorHandler)
+		if err != nil {
+			return err
+		}
+		handler, out = newHandler(file, format, false), file
+	case OutputSyslog:
+		writer, err := openSyslog(cfg.SyslogAddress, cfg.SyslogTag)
+		if err != nil {
+			return err
+		}
+		// The syslog daemon stamps the messages itself
+		handler, out = newSyslogHandler(writer, format), writer
+	default:
+		return errors.Join(errUnknownOutput, fmt.Errorf("output: %q", cfg.Output))
+	}
+
+	level.Set(l)
+	instance.Store(slog.New(newContextHandler(handler)))
+	closerMu.Lock()
+	previous := closer
+	closer = out
+	closerMu.Unlock()
+	if previous != nil {
+		previous.Close()
 	}
+	return nil
 }
 
-// SetLevel allows changing the log level dynamically
-func SetLevel(level int) {
-	instance.level = level
+// Close closes the output opened by Setup (the log file or the syslog connection), if any.
+// The later messages are written to stdout.
+func Close() error {
+	closerMu.Lock()
+	out := closer
+	closer = nil
+	closerMu.Unlock()
+	if out == nil {
+		return nil
+	}
+	instance.Store(slog.New(newContextHandler(newHandler(os.Stdout, FormatText, false))))
+	return out.Close()
+}
+
+// SetLevel changes the minimum level of the logged messages.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// newHandler creates the slog handler of the format, writing to w.
+// The time is left out if omitTime is set, e.g. for syslog.
+func newHandler(w io.Writer, format string, omitTime bool) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.TimeKey:
+				if omitTime {
+					return slog.Attr{}
+				}
+			case slog.LevelKey:
+				if l, ok := a.Value.Any().(slog.Level); ok && l == LevelFatal {
+					a.Value = slog.StringValue("FATAL")
+				}
+			}
+			return a
+		},
+	}
+	if format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
 }
 
-// logMessage is the internal logging method that checks the level and logs the message
-func (l *Logger) logMessage(level int, args ...any) {
-	if level >= l.level {
-		timestamp := time.Now().Format(time.RFC3339)
-		message := fmt.Sprint(args...)
-		logOutput := fmt.Sprintf("[%s%s%s] [%s%s%s] - %s", ColorCyan, timestamp, ColorReset, l.colors[level], l.logLevel[level], ColorReset, message)
-		l.log.Println(logOutput)
+// log logs the message of the arguments (formatted like fmt.Sprint) at the level, with the attributes of the context.
+func log(ctx context.Context, l slog.Level, args ...any) {
+	handler := instance.Load().Handler()
+	if !handler.Enabled(ctx, l) {
+		return
 	}
+	handler.Handle(ctx, slog.NewRecord(time.Now(), l, fmt.Sprint(args...), 0))
 }
 
 // Global log methods
 
 // Debug logs a message with DEBUG level
 func Debug(args ...any) {
-	instance.logMessage(DEBUG, args...)
+	log(context.Background(), slog.LevelDebug, args...)
 }
 
 // Info logs a message with INFO level
 func Info(args ...any) {
-	instance.logMessage(INFO, args...)
+	log(context.Background(), slog.LevelInfo, args...)
 }
 
 // Warn logs a message with WARN level
 func Warn(args ...any) {
-	instance.logMessage(WARN, args...)
+	log(context.Background(), slog.LevelWarn, args...)
 }
 
 // Error logs a message with ERROR level
 func Error(args ...any) {
-	instance.logMessage(ERROR, args...)
+	log(context.Background(), slog.LevelError, args...)
 }
 
 // Fatal logs a message with FATAL level and exits the program
 func Fatal(args ...any) {
-	instance.logMessage(FATAL, args...)
+	log(context.Background(), LevelFatal, args...)
+	Close()
 	os.Exit(1)
 }
+
+// DebugContext logs a message with DEBUG level, with the attributes of the context
+func DebugContext(ctx context.Context, args ...any) {
+	log(ctx, slog.LevelDebug, args...)
+}
+
+// InfoContext logs a message with INFO level, with the attributes of the context
+func InfoContext(ctx context.Context, args ...any) {
+	log(ctx, slog.LevelInfo, args...)
+}
+
+// WarnContext logs a message with WARN level, with the attributes of the context
+func WarnContext(ctx context.Context, args ...any) {
+	log(ctx, slog.LevelWarn, args...)
+}
+
+// ErrorContext logs a message with ERROR level, with the attributes of the context
+func ErrorContext(ctx context.Context, args ...any) {
+	log(ctx, slog.LevelError, args...)
+}
@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"Warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		got, err := ParseLevel(name)
+		if err != nil {
+			t.Errorf("ParseLevel(%q): %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); !errors.Is(err, errUnknownLevel) {
+		t.Errorf("ParseLevel(\"verbose\") returned %v, want %v", err, errUnknownLevel)
+	}
+}
+
+func TestSetupRejectsUnknownSettings(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want error
+	}{
+		{Config{Level: "verbose"}, errUnknownLevel},
+		{Config{Format: "xml"}, errUnknownFormat},
+		{Config{Output: "stderr"}, errUnknownOutput},
+		{Config{Output: OutputFile}, errEmptyLogFile},
+	} {
+		if err := Setup(tc.cfg); !errors.Is(err, tc.want) {
+			t.Errorf("Setup(%+v) returned %v, want %v", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+// setupFile sets the logger up with the file output of the level and format, and returns the log file's path.
+func setupFile(t *testing.T, level, format string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gordafarid.log")
+	if err := Setup(Config{Level: level, Format: format, Output: OutputFile, File: path}); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	t.Cleanup(func() {
+		Close()
+		SetLevel(slog.LevelInfo)
+	})
+	return path
+}
+
+func TestSetupFileJSON(t *testing.T) {
+	path := setupFile(t, "warn", FormatJSON)
+	Info("below the level")
+	Warn("the ", "warning")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("the log file holds %d lines, want only the warning: %q", len(lines), content)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("the line isn't JSON: %v", err)
+	}
+	if record["level"] != "WARN" || record["msg"] != "the warning" {
+		t.Fatalf("got the record %v", record)
+	}
+}
+
+func TestSetupFileText(t *testing.T) {
+	path := setupFile(t, "debug", FormatText)
+	Debug("the details")
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.Contains(string(content), `level=DEBUG msg="the details"`) {
+		t.Fatalf("the log file holds %q, want the debug message in the text format", content)
+	}
+}
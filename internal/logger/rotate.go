@@ -0,0 +1,136 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file rotated once it reaches its maximum size:
+// the file is renamed to <path>.1, the older ones are shifted (<path>.1 to <path>.2, ...), and the oldest one beyond the backups is removed.
+// It's safe for concurrent use.
+//
+// Each process tracks the size of its own writes, so if several processes share the file (e.g. during an upgrade),
+// it may grow a bit beyond the maximum size before it's rotated.
+type rotatingFile struct {
+	path       string
+	maxSize    int64 // In bytes
+	maxBackups int
+	onError    func(err error) // Called with the errors of the rotations and of the retried reopens, nil means they're dropped
+
+	mu     sync.Mutex
+	file   *os.File // The current file, nil if it failed to reopen on the last rotation
+	size   int64    // Size of the current file
+	closed bool     // Closed by Close, unlike a file that failed to reopen
+}
+
+// openRotatingFile opens (or creates) the log file for appending.
+// The onError function (OPTIONAL) is called with the errors of the rotations.
+func openRotatingFile(path string, maxSize int64, maxBackups int, onError func(err error)) (*rotatingFile, error) {
+	if len(path) < 1 {
+		return nil, errEmptyLogFile
+	}
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		onError:    onError,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the log file for appending, and reads its current size. The caller must hold the lock, if the file is shared.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return errors.Join(errFailedToOpenLogFile, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Join(errFailedToOpenLogFile, err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write writes a log line to the file, rotating it first if the line would exceed its maximum size.
+// A line is never split across the files.
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	// The file failed to reopen on the last rotation, the reopen is retried on each write until it succeeds
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			err = errors.Join(errFailedToRotateLogFile, err)
+			if f.onError != nil {
+				f.onError(err)
+			}
+			return 0, err
+		}
+	}
+	if f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			if f.onError != nil {
+				f.onError(err)
+			}
+			if f.file == nil {
+				return 0, err
+			}
+			// Only closing the previous file failed, the line is written to the new one
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file, shifts the backups, and opens a new file. The caller must hold the lock.
+// If the new file can't be opened, the later writes retry opening it.
+func (f *rotatingFile) rotate() error {
+	// The file can't be used anymore even if closing it fails, so the rotation goes on
+	closeErr := f.file.Close()
+	if f.maxBackups > 0 {
+		os.Remove(backupPath(f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(backupPath(f.path, i), backupPath(f.path, i+1))
+		}
+		os.Rename(f.path, backupPath(f.path, 1))
+	} else {
+		// Without backups, the current file is just removed
+		os.Remove(f.path)
+	}
+	if err := f.open(); err != nil {
+		f.file = nil
+		return errors.Join(errFailedToRotateLogFile, closeErr, err)
+	}
+	if closeErr != nil {
+		return errors.Join(errFailedToRotateLogFile, closeErr)
+	}
+	return nil
+}
+
+// Close closes the file, the later writes fail.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// backupPath returns the path of the i-th rotated file.
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gordafarid.log")
+	f, err := openRotatingFile(path, 10, 2, nil)
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// Each line exceeds the maximum size along with the previous one, so each is in a file of its own,
+	// and the oldest line is gone beyond the two backups
+	for path, want := range map[string]string{
+		path:                "line4\n",
+		backupPath(path, 1): "line3\n",
+		backupPath(path, 2): "line2\n",
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("%s holds %q, want %q", path, got, want)
+		}
+	}
+	if _, err := os.Stat(backupPath(path, 3)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the backup beyond the maximum backups exists: %v", err)
+	}
+}
+
+func TestRotatingFileReopensWithItsSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gordafarid.log")
+	if err := os.WriteFile(path, []byte("previous\n"), 0o640); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	f, err := openRotatingFile(path, 10, 1, nil)
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	// The existing content counts towards the maximum size
+	if _, err := f.Write([]byte("line1\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, _ := os.ReadFile(backupPath(path, 1)); string(got) != "previous\n" {
+		t.Fatalf("the backup holds %q, want the previous content", got)
+	}
+}
+
+func TestRotatingFileRetriesFailedReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gordafarid.log")
+	var reported []error
+	f, err := openRotatingFile(path, 10, 0, func(err error) {
+		reported = append(reported, err)
+	})
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("line1\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// A directory in place of the log file can neither be removed nor opened by the rotation
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(path, "busy"), 0o750); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if _, err := f.Write([]byte("line2\n")); !errors.Is(err, errFailedToRotateLogFile) {
+		t.Fatalf("the write after the failed rotation returned %v, want the rotation error", err)
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], errFailedToRotateLogFile) {
+		t.Fatalf("the error handler got %v, want the rotation error", reported)
+	}
+
+	// The later writes retry opening the file, and report each failure
+	if _, err := f.Write([]byte("line3\n")); !errors.Is(err, errFailedToRotateLogFile) {
+		t.Fatalf("the write while the file can't be opened returned %v, want the rotation error", err)
+	}
+	if len(reported) != 2 || !errors.Is(reported[1], errFailedToOpenLogFile) {
+		t.Fatalf("the error handler got %v, want the failure to open the file", reported)
+	}
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	if _, err := f.Write([]byte("line4\n")); err != nil {
+		t.Fatalf("the write once the file can be opened returned %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "line4\n" {
+		t.Fatalf("the reopened file holds %q, want the written line", got)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("the error handler got %v after the successful write", reported)
+	}
+
+	// Once closed, the writes fail without reopening the file
+	f.Close()
+	if _, err := f.Write([]byte("line5\n")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("the write after Close returned %v, want %v", err, os.ErrClosed)
+	}
+}
@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// syslogWriter sends the messages to the local syslog daemon, with the severity of their level.
+type syslogWriter interface {
+	io.Closer
+	write(l slog.Level, msg string) error
+}
+
+// syslogOutput is the state shared by a syslogHandler and the handlers derived from it.
+type syslogOutput struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer // The line being formatted
+	writer syslogWriter
+}
+
+// syslogHandler formats the records like the other outputs (without the time, stamped by the daemon),
+// and sends each one as a message with the severity of its level.
+type syslogHandler struct {
+	slog.Handler
+	out *syslogOutput
+}
+
+// newSyslogHandler creates the handler sending the records of the format to the syslog daemon.
+func newSyslogHandler(writer syslogWriter, format string) slog.Handler {
+	out := &syslogOutput{writer: writer}
+	return &syslogHandler{
+		Handler: newHandler(&out.buf, format, true),
+		out:     out,
+	}
+}
+
+// Handle formats the record, and sends it to the syslog daemon.
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.buf.Reset()
+	if err := h.Handler.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.out.writer.write(r.Level, string(bytes.TrimSuffix(h.out.buf.Bytes(), []byte("\n"))))
+}
+
+// WithAttrs returns a handler with the attributes, sending to the same daemon.
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithAttrs(attrs), out: h.out}
+}
+
+// WithGroup returns a handler with the group, sending to the same daemon.
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithGroup(name), out: h.out}
+}
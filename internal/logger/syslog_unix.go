@@ -0,0 +1,55 @@
+//go:build !windows
+
+package logger
+
+import (
+	"errors"
+	"log/slog"
+	"log/syslog"
+)
+
+// syslogFacility is the facility of the messages.
+const syslogFacility = syslog.LOG_DAEMON
+
+// unixSyslog sends the messages to the local syslog daemon through its unix socket.
+type unixSyslog struct {
+	w *syslog.Writer
+}
+
+// openSyslog connects to the local syslog daemon, on the unix socket at the address,
+// or on the well-known sockets (e.g. /dev/log) if it's empty.
+func openSyslog(address, tag string) (syslogWriter, error) {
+	var w *syslog.Writer
+	var err error
+	if len(address) < 1 {
+		w, err = syslog.New(syslogFacility|syslog.LOG_INFO, tag)
+	} else if w, err = syslog.Dial("unixgram", address, syslogFacility|syslog.LOG_INFO, tag); err != nil {
+		// Some daemons listen on a stream socket
+		w, err = syslog.Dial("unix", address, syslogFacility|syslog.LOG_INFO, tag)
+	}
+	if err != nil {
+		return nil, errors.Join(errFailedToOpenSyslog, err)
+	}
+	return &unixSyslog{w: w}, nil
+}
+
+// write sends the message with the severity of the level.
+func (s *unixSyslog) write(l slog.Level, msg string) error {
+	switch {
+	case l >= LevelFatal:
+		return s.w.Crit(msg)
+	case l >= slog.LevelError:
+		return s.w.Err(msg)
+	case l >= slog.LevelWarn:
+		return s.w.Warning(msg)
+	case l >= slog.LevelInfo:
+		return s.w.Info(msg)
+	default:
+		return s.w.Debug(msg)
+	}
+}
+
+// Close closes the connection to the syslog daemon.
+func (s *unixSyslog) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,6 @@
+package logger
+
+// openSyslog returns an error, the syslog output isn't supported on Windows.
+func openSyslog(address, tag string) (syslogWriter, error) {
+	return nil, errSyslogUnsupported
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import "net"
+
+// countingListener wraps a net.Listener, and counts the accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted *Counter
+}
+
+// NewListener wraps the listener, so its accepted connections are counted by the counter.
+func NewListener(ln net.Listener, accepted *Counter) net.Listener {
+	return &countingListener{Listener: ln, accepted: accepted}
+}
+
+// Accept waits for and returns the next connection, and counts it.
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Inc()
+	}
+	return c, err
+}
+
+// countingConn wraps a net.Conn, and counts the bytes read from it.
+type countingConn struct {
+	net.Conn
+	read *Counter
+}
+
+// NewConn wraps the connection, so the bytes read from it are counted by the counter.
+func NewConn(c net.Conn, read *Counter) net.Conn {
+	return &countingConn{Conn: c, read: read}
+}
+
+// Read reads data from the connection, and counts the read bytes.
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.read.Add(uint64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,7 @@
+package metrics
+
+import "errors"
+
+var (
+	errListenFailed = errors.New("metrics server failed to start listening on specified address")
+)
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Dial error types, the values of the "type" label of the dial error counters.
+const (
+	DialErrorTimeout     = "timeout"
+	DialErrorRefused     = "refused"
+	DialErrorUnreachable = "unreachable"
+	DialErrorResolve     = "resolve"
+	DialErrorOther       = "other"
+)
+
+// DialErrorType classifies a dial error for the "type" label of the dial error counters.
+func DialErrorType(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return DialErrorResolve
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return DialErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return DialErrorRefused
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return DialErrorUnreachable
+	default:
+		return DialErrorOther
+	}
+}
@@ -0,0 +1,373 @@
+// Package metrics provides the counters, gauges and histograms of the Gordafarid server and client,
+// exposed in the Prometheus text format (version 0.0.4) without any third-party dependency.
+//
+// The metrics are created through a Registry, which groups them by name and writes them on each scrape:
+//
+//	registry := metrics.NewRegistry()
+//	accepted := registry.NewCounter("gordafarid_server_connections_accepted_total", "Accepted TCP connections.")
+//	accepted.Inc()
+//	http.Handle("/metrics", registry)
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Types of the metric families, as they're written in the TYPE lines.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// contentType is the content type of the Prometheus text format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultLatencyBuckets are the upper bounds of the latency histograms in seconds, from 5ms to 10s.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Labels are the constant labels of a metric, by name.
+type Labels map[string]string
+
+// collector is a metric (or a vector of metrics) of a family, writing its samples on each scrape.
+type collector interface {
+	write(w *bufio.Writer, name string)
+}
+
+// family is a group of metrics with the same name, help and type.
+type family struct {
+	name       string
+	help       string
+	kind       string
+	collectors []collector
+}
+
+// Registry holds the metrics, and writes them in the Prometheus text format.
+// It's safe for concurrent use, and it implements http.Handler to serve the metrics.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family // In the registration order
+	byName   map[string]*family
+}
+
+// NewRegistry creates a new Registry without any metrics.
+func NewRegistry() *Registry {
+	return &Registry{
+		byName: make(map[string]*family),
+	}
+}
+
+// register adds the collector to the family of the given name, creating the family if it doesn't exist.
+// It panics if the family exists with a different type, as that's a programming error.
+func (r *Registry) register(name, help, kind string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, exists := r.byName[name]
+	if !exists {
+		f = &family{name: name, help: help, kind: kind}
+		r.byName[name] = f
+		r.families = append(r.families, f)
+	} else if f.kind != kind {
+		panic(fmt.Sprintf("metrics: %s is already registered as a %s", name, f.kind))
+	}
+	f.collectors = append(f.collectors, c)
+}
+
+// NewCounter creates and registers a new Counter, with the optional constant labels.
+func (r *Registry) NewCounter(name, help string, labels Labels) *Counter {
+	c := &Counter{labels: formatLabels(labels)}
+	r.register(name, help, typeCounter, c)
+	return c
+}
+
+// NewCounterVec creates and registers a new CounterVec, partitioned by the given label names.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := &CounterVec{
+		labelNames: labelNames,
+		counters:   make(map[string]*Counter),
+	}
+	r.register(name, help, typeCounter, v)
+	return v
+}
+
+// NewGauge creates and registers a new Gauge, with the optional constant labels.
+func (r *Registry) NewGauge(name, help string, labels Labels) *Gauge {
+	g := &Gauge{labels: formatLabels(labels)}
+	r.register(name, help, typeGauge, g)
+	return g
+}
+
+// NewGaugeFunc creates and registers a gauge whose value is returned by fn on each scrape, with the optional constant labels.
+// The fn must be safe for concurrent use.
+func (r *Registry) NewGaugeFunc(name, help string, labels Labels, fn func() float64) {
+	r.register(name, help, typeGauge, &gaugeFunc{labels: formatLabels(labels), fn: fn})
+}
+
+// NewHistogram creates and registers a new Histogram with the given bucket upper bounds, which must be sorted.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		buckets: slices.Clone(buckets),
+		counts:  make([]uint64, len(buckets)),
+	}
+	r.register(name, help, typeHistogram, h)
+	return h
+}
+
+// WriteTo writes all the metrics to w in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+		for _, c := range f.collectors {
+			c.write(bw, f.name)
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP writes the metrics as the response body.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	r.WriteTo(w)
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes to the underlying writer, and counts the written bytes.
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Counter is a monotonically increasing count, e.g. the accepted connections or the relayed bytes.
+// The zero value is a counter that isn't registered (and isn't exposed), e.g. to count the bytes of a single connection.
+type Counter struct {
+	labels string // Formatted constant labels, e.g. {user="bob"}
+	value  atomic.Uint64
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n.
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// write writes the counter's sample.
+func (c *Counter) write(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "%s%s %d\n", name, c.labels, c.value.Load())
+}
+
+// CounterVec is a set of counters of the same name, partitioned by the values of its labels.
+type CounterVec struct {
+	labelNames []string
+	mu         sync.RWMutex
+	counters   map[string]*Counter // By the formatted labels
+}
+
+// With returns the counter of the given label values, in the order of the label names, creating it if it doesn't exist.
+// The returned counter can be kept, to avoid looking it up on each increment.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %d label values are given for %d label names", len(labelValues), len(v.labelNames)))
+	}
+	labels := make(Labels, len(v.labelNames))
+	for i, name := range v.labelNames {
+		labels[name] = labelValues[i]
+	}
+	key := formatLabels(labels)
+
+	v.mu.RLock()
+	c, exists := v.counters[key]
+	v.mu.RUnlock()
+	if exists {
+		return c
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, exists = v.counters[key]; !exists {
+		c = &Counter{labels: key}
+		v.counters[key] = c
+	}
+	return c
+}
+
+// write writes the samples of all the counters, sorted by their labels.
+func (v *CounterVec) write(w *bufio.Writer, name string) {
+	v.mu.RLock()
+	counters := make([]*Counter, 0, len(v.counters))
+	for _, c := range v.counters {
+		counters = append(counters, c)
+	}
+	v.mu.RUnlock()
+	slices.SortFunc(counters, func(a, b *Counter) int {
+		return strings.Compare(a.labels, b.labels)
+	})
+	for _, c := range counters {
+		c.write(w, name)
+	}
+}
+
+// Gauge is a value that can go up and down, e.g. the active tunnels.
+type Gauge struct {
+	labels string
+	value  atomic.Int64
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// write writes the gauge's sample.
+func (g *Gauge) write(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "%s%s %d\n", name, g.labels, g.value.Load())
+}
+
+// gaugeFunc is a gauge whose value is computed on each scrape.
+type gaugeFunc struct {
+	labels string
+	fn     func() float64
+}
+
+// write writes the gauge's sample.
+func (g *gaugeFunc) write(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, g.labels, formatFloat(g.fn()))
+}
+
+// Histogram counts the observations (e.g. latencies) in cumulative buckets, along with their sum and count.
+type Histogram struct {
+	buckets []float64 // Upper bounds, the +Inf bucket is implicit
+	mu      sync.Mutex
+	counts  []uint64 // Non-cumulative counts of the buckets
+	count   uint64
+	sum     float64
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i, _ := slices.BinarySearch(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// write writes the samples of the cumulative buckets, the sum and the count.
+func (h *Histogram) write(w *bufio.Writer, name string) {
+	h.mu.Lock()
+	counts := slices.Clone(h.counts)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, upperBound := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(upperBound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
+
+// formatLabels formats the labels as {name="value",...}, sorted by name, or an empty string if there are none.
+func formatLabels(labels Labels) string {
+	if len(labels) < 1 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// labelValueEscaper escapes the backslashes, double quotes and line feeds of the label values.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes a label value for the text format.
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+// helpEscaper escapes the backslashes and line feeds of the help texts.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// escapeHelp escapes a help text for the text format.
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// formatFloat formats a sample value for the text format.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
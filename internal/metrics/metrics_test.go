@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wantExposition is the text format of the registry built by newTestRegistry.
+const wantExposition = `# HELP gordafarid_connections_total Accepted TCP connections.
+# TYPE gordafarid_connections_total counter
+gordafarid_connections_total 3
+gordafarid_connections_total{listener="socks5"} 1
+# HELP gordafarid_relayed_bytes_total Relayed bytes, by init key, user and direction.
+# TYPE gordafarid_relayed_bytes_total counter
+gordafarid_relayed_bytes_total{direction="download",init_key="default",user="alice"} 2048
+gordafarid_relayed_bytes_total{direction="upload",init_key="default",user="a\\b\"c\nd"} 7
+gordafarid_relayed_bytes_total{direction="upload",init_key="default",user="alice"} 512
+# HELP gordafarid_active_tunnels Active tunnels.
+# TYPE gordafarid_active_tunnels gauge
+gordafarid_active_tunnels 2
+# HELP gordafarid_banned_ips Banned client IPs, computed on scrape.\nIt's a line feed and a backslash: \\.
+# TYPE gordafarid_banned_ips gauge
+gordafarid_banned_ips 0.5
+gordafarid_banned_ips{kind="inf"} +Inf
+# HELP gordafarid_handshake_seconds Handshake latency.
+# TYPE gordafarid_handshake_seconds histogram
+gordafarid_handshake_seconds_bucket{le="0.125"} 1
+gordafarid_handshake_seconds_bucket{le="0.5"} 3
+gordafarid_handshake_seconds_bucket{le="1"} 3
+gordafarid_handshake_seconds_bucket{le="+Inf"} 4
+gordafarid_handshake_seconds_sum 2.8125
+gordafarid_handshake_seconds_count 4
+`
+
+// newTestRegistry returns a registry with a metric of each type, some values and labels to escape.
+func newTestRegistry() *Registry {
+	r := NewRegistry()
+	r.NewCounter("gordafarid_connections_total", "Accepted TCP connections.", nil).Add(3)
+	// A metric of an existing family is written under its HELP and TYPE lines
+	r.NewCounter("gordafarid_connections_total", "Ignored help of the existing family.", Labels{"listener": "socks5"}).Inc()
+
+	relayed := r.NewCounterVec("gordafarid_relayed_bytes_total", "Relayed bytes, by init key, user and direction.", "init_key", "user", "direction")
+	relayed.With("default", "alice", "upload").Add(512)
+	relayed.With("default", "alice", "download").Add(2048)
+	relayed.With("default", "a\\b\"c\nd", "upload").Add(7)
+
+	tunnels := r.NewGauge("gordafarid_active_tunnels", "Active tunnels.", nil)
+	tunnels.Inc()
+	tunnels.Inc()
+	tunnels.Inc()
+	tunnels.Dec()
+
+	r.NewGaugeFunc("gordafarid_banned_ips", "Banned client IPs, computed on scrape.\nIt's a line feed and a backslash: \\.", nil, func() float64 { return 0.5 })
+	r.NewGaugeFunc("gordafarid_banned_ips", "", Labels{"kind": "inf"}, func() float64 { return math.Inf(1) })
+
+	h := r.NewHistogram("gordafarid_handshake_seconds", "Handshake latency.", []float64{0.125, 0.5, 1})
+	for _, v := range []float64{0.0625, 0.25, 0.5, 2} {
+		h.Observe(v)
+	}
+	return r
+}
+
+func TestRegistryWriteTo(t *testing.T) {
+	var b strings.Builder
+	n, err := newTestRegistry().WriteTo(&b)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if got := b.String(); got != wantExposition {
+		t.Fatalf("WriteTo wrote:\n%s\nwant:\n%s", got, wantExposition)
+	}
+	if n != int64(b.Len()) {
+		t.Fatalf("WriteTo = %d, want the %d written bytes", n, b.Len())
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestRegistry().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != contentType {
+		t.Fatalf("Content-Type = %q, want %q", got, contentType)
+	}
+	if got := w.Body.String(); got != wantExposition {
+		t.Fatalf("the response body is:\n%s\nwant:\n%s", got, wantExposition)
+	}
+}
+
+func TestCounterVecWithReturnsSameCounter(t *testing.T) {
+	v := NewRegistry().NewCounterVec("gordafarid_dial_errors_total", "Dial errors.", "type")
+	if v.With(DialErrorTimeout) != v.With(DialErrorTimeout) {
+		t.Fatal("the same label values return different counters")
+	}
+	if v.With(DialErrorTimeout) == v.With(DialErrorRefused) {
+		t.Fatal("different label values return the same counter")
+	}
+}
+
+// expectPanic calls fn, and fails the test unless it panics with a message containing want.
+func expectPanic(t *testing.T, want string, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if r == nil {
+			t.Fatalf("no panic, want %q", want)
+		}
+		if msg, ok := r.(string); !ok || !strings.Contains(msg, want) {
+			t.Fatalf("panic %v, want %q", r, want)
+		}
+	}()
+	fn()
+}
+
+func TestRegistryPanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("gordafarid_connections_total", "Accepted TCP connections.", nil)
+	expectPanic(t, "gordafarid_connections_total is already registered as a counter", func() {
+		r.NewGauge("gordafarid_connections_total", "Accepted TCP connections.", nil)
+	})
+	expectPanic(t, "gordafarid_connections_total is already registered as a counter", func() {
+		r.NewHistogram("gordafarid_connections_total", "Accepted TCP connections.", DefaultLatencyBuckets)
+	})
+
+	v := r.NewCounterVec("gordafarid_relayed_bytes_total", "Relayed bytes.", "user", "direction")
+	expectPanic(t, "1 label values are given for 2 label names", func() {
+		v.With("alice")
+	})
+	expectPanic(t, "3 label values are given for 2 label names", func() {
+		v.With("alice", "upload", "extra")
+	})
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// metricsPath is the path the metrics are served on.
+const metricsPath = "/metrics"
+
+// Server serves the metrics of a Registry over HTTP, on the /metrics path.
+// It can be started again after it's shut down, e.g. when a server upgrade fails.
+type Server struct {
+	addr       string
+	registry   *Registry
+	httpServer *http.Server // nil if not running
+}
+
+// NewServer creates a new Server for the registry, listening on addr once it's started.
+func NewServer(addr string, registry *Registry) *Server {
+	return &Server{
+		addr:     addr,
+		registry: registry,
+	}
+}
+
+// Listen starts listening on the server's address, and serves the metrics in the background.
+// The onError callback is called if serving fails after the server has started (OPTIONAL).
+//
+// Returns:
+//   - net.Addr: The address the server is listening on.
+//   - error: If the address can't be listened on.
+func (s *Server) Listen(onError func(error)) (net.Addr, error) {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return nil, errors.Join(errListenFailed, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET "+metricsPath, s.registry)
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func(httpServer *http.Server) {
+		if err := httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) && onError != nil {
+			onError(err)
+		}
+	}(s.httpServer)
+	return ln.Addr(), nil
+}
+
+// Shutdown stops the server, waiting for the in-flight scrapes until the context is done.
+// It does nothing if the server isn't running.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.httpServer == nil {
+		return nil
+	}
+	err := s.httpServer.Shutdown(ctx)
+	s.httpServer = nil
+	return err
+}
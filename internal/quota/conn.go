@@ -0,0 +1,50 @@
+package quota
+
+import (
+	"net"
+	"sync"
+)
+
+// Conn wraps a net.Conn, and counts the data read from it as the account's traffic.
+// Counting the reads of both relay sides counts both directions.
+// Once the account exceeds its quotas, all its open connections are closed.
+type Conn struct {
+	net.Conn
+	store   *Store
+	account Account
+	limits  Limits
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewConn wraps the connection, counting its reads as the traffic of the given account.
+func (s *Store) NewConn(conn net.Conn, account Account, limits Limits) *Conn {
+	c := &Conn{
+		Conn:    conn,
+		store:   s,
+		account: account,
+		limits:  limits,
+	}
+	s.register(c)
+	return c
+}
+
+// Read reads data from the connection, and counts it as the account's traffic.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.store.add(c.account, int64(n), c.limits) {
+		// The data is already read, deliver it and close all the account's connections
+		c.store.closeAll(c.account)
+	}
+	return n, err
+}
+
+// Close closes the connection, and removes it from the account's open connections.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.store.unregister(c)
+		c.closeErr = c.Conn.Close()
+	})
+	return c.closeErr
+}
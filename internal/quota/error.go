@@ -0,0 +1,9 @@
+package quota
+
+import "errors"
+
+var (
+	errFailedToLoadStateFile  = errors.New("failed to load the quota state file")
+	errFailedToSaveStateFile  = errors.New("failed to save the quota state file")
+	errFailedToMergeDeltaFile = errors.New("failed to merge the quota delta file of a previous process")
+)
@@ -0,0 +1,485 @@
+// Package quota provides the per-account traffic accounting and quotas of the Gordafarid server.
+//
+// The traffic of the accounts (both directions) is counted per day, per month and in total,
+// and persisted to a JSON state file. The days and months are in UTC.
+// The accounts are identified by their init key and username, as the usernames are only unique within an init key.
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Credential attributes of the per-account quotas, in bytes.
+const (
+	DailyQuotaAttribute   = "dailyQuota"
+	MonthlyQuotaAttribute = "monthlyQuota"
+	TotalQuotaAttribute   = "totalQuota"
+)
+
+// deltaFileSuffix is the pattern of the delta files after the state file's name, see Store.HandOff.
+const deltaFileSuffix = ".*.delta"
+
+// Layouts of the accounting periods
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// Account identifies an account, the same-named accounts of different init keys are counted apart.
+type Account struct {
+	InitKey  string // ID of the init key the account belongs to
+	Username string
+}
+
+// Limits are the quotas of an account in bytes, zero means unlimited.
+type Limits struct {
+	Daily   int64
+	Monthly int64
+	Total   int64
+}
+
+// IsZero reports whether the limits are all unlimited.
+func (l Limits) IsZero() bool {
+	return l.Daily <= 0 && l.Monthly <= 0 && l.Total <= 0
+}
+
+// Usage is the traffic of an account in bytes.
+type Usage struct {
+	Day     string `json:"day"`     // The day of the Daily counter
+	Daily   int64  `json:"daily"`   // Traffic of the Day
+	Month   string `json:"month"`   // The month of the Monthly counter
+	Monthly int64  `json:"monthly"` // Traffic of the Month
+	Total   int64  `json:"total"`   // Total traffic
+}
+
+// rollover resets the counters of the past periods.
+func (u *Usage) rollover(now time.Time) {
+	day := now.UTC().Format(dayLayout)
+	if u.Day != day {
+		u.Day = day
+		u.Daily = 0
+	}
+	month := now.UTC().Format(monthLayout)
+	if u.Month != month {
+		u.Month = month
+		u.Monthly = 0
+	}
+}
+
+// merge adds the traffic of the delta to the usage, only the counters of the current periods.
+func (u *Usage) merge(delta *Usage, now time.Time) {
+	u.rollover(now)
+	if delta.Day == u.Day {
+		u.Daily += delta.Daily
+	}
+	if delta.Month == u.Month {
+		u.Monthly += delta.Monthly
+	}
+	u.Total += delta.Total
+}
+
+// Exceeds reports whether the usage has reached any of the limits.
+func (u Usage) Exceeds(l Limits) bool {
+	return (l.Daily > 0 && u.Daily >= l.Daily) ||
+		(l.Monthly > 0 && u.Monthly >= l.Monthly) ||
+		(l.Total > 0 && u.Total >= l.Total)
+}
+
+// stateFile is the content of the state file.
+type stateFile struct {
+	Accounts map[string]map[string]*Usage `json:"accounts"` // Usage by init key ID, then by username
+}
+
+// newStateFile returns the state file content of the usage.
+func newStateFile(usage map[Account]*Usage) stateFile {
+	state := stateFile{Accounts: make(map[string]map[string]*Usage)}
+	for account, u := range usage {
+		users, exists := state.Accounts[account.InitKey]
+		if !exists {
+			users = make(map[string]*Usage)
+			state.Accounts[account.InitKey] = users
+		}
+		users[account.Username] = u
+	}
+	return state
+}
+
+// forEach calls fn with the usage of each account in the state file.
+func (state stateFile) forEach(fn func(Account, *Usage)) {
+	for initKey, users := range state.Accounts {
+		for username, usage := range users {
+			if usage != nil {
+				fn(Account{InitKey: initKey, Username: username}, usage)
+			}
+		}
+	}
+}
+
+// Store counts the traffic of the accounts, and keeps track of their open connections
+// so they can be closed once a quota is exceeded. It's safe for concurrent use.
+//
+// During an upgrade, the state file is handed off to the new process (see HandOff), and the traffic
+// of the connections this process drains afterwards is saved to delta files, which the new process merges.
+type Store struct {
+	path string // The state file, empty means the usage is only kept in memory
+
+	saveMu sync.Mutex // Serializes the saves, so the hand-off doesn't race a periodic save
+
+	mu        sync.Mutex
+	usage     map[Account]*Usage
+	conns     map[Account]map[*Conn]struct{} // Open connections by account
+	dirty     bool                           // The usage has changed since the last save
+	handedOff bool                           // The state file is owned by a new process, see HandOff
+	deltas    map[Account]*Usage             // The traffic counted since the hand-off, or since the last delta file
+}
+
+// NewStore creates a new Store, and loads the state file if it exists.
+// If path is empty, the usage is only kept in memory.
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		usage: make(map[Account]*Usage),
+		conns: make(map[Account]map[*Conn]struct{}),
+	}
+	if len(path) < 1 {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, errors.Join(errFailedToLoadStateFile, err)
+	}
+	var state stateFile
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Join(errFailedToLoadStateFile, err)
+	}
+	state.forEach(func(account Account, usage *Usage) {
+		s.usage[account] = usage
+	})
+	return s, nil
+}
+
+// Usage returns the current usage of the account.
+func (s *Store) Usage(account Account) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.usageOf(account, time.Now())
+}
+
+// Exceeded reports whether the account has reached any of its quotas.
+func (s *Store) Exceeded(account Account, limits Limits) bool {
+	return s.Usage(account).Exceeds(limits)
+}
+
+// usageOf returns the usage of the account for the current periods, the caller must hold the lock.
+func (s *Store) usageOf(account Account, now time.Time) *Usage {
+	return currentUsage(s.usage, account, now)
+}
+
+// currentUsage returns the usage of the account in the map for the current periods, it's added if it's missing.
+func currentUsage(usage map[Account]*Usage, account Account, now time.Time) *Usage {
+	u, exists := usage[account]
+	if !exists {
+		u = &Usage{}
+		usage[account] = u
+	}
+	u.rollover(now)
+	return u
+}
+
+// add counts n bytes of the account's traffic, and reports whether the account has exceeded its quotas.
+func (s *Store) add(account Account, n int64, limits Limits) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	usage := s.usageOf(account, now)
+	usage.Daily += n
+	usage.Monthly += n
+	usage.Total += n
+	if s.handedOff {
+		delta := currentUsage(s.deltas, account, now)
+		delta.Daily += n
+		delta.Monthly += n
+		delta.Total += n
+	}
+	s.dirty = true
+	return usage.Exceeds(limits)
+}
+
+// Save writes the usage to the state file, if it has changed since the last save.
+// The file is replaced atomically, so a crash doesn't leave a truncated state behind.
+// The delta files of a previous process (see HandOff) are merged first, and removed once the state file is written.
+// Once the state file is handed off, the traffic counted since the last save is written to a new delta file instead.
+func (s *Store) Save() error {
+	if len(s.path) < 1 {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	handedOff := s.handedOff
+	s.mu.Unlock()
+	if handedOff {
+		return s.saveDelta()
+	}
+
+	deltaFiles, mergeErr := s.mergeDeltaFiles()
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return mergeErr
+	}
+	data, err := json.MarshalIndent(newStateFile(s.usage), "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return errors.Join(mergeErr, errFailedToSaveStateFile, err)
+	}
+	if err = writeFileAtomic(s.path, data); err != nil {
+		return errors.Join(mergeErr, s.saveFailed(err))
+	}
+	return errors.Join(mergeErr, removeFiles(deltaFiles))
+}
+
+// HandOff saves the usage to the state file for a new process taking it over (e.g. during an upgrade), which loads it on startup.
+// Afterwards, the store doesn't write the state file anymore: Save writes the traffic counted since the hand-off to delta files,
+// which the new process merges into the state file. CancelHandOff reverts it, if the new process didn't take over.
+func (s *Store) HandOff() error {
+	if len(s.path) < 1 {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	deltaFiles, mergeErr := s.mergeDeltaFiles()
+	s.mu.Lock()
+	data, err := json.MarshalIndent(newStateFile(s.usage), "", "  ")
+	s.handedOff = true
+	s.deltas = make(map[Account]*Usage)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return errors.Join(mergeErr, errFailedToSaveStateFile, err)
+	}
+	if err = writeFileAtomic(s.path, data); err != nil {
+		return errors.Join(mergeErr, errFailedToSaveStateFile, err)
+	}
+	return errors.Join(mergeErr, removeFiles(deltaFiles))
+}
+
+// CancelHandOff takes the state file back after HandOff, if the new process didn't take it over.
+// The usage counted meanwhile is written to the state file by the next Save.
+func (s *Store) CancelHandOff() {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.handedOff {
+		return
+	}
+	s.handedOff = false
+	s.deltas = nil
+	s.dirty = true
+}
+
+// saveDelta writes the traffic counted since the hand-off (or the last delta file) to a new delta file, the caller must hold saveMu.
+func (s *Store) saveDelta() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	deltas := s.deltas
+	data, err := json.MarshalIndent(newStateFile(deltas), "", "  ")
+	s.deltas = make(map[Account]*Usage)
+	s.dirty = false
+	s.mu.Unlock()
+	if err == nil {
+		err = writeDeltaFile(s.path, data)
+	}
+	if err != nil {
+		// Keep the traffic for the next save
+		s.mu.Lock()
+		now := time.Now()
+		for account, delta := range deltas {
+			currentUsage(s.deltas, account, now).merge(delta, now)
+		}
+		s.dirty = true
+		s.mu.Unlock()
+		return errors.Join(errFailedToSaveStateFile, err)
+	}
+	return nil
+}
+
+// mergeDeltaFiles adds the traffic of the delta files written by a previous process to the usage, and returns the merged files,
+// to be removed once the state file is written. The caller must hold saveMu.
+func (s *Store) mergeDeltaFiles() ([]string, error) {
+	paths, err := filepath.Glob(s.path + deltaFileSuffix)
+	if err != nil || len(paths) < 1 {
+		return nil, nil
+	}
+	var merged []string
+	var errs []error
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, errors.Join(errFailedToMergeDeltaFile, err))
+			continue
+		}
+		if len(data) < 1 {
+			// The delta file is being written, it's merged by the next save
+			continue
+		}
+		var state stateFile
+		if err = json.Unmarshal(data, &state); err != nil {
+			errs = append(errs, errors.Join(errFailedToMergeDeltaFile, fmt.Errorf("file: %s", path), err))
+			continue
+		}
+		s.mu.Lock()
+		now := time.Now()
+		state.forEach(func(account Account, delta *Usage) {
+			s.usageOf(account, now).merge(delta, now)
+		})
+		s.dirty = true
+		s.mu.Unlock()
+		merged = append(merged, path)
+	}
+	return merged, errors.Join(errs...)
+}
+
+// saveFailed marks the usage as changed again, so the next save retries, and returns the wrapped error.
+func (s *Store) saveFailed(err error) error {
+	s.mu.Lock()
+	s.dirty = true
+	s.mu.Unlock()
+	return errors.Join(errFailedToSaveStateFile, err)
+}
+
+// writeFileAtomic replaces the file with the data atomically, through a temporary file in the same directory.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath, err := writeTempFile(path, data)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// writeDeltaFile writes the data to a new delta file of the state file, it's renamed in place once it's complete.
+func writeDeltaFile(path string, data []byte) error {
+	tmpPath, err := writeTempFile(path, data)
+	if err != nil {
+		return err
+	}
+	deltaFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+deltaFileSuffix)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	deltaFile.Close()
+	if err = os.Rename(tmpPath, deltaFile.Name()); err != nil {
+		os.Remove(tmpPath)
+		os.Remove(deltaFile.Name())
+		return err
+	}
+	return nil
+}
+
+// writeTempFile writes the data to a new temporary file next to the given path, and returns the temporary file's path.
+func writeTempFile(path string, data []byte) (string, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+	if err = tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+	return tmpFile.Name(), nil
+}
+
+// removeFiles removes the files, and returns the errors joined.
+func removeFiles(paths []string) error {
+	var errs []error
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StartPersistRoutine starts a background routine to save the usage to the state file every saveInterval.
+// Save errors are passed to onError (if not nil). The routine saves once more and stops when the context is cancelled.
+func (s *Store) StartPersistRoutine(ctx context.Context, saveInterval time.Duration, onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Save(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-ctx.Done():
+				// Save the last changes, and stop the goroutine when the context is cancelled
+				if err := s.Save(); err != nil && onError != nil {
+					onError(err)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// register adds the connection to the account's open connections.
+func (s *Store) register(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns, exists := s.conns[c.account]
+	if !exists {
+		conns = make(map[*Conn]struct{})
+		s.conns[c.account] = conns
+	}
+	conns[c] = struct{}{}
+}
+
+// unregister removes the connection from the account's open connections.
+func (s *Store) unregister(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns[c.account], c)
+	if len(s.conns[c.account]) < 1 {
+		delete(s.conns, c.account)
+	}
+}
+
+// closeAll closes all the open connections of the account.
+func (s *Store) closeAll(account Account) {
+	s.mu.Lock()
+	conns := make([]*Conn, 0, len(s.conns[account]))
+	for c := range s.conns[account] {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,173 @@
+package quota
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+var (
+	alice       = Account{InitKey: gordafarid.DefaultInitKeyID, Username: "alice"}
+	tenantAlice = Account{InitKey: "tenant-b", Username: "alice"}
+)
+
+// readAll reads n bytes through the quota connection, the peer writes them.
+func readAll(t *testing.T, c *Conn, peer net.Conn, n int) {
+	t.Helper()
+	go func() {
+		peer.Write(make([]byte, n))
+	}()
+	if _, err := io.ReadFull(c, make([]byte, n)); err != nil {
+		t.Fatalf("reading through the quota connection: %v", err)
+	}
+}
+
+func TestStoreCountsAccountsPerInitKey(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := Limits{Total: 100}
+	s.add(alice, 100, limits)
+
+	if !s.Exceeded(alice, limits) {
+		t.Fatal("the account hasn't exceeded its quota")
+	}
+	if s.Exceeded(tenantAlice, limits) {
+		t.Fatal("the same-named account of another init key has exceeded its quota")
+	}
+	if usage := s.Usage(tenantAlice); usage.Total != 0 {
+		t.Fatalf("the same-named account of another init key has used %d bytes", usage.Total)
+	}
+}
+
+func TestStoreClosesOnlyTheExceededAccount(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := Limits{Daily: 10}
+
+	client, peer := net.Pipe()
+	defer peer.Close()
+	aliceConn := s.NewConn(client, alice, limits)
+	otherClient, otherPeer := net.Pipe()
+	defer otherPeer.Close()
+	tenantAliceConn := s.NewConn(otherClient, tenantAlice, limits)
+	defer tenantAliceConn.Close()
+
+	readAll(t, aliceConn, peer, 10)
+	if _, err = aliceConn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("the connection of the exceeded account isn't closed")
+	}
+	go otherPeer.Write([]byte{1})
+	if _, err = tenantAliceConn.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("the connection of the same-named account of another init key is closed: %v", err)
+	}
+}
+
+func TestStorePersistsAccountsPerInitKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.add(alice, 10, Limits{})
+	s.add(tenantAlice, 20, Limits{})
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if got := loaded.Usage(alice).Total; got != 10 {
+		t.Errorf("alice of the default init key: total = %d, want 10", got)
+	}
+	if got := loaded.Usage(tenantAlice).Total; got != 20 {
+		t.Errorf("alice of tenant-b: total = %d, want 20", got)
+	}
+}
+
+func TestStoreHandOffMergesDrainedTraffic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	old, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	old.add(alice, 100, Limits{})
+	if err = old.HandOff(); err != nil {
+		t.Fatalf("HandOff: %v", err)
+	}
+
+	// The new process loads the handed off state, while the old one drains its connections
+	next, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if usage := next.Usage(alice); usage.Total != 100 {
+		t.Fatalf("the new process loaded %d bytes, want 100", usage.Total)
+	}
+	next.add(alice, 10, Limits{})
+	old.add(alice, 50, Limits{})
+	old.add(tenantAlice, 7, Limits{})
+	if err = old.Save(); err != nil {
+		t.Fatalf("Save after the hand-off: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), `"total": 100`) {
+		t.Fatalf("the old process overwrote the handed off state file: %s, %v", data, err)
+	}
+
+	// The new process merges the drained traffic on its next save, and removes the delta file
+	if err = next.Save(); err != nil {
+		t.Fatalf("Save of the new process: %v", err)
+	}
+	if usage := next.Usage(alice); usage.Total != 160 || usage.Daily != 160 || usage.Monthly != 160 {
+		t.Fatalf("the new process counts %+v, want 160 bytes", usage)
+	}
+	if usage := next.Usage(tenantAlice); usage.Total != 7 {
+		t.Fatalf("the new process counts %d bytes of the other init key's account, want 7", usage.Total)
+	}
+	if deltas, _ := filepath.Glob(path + deltaFileSuffix); len(deltas) > 0 {
+		t.Fatalf("the merged delta files aren't removed: %v", deltas)
+	}
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if usage := reloaded.Usage(alice); usage.Total != 160 {
+		t.Fatalf("the state file has %d bytes, want 160", usage.Total)
+	}
+}
+
+func TestStoreCancelHandOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if err = s.HandOff(); err != nil {
+		t.Fatalf("HandOff: %v", err)
+	}
+	s.add(alice, 42, Limits{})
+	s.CancelHandOff()
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if deltas, _ := filepath.Glob(path + deltaFileSuffix); len(deltas) > 0 {
+		t.Fatalf("a delta file is written after the hand-off is cancelled: %v", deltas)
+	}
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if usage := reloaded.Usage(alice); usage.Total != 42 {
+		t.Fatalf("the state file has %d bytes, want 42", usage.Total)
+	}
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Conn wraps a net.Conn, and limits the rate of the data read from it.
+// Limiting the reads of both relay sides limits both directions,
+// since a relay writes the data as fast as it reads it.
+type Conn struct {
+	net.Conn
+	limiters []*Limiter // All the limiters are applied, e.g. the account's and the server's global limiter
+
+	ctx       context.Context // Canceled when the connection is closed, to stop the waiting reads
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+// NewConn wraps the connection, limiting its reads by the given limiters.
+// Nil limiters are ignored, and the connection is returned as is if there is no limiter.
+func NewConn(conn net.Conn, limiters ...*Limiter) net.Conn {
+	var activeLimiters []*Limiter
+	for _, l := range limiters {
+		if l != nil {
+			activeLimiters = append(activeLimiters, l)
+		}
+	}
+	if len(activeLimiters) < 1 {
+		return conn
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		Conn:     conn,
+		limiters: activeLimiters,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Read reads data from the connection, and waits until the limiters allow the read bytes.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		for _, l := range c.limiters {
+			if waitErr := l.WaitN(c.ctx, n); waitErr != nil {
+				// The connection is closed, return the read data anyway
+				return n, err
+			}
+		}
+	}
+	return n, err
+}
+
+// Close closes the connection, and stops the waiting reads.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(c.cancel)
+	return c.Conn.Close()
+}
@@ -0,0 +1,110 @@
+// Package ratelimit provides token bucket bandwidth limiting for the relayed connections.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Credential attributes of the per-account limits, in bytes per second.
+const (
+	UploadRateLimitAttribute   = "uploadRateLimit"
+	DownloadRateLimitAttribute = "downloadRateLimit"
+)
+
+// Limiter is a token bucket limiting a byte rate.
+// The bucket may go into debt, so a read larger than the bucket is allowed, and the following ones wait for the debt to be paid off.
+// It's safe for concurrent use, the waiting callers share the rate.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64   // Tokens (bytes) added per second
+	burst  float64   // Maximum tokens the bucket holds
+	tokens float64   // Current tokens, negative when in debt
+	last   time.Time // Last time the tokens were updated
+}
+
+// NewLimiter creates a new Limiter allowing rate bytes per second, with bursts up to burst bytes.
+// If burst is not positive, it's set to the rate (one second worth of bytes).
+func NewLimiter(rate, burst int64) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(rate, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// SetLimit changes the rate and the burst of the Limiter, see NewLimiter.
+func (l *Limiter) SetLimit(rate, burst int64) {
+	if burst <= 0 {
+		burst = rate
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = float64(rate)
+	l.burst = float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Rate returns the rate of the Limiter in bytes per second.
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// refill adds the tokens earned since the last update, the caller must hold the lock.
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// WaitN takes n tokens from the bucket, and waits until the bucket is out of debt.
+// A zero rate is unlimited, so no tokens are taken.
+// It returns the context's error if the context is done before that, and gives the tokens back,
+// so the following callers don't wait for the debt of a canceled one.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.refund(n)
+		return ctx.Err()
+	}
+}
+
+// refund gives n tokens back to the bucket.
+func (l *Limiter) refund(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.tokens += float64(n)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
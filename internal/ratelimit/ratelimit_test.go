@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transfer writes size bytes to a pipe, reads them through the limited end, and returns how long it took.
+// It may be called from another goroutine than the test's.
+func transfer(t *testing.T, size int, limiters ...*Limiter) time.Duration {
+	t.Helper()
+	writer, reader := net.Pipe()
+	conn := NewConn(reader, limiters...)
+	defer conn.Close()
+	go func() {
+		writer.Write(make([]byte, size))
+		writer.Close()
+	}()
+	start := time.Now()
+	n, err := io.Copy(io.Discard, conn)
+	if err != nil {
+		t.Errorf("read: %v", err)
+	} else if n != int64(size) {
+		t.Errorf("read %d bytes, want %d", n, size)
+	}
+	return time.Since(start)
+}
+
+func TestLimiterEnforcesRate(t *testing.T) {
+	// The first 1000 bytes are in the bucket, the other 4000 take 400ms at 10000 B/s
+	elapsed := transfer(t, 5000, NewLimiter(10000, 1000))
+	if elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("transfer took %v, want about 400ms", elapsed)
+	}
+}
+
+func TestLimiterBurstLargerThanBucket(t *testing.T) {
+	l := NewLimiter(10000, 100)
+	start := time.Now()
+	// The 2000 bytes over the bucket are a debt, paid off in 200ms
+	if err := l.WaitN(context.Background(), 2100); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("WaitN took %v, want about 200ms", elapsed)
+	}
+}
+
+func TestLimiterZeroRateIsUnlimited(t *testing.T) {
+	l := NewLimiter(0, 0)
+	start := time.Now()
+	for range 10 {
+		if err := l.WaitN(context.Background(), 1<<30); err != nil {
+			t.Fatalf("WaitN: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("WaitN of an unlimited limiter took %v", elapsed)
+	}
+
+	// Setting a rate afterwards doesn't inherit a debt of the unlimited waits
+	l.SetLimit(10000, 1000)
+	start = time.Now()
+	if err := l.WaitN(context.Background(), 500); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("WaitN after setting the rate took %v", elapsed)
+	}
+}
+
+func TestLimiterCanceledWaitRefundsTokens(t *testing.T) {
+	l := NewLimiter(1000, 1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// 2000 bytes over the bucket would wait 2s
+	if err := l.WaitN(ctx, 3000); err != context.DeadlineExceeded {
+		t.Fatalf("WaitN = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The bucket is back to about full, so the next caller doesn't wait for the canceled debt
+	start := time.Now()
+	if err := l.WaitN(context.Background(), 500); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("WaitN after a canceled wait took %v", elapsed)
+	}
+}
+
+func TestSetLimitChangesRate(t *testing.T) {
+	l := NewLimiter(1000, 1000)
+	l.SetLimit(10000, 1000)
+	if got := l.Rate(); got != 10000 {
+		t.Fatalf("Rate = %d, want 10000", got)
+	}
+	if elapsed := transfer(t, 3000, l); elapsed > time.Second {
+		t.Errorf("transfer took %v at the new rate, want about 200ms", elapsed)
+	}
+}
+
+func TestConnAppliesGlobalAndAccountLimiters(t *testing.T) {
+	global := NewLimiter(10000, 1000)
+	accounts := []*Limiter{NewLimiter(1000000, 1000000), NewLimiter(1000000, 1000000)}
+
+	// The accounts' limits are higher, so the shared global limit paces both transfers:
+	// the 5000 bytes over the global bucket take 500ms at 10000 B/s
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, account := range accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transfer(t, 3000, account, global)
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("transfers took %v, want about 500ms", elapsed)
+	}
+
+	// The account's limit is lower than the global one, so it paces the transfer:
+	// the 2000 bytes over the account's bucket take 400ms at 5000 B/s
+	account := NewLimiter(5000, 1000)
+	if elapsed := transfer(t, 3000, account, NewLimiter(1000000, 1000000)); elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("transfer took %v, want about 400ms", elapsed)
+	}
+}
+
+func TestNewConnWithoutLimiters(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if conn := NewConn(server, nil, nil); conn != server {
+		t.Error("the connection without limiters is wrapped")
+	}
+}
+
+func TestConnCloseStopsWaitingRead(t *testing.T) {
+	writer, reader := net.Pipe()
+	defer writer.Close()
+	conn := NewConn(reader, NewLimiter(1, 1))
+	go writer.Write(make([]byte, 100))
+
+	read := make(chan int, 1)
+	go func() {
+		// The 99 bytes over the bucket would wait 99s
+		n, _ := conn.Read(make([]byte, 100))
+		read <- n
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case n := <-read:
+		if n != 100 {
+			t.Errorf("Read = %d bytes, want the 100 read bytes", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the read is still waiting after the connection is closed")
+	}
+}
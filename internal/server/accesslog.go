@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/accesslog"
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/internal/quota"
+)
+
+// buildAccessLog opens the access log file, if it's enabled.
+// During an upgrade, both the old and the new process append their records to the same file.
+func (s *Server) buildAccessLog() error {
+	if !s.cfg.AccessLog.IsEnabled() {
+		return nil
+	}
+	var err error
+	if s.accessLog, err = accesslog.Open(s.cfg.AccessLog.Path, s.cfg.AccessLog.Format); err != nil {
+		return err
+	}
+	logger.Info("Access log is written to: ", s.cfg.AccessLog.Path)
+	return nil
+}
+
+// closeAccessLog closes the access log file, if it's open.
+func (s *Server) closeAccessLog() error {
+	if s.accessLog == nil {
+		return nil
+	}
+	return s.accessLog.Close()
+}
+
+// logAccess writes the access log record of the finished session, if the access log is enabled.
+//
+// Parameters:
+//   - ss: The finished session.
+//   - resolvedIP: The dialed IP address of the destination, empty if it wasn't dialed.
+//   - reason: Why the session ended, one of the accesslog.Reason* values.
+func (s *Server) logAccess(ss *session, resolvedIP, reason string) {
+	if s.accessLog == nil {
+		return
+	}
+	now := time.Now()
+	err := s.accessLog.Log(accesslog.Record{
+		Time:          now,
+		Session:       ss.id.String(),
+		ClientSession: ss.clientSession.String(),
+		Client:        ss.source,
+		InitKey:       ss.account.initKey,
+		User:          ss.account.username,
+		Destination:   ss.destination,
+		ResolvedIP:    resolvedIP,
+		Upload:        ss.upload.Load(),
+		Download:      ss.download.Load(),
+		Duration:      now.Sub(ss.start),
+		Reason:        reason,
+	})
+	if err != nil {
+		logger.Warn(err)
+	}
+}
+
+// relayCloseReason returns why the relay of the session ended.
+// The expiries, the kills and the shutdown (ctx is cancelled at the shutdown deadline) are checked first,
+// as they end the relay with "use of closed connection" errors.
+func (s *Server) relayCloseReason(ctx context.Context, ss *session, quotaLimits quota.Limits, relayErr error) string {
+	switch {
+	case ss.isExpired():
+		return accesslog.ReasonExpired
+	case ss.isKilled():
+		return accesslog.ReasonKilled
+	case ctx.Err() != nil:
+		return accesslog.ReasonShutdown
+	case s.quotaStore.Exceeded(ss.account.quota(), quotaLimits):
+		return accesslog.ReasonQuotaExceeded
+	case relayErr != nil:
+		return accesslog.ReasonError
+	default:
+		return accesslog.ReasonCompleted
+	}
+}
+
+// addrIP returns the IP address of the network address, or an empty string if it has none.
+func addrIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
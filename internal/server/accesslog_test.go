@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/accesslog"
+	"github.com/Iam54r1n4/Gordafarid/internal/config"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+// accessRecord holds the fields of a JSON access log record checked by the tests.
+type accessRecord struct {
+	Session     string `json:"session"`
+	Client      string `json:"client"`
+	InitKey     string `json:"initKey"`
+	User        string `json:"user"`
+	Destination string `json:"destination"`
+	ResolvedIP  string `json:"resolvedIP"`
+	Upload      int64  `json:"upload"`
+	Download    int64  `json:"download"`
+	Reason      string `json:"reason"`
+}
+
+// readAccessLog returns the records of the JSON access log file.
+func readAccessLog(t *testing.T, path string) []accessRecord {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var records []accessRecord
+	for _, line := range bytes.Split(bytes.TrimSuffix(content, []byte("\n")), []byte("\n")) {
+		if len(line) < 1 {
+			continue
+		}
+		var record accessRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("the access log line %q isn't a JSON record: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAccessLogRecordPerConnection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	s, addr := newTestServer(t, func(cfg *config.ServerConfig) {
+		cfg.AccessLog.Path = path
+	})
+	// waitForRecords waits for the access log to hold n records, and returns the last one
+	waitForRecords := func(n int) accessRecord {
+		t.Helper()
+		var records []accessRecord
+		waitFor(t, fmt.Sprintf("%d access log records", n), func() bool {
+			records = readAccessLog(t, path)
+			return len(records) >= n
+		})
+		if len(records) != n {
+			t.Fatalf("the access log holds %d records, want %d", len(records), n)
+		}
+		return records[n-1]
+	}
+
+	// A relay ending normally
+	port, destinations := listenEcho(t)
+	c, status := dialTunnel(t, addr, testUsername, testPassword, testDestination(t, "127.0.0.1", port))
+	if status != gordafarid.ReplySuccess {
+		t.Fatalf("the tunnel's reply status is %d", status)
+	}
+	c.echo("ping")
+	c.endRelay(destinations)
+	record := waitForRecords(1)
+	if record.Reason != accesslog.ReasonCompleted || record.User != testUsername || record.InitKey != gordafarid.DefaultInitKeyID ||
+		record.Destination != fmt.Sprintf("127.0.0.1:%d", port) || record.ResolvedIP != "127.0.0.1" ||
+		record.Client != c.LocalAddr().String() || len(record.Session) < 1 || record.Upload != 4 || record.Download != 4 {
+		t.Fatalf("the completed relay's record is %+v", record)
+	}
+
+	// A failed handshake isn't a proxied connection, it has no record
+	dialTunnel(t, addr, "mallory", testPassword, testDestination(t, "127.0.0.1", port))
+
+	// A destination denied by the policy
+	if _, status = dialTunnel(t, addr, testUsername, testPassword, testDestination(t, "10.0.0.1", 80)); status != gordafarid.ReplyNotAllowed {
+		t.Fatalf("the tunnel to a private address got status %d, want %d", status, gordafarid.ReplyNotAllowed)
+	}
+	if record = waitForRecords(2); record.Reason != accesslog.ReasonNotAllowed || record.ResolvedIP != "" || record.Upload != 0 {
+		t.Fatalf("the denied destination's record is %+v", record)
+	}
+
+	// A destination refusing the dial
+	ln, closedPort := listenLoopback(t, "127.0.0.1:0")
+	ln.Close()
+	if _, status = dialTunnel(t, addr, testUsername, testPassword, testDestination(t, "127.0.0.1", closedPort)); status != gordafarid.ReplyFailed {
+		t.Fatalf("the tunnel to a closed port got status %d, want %d", status, gordafarid.ReplyFailed)
+	}
+	if record = waitForRecords(3); record.Reason != accesslog.ReasonDialFailed {
+		t.Fatalf("the refused dial's record is %+v", record)
+	}
+
+	// A relay closed at the shutdown deadline, its record is written before the access log is closed
+	c, status = dialTunnel(t, addr, testUsername, testPassword, testDestination(t, "127.0.0.1", port))
+	if status != gordafarid.ReplySuccess {
+		t.Fatalf("the tunnel's reply status is %d", status)
+	}
+	c.echo("ping")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Shutdown(ctx)
+	if records := readAccessLog(t, path); len(records) != 4 || records[3].Reason != accesslog.ReasonShutdown {
+		t.Fatalf("the access log holds %+v after the shutdown, want the shutdown record last", records)
+	}
+}
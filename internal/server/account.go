@@ -0,0 +1,23 @@
+package server
+
+import (
+	"github.com/Iam54r1n4/Gordafarid/internal/quota"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+// accountID identifies an account of the server.
+// The usernames are only unique within an init key, the accounts of different init keys may share a username.
+type accountID struct {
+	initKey  string // ID of the init key the account belongs to
+	username string
+}
+
+// accountOf returns the account of the authenticated connection.
+func accountOf(gc *gordafarid.Conn) accountID {
+	return accountID{initKey: gc.InitKeyID(), username: gc.Username()}
+}
+
+// quota returns the account as it's counted by the quota store.
+func (a accountID) quota() quota.Account {
+	return quota.Account{InitKey: a.initKey, Username: a.username}
+}
@@ -0,0 +1,450 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/config"
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	net_session "github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+var (
+	errAdminUnauthorized  = errors.New("missing or invalid admin token")
+	errAdminListenFailed  = errors.New("admin API failed to start listening on specified address")
+	errSessionNotFound    = errors.New("the session is not found")
+	errInvalidSessionID   = errors.New("invalid session ID")
+	errInvalidRequestBody = errors.New("invalid request body")
+	errInvalidIP          = errors.New("invalid IP address")
+	errBansDisabled       = errors.New("the client IP bans are disabled")
+	errBanNotFound        = errors.New("the client IP is not banned")
+	errClientIPExempt     = errors.New("the client IP is exempt from the bans")
+	errInvalidBanDuration = errors.New("the ban duration must be positive")
+	errEmptyAdminUsername = errors.New("empty username")
+	errAdminRequestTooBig = errors.New("the request body is too large")
+)
+
+const (
+	// adminMaxBodySize is the maximum size of the admin API request bodies.
+	adminMaxBodySize = 1 << 20
+	// adminShutdownTimeout is how long the admin API waits for its in-flight requests on shutdown.
+	adminShutdownTimeout = 5 * time.Second
+)
+
+// startAdmin starts the admin HTTP API on the admin.address, if it's enabled.
+//
+// The API is authenticated by the admin.token, sent as a bearer token (Authorization: Bearer <token>).
+// Its endpoints are:
+//   - GET /sessions[?user=<username>]: Lists the sessions (init key, username, source, destination, transferred bytes, age).
+//   - DELETE /sessions/{id}: Kills a session, by its ID of 16 hex digits.
+//   - DELETE /users/{username}/sessions: Kills all the sessions of an account.
+//   - GET /users: Lists the configured usernames, and the runtime changes.
+//   - POST /users: Adds an account, the body is a credential entry as in the config (JSON), with an optional "initKey" ID.
+//   - POST /users/{username}/disable, POST /users/{username}/enable: Disables (and kills its sessions) or enables an account.
+//   - DELETE /users/{username}: Removes an account, and kills its sessions.
+//
+// The usernames are only unique within an init key, so the account endpoints (and the "user" filter of the sessions)
+// take the "initKey" query parameter, the server.initPassword's key by default.
+//   - GET /bans, POST /bans, DELETE /bans/{ip}: Lists, adds or lifts the client IP bans.
+//   - GET /status: Shows the server's status and enabled features.
+//
+// The account changes are kept in memory only, so they're lost on restart.
+func (s *Server) startAdmin() error {
+	if !s.cfg.Admin.IsEnabled() {
+		return nil
+	}
+	ln, err := net.Listen("tcp", s.cfg.Admin.Address)
+	if err != nil {
+		return errors.Join(errAdminListenFailed, err)
+	}
+	if !isLoopbackAddr(ln.Addr()) {
+		logger.Warn("The admin API is listening on a non-loopback address, it should only be reachable by the administrators: ", ln.Addr())
+	}
+
+	s.adminServer = &http.Server{
+		Handler:           s.adminHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func(srv *http.Server) {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(err)
+		}
+	}(s.adminServer)
+	logger.Info("Admin API is listening on: ", ln.Addr())
+	return nil
+}
+
+// stopAdmin stops the admin HTTP API, if it's running.
+func (s *Server) stopAdmin() error {
+	if s.adminServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	err := s.adminServer.Shutdown(ctx)
+	s.adminServer = nil
+	return err
+}
+
+// isLoopbackAddr reports whether the listening address is a loopback address.
+func isLoopbackAddr(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && tcpAddr.IP.IsLoopback()
+}
+
+// adminHandler returns the handler of the admin HTTP API, with the bearer token authentication.
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleListSessions)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleKillSession)
+	mux.HandleFunc("DELETE /users/{username}/sessions", s.handleKillUserSessions)
+	mux.HandleFunc("GET /users", s.handleListUsers)
+	mux.HandleFunc("POST /users", s.handleAddUser)
+	mux.HandleFunc("POST /users/{username}/disable", s.handleDisableUser)
+	mux.HandleFunc("POST /users/{username}/enable", s.handleEnableUser)
+	mux.HandleFunc("DELETE /users/{username}", s.handleRemoveUser)
+	mux.HandleFunc("GET /bans", s.handleListBans)
+	mux.HandleFunc("POST /bans", s.handleAddBan)
+	mux.HandleFunc("DELETE /bans/{ip}", s.handleUnban)
+	mux.HandleFunc("GET /status", s.handleStatus)
+
+	token := []byte("Bearer " + s.cfg.Admin.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, errAdminUnauthorized)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, adminMaxBodySize)
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeAdminJSON writes the value as the JSON response body, with the given status code.
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Debug(err)
+	}
+}
+
+// writeAdminError writes the error as a JSON response body ({"error": "..."}), with the given status code.
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	// The joined errors are on separate lines, keep the message on one line
+	writeAdminJSON(w, status, map[string]string{"error": strings.ReplaceAll(err.Error(), "\n", ": ")})
+}
+
+// decodeAdminBody decodes the JSON request body into v, rejecting the unknown fields.
+func decodeAdminBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAdminError(w, http.StatusRequestEntityTooLarge, errAdminRequestTooBig)
+			return false
+		}
+		writeAdminError(w, http.StatusBadRequest, errors.Join(errInvalidRequestBody, err))
+		return false
+	}
+	return true
+}
+
+// adminAccount returns the account of the request, by the username and the "initKey" query parameter.
+// The init key is the server.initPassword's key if the parameter is empty, otherwise it must be defined.
+func (s *Server) adminAccount(r *http.Request, username string) (accountID, error) {
+	account := accountID{initKey: r.URL.Query().Get("initKey"), username: username}
+	if len(account.initKey) < 1 {
+		account.initKey = gordafarid.DefaultInitKeyID
+	}
+	if !s.users.hasInitKey(account.initKey) {
+		return accountID{}, errors.Join(errUnknownInitKey, fmt.Errorf("initKey: %q", account.initKey))
+	}
+	return account, nil
+}
+
+// handleListSessions lists the sessions, optionally only the ones of the "user" (and "initKey") query parameters.
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	account, err := s.adminAccount(r, r.URL.Query().Get("user"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, s.sessions.list(account))
+}
+
+// handleKillSession kills the session with the given ID.
+func (s *Server) handleKillSession(w http.ResponseWriter, r *http.Request) {
+	id, err := net_session.ParseID(r.PathValue("id"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, errInvalidSessionID)
+		return
+	}
+	if !s.sessions.kill(id) {
+		writeAdminError(w, http.StatusNotFound, errSessionNotFound)
+		return
+	}
+	logger.Info("Killed the session through the admin API: ", id)
+	writeAdminJSON(w, http.StatusOK, map[string]int{"killed": 1})
+}
+
+// handleKillUserSessions kills all the sessions of the account.
+func (s *Server) handleKillUserSessions(w http.ResponseWriter, r *http.Request) {
+	account, err := s.adminAccount(r, r.PathValue("username"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	killed := s.sessions.killUser(account)
+	logger.Info("Killed the sessions of the user through the admin API: ", account.username, ", init key: ", account.initKey, ", sessions: ", killed)
+	writeAdminJSON(w, http.StatusOK, map[string]int{"killed": killed})
+}
+
+// usersResponse is the response of GET /users.
+type usersResponse struct {
+	Configured map[string][]string `json:"configured"` // Usernames of the config file by init key ID, the user files and authorizers aren't listed
+	Runtime    runtimeUsersState   `json:"runtime"`    // Changes made through the admin API
+}
+
+// handleListUsers lists the configured usernames, and the runtime changes.
+func (s *Server) handleListUsers(w http.ResponseWriter, _ *http.Request) {
+	configured := map[string][]string{gordafarid.DefaultInitKeyID: credentialUsernames(s.cfg.Credentials)}
+	for _, ik := range s.cfg.InitKeys {
+		configured[ik.ID] = credentialUsernames(ik.Credentials)
+	}
+	writeAdminJSON(w, http.StatusOK, usersResponse{
+		Configured: configured,
+		Runtime:    s.users.state(),
+	})
+}
+
+// credentialUsernames returns the sorted usernames of the configured credentials.
+func credentialUsernames(creds []config.Credential) []string {
+	usernames := make([]string, 0, len(creds))
+	for _, cred := range creds {
+		usernames = append(usernames, cred.Username)
+	}
+	slices.Sort(usernames)
+	return usernames
+}
+
+// addUserRequest is the body of POST /users, a credential entry as in the config.
+type addUserRequest struct {
+	config.Credential
+	InitKey string `json:"initKey"` // ID of the init key the account belongs to (OPTIONAL, default: the server.initPassword's key)
+}
+
+// handleAddUser validates and adds the account, replacing any account with the same username and key.
+func (s *Server) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	var req addUserRequest
+	if !decodeAdminBody(w, r, &req) {
+		return
+	}
+	if len(req.InitKey) < 1 {
+		req.InitKey = gordafarid.DefaultInitKeyID
+	}
+	if err := s.cfg.ValidateCredential(req.Credential); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	credential, err := buildGordafaridCredential(req.Credential)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = s.users.add(req.InitKey, credential); err != nil {
+		writeAdminError(w, http.StatusBadRequest, errors.Join(err, fmt.Errorf("initKey: %q", req.InitKey)))
+		return
+	}
+	logger.Info("Added the user through the admin API: ", credential.Username, ", init key: ", req.InitKey)
+	writeAdminJSON(w, http.StatusCreated, map[string]string{"username": credential.Username, "initKey": req.InitKey})
+}
+
+// handleDisableUser disables the account, and kills its sessions.
+func (s *Server) handleDisableUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	if len(username) < 1 {
+		writeAdminError(w, http.StatusBadRequest, errEmptyAdminUsername)
+		return
+	}
+	account, err := s.adminAccount(r, username)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = s.users.setDisabled(account, true); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	killed := s.sessions.killUser(account)
+	logger.Info("Disabled the user through the admin API: ", username, ", init key: ", account.initKey, ", killed sessions: ", killed)
+	writeAdminJSON(w, http.StatusOK, map[string]int{"killed": killed})
+}
+
+// handleEnableUser lifts the runtime disable of the account.
+// The accounts disabled in the config or the user files stay disabled.
+func (s *Server) handleEnableUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	if len(username) < 1 {
+		writeAdminError(w, http.StatusBadRequest, errEmptyAdminUsername)
+		return
+	}
+	account, err := s.adminAccount(r, username)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = s.users.setDisabled(account, false); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	logger.Info("Enabled the user through the admin API: ", username, ", init key: ", account.initKey)
+	writeAdminJSON(w, http.StatusOK, map[string]string{"username": username, "initKey": account.initKey})
+}
+
+// handleRemoveUser removes the account, and kills its sessions.
+func (s *Server) handleRemoveUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	if len(username) < 1 {
+		writeAdminError(w, http.StatusBadRequest, errEmptyAdminUsername)
+		return
+	}
+	account, err := s.adminAccount(r, username)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = s.users.remove(account); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	killed := s.sessions.killUser(account)
+	logger.Info("Removed the user through the admin API: ", username, ", init key: ", account.initKey, ", killed sessions: ", killed)
+	writeAdminJSON(w, http.StatusOK, map[string]int{"killed": killed})
+}
+
+// handleListBans lists the active client IP bans.
+func (s *Server) handleListBans(w http.ResponseWriter, _ *http.Request) {
+	if s.banList == nil {
+		writeAdminError(w, http.StatusNotFound, errBansDisabled)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, s.banList.Bans())
+}
+
+// addBanRequest is the body of POST /bans.
+type addBanRequest struct {
+	IP       string `json:"ip"`
+	Duration int    `json:"duration"` // In seconds (OPTIONAL, default: bans.banDuration)
+	Reason   string `json:"reason"`   // (OPTIONAL)
+}
+
+// handleAddBan bans the client IP.
+func (s *Server) handleAddBan(w http.ResponseWriter, r *http.Request) {
+	if s.banList == nil {
+		writeAdminError(w, http.StatusNotFound, errBansDisabled)
+		return
+	}
+	var req addBanRequest
+	if !decodeAdminBody(w, r, &req) {
+		return
+	}
+	ip, err := netip.ParseAddr(req.IP)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, errors.Join(errInvalidIP, err))
+		return
+	}
+	if req.Duration < 0 {
+		writeAdminError(w, http.StatusBadRequest, errInvalidBanDuration)
+		return
+	}
+	if req.Duration == 0 {
+		req.Duration = s.cfg.Bans.BanDuration
+	}
+	if len(req.Reason) < 1 {
+		req.Reason = "banned through the admin API"
+	}
+	b, banned := s.banList.Ban(ip, time.Duration(req.Duration)*time.Second, req.Reason)
+	if !banned {
+		writeAdminError(w, http.StatusConflict, errClientIPExempt)
+		return
+	}
+	logger.Warn("Banned the client IP through the admin API: ", b.IP, " until ", b.Until.Format(time.RFC3339), ", reason: ", b.Reason)
+	writeAdminJSON(w, http.StatusCreated, b)
+}
+
+// handleUnban lifts the ban of the client IP.
+func (s *Server) handleUnban(w http.ResponseWriter, r *http.Request) {
+	if s.banList == nil {
+		writeAdminError(w, http.StatusNotFound, errBansDisabled)
+		return
+	}
+	ip, err := netip.ParseAddr(r.PathValue("ip"))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, errors.Join(errInvalidIP, err))
+		return
+	}
+	if !s.banList.Unban(ip) {
+		writeAdminError(w, http.StatusNotFound, errBanNotFound)
+		return
+	}
+	logger.Info("Lifted the ban of the client IP through the admin API: ", ip)
+	writeAdminJSON(w, http.StatusOK, map[string]string{"ip": ip.String()})
+}
+
+// statusResponse is the response of GET /status.
+type statusResponse struct {
+	Address         string    `json:"address"`
+	Start           time.Time `json:"start"`
+	Uptime          float64   `json:"uptime"` // In seconds
+	Sessions        int       `json:"sessions"`
+	CryptoAlgorithm string    `json:"cryptoAlgorithm"`
+	InitKeys        []string  `json:"initKeys"` // IDs of the init keys, the server.initPassword's key first
+	Policies        []string  `json:"policies"`
+	DefaultPolicy   string    `json:"defaultPolicy"`
+	Bans            bool      `json:"bans"`           // The client IP bans are enabled
+	BannedIPs       int       `json:"bannedIPs"`      // Active client IP bans
+	QuotaStateFile  string    `json:"quotaStateFile"` // Empty if the traffic counters are kept in memory
+	HandedOff       bool      `json:"handedOff"`      // The server handed its listening socket off to a new process, and is draining
+}
+
+// handleStatus shows the server's status and enabled features.
+func (s *Server) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	status := statusResponse{
+		Start:           s.startTime,
+		Uptime:          now.Sub(s.startTime).Seconds(),
+		Sessions:        s.sessions.count(),
+		CryptoAlgorithm: s.cfg.CryptoAlgorithm,
+		InitKeys:        []string{gordafarid.DefaultInitKeyID},
+		Policies:        make([]string, 0, len(s.policies)),
+		DefaultPolicy:   s.cfg.DefaultPolicy,
+		Bans:            s.banList != nil,
+		QuotaStateFile:  s.cfg.Quota.StateFile,
+		HandedOff:       s.handedOff.Load(),
+	}
+	if s.listener != nil {
+		status.Address = s.listener.Addr().String()
+	}
+	for _, ik := range s.cfg.InitKeys {
+		status.InitKeys = append(status.InitKeys, ik.ID)
+	}
+	for name := range s.policies {
+		status.Policies = append(status.Policies, name)
+	}
+	slices.Sort(status.Policies)
+	if s.banList != nil {
+		status.BannedIPs = len(s.banList.Bans())
+	}
+	writeAdminJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/ban"
+	"github.com/Iam54r1n4/Gordafarid/internal/config"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	net_session "github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+func TestAdminAccountActionsRejectEmptyUsername(t *testing.T) {
+	s := &Server{}
+	handlers := map[string]http.HandlerFunc{
+		"disable": s.handleDisableUser,
+		"enable":  s.handleEnableUser,
+		"remove":  s.handleRemoveUser,
+	}
+	for name, handler := range handlers {
+		r := httptest.NewRequest(http.MethodPost, "/users//"+name, nil)
+		r.SetPathValue("username", "")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+		if !strings.Contains(w.Body.String(), errEmptyAdminUsername.Error()) {
+			t.Errorf("%s: body = %q, want the empty username error", name, w.Body.String())
+		}
+	}
+}
+
+const testAdminToken = "admin-token"
+
+// newTestAdminServer returns a server with the admin API's handler, the "alice" account of the default init key, and the client IP bans enabled.
+func newTestAdminServer(t *testing.T) (*Server, http.Handler, gordafarid.CredentialStore) {
+	t.Helper()
+	cfg := &config.ServerConfig{CryptoAlgorithm: testAlgorithm}
+	cfg.Admin.Token = testAdminToken
+	cfg.Bans.BanDuration = 3600
+	s := NewServer(cfg)
+	store := s.users.wrap(gordafarid.DefaultInitKeyID, gordafarid.NewMemoryCredentialStore(gordafarid.NewCredential("alice", testPassword)))
+	banList, err := ban.NewList("", 3, time.Minute, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("ban.NewList: %v", err)
+	}
+	s.banList = banList
+	return s, s.adminHandler(), store
+}
+
+// adminRequest sends the request to the admin API's handler with the admin token, and decodes the JSON response body into v (if not nil).
+func adminRequest(t *testing.T, h http.Handler, method, path, body string, v any) int {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: invalid response body %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func TestAdminRejectsUnauthorizedRequests(t *testing.T) {
+	_, h, _ := newTestAdminServer(t)
+	for name, authorization := range map[string]string{
+		"missing token":   "",
+		"wrong token":     "Bearer wrong-token",
+		"no bearer":       testAdminToken,
+		"token prefix":    "Bearer " + testAdminToken[:len(testAdminToken)-1],
+		"wrong scheme":    "Basic " + testAdminToken,
+		"trailing spaces": "Bearer " + testAdminToken + " ",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if len(authorization) > 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, http.StatusUnauthorized)
+		}
+		if !strings.Contains(w.Body.String(), errAdminUnauthorized.Error()) {
+			t.Errorf("%s: body = %q, want the unauthorized error", name, w.Body.String())
+		}
+	}
+
+	if code := adminRequest(t, h, http.MethodGet, "/status", "", nil); code != http.StatusOK {
+		t.Fatalf("the authorized request: status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	s, h, _ := newTestAdminServer(t)
+	alice := accountID{initKey: gordafarid.DefaultInitKeyID, username: "alice"}
+	bob := accountID{initKey: gordafarid.DefaultInitKeyID, username: "bob"}
+	openSession := func(account accountID) (*session, net.Conn) {
+		conn, peer := net.Pipe()
+		t.Cleanup(func() { peer.Close() })
+		ss := s.sessions.open(net_session.NewID(), net_session.ID{}, account, "10.0.0.1:1000", "example.com:443")
+		ss.addConn(conn)
+		return ss, peer
+	}
+	aliceFirst, aliceFirstPeer := openSession(alice)
+	aliceSecond, _ := openSession(alice)
+	bobSession, _ := openSession(bob)
+
+	var listed []sessionInfo
+	if code := adminRequest(t, h, http.MethodGet, "/sessions", "", &listed); code != http.StatusOK || len(listed) != 3 {
+		t.Fatalf("GET /sessions: status = %d, sessions = %+v", code, listed)
+	}
+	if code := adminRequest(t, h, http.MethodGet, "/sessions?user=alice", "", &listed); code != http.StatusOK || len(listed) != 2 {
+		t.Fatalf("GET /sessions?user=alice: status = %d, sessions = %+v", code, listed)
+	}
+	for _, info := range listed {
+		if info.Username != "alice" || info.InitKey != gordafarid.DefaultInitKeyID {
+			t.Fatalf("GET /sessions?user=alice listed %+v", info)
+		}
+	}
+	if code := adminRequest(t, h, http.MethodGet, "/sessions?user=alice&initKey=tenant-b", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("GET /sessions of an unknown init key: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Killing a session closes its connections
+	var killed map[string]int
+	if code := adminRequest(t, h, http.MethodDelete, "/sessions/"+aliceFirst.id.String(), "", &killed); code != http.StatusOK || killed["killed"] != 1 {
+		t.Fatalf("DELETE /sessions/{id}: status = %d, body = %v", code, killed)
+	}
+	if !aliceFirst.isKilled() || aliceSecond.isKilled() || bobSession.isKilled() {
+		t.Fatal("DELETE /sessions/{id} didn't kill only the session")
+	}
+	aliceFirstPeer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := aliceFirstPeer.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Fatalf("the killed session's connection isn't closed: %v", err)
+	}
+	if code := adminRequest(t, h, http.MethodDelete, "/sessions/"+net_session.NewID().String(), "", nil); code != http.StatusNotFound {
+		t.Fatalf("DELETE /sessions/{id} of an unknown session: status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := adminRequest(t, h, http.MethodDelete, "/sessions/not-an-id", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("DELETE /sessions/{id} of an invalid ID: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Killing the sessions of a user leaves the other users' ones
+	if code := adminRequest(t, h, http.MethodDelete, "/users/alice/sessions", "", &killed); code != http.StatusOK || killed["killed"] != 2 {
+		t.Fatalf("DELETE /users/alice/sessions: status = %d, body = %v", code, killed)
+	}
+	if !aliceSecond.isKilled() || bobSession.isKilled() {
+		t.Fatal("DELETE /users/alice/sessions didn't kill only alice's sessions")
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	s, h, store := newTestAdminServer(t)
+	ctx := context.Background()
+	bob := gordafarid.NewCredential("bob", testPassword)
+
+	if code := adminRequest(t, h, http.MethodPost, "/users", `{"username": "bob", "password": "short"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("POST /users of an invalid password: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := adminRequest(t, h, http.MethodPost, "/users", `{"username": "bob", "unknown": 1}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("POST /users of an unknown field: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := adminRequest(t, h, http.MethodPost, "/users", `{"username": "bob", "password": "`+testPassword+`", "initKey": "tenant-b"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("POST /users of an unknown init key: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := adminRequest(t, h, http.MethodPost, "/users", `{"username": "bob", "password": "`+testPassword+`"}`, nil); code != http.StatusCreated {
+		t.Fatalf("POST /users: status = %d, want %d", code, http.StatusCreated)
+	}
+	if credential, err := store.Lookup(ctx, keyIDOf(bob)); err != nil || credential.Disabled {
+		t.Fatalf("the added account: disabled = %v, err = %v", credential.Disabled, err)
+	}
+
+	// Disabling the account kills its sessions
+	bobSession := s.sessions.open(net_session.NewID(), net_session.ID{}, accountID{initKey: gordafarid.DefaultInitKeyID, username: "bob"}, "10.0.0.1:1000", "example.com:443")
+	var killed map[string]int
+	if code := adminRequest(t, h, http.MethodPost, "/users/bob/disable", "", &killed); code != http.StatusOK || killed["killed"] != 1 {
+		t.Fatalf("POST /users/bob/disable: status = %d, body = %v", code, killed)
+	}
+	if !bobSession.isKilled() {
+		t.Fatal("the disabled account's session isn't killed")
+	}
+	if credential, err := store.Lookup(ctx, keyIDOf(bob)); err != nil || !credential.Disabled {
+		t.Fatalf("the disabled account: disabled = %v, err = %v", credential.Disabled, err)
+	}
+	var users usersResponse
+	if code := adminRequest(t, h, http.MethodGet, "/users", "", &users); code != http.StatusOK {
+		t.Fatalf("GET /users: status = %d, want %d", code, http.StatusOK)
+	}
+	if added := users.Runtime.Added[gordafarid.DefaultInitKeyID]; !slices.Equal(added, []string{"bob"}) {
+		t.Fatalf("GET /users: added = %v, want [bob]", added)
+	}
+	if disabled := users.Runtime.Disabled[gordafarid.DefaultInitKeyID]; !slices.Equal(disabled, []string{"bob"}) {
+		t.Fatalf("GET /users: disabled = %v, want [bob]", disabled)
+	}
+
+	if code := adminRequest(t, h, http.MethodPost, "/users/bob/enable", "", nil); code != http.StatusOK {
+		t.Fatalf("POST /users/bob/enable: status = %d, want %d", code, http.StatusOK)
+	}
+	if credential, err := store.Lookup(ctx, keyIDOf(bob)); err != nil || credential.Disabled {
+		t.Fatalf("the enabled account: disabled = %v, err = %v", credential.Disabled, err)
+	}
+
+	// Both the added and the configured accounts can be removed
+	for _, credential := range []gordafarid.Credential{bob, gordafarid.NewCredential("alice", testPassword)} {
+		if code := adminRequest(t, h, http.MethodDelete, "/users/"+credential.Username, "", nil); code != http.StatusOK {
+			t.Fatalf("DELETE /users/%s: status = %d, want %d", credential.Username, code, http.StatusOK)
+		}
+		if _, err := store.Lookup(ctx, keyIDOf(credential)); !errors.Is(err, gordafarid.ErrCredentialNotFound) {
+			t.Fatalf("the removed account %s: err = %v, want %v", credential.Username, err, gordafarid.ErrCredentialNotFound)
+		}
+	}
+}
+
+func TestAdminUsersWithoutInitPassword(t *testing.T) {
+	s, _ := newTestServer(t, func(cfg *config.ServerConfig) {
+		cfg.Server.InitPassword = ""
+		cfg.Credentials = nil
+		cfg.Admin.Token = testAdminToken
+	})
+	h := s.adminHandler()
+
+	// There's no default init key, so its accounts can be neither added nor changed
+	if s.users.hasInitKey(gordafarid.DefaultInitKeyID) {
+		t.Fatal("the default init key is defined without the server.initPassword")
+	}
+	if code := adminRequest(t, h, http.MethodPost, "/users", `{"username": "bob", "password": "`+testPassword+`"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("POST /users of the default init key: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := adminRequest(t, h, http.MethodPost, "/users/bob/disable", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("POST /users/bob/disable of the default init key: status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminBans(t *testing.T) {
+	s, h, _ := newTestAdminServer(t)
+	ip := netip.MustParseAddr("203.0.113.7")
+
+	var b ban.Ban
+	if code := adminRequest(t, h, http.MethodPost, "/bans", `{"ip": "203.0.113.7", "duration": 60, "reason": "probing"}`, &b); code != http.StatusCreated {
+		t.Fatalf("POST /bans: status = %d, want %d", code, http.StatusCreated)
+	}
+	if b.IP != ip || b.Reason != "probing" || b.Until.Sub(b.Since) != time.Minute {
+		t.Fatalf("POST /bans = %+v", b)
+	}
+	if !s.banList.Banned(ip) {
+		t.Fatal("the IP banned through the admin API isn't banned")
+	}
+	var bans []ban.Ban
+	if code := adminRequest(t, h, http.MethodGet, "/bans", "", &bans); code != http.StatusOK || len(bans) != 1 || bans[0].IP != ip {
+		t.Fatalf("GET /bans: status = %d, bans = %+v", code, bans)
+	}
+
+	for body, want := range map[string]int{
+		`{"ip": "not-an-ip"}`:                     http.StatusBadRequest,
+		`{"ip": "203.0.113.8", "duration": -1}`:   http.StatusBadRequest,
+		`{"ip": "203.0.113.8", "unknown": "yes"}`: http.StatusBadRequest,
+	} {
+		if code := adminRequest(t, h, http.MethodPost, "/bans", body, nil); code != want {
+			t.Errorf("POST /bans %s: status = %d, want %d", body, code, want)
+		}
+	}
+
+	if code := adminRequest(t, h, http.MethodDelete, "/bans/203.0.113.7", "", nil); code != http.StatusOK {
+		t.Fatalf("DELETE /bans/{ip}: status = %d, want %d", code, http.StatusOK)
+	}
+	if s.banList.Banned(ip) {
+		t.Fatal("the unbanned IP is still banned")
+	}
+	if code := adminRequest(t, h, http.MethodDelete, "/bans/203.0.113.7", "", nil); code != http.StatusNotFound {
+		t.Fatalf("DELETE /bans/{ip} of an unbanned IP: status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := adminRequest(t, h, http.MethodDelete, "/bans/not-an-ip", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("DELETE /bans/{ip} of an invalid IP: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// The ban endpoints are not found while the bans are disabled
+	s.banList = nil
+	if code := adminRequest(t, h, http.MethodGet, "/bans", "", nil); code != http.StatusNotFound {
+		t.Fatalf("GET /bans with the bans disabled: status = %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/ban"
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+// banCleanupInterval is how often the expired bans are removed, and the bans are saved to the state file.
+const banCleanupInterval = time.Minute
+
+// buildBanList loads the client IP bans, and starts removing the expired ones periodically.
+// The ban list stays nil if the bans are disabled.
+func (s *Server) buildBanList() error {
+	if !s.cfg.Bans.IsEnabled() {
+		return nil
+	}
+	var err error
+	s.banList, err = ban.NewList(s.cfg.Bans.StateFile, s.cfg.Bans.MaxFailures, time.Duration(s.cfg.Bans.FailureWindow)*time.Second, time.Duration(s.cfg.Bans.BanDuration)*time.Second, s.cfg.Bans.Exempt)
+	if err != nil {
+		return err
+	}
+	s.banList.StartCleanupRoutine(s.ctx, banCleanupInterval, func(b ban.Ban) {
+		logger.Info("The ban of the client IP is expired: ", b.IP)
+	}, func(err error) {
+		logger.Warn(err)
+	})
+	if len(s.cfg.Bans.StateFile) > 0 {
+		logger.Info("Loaded the ban state file: ", s.cfg.Bans.StateFile, ", banned client IPs: ", len(s.banList.Bans()))
+	}
+	return nil
+}
+
+// recordHandshakeFailure counts the failed handshake against the client IP, and bans it if it has failed too many.
+// A replayed greeting bans the client IP right away, as only the active probes replay the recorded greetings.
+// The rejected accounts (disabled, expired, ...) aren't counted, as the client knows the account's key,
+// neither are the credential store failures (e.g. an authorizer outage), as they're not the client's fault.
+func (s *Server) recordHandshakeFailure(err error) {
+	var handshakeErr *gordafarid.HandshakeError
+	if s.banList == nil || !errors.As(err, &handshakeErr) || handshakeErr.IsAccountRejected() || handshakeErr.IsCredentialStoreUnavailable() {
+		return
+	}
+	ip, ok := ban.RemoteIP(handshakeErr.RemoteAddr)
+	if !ok {
+		return
+	}
+
+	var b ban.Ban
+	var banned bool
+	if handshakeErr.IsReplayAttack() {
+		b, banned = s.banList.Ban(ip, time.Duration(s.cfg.Bans.ReplayBanDuration)*time.Second, "replay attack")
+	} else {
+		// The joined errors are on separate lines, keep the reason on one line
+		b, banned = s.banList.Failure(ip, strings.ReplaceAll(handshakeErr.Err.Error(), "\n", ": "))
+	}
+	if banned {
+		logger.Warn("Banned the client IP: ", b.IP, " until ", b.Until.Format(time.RFC3339), ", reason: ", b.Reason)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/ban"
+	"github.com/Iam54r1n4/Gordafarid/internal/config"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+const (
+	testAlgorithm    = "chacha20-poly1305"
+	testInitPassword = "0123456789abcdef0123456789abcdef"
+	testPassword     = "abcdef0123456789abcdef0123456789"
+)
+
+// credentialStoreFunc is a CredentialStore calling the function.
+type credentialStoreFunc func(ctx context.Context, hash gordafarid.Hash) (gordafarid.Credential, error)
+
+func (f credentialStoreFunc) Lookup(ctx context.Context, hash gordafarid.Hash) (gordafarid.Credential, error) {
+	return f(ctx, hash)
+}
+
+// failedHandshake performs a handshake of the account with a server looking up its accounts in the store,
+// and returns the server-side handshake error.
+func failedHandshake(t *testing.T, store gordafarid.CredentialStore, username string) error {
+	t.Helper()
+	serverConfig := gordafarid.NewServerConfig(nil, testAlgorithm, testAlgorithm, testInitPassword, 2).AddCredentialStores(store)
+	ln, err := gordafarid.Listen("127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		destination, _ := protocol.ParseAddressHeader("127.0.0.1:80")
+		accountConfig := gordafarid.NewDialAccountConfig(gordafarid.NewCredential(username, testPassword), testInitPassword, testAlgorithm, testAlgorithm)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if conn, err := gordafarid.NewDialer(accountConfig, nil).DialContext(ctx, gordafarid.NewDialConnConfig(destination), ln.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := ln.AcceptConn()
+	if err == nil {
+		conn.Close()
+		t.Fatal("the handshake succeeded")
+	}
+	return err
+}
+
+func TestRecordHandshakeFailure(t *testing.T) {
+	localhost := netip.MustParseAddr("127.0.0.1")
+	newServer := func() *Server {
+		banList, err := ban.NewList("", 2, time.Minute, time.Hour, nil)
+		if err != nil {
+			t.Fatalf("ban.NewList: %v", err)
+		}
+		return &Server{cfg: &config.ServerConfig{}, banList: banList}
+	}
+
+	// An authorizer outage isn't the client's fault, it's neither counted nor labeled as a failed authentication
+	unavailable := credentialStoreFunc(func(context.Context, gordafarid.Hash) (gordafarid.Credential, error) {
+		return gordafarid.Credential{}, errors.New("the authorizer timed out")
+	})
+	s := newServer()
+	for i := 0; i < 3; i++ {
+		err := failedHandshake(t, unavailable, "alice")
+		if reason := gordafarid.HandshakeFailureReason(err); reason != gordafarid.HandshakeFailureStore {
+			t.Fatalf("the store failure reason is %q, want %q", reason, gordafarid.HandshakeFailureStore)
+		}
+		s.recordHandshakeFailure(err)
+	}
+	if s.banList.Banned(localhost) {
+		t.Fatal("the client IP is banned for the credential store failures")
+	}
+
+	// The unknown accounts are counted
+	notFound := credentialStoreFunc(func(context.Context, gordafarid.Hash) (gordafarid.Credential, error) {
+		return gordafarid.Credential{}, gordafarid.ErrCredentialNotFound
+	})
+	s = newServer()
+	for i := 0; i < 2; i++ {
+		err := failedHandshake(t, notFound, "mallory")
+		if reason := gordafarid.HandshakeFailureReason(err); reason != gordafarid.HandshakeFailureAuth {
+			t.Fatalf("the unknown account reason is %q, want %q", reason, gordafarid.HandshakeFailureAuth)
+		}
+		s.recordHandshakeFailure(err)
+	}
+	if !s.banList.Banned(localhost) {
+		t.Fatal("the client IP isn't banned for the failed authentications")
+	}
+}
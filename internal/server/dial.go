@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/acl"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+)
+
+// resolveDestination returns the IP addresses of the requested destination.
+// Domain names are resolved, so the access control lists are checked against the addresses that are actually dialed.
+func resolveDestination(ctx context.Context, dst protocol.AddressHeader) ([]netip.Addr, error) {
+	switch dst.Atyp {
+	case protocol.AtypIPv4, protocol.AtypIPv6:
+		addr, ok := netip.AddrFromSlice(dst.DstAddr)
+		if !ok {
+			return nil, errInvalidDestinationAddress
+		}
+		return []netip.Addr{addr.Unmap()}, nil
+	case protocol.AtypDomain:
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", string(dst.DstAddr))
+		if err != nil {
+			return nil, errors.Join(errUnableToResolveDestination, err)
+		}
+		for i := range addrs {
+			addrs[i] = addrs[i].Unmap()
+		}
+		return addrs, nil
+	default:
+		return nil, errInvalidDestinationAddress
+	}
+}
+
+// dialDestination resolves the requested destination, and dials the first of its addresses the policy allows.
+// The addresses are checked after the resolution and dialed directly, so a domain can't be re-resolved
+// to a different (e.g. private) address between the check and the dial (DNS rebinding).
+// The server's own listener is never dialed, to avoid connection loops.
+//
+// Parameters:
+//   - ctx: The context for the resolution and the dial, usually carrying the dial timeout.
+//   - policy: The account's destination access control list.
+//   - dst: The requested destination.
+//
+// Returns:
+//   - net.Conn: The connection to the destination.
+//   - error: errDestinationNotAllowed if the policy denies all the destination's addresses, or the resolution/dial error.
+func (s *Server) dialDestination(ctx context.Context, policy *acl.Policy, dst protocol.AddressHeader) (net.Conn, error) {
+	addrs, err := resolveDestination(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	port := binary.BigEndian.Uint16(dst.DstPort[:])
+	var domain string
+	if dst.Atyp == protocol.AtypDomain {
+		domain = string(dst.DstAddr)
+	}
+
+	var dialer net.Dialer
+	var dialErr error
+	for _, addr := range addrs {
+		if s.isOwnListener(addr, port) || !policy.Allowed(acl.Destination{Domain: domain, IP: addr, Port: port}) {
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", netip.AddrPortFrom(addr, port).String())
+		if err != nil {
+			dialErr = errors.Join(dialErr, err)
+			continue
+		}
+		return conn, nil
+	}
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	return nil, errors.Join(errDestinationNotAllowed, fmt.Errorf("destination: %s", dst.String()))
+}
+
+// isOwnListener reports whether the address is the server's own listener, so dialing it would loop the connection back into the server.
+// If the listener is bound to an unspecified address, all the local addresses on its port are considered.
+func (s *Server) isOwnListener(addr netip.Addr, port uint16) bool {
+	if s.listener == nil {
+		return false
+	}
+	listenerAddr, err := netip.ParseAddrPort(s.listener.Addr().String())
+	if err != nil || listenerAddr.Port() != port {
+		return false
+	}
+	listenerIP := listenerAddr.Addr().Unmap()
+	if !listenerIP.IsUnspecified() {
+		return listenerIP == addr
+	}
+	if addr.IsLoopback() || addr.IsUnspecified() {
+		return true
+	}
+	localAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		// Fail closed, the local addresses are unknown
+		return true
+	}
+	for _, localAddr := range localAddrs {
+		if prefix, err := netip.ParsePrefix(localAddr.String()); err == nil && prefix.Addr().Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
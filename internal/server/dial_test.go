@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/acl"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+)
+
+// testDestination returns the address header of the host and port.
+func testDestination(t *testing.T, host string, port uint16) protocol.AddressHeader {
+	t.Helper()
+	var dstPort [protocol.DstPortSize]byte
+	binary.BigEndian.PutUint16(dstPort[:], port)
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if addr.Is4() {
+			return *protocol.NewAddressHeader(protocol.AtypIPv4, addr.AsSlice(), dstPort)
+		}
+		return *protocol.NewAddressHeader(protocol.AtypIPv6, addr.AsSlice(), dstPort)
+	}
+	return *protocol.NewAddressHeader(protocol.AtypDomain, []byte(host), dstPort)
+}
+
+// listenLoopback starts a TCP listener on the loopback address, accepting and closing its connections.
+func listenLoopback(t *testing.T, address string) (net.Listener, uint16) {
+	t.Helper()
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln, uint16(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func dialTest(s *Server, policy *acl.Policy, dst protocol.AddressHeader) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, err := s.dialDestination(ctx, policy, dst)
+	if err == nil {
+		conn.Close()
+	}
+	return err
+}
+
+func TestDialDestinationSpecialRanges(t *testing.T) {
+	_, port := listenLoopback(t, "127.0.0.1:0")
+	s := &Server{}
+
+	// The special-purpose ranges are denied by default, whether they're requested directly or resolved from a domain
+	allowAll := acl.NewAllowAllPolicy("all")
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		if err := dialTest(s, allowAll, testDestination(t, host, port)); !errors.Is(err, errDestinationNotAllowed) {
+			t.Errorf("dialing %s returned %v, want %v", host, err, errDestinationNotAllowed)
+		}
+	}
+
+	// Unless the policy allows them
+	allowLoopback, err := acl.NewPolicy("loopback", "allow", []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	if err := dialTest(s, allowLoopback, testDestination(t, "127.0.0.1", port)); err != nil {
+		t.Errorf("dialing the allowed loopback range failed: %v", err)
+	}
+}
+
+func TestDialDestinationRefusesOwnListener(t *testing.T) {
+	allowLoopback, err := acl.NewPolicy("loopback", "allow", []string{"127.0.0.0/8", "::1/128"})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+
+	for _, address := range []string{"127.0.0.1:0", ":0"} {
+		ln, port := listenLoopback(t, address)
+		s := &Server{listener: ln}
+		// The listener's own address, the loopback one if it's bound to all the addresses, and a domain resolving to it
+		for _, host := range []string{"127.0.0.1", "localhost"} {
+			if err := dialTest(s, allowLoopback, testDestination(t, host, port)); !errors.Is(err, errDestinationNotAllowed) {
+				t.Errorf("dialing the listener %s through %s returned %v, want %v", ln.Addr(), host, err, errDestinationNotAllowed)
+			}
+		}
+
+		// The other ports of the same address are dialed
+		_, otherPort := listenLoopback(t, "127.0.0.1:0")
+		if err := dialTest(s, allowLoopback, testDestination(t, "127.0.0.1", otherPort)); err != nil {
+			t.Errorf("dialing another port than the listener %s's failed: %v", ln.Addr(), err)
+		}
+	}
+}
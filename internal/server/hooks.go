@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/accesslog"
+	"github.com/Iam54r1n4/Gordafarid/internal/acl"
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/internal/metrics"
+	"github.com/Iam54r1n4/Gordafarid/internal/quota"
+	"github.com/Iam54r1n4/Gordafarid/internal/ratelimit"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/proxy"
+	proxy_server "github.com/Iam54r1n4/Gordafarid/pkg/proxy/server"
+)
+
+// requestState is the state of a request, kept in its context from the Authorize hook to the OnClose hook.
+type requestState struct {
+	session         *session           // The session of the request, listed and killed through the admin API
+	policy          *acl.Policy        // The account's destination access control list
+	clientIP        string             // The client IP of the account's connection, counted by the connection limiter
+	limited         bool               // The connection is counted by the connection limiter, it's released on close
+	quotaLimits     quota.Limits       // The account's traffic quotas
+	uploadLimiter   *ratelimit.Limiter // The account's upload bandwidth limiter
+	downloadLimiter *ratelimit.Limiter // The account's download bandwidth limiter
+	tunnel          bool               // The relay is started, it's counted in the active tunnels
+	expiry          *time.Timer        // Kills the session once the account stops being allowed to authenticate, nil if it doesn't
+}
+
+// requestStateKey is the context key of the requestState.
+type requestStateKey struct{}
+
+// stateOf returns the state of the request, stored in its context by the Authorize hook.
+func stateOf(ctx context.Context) *requestState {
+	state, _ := ctx.Value(requestStateKey{}).(*requestState)
+	return state
+}
+
+// hooks returns the hooks of the proxy server, which add the features of the server (policies, limits, quotas, sessions, metrics and access log).
+func (s *Server) hooks() proxy_server.Hooks {
+	return proxy_server.Hooks{
+		OnHandshakeError: s.onHandshakeError,
+		Authorize:        s.authorize,
+		Dial:             s.dial,
+		Wrap:             s.wrap,
+		OnClose:          s.onClose,
+	}
+}
+
+// onHandshakeError counts the failed handshake against the client IP, and in the metrics.
+func (s *Server) onHandshakeError(err error) {
+	s.recordHandshakeFailure(err)
+	s.metrics.handshakeFailed(err)
+}
+
+// authorize registers the session of the request, and checks the account's policy, connection limits and traffic quotas.
+// The state of the request is stored in the returned context, even if it's rejected, so it's cleaned up by onClose.
+func (s *Server) authorize(ctx context.Context, req *proxy_server.Request) (context.Context, error) {
+	gc := req.Conn
+	s.metrics.handshakeSucceeded(gc)
+
+	// Register the session, so it can be listed and killed through the admin API
+	state := &requestState{
+		session: s.sessions.open(gc.SessionID(), gc.ClientSessionID(), accountOf(gc), gc.RemoteAddr().String(), req.Destination.String()),
+	}
+	state.session.addConn(gc)
+	// The account's lifecycle is only checked by the handshake, so its session is killed once it expires or leaves its allowed hours
+	state.expiry = state.session.expireAt(gc.ValidUntil())
+	ctx = context.WithValue(ctx, requestStateKey{}, state)
+	// Attach the account to every line logged for the request by the hooks
+	ctx = logger.WithAttrs(ctx, "user", gc.Username(), "client", gc.RemoteAddr().String())
+	if clientSession := gc.ClientSessionID(); !clientSession.IsZero() {
+		ctx = logger.WithAttrs(ctx, "clientSession", clientSession.String())
+	}
+
+	// Find the account's destination access control list
+	var err error
+	if state.policy, err = s.accountPolicy(gc); err != nil {
+		return ctx, proxy_server.Reject(gordafarid.ReplyNotAllowed, errors.Join(errDestinationNotAllowed, err))
+	}
+
+	// Check the account's concurrent connection limits, the client IP's one is checked at accept time (see admitConnection)
+	connectionLimits, err := s.accountConnectionLimits(gc)
+	if err != nil {
+		return ctx, err
+	}
+	state.clientIP = remoteIP(gc)
+	if err = s.connectionLimiter.acquire(state.session.account, state.clientIP, connectionLimits); err != nil {
+		return ctx, proxy_server.Reject(gordafarid.ReplyLimitExceeded, err)
+	}
+	state.limited = true
+
+	// Check the account's traffic quotas
+	if state.quotaLimits, err = accountQuotaLimits(gc); err != nil {
+		return ctx, err
+	}
+	if s.quotaStore.Exceeded(state.session.account.quota(), state.quotaLimits) {
+		return ctx, proxy_server.Reject(gordafarid.ReplyQuotaExceeded, errQuotaExceeded)
+	}
+
+	// Find the account's bandwidth limiters
+	if state.uploadLimiter, state.downloadLimiter, err = s.accountRateLimiters(gc); err != nil {
+		return ctx, err
+	}
+	return ctx, nil
+}
+
+// dial dials the destination of the request, only the addresses the account's policy allows.
+func (s *Server) dial(ctx context.Context, req *proxy_server.Request) (net.Conn, error) {
+	state := stateOf(ctx)
+	tconn, err := s.dialDestination(ctx, state.policy, req.Destination)
+	if err != nil {
+		if errors.Is(err, errDestinationNotAllowed) {
+			return nil, proxy_server.Reject(gordafarid.ReplyNotAllowed, err)
+		}
+		s.metrics.dialErrors.With(metrics.DialErrorType(err)).Inc()
+		return nil, err
+	}
+	state.session.addConn(tconn)
+	return tconn, nil
+}
+
+// wrap counts the traffic and limits the bandwidth by the reads of both sides, the client's reads are the uploads and the target's reads are the downloads.
+// Once the account exceeds its quotas, the quota store closes all its connections.
+func (s *Server) wrap(ctx context.Context, req *proxy_server.Request, client, target net.Conn) (net.Conn, net.Conn) {
+	state := stateOf(ctx)
+	account := state.session.account
+	s.metrics.activeTunnels.Inc()
+	state.tunnel = true
+
+	uploadCounter := &countingConn{Conn: client, n: &state.session.upload, total: s.metrics.bytesRelayed.With(account.initKey, account.username, directionUpload)}
+	clientConn := ratelimit.NewConn(s.quotaStore.NewConn(uploadCounter, account.quota(), state.quotaLimits), state.uploadLimiter, s.globalUploadLimiter)
+	downloadCounter := &countingConn{Conn: target, n: &state.session.download, total: s.metrics.bytesRelayed.With(account.initKey, account.username, directionDownload)}
+	targetConn := ratelimit.NewConn(s.quotaStore.NewConn(downloadCounter, account.quota(), state.quotaLimits), state.downloadLimiter, s.globalDownloadLimiter)
+	return clientConn, targetConn
+}
+
+// onClose releases the resources of the request, and writes its access log record.
+func (s *Server) onClose(ctx context.Context, req *proxy_server.Request, result proxy_server.Result) {
+	state := stateOf(ctx)
+	if state == nil {
+		return
+	}
+	defer s.sessions.close(state.session)
+	if state.expiry != nil {
+		state.expiry.Stop()
+	}
+	if state.limited {
+		s.connectionLimiter.release(state.session.account, state.clientIP)
+	}
+	if state.tunnel {
+		s.metrics.activeTunnels.Dec()
+	}
+
+	var resolvedIP string
+	if result.Target != nil {
+		resolvedIP = addrIP(result.Target)
+	}
+	s.logAccess(state.session, resolvedIP, s.closeReason(ctx, state, result))
+}
+
+// closeReason returns why the request ended, one of the accesslog.Reason* values.
+func (s *Server) closeReason(ctx context.Context, state *requestState, result proxy_server.Result) string {
+	switch {
+	case result.Err == nil || errors.Is(result.Err, proxy.ErrRelayFailed):
+		return s.relayCloseReason(ctx, state.session, state.quotaLimits, result.Err)
+	case errors.Is(result.Err, errUndefinedPolicy):
+		return accesslog.ReasonFailed
+	case result.Reply == gordafarid.ReplyNotAllowed:
+		return accesslog.ReasonNotAllowed
+	case result.Reply == gordafarid.ReplyLimitExceeded:
+		return accesslog.ReasonLimitExceeded
+	case result.Reply == gordafarid.ReplyQuotaExceeded:
+		return accesslog.ReasonQuotaExceeded
+	case errors.Is(result.Err, proxy_server.ErrDialFailed):
+		return accesslog.ReasonDialFailed
+	case errors.Is(result.Err, proxy_server.ErrReplyFailed):
+		return accesslog.ReasonError
+	default:
+		return accesslog.ReasonFailed
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+// Credential attributes of the per-account connection limits, overriding the server-wide limits.
+const (
+	maxConnectionsAttribute = "maxConnections"
+	maxIPsAttribute         = "maxIPs"
+)
+
+var (
+	errTooManyConnectionsPerUser = errors.New("the account has reached its concurrent connections limit")
+	errTooManyConnectionsPerIP   = errors.New("the client IP has reached its concurrent connections limit")
+	errTooManyIPsPerUser         = errors.New("the account has reached its distinct client IPs limit, the credential may be shared")
+	errInvalidLimitAttribute     = errors.New("the account's connection limit attribute is invalid")
+)
+
+// connectionLimits are the concurrent connection limits of an account, 0 means unlimited.
+type connectionLimits struct {
+	maxPerUser    int // Concurrent connections of the account
+	maxIPsPerUser int // Distinct client IPs of the account's concurrent connections
+}
+
+// connectionLimiter counts the open connections per account and per client IP.
+// The connections of a client IP are counted from accept time (see acquireIP), so its handshakes in flight count too,
+// while the connections of an account are counted once it's authenticated (see acquire).
+type connectionLimiter struct {
+	mu     sync.Mutex
+	byIP   map[string]int               // Open connections by client IP, including the ones being handshaked
+	byUser map[accountID]map[string]int // Open connections by account, then by client IP
+}
+
+// acquireIP counts a new accepted connection of the client IP, if it doesn't exceed the limit (0 means unlimited).
+// The connection must be released by releaseIP once it's closed.
+func (cl *connectionLimiter) acquireIP(ip string, maxPerIP int) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.byIP == nil {
+		cl.byIP = make(map[string]int)
+	}
+	if maxPerIP > 0 && cl.byIP[ip] >= maxPerIP {
+		return errTooManyConnectionsPerIP
+	}
+	cl.byIP[ip]++
+	return nil
+}
+
+// releaseIP uncounts a connection counted by acquireIP.
+func (cl *connectionLimiter) releaseIP(ip string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.byIP[ip]--; cl.byIP[ip] <= 0 {
+		delete(cl.byIP, ip)
+	}
+}
+
+// acquire counts a new connection of the account from the client IP, if it doesn't exceed the limits.
+// The connection must be released once it's closed.
+func (cl *connectionLimiter) acquire(account accountID, ip string, limits connectionLimits) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.byUser == nil {
+		cl.byUser = make(map[accountID]map[string]int)
+	}
+
+	userIPs := cl.byUser[account]
+	userConnections := 0
+	for _, count := range userIPs {
+		userConnections += count
+	}
+	if limits.maxPerUser > 0 && userConnections >= limits.maxPerUser {
+		return errTooManyConnectionsPerUser
+	}
+	if _, exists := userIPs[ip]; !exists && limits.maxIPsPerUser > 0 && len(userIPs) >= limits.maxIPsPerUser {
+		return errTooManyIPsPerUser
+	}
+
+	if userIPs == nil {
+		userIPs = make(map[string]int)
+		cl.byUser[account] = userIPs
+	}
+	userIPs[ip]++
+	return nil
+}
+
+// release uncounts a connection counted by acquire.
+func (cl *connectionLimiter) release(account accountID, ip string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if userIPs := cl.byUser[account]; userIPs != nil {
+		if userIPs[ip]--; userIPs[ip] <= 0 {
+			delete(userIPs, ip)
+		}
+		if len(userIPs) < 1 {
+			delete(cl.byUser, account)
+		}
+	}
+}
+
+// parseLimitAttribute parses a connection limit attribute of the account.
+// A missing attribute means the given server-wide limit applies.
+func parseLimitAttribute(attributes map[string]string, name string, serverLimit int) (int, error) {
+	value, exists := attributes[name]
+	if !exists {
+		return serverLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, errors.Join(errInvalidLimitAttribute, fmt.Errorf("%s: %q", name, value))
+	}
+	return limit, nil
+}
+
+// accountConnectionLimits returns the concurrent connection limits of the connection's account.
+func (s *Server) accountConnectionLimits(gc *gordafarid.Conn) (connectionLimits, error) {
+	var limits connectionLimits
+	var err error
+	if limits.maxPerUser, err = parseLimitAttribute(gc.Attributes(), maxConnectionsAttribute, s.cfg.Limits.MaxConnectionsPerUser); err != nil {
+		return connectionLimits{}, err
+	}
+	if limits.maxIPsPerUser, err = parseLimitAttribute(gc.Attributes(), maxIPsAttribute, s.cfg.Limits.MaxIPsPerUser); err != nil {
+		return connectionLimits{}, err
+	}
+	return limits, nil
+}
+
+// remoteIP returns the IP address of the connection's remote address.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// admitConnection counts the accepted connection against its client IP's concurrent connections limit, before its handshake,
+// so a single client IP can't take all the handshake slots. It returns the connection wrapped to release it once it's closed,
+// or errTooManyConnectionsPerIP to reject it.
+func (s *Server) admitConnection(c net.Conn) (net.Conn, error) {
+	ip := remoteIP(c)
+	if err := s.connectionLimiter.acquireIP(ip, s.cfg.Limits.MaxConnectionsPerIP); err != nil {
+		return nil, err
+	}
+	return &ipLimitedConn{Conn: c, release: func() { s.connectionLimiter.releaseIP(ip) }}, nil
+}
+
+// ipLimitedConn is a connection counted against its client IP's limit, it's released once the connection is closed.
+type ipLimitedConn struct {
+	net.Conn
+	release   func()
+	closeOnce sync.Once
+}
+
+// Close closes the connection, and releases it from the client IP's count.
+func (c *ipLimitedConn) Close() error {
+	c.closeOnce.Do(c.release)
+	return c.Conn.Close()
+}
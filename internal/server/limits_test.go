@@ -0,0 +1,124 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/config"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+func TestConnectionLimiterPerInitKey(t *testing.T) {
+	var cl connectionLimiter
+	alice := accountID{initKey: "default", username: "alice"}
+	tenantAlice := accountID{initKey: "tenant-b", username: "alice"}
+	limits := connectionLimits{maxPerUser: 1, maxIPsPerUser: 1}
+
+	if err := cl.acquire(alice, "192.0.2.1", limits); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if err := cl.acquire(alice, "192.0.2.1", limits); !errors.Is(err, errTooManyConnectionsPerUser) {
+		t.Fatalf("the account's second connection got %v, want the per-account limit", err)
+	}
+	// The same-named account of another init key has limits of its own
+	if err := cl.acquire(tenantAlice, "198.51.100.1", limits); err != nil {
+		t.Fatalf("the same-named account of another init key is limited: %v", err)
+	}
+
+	cl.release(alice, "192.0.2.1")
+	if err := cl.acquire(alice, "203.0.113.1", limits); err != nil {
+		t.Fatalf("the released connection is still counted: %v", err)
+	}
+	if err := cl.acquire(alice, "192.0.2.1", connectionLimits{maxIPsPerUser: 1}); !errors.Is(err, errTooManyIPsPerUser) {
+		t.Fatalf("the account's second IP got %v, want the distinct IPs limit", err)
+	}
+}
+
+func TestConnectionLimiterPerIP(t *testing.T) {
+	var cl connectionLimiter
+	if err := cl.acquireIP("192.0.2.1", 1); err != nil {
+		t.Fatalf("acquireIP: %v", err)
+	}
+	if err := cl.acquireIP("192.0.2.1", 1); !errors.Is(err, errTooManyConnectionsPerIP) {
+		t.Fatalf("the IP's second connection got %v, want the per-IP limit", err)
+	}
+	if err := cl.acquireIP("198.51.100.1", 1); err != nil {
+		t.Fatalf("another IP is limited: %v", err)
+	}
+	cl.releaseIP("192.0.2.1")
+	if err := cl.acquireIP("192.0.2.1", 1); err != nil {
+		t.Fatalf("the released connection is still counted: %v", err)
+	}
+}
+
+func TestAdmitConnectionBeforeHandshake(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.Limits.MaxConnectionsPerIP = 1
+	s := NewServer(cfg)
+	listenConfig := gordafarid.NewServerConfig(nil, testAlgorithm, testAlgorithm, testInitPassword, 5)
+	listenConfig.Admit = s.admitConnection
+	ln, err := gordafarid.Listen("127.0.0.1:0", listenConfig)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan error, 2)
+	go func() {
+		for {
+			_, err := ln.AcceptConn()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			accepted <- err
+		}
+	}()
+
+	// The silent connection is being handshaked, it holds the client IP's only slot
+	silent, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// The next connection of the IP is rejected before its handshake, with the greeting failure reply
+	rejected, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if reply, err := io.ReadAll(rejected); err != nil || len(reply) != 2 {
+		t.Fatalf("the rejected connection got %x (%v), want the greeting failure reply", reply, err)
+	}
+	select {
+	case err = <-accepted:
+		if !errors.Is(err, errTooManyConnectionsPerIP) {
+			t.Fatalf("AcceptConn returned %v, want the per-IP limit", err)
+		}
+		if reason := gordafarid.HandshakeFailureReason(err); reason != gordafarid.HandshakeFailureLimit {
+			t.Fatalf("the failure reason is %q, want %q", reason, gordafarid.HandshakeFailureLimit)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the rejected connection wasn't reported by AcceptConn")
+	}
+
+	// Once the silent connection's handshake fails and it's closed, its slot is released
+	silent.Close()
+	select {
+	case err = <-accepted:
+		if err == nil || errors.Is(err, errTooManyConnectionsPerIP) {
+			t.Fatalf("the silent connection's handshake returned %v, want its failure", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the silent connection's handshake failure wasn't reported by AcceptConn")
+	}
+	s.connectionLimiter.mu.Lock()
+	count := s.connectionLimiter.byIP["127.0.0.1"]
+	s.connectionLimiter.mu.Unlock()
+	if count != 0 {
+		t.Fatalf("the closed connections are still counted: %d", count)
+	}
+}
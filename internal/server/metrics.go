@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/internal/metrics"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/cipher_conn"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
+)
+
+// Directions of the relayed bytes, the values of the "direction" label.
+const (
+	directionUpload   = "upload"   // From the client to the destination
+	directionDownload = "download" // From the destination to the client
+)
+
+// metricsShutdownTimeout is how long the metrics endpoint waits for its in-flight scrapes on shutdown.
+const metricsShutdownTimeout = 5 * time.Second
+
+// serverMetrics holds the metrics of the server, exposed on the metrics.address in the Prometheus text format.
+// They're collected even if the endpoint is disabled, as they're cheap atomic counters.
+type serverMetrics struct {
+	registry            *metrics.Registry
+	connectionsAccepted *metrics.Counter    // TCP connections accepted, before the bans and the handshakes
+	handshakes          *metrics.Counter    // Successful handshakes
+	handshakeFailures   *metrics.CounterVec // Failed handshakes by reason
+	handshakeDuration   *metrics.Histogram  // Duration of the successful handshakes
+	bytesRelayed        *metrics.CounterVec // Relayed bytes by account (init key and user) and direction
+	activeTunnels       *metrics.Gauge      // Tunnels relaying data
+	dialErrors          *metrics.CounterVec // Failed destination dials by type
+}
+
+// newServerMetrics creates and registers the metrics of the server.
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry:            r,
+		connectionsAccepted: r.NewCounter("gordafarid_server_connections_accepted_total", "TCP connections accepted by the server.", nil),
+		handshakes:          r.NewCounter("gordafarid_server_handshakes_total", "Successful Gordafarid handshakes.", nil),
+		handshakeFailures:   r.NewCounterVec("gordafarid_server_handshake_failures_total", "Failed Gordafarid handshakes by reason.", "reason"),
+		handshakeDuration:   r.NewHistogram("gordafarid_server_handshake_duration_seconds", "Duration of the successful Gordafarid handshakes.", metrics.DefaultLatencyBuckets),
+		bytesRelayed:        r.NewCounterVec("gordafarid_server_relayed_bytes_total", "Bytes relayed by account (init key and user) and direction (upload: client to destination, download: destination to client).", "init_key", "user", "direction"),
+		activeTunnels:       r.NewGauge("gordafarid_server_active_tunnels", "Tunnels relaying data.", nil),
+		dialErrors:          r.NewCounterVec("gordafarid_server_dial_errors_total", "Failed destination dials by type.", "type"),
+	}
+	r.NewGaugeFunc("gordafarid_server_nonce_cache_size", "Nonces kept to detect the replays.", metrics.Labels{"cache": "greeting"}, func() float64 {
+		return float64(aead.NonceCacheSize())
+	})
+	r.NewGaugeFunc("gordafarid_server_nonce_cache_size", "Nonces kept to detect the replays.", metrics.Labels{"cache": "data"}, func() float64 {
+		return float64(cipher_conn.NonceCacheSize())
+	})
+	return m
+}
+
+// handshakeSucceeded records a successful handshake.
+func (m *serverMetrics) handshakeSucceeded(gc *gordafarid.Conn) {
+	m.handshakes.Inc()
+	m.handshakeDuration.Observe(gc.HandshakeDuration().Seconds())
+}
+
+// handshakeFailed records a failed handshake, or a connection rejected by the handshakes limit.
+// The other accept errors aren't counted.
+func (m *serverMetrics) handshakeFailed(err error) {
+	var handshakeErr *gordafarid.HandshakeError
+	if errors.As(err, &handshakeErr) {
+		m.handshakeFailures.With(handshakeErr.Reason()).Inc()
+	} else if reason := gordafarid.HandshakeFailureReason(err); reason == gordafarid.HandshakeFailureLimit {
+		m.handshakeFailures.With(reason).Inc()
+	}
+}
+
+// startMetrics starts serving the metrics on the metrics.address, if it's enabled.
+func (s *Server) startMetrics() error {
+	if !s.cfg.Metrics.IsEnabled() {
+		return nil
+	}
+	s.metricsServer = metrics.NewServer(s.cfg.Metrics.Address, s.metrics.registry)
+	addr, err := s.metricsServer.Listen(func(err error) {
+		logger.Error(err)
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Metrics are served on: http://", addr, "/metrics")
+	return nil
+}
+
+// stopMetrics stops serving the metrics, if it's running.
+func (s *Server) stopMetrics() error {
+	if s.metricsServer == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+	defer cancel()
+	return s.metricsServer.Shutdown(ctx)
+}
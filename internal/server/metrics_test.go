@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/metrics"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+// waitFor polls the condition until it's true, failing the test after 2 seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsFollowProxiedConnection(t *testing.T) {
+	s, addr := newTestServer(t, nil)
+	m := s.metrics
+	upload := m.bytesRelayed.With(gordafarid.DefaultInitKeyID, testUsername, directionUpload)
+	download := m.bytesRelayed.With(gordafarid.DefaultInitKeyID, testUsername, directionDownload)
+
+	port, destinations := listenEcho(t)
+	c, status := dialTunnel(t, addr, testUsername, testPassword, testDestination(t, "127.0.0.1", port))
+	if status != gordafarid.ReplySuccess {
+		t.Fatalf("the tunnel's reply status is %d", status)
+	}
+	c.echo("ping")
+	c.echo("hello")
+	if got := m.connectionsAccepted.Value(); got != 1 {
+		t.Errorf("connections accepted = %d, want 1", got)
+	}
+	if got := m.handshakes.Value(); got != 1 {
+		t.Errorf("handshakes = %d, want 1", got)
+	}
+	if got := m.activeTunnels.Value(); got != 1 {
+		t.Errorf("active tunnels = %d during the relay, want 1", got)
+	}
+	// The echoed bytes are counted once they're read from either side
+	if got := upload.Value(); got != 9 {
+		t.Errorf("uploaded bytes = %d, want 9", got)
+	}
+	if got := download.Value(); got != 9 {
+		t.Errorf("downloaded bytes = %d, want 9", got)
+	}
+
+	// The scrape shows the counters of the account
+	var scrape bytes.Buffer
+	if _, err := m.registry.WriteTo(&scrape); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	for _, sample := range []string{
+		"gordafarid_server_handshakes_total 1\n",
+		"gordafarid_server_handshake_duration_seconds_count 1\n",
+		`gordafarid_server_relayed_bytes_total{direction="upload",init_key="default",user="alice"} 9` + "\n",
+		`gordafarid_server_relayed_bytes_total{direction="download",init_key="default",user="alice"} 9` + "\n",
+		"gordafarid_server_active_tunnels 1\n",
+	} {
+		if !strings.Contains(scrape.String(), sample) {
+			t.Errorf("the scrape doesn't hold %q:\n%s", sample, scrape.String())
+		}
+	}
+
+	c.endRelay(destinations)
+	waitFor(t, "the tunnel to end", func() bool { return m.activeTunnels.Value() == 0 })
+}
+
+func TestMetricsCountFailures(t *testing.T) {
+	s, addr := newTestServer(t, nil)
+	m := s.metrics
+
+	// An unknown account fails its handshake
+	if _, status := dialTunnel(t, addr, "mallory", testPassword, testDestination(t, "127.0.0.1", 80)); status == 0 {
+		t.Fatal("the unknown account's greeting succeeded")
+	}
+	failures := m.handshakeFailures.With(gordafarid.HandshakeFailureAuth)
+	waitFor(t, "the failed handshake to be counted", func() bool { return failures.Value() == 1 })
+
+	// A closed port refuses the dial
+	ln, port := listenLoopback(t, "127.0.0.1:0")
+	ln.Close()
+	if _, status := dialTunnel(t, addr, testUsername, testPassword, testDestination(t, "127.0.0.1", port)); status != gordafarid.ReplyFailed {
+		t.Fatalf("the tunnel to a closed port got status %d, want %d", status, gordafarid.ReplyFailed)
+	}
+	if got := m.dialErrors.With(metrics.DialErrorRefused).Value(); got != 1 {
+		t.Errorf("refused dials = %d, want 1", got)
+	}
+	if got := m.connectionsAccepted.Value(); got != 2 {
+		t.Errorf("connections accepted = %d, want 2", got)
+	}
+	if got := m.handshakes.Value(); got != 1 {
+		t.Errorf("handshakes = %d, want 1", got)
+	}
+	if got := m.activeTunnels.Value(); got != 0 {
+		t.Errorf("active tunnels = %d without a relay, want 0", got)
+	}
+}
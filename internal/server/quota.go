@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/internal/quota"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+var (
+	errQuotaExceeded         = errors.New("the account has exceeded its traffic quota")
+	errInvalidQuotaAttribute = errors.New("the account's quota attribute is invalid")
+)
+
+// buildQuotaStore loads the traffic counters, and starts saving them periodically.
+func (s *Server) buildQuotaStore() error {
+	var err error
+	s.quotaStore, err = quota.NewStore(s.cfg.Quota.StateFile)
+	if err != nil {
+		return err
+	}
+	if len(s.cfg.Quota.StateFile) > 0 {
+		saveInterval := time.Duration(s.cfg.Quota.SaveInterval) * time.Second
+		s.quotaStore.StartPersistRoutine(s.ctx, saveInterval, func(err error) {
+			logger.Warn(err)
+		})
+		logger.Info("Loaded the quota state file: ", s.cfg.Quota.StateFile)
+	}
+	return nil
+}
+
+// parseQuotaAttribute parses a quota attribute of the account, in bytes.
+// A missing attribute means unlimited.
+func parseQuotaAttribute(attributes map[string]string, name string) (int64, error) {
+	value, exists := attributes[name]
+	if !exists {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return 0, errors.Join(errInvalidQuotaAttribute, fmt.Errorf("%s: %q", name, value))
+	}
+	return limit, nil
+}
+
+// accountQuotaLimits returns the traffic quotas of the connection's account.
+func accountQuotaLimits(gc *gordafarid.Conn) (quota.Limits, error) {
+	var limits quota.Limits
+	var err error
+	if limits.Daily, err = parseQuotaAttribute(gc.Attributes(), quota.DailyQuotaAttribute); err != nil {
+		return quota.Limits{}, err
+	}
+	if limits.Monthly, err = parseQuotaAttribute(gc.Attributes(), quota.MonthlyQuotaAttribute); err != nil {
+		return quota.Limits{}, err
+	}
+	if limits.Total, err = parseQuotaAttribute(gc.Attributes(), quota.TotalQuotaAttribute); err != nil {
+		return quota.Limits{}, err
+	}
+	return limits, nil
+}
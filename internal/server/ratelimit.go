@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/ratelimit"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+var errInvalidRateLimitAttribute = errors.New("the account's rate limit attribute is invalid")
+
+// userRateLimiters holds the per-account limiters, shared by all the connections of an account.
+type userRateLimiters struct {
+	mu       sync.Mutex
+	upload   map[accountID]*ratelimit.Limiter
+	download map[accountID]*ratelimit.Limiter
+}
+
+// get returns the limiter of the account from the given limiters, creating it (or updating its rate) as needed.
+// It returns nil if the rate is not positive, i.e. unlimited.
+func (ul *userRateLimiters) get(limiters map[accountID]*ratelimit.Limiter, account accountID, rate int64) *ratelimit.Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	l, exists := limiters[account]
+	if !exists {
+		l = ratelimit.NewLimiter(rate, 0)
+		limiters[account] = l
+	} else if l.Rate() != rate {
+		// The account's limit has changed, e.g. its user file was reloaded
+		l.SetLimit(rate, 0)
+	}
+	return l
+}
+
+// limiters returns the upload and download limiters of the account with the given rates in bytes per second.
+func (ul *userRateLimiters) limiters(account accountID, uploadRate, downloadRate int64) (upload, download *ratelimit.Limiter) {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	if ul.upload == nil {
+		ul.upload = make(map[accountID]*ratelimit.Limiter)
+		ul.download = make(map[accountID]*ratelimit.Limiter)
+	}
+	return ul.get(ul.upload, account, uploadRate), ul.get(ul.download, account, downloadRate)
+}
+
+// buildGlobalRateLimiters builds the server's global limiters, shared by all the connections.
+func (s *Server) buildGlobalRateLimiters() {
+	if s.cfg.RateLimit.Upload > 0 {
+		s.globalUploadLimiter = ratelimit.NewLimiter(s.cfg.RateLimit.Upload, 0)
+	}
+	if s.cfg.RateLimit.Download > 0 {
+		s.globalDownloadLimiter = ratelimit.NewLimiter(s.cfg.RateLimit.Download, 0)
+	}
+}
+
+// parseRateLimitAttribute parses a rate limit attribute of the account, in bytes per second.
+// A missing attribute means unlimited.
+func parseRateLimitAttribute(attributes map[string]string, name string) (int64, error) {
+	value, exists := attributes[name]
+	if !exists {
+		return 0, nil
+	}
+	rate, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || rate < 0 {
+		return 0, errors.Join(errInvalidRateLimitAttribute, fmt.Errorf("%s: %q", name, value))
+	}
+	return rate, nil
+}
+
+// accountRateLimiters returns the upload and download limiters of the connection's account, nil means unlimited.
+func (s *Server) accountRateLimiters(gc *gordafarid.Conn) (upload, download *ratelimit.Limiter, err error) {
+	uploadRate, err := parseRateLimitAttribute(gc.Attributes(), ratelimit.UploadRateLimitAttribute)
+	if err != nil {
+		return nil, nil, err
+	}
+	downloadRate, err := parseRateLimitAttribute(gc.Attributes(), ratelimit.DownloadRateLimitAttribute)
+	if err != nil {
+		return nil, nil, err
+	}
+	upload, download = s.userLimiters.limiters(accountOf(gc), uploadRate, downloadRate)
+	return upload, download, nil
+}
@@ -0,0 +1,27 @@
+package server
+
+import "testing"
+
+func TestUserRateLimitersPerInitKey(t *testing.T) {
+	var ul userRateLimiters
+	alice := accountID{initKey: "default", username: "alice"}
+	tenantAlice := accountID{initKey: "tenant-b", username: "alice"}
+
+	upload, download := ul.limiters(alice, 1000, 2000)
+	if upload == nil || download == nil {
+		t.Fatal("the limited account has no limiters")
+	}
+	if again, _ := ul.limiters(alice, 1000, 2000); again != upload {
+		t.Fatal("the connections of the same account don't share their limiter")
+	}
+	if other, _ := ul.limiters(tenantAlice, 1000, 2000); other == upload {
+		t.Fatal("the same-named accounts of different init keys share a limiter")
+	}
+
+	if again, _ := ul.limiters(alice, 500, 0); again != upload || again.Rate() != 500 {
+		t.Fatal("the account's limiter isn't updated to its new rate")
+	}
+	if _, download = ul.limiters(alice, 500, 0); download != nil {
+		t.Fatal("the unlimited direction has a limiter")
+	}
+}
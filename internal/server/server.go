@@ -2,28 +2,71 @@
 package server
 
 import (
-	"encoding/binary"
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net"
-	"sync"
+	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/Iam54r1n4/Gordafarid/internal/accesslog"
+	"github.com/Iam54r1n4/Gordafarid/internal/acl"
+	"github.com/Iam54r1n4/Gordafarid/internal/ban"
 	"github.com/Iam54r1n4/Gordafarid/internal/config"
 	"github.com/Iam54r1n4/Gordafarid/internal/logger"
+	"github.com/Iam54r1n4/Gordafarid/internal/metrics"
+	"github.com/Iam54r1n4/Gordafarid/internal/quota"
+	"github.com/Iam54r1n4/Gordafarid/internal/ratelimit"
 	"github.com/Iam54r1n4/Gordafarid/internal/shared_error"
-	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/internal/upgrade"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
-	"github.com/Iam54r1n4/Gordafarid/pkg/net/utils"
+	proxy_server "github.com/Iam54r1n4/Gordafarid/pkg/proxy/server"
 )
 
-var errUnableToGetGordafaridHandshakeResult = errors.New("failed to get Gordafarid handshake result")
+var (
+	errUndefinedPolicy            = errors.New("the account's policy is not defined")
+	errDestinationNotAllowed      = errors.New("the destination is not allowed by the account's policy")
+	errUnableToResolveDestination = errors.New("failed to resolve the destination")
+	errInvalidDestinationAddress  = errors.New("invalid destination address")
+)
 
 // Server represents the main server structure.
 type Server struct {
-	cfg                *config.ServerConfig // Configuration for the server
-	gordafaridListener *gordafarid.Listener // Network listener for incoming connections
+	cfg           *config.ServerConfig   // Configuration for the server
+	proxy         *proxy_server.Server   // Accepts, authorizes and relays the Gordafarid connections, extended by the hooks
+	policies      map[string]*acl.Policy // Destination access control lists by name
+	defaultPolicy *acl.Policy            // Policy of the accounts without a policy attribute
+
+	userLimiters          userRateLimiters   // Per-account bandwidth limiters
+	globalUploadLimiter   *ratelimit.Limiter // Server-wide upload limiter, nil means unlimited
+	globalDownloadLimiter *ratelimit.Limiter // Server-wide download limiter, nil means unlimited
+
+	quotaStore *quota.Store // Per-account traffic accounting
+
+	connectionLimiter connectionLimiter // Per-account and per-client IP concurrent connections
+
+	banList *ban.List // Client IP bans on repeated handshake failures, nil if disabled
+
+	users    *runtimeUsers   // Account changes made at runtime through the admin API
+	sessions sessionRegistry // Connections being handled, listed and killed through the admin API
+
+	adminServer *http.Server // The admin HTTP API, nil if disabled
+
+	metrics       *serverMetrics  // Counters and histograms of the server
+	metricsServer *metrics.Server // The metrics endpoint, nil if disabled
+	startTime     time.Time       // When the server was created, shown by the admin API
+
+	accessLog *accesslog.Logger // A record per proxied connection, nil if disabled
+
+	listener  net.Listener // The listening socket, passed to the new process on an upgrade
+	ln        net.Listener // The listening socket wrapped with the metrics and the bans, served by the proxy
+	handedOff atomic.Bool  // The listening socket and the state files are handed off to a new process
+
+	ctx    context.Context    // Cancelled on shutdown, stops the background routines
+	cancel context.CancelFunc // Cancels ctx
 }
 
 // NewServer creates and returns a new Server instance.
@@ -41,8 +84,14 @@ type Server struct {
 //		aead, _ := crypto.NewAEAD(cfg.Crypto.Algorithm, []byte(cfg.Crypto.Password))
 //		server := NewServer(cfg, aead)
 func NewServer(cfg *config.ServerConfig) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{
-		cfg: cfg,
+		cfg:       cfg,
+		users:     newRuntimeUsers(),
+		metrics:   newServerMetrics(),
+		startTime: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 }
 
@@ -55,26 +104,239 @@ func NewServer(cfg *config.ServerConfig) *Server {
 //		log.Fatal("Failed to start server:", err)
 //	}
 func (s *Server) Listen() error {
+	return s.listen(nil)
+}
+
+// ListenOn is like Listen, but it uses the given listening socket (e.g. passed by systemd socket activation)
+// instead of binding the server.address.
+func (s *Server) ListenOn(ln net.Listener) error {
+	return s.listen(ln)
+}
+
+// listen builds the server's policies, limits and credentials, and wraps the listening socket with the Gordafarid listener.
+// If ln is nil, the socket is inherited from the old process on an upgrade, or bound to the server.address otherwise.
+func (s *Server) listen(ln net.Listener) error {
+	if err := s.buildPolicies(); err != nil {
+		return err
+	}
+	s.buildGlobalRateLimiters()
+	if err := s.buildQuotaStore(); err != nil {
+		return err
+	}
+	if err := s.buildBanList(); err != nil {
+		return err
+	}
+	if err := s.buildAccessLog(); err != nil {
+		return err
+	}
+
+	listenConfig := gordafarid.NewServerConfig(nil, s.cfg.CryptoAlgorithm, s.cfg.Server.InitCryptoAlgorithm, s.cfg.Server.InitPassword, s.cfg.Timeout.GordafaridHandshakeTimeout)
+	listenConfig.MaxHandshakes = s.cfg.Limits.MaxHandshakes
+	if s.cfg.Limits.MaxConnectionsPerIP > 0 {
+		listenConfig.Admit = s.admitConnection
+	}
+	// The top-level credentials belong to the server.initPassword's key, there's no default key without it
+	if len(s.cfg.Server.InitPassword) > 0 {
+		credentialStore, err := s.buildInitKeyCredentialStore(gordafarid.DefaultInitKeyID, s.cfg.Credentials, s.cfg.CredentialsFile, &s.cfg.Authorizer)
+		if err != nil {
+			return err
+		}
+		listenConfig.AddCredentialStores(credentialStore)
+	}
+
+	// Add the additional init keys, each one with its own credentials
+	for _, ik := range s.cfg.InitKeys {
+		initKeyCredentialStore, err := s.buildInitKeyCredentialStore(ik.ID, ik.Credentials, ik.CredentialsFile, &ik.Authorizer)
+		if err != nil {
+			return err
+		}
+		listenConfig.AddInitKeys(gordafarid.NewInitKey(ik.ID, ik.InitCryptoAlgorithm, ik.InitPassword, ik.NotBefore, ik.NotAfter, nil, initKeyCredentialStore))
+	}
+
+	// The listening socket is inherited from the old process on an upgrade
 	var err error
+	if ln == nil {
+		if ln, err = upgrade.Listen(s.cfg.Server.Address); err != nil {
+			return err
+		}
+		if upgrade.Inherited() {
+			logger.Info("Inherited the listening socket from the old server process")
+		}
+	}
+	s.listener = ln
+	ln = metrics.NewListener(ln, s.metrics.connectionsAccepted)
+	// The connections of the banned client IPs are closed before their handshakes
+	if s.banList != nil {
+		ln = ban.NewListener(ln, s.banList)
+	}
+	s.ln = ln
+	if s.proxy, err = proxy_server.NewServer(proxy_server.Options{
+		Config:      listenConfig,
+		DialTimeout: time.Duration(s.cfg.Timeout.DialTimeout) * time.Second,
+		Logger:      slog.New(logger.Handler()),
+		Hooks:       s.hooks(),
+	}); err != nil {
+		return err
+	}
+	logger.Info("Server is listening on: ", ln.Addr())
+	if err = s.startAdmin(); err != nil {
+		return err
+	}
+	return s.startMetrics()
+}
 
+// buildGordafaridCredentials converts the configured credentials into gordafarid.Credentials.
+func buildGordafaridCredentials(creds []config.Credential) ([]gordafarid.Credential, error) {
 	var gordafaridCredentials []gordafarid.Credential
-
-	if s.cfg.Credentials != nil {
-		for _, account := range s.cfg.Credentials {
-			gordafaridCredentials = append(gordafaridCredentials, gordafarid.NewCredential(account.Username, account.Password))
+	for _, cred := range creds {
+		gordafaridCredential, err := buildGordafaridCredential(cred)
+		if err != nil {
+			return nil, err
 		}
+		gordafaridCredentials = append(gordafaridCredentials, gordafaridCredential)
 	}
+	return gordafaridCredentials, nil
+}
 
-	listenConfig := gordafarid.NewServerConfig(gordafaridCredentials, s.cfg.CryptoAlgorithm, s.cfg.Server.InitPassword, s.cfg.Timeout.GordafaridHandshakeTimeout)
-	s.gordafaridListener, err = gordafarid.Listen(s.cfg.Server.Address, listenConfig)
+// buildGordafaridCredential converts a configured credential into a gordafarid.Credential.
+func buildGordafaridCredential(cred config.Credential) (gordafarid.Credential, error) {
+	credential, err := gordafarid.ParseCredential(cred.Username, cred.Password, cred.KeyID, cred.Key)
 	if err != nil {
-		return err
+		return gordafarid.Credential{}, err
+	}
+	credential.Attributes = credentialAttributes(cred)
+	credential.NotBefore = cred.NotBefore
+	credential.ExpiresAt = cred.ExpiresAt
+	credential.Disabled = cred.Disabled
+	if credential.AllowedHours, err = gordafarid.ParseAccessWindows(cred.AllowedHours, cred.Timezone); err != nil {
+		return gordafarid.Credential{}, err
+	}
+	return credential, nil
+}
+
+// credentialAttributes returns the attributes of a configured credential.
+// The limits of the entry (rate limits, quotas and connection limits) are passed as attributes, the same way the user files and authorizers set them.
+func credentialAttributes(cred config.Credential) map[string]string {
+	limits := map[string]int64{
+		ratelimit.UploadRateLimitAttribute:   cred.UploadRateLimit,
+		ratelimit.DownloadRateLimitAttribute: cred.DownloadRateLimit,
+		quota.DailyQuotaAttribute:            cred.DailyQuota,
+		quota.MonthlyQuotaAttribute:          cred.MonthlyQuota,
+		quota.TotalQuotaAttribute:            cred.TotalQuota,
+		maxConnectionsAttribute:              int64(cred.MaxConnections),
+		maxIPsAttribute:                      int64(cred.MaxIPs),
+	}
+	attributes := make(map[string]string, len(cred.Attributes)+len(limits))
+	for name, value := range cred.Attributes {
+		attributes[name] = value
+	}
+	for name, value := range limits {
+		if value > 0 {
+			attributes[name] = strconv.FormatInt(value, 10)
+		}
+	}
+	return attributes
+}
+
+// buildPolicies builds the configured destination access control lists.
+func (s *Server) buildPolicies() error {
+	s.policies = make(map[string]*acl.Policy, len(s.cfg.Policies))
+	for name, pc := range s.cfg.Policies {
+		policy, err := pc.Build(name)
+		if err != nil {
+			return err
+		}
+		s.policies[name] = policy
+	}
+	if len(s.cfg.DefaultPolicy) > 0 {
+		s.defaultPolicy = s.policies[s.cfg.DefaultPolicy]
+	} else {
+		// Allow all the destinations, except the special-purpose ranges
+		s.defaultPolicy = acl.NewAllowAllPolicy("")
 	}
-	logger.Info("Server is listening on: ", s.cfg.Server.Address)
 	return nil
 }
 
+// accountPolicy returns the destination access control list of the connection's account.
+// The policy is named by the account's "policy" attribute, otherwise the default policy applies.
+func (s *Server) accountPolicy(gc *gordafarid.Conn) (*acl.Policy, error) {
+	name, exists := gc.Attributes()[acl.PolicyAttribute]
+	if !exists {
+		return s.defaultPolicy, nil
+	}
+	policy, exists := s.policies[name]
+	if !exists {
+		// Fail closed, e.g. if an external authorizer returns an unknown policy
+		return nil, errors.Join(errUndefinedPolicy, fmt.Errorf("policy: %q", name))
+	}
+	return policy, nil
+}
+
+// buildInitKeyCredentialStore builds the credential store of an init key, which is its configured credentials
+// followed by its user file and external authorizer, with the runtime changes of the admin API applied on top of them.
+func (s *Server) buildInitKeyCredentialStore(initKeyID string, creds []config.Credential, credentialsFile string, authorizer *config.AuthorizerConfig) (gordafarid.CredentialStore, error) {
+	gordafaridCredentials, err := buildGordafaridCredentials(creds)
+	if err != nil {
+		return nil, err
+	}
+	credentialStores, err := s.buildCredentialStores(credentialsFile, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	configured := append(gordafarid.ChainCredentialStore{gordafarid.NewMemoryCredentialStore(gordafaridCredentials...)}, credentialStores...)
+	return s.users.wrap(initKeyID, configured), nil
+}
+
+// buildCredentialStores builds the additional credential stores of an init key,
+// which are the user file (if any) followed by the external authorizer (if any).
+func (s *Server) buildCredentialStores(credentialsFile string, authorizer *config.AuthorizerConfig) ([]gordafarid.CredentialStore, error) {
+	var credentialStores []gordafarid.CredentialStore
+	if len(credentialsFile) > 0 {
+		fileStore, err := s.buildFileCredentialStore(credentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		credentialStores = append(credentialStores, fileStore)
+	}
+	if authorizer.IsEnabled() {
+		credentialStores = append(credentialStores, s.buildAuthorizerCredentialStore(authorizer))
+	}
+	return credentialStores, nil
+}
+
+// buildAuthorizerCredentialStore builds the external authorizer's credential store, with its lookup results cached.
+func (s *Server) buildAuthorizerCredentialStore(authorizer *config.AuthorizerConfig) gordafarid.CredentialStore {
+	var store gordafarid.CredentialStore
+	if len(authorizer.Exec) > 0 {
+		store = gordafarid.NewExecCredentialStore(authorizer.Exec, s.cfg.CryptoAlgorithm)
+		logger.Info("Using the external authorizer executable: ", authorizer.Exec[0])
+	} else {
+		store = gordafarid.NewHTTPCredentialStore(authorizer.URL, authorizer.Token, s.cfg.CryptoAlgorithm, nil)
+		logger.Info("Using the external authorizer endpoint: ", authorizer.URL)
+	}
+	cacheTTL := time.Duration(authorizer.CacheTTL) * time.Second
+	cachedStore := gordafarid.NewCachedCredentialStore(store, cacheTTL)
+	cachedStore.StartCleanupRoutine(s.ctx, cacheTTL)
+	return cachedStore
+}
+
+// buildFileCredentialStore loads the given user file, and starts watching it for changes.
+// Reload errors are logged, and the previously loaded accounts are kept.
+func (s *Server) buildFileCredentialStore(path string) (*gordafarid.FileCredentialStore, error) {
+	fileStore, err := gordafarid.NewFileCredentialStore(path, s.cfg.CryptoAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	checkInterval := time.Duration(s.cfg.CredentialsFileCheckInterval) * time.Second
+	fileStore.StartWatchRoutine(s.ctx, checkInterval, func(err error) {
+		logger.Warn(err)
+	})
+	logger.Info("Loaded the credentials file: ", path)
+	return fileStore, nil
+}
+
 // Start begins accepting and handling incoming connections.
+// It returns shared_error.ErrServerClosed once the server is shut down by Shutdown.
 //
 // Example usage:
 //
@@ -83,153 +345,132 @@ func (s *Server) Listen() error {
 //		log.Fatal("Server error:", err)
 //	}
 func (s *Server) Start() error {
-	if s.gordafaridListener == nil {
+	if s.proxy == nil {
 		return shared_error.ErrListenerIsNotInitialized
 	}
-
-	acceptedConnChan := make(chan *gordafarid.Conn, 64)
-	errChan := make(chan error, 64)
-	defer close(acceptedConnChan)
-	defer close(errChan)
-
-	go func() {
-		for {
-			conn, err := s.gordafaridListener.Accept()
-			if err != nil {
-				select {
-				case errChan <- err:
-				default:
-					logger.Warn("Error channel is full, dropping error:", err)
-				}
-				// Skip the rest of the loop iteration if there's an error
-				continue
-			}
-			if conn == nil {
-				logger.Warn("Accepted a nil connection, WTF?!")
-				continue
-			}
-
-			select {
-			case acceptedConnChan <- conn:
-			default:
-				logger.Warn("Connection channel is full, dropping connection from:", conn.RemoteAddr())
-				conn.Close() // Optionally close the connection if the buffer is full
-			}
-		}
-	}()
-
-	for {
-		select {
-		case conn := <-acceptedConnChan:
-			// Ensure conn is not nil before accessing its methods
-			if conn == nil {
-				logger.Warn("Received nil connection from channel, WTF?!")
-				continue
-			}
-
-			logger.Info("Accepted connection from:", conn.RemoteAddr())
-			go s.handleConnection(conn)
-		case err := <-errChan:
-			logger.Warn(errors.Join(shared_error.ErrConnectionAccepting, err))
-		}
-	}
+	return s.proxy.Serve(context.Background(), s.ln)
 }
 
-// handleConnection manages a single client connection.
-// It performs the Gordafarid handshake, establishes a connection to the target server,
-// and facilitates bidirectional data transfer between the client and the target server.
-//
-// This function is the core of the proxy server's operation. It handles each client
-// connection in a separate goroutine, allowing for concurrent handling of multiple clients.
+// Shutdown gracefully shuts down the server.
 //
-// The function performs the following steps:
-// 1. Defers closing the Gordafarid connection to ensure cleanup.
-// 2. Retrieves the handshake result from the Gordafarid connection.
-// 3. Extracts the destination address and port from the handshake result.
-// 4. Establishes a connection to the target server.
-// 5. Sets up bidirectional data transfer between the client and the target server.
-// 6. Handles any errors that occur during the data transfer.
+// It performs the following steps:
+// 1. Closes the listener, so no new connections are accepted (Start returns shared_error.ErrServerClosed), and stops the admin API and the metrics endpoint.
+// 2. Waits for the active connections to finish, and closes them once the context is done.
+// 3. Stops the background routines (credential file watchers, caches, ...).
+// 4. Saves the traffic counters and the bans to their state files.
 //
 // Parameters:
-//   - gc: A pointer to a gordafarid.Conn, which represents the client connection.
+//   - ctx: The deadline of the active connections.
 //
-// The function doesn't return any values, but it logs various information and errors:
-// - Warns if unable to get the Gordafarid handshake result.
-// - Logs debug information about the handshake and connection process.
-// - Warns if unable to dial the target server.
-// - Logs errors that occur during data transfer, except for io.EOF which is expected.
+// Returns:
+//   - The context's error if the active connections were closed before finishing, along with any save errors.
 //
-// Error handling:
-//   - If an error occurs during the handshake or when dialing the target server,
-//     the function logs the error and returns, closing the connection.
-//   - Errors during data transfer are logged, but don't cause the function to return immediately.
+// Example usage:
 //
-// Concurrency:
-// - The function uses goroutines and a WaitGroup to handle bidirectional data transfer concurrently.
-// - It creates an error channel to collect errors from the data transfer goroutines.
-// Note: This function is designed to be run as a goroutine for each incoming connection.
-func (s *Server) handleConnection(gc *gordafarid.Conn) {
-	// Close the Gordafarid connection when the function returns
-	defer gc.Close()
-
-	// Get the handshake result from the Gordafarid connection
-	logger.Debug("Getting the Gordafarid handshake result...")
-	handshakeResult, err := gc.GetHandshakeResult()
-	if err != nil {
-		logger.Error(errors.Join(errUnableToGetGordafaridHandshakeResult, err))
-		return
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//	if err := server.Shutdown(ctx); err != nil {
+//		log.Println("Shutdown error:", err)
+//	}
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if err := s.stopAdmin(); err != nil {
+		errs = append(errs, err)
 	}
-
-	// Extract target server information from the handshake result
-	dstAddr := string(handshakeResult.DstAddr)
-	dstPort := binary.BigEndian.Uint16(handshakeResult.DstPort[:])
-	targetAddr := net.JoinHostPort(dstAddr, fmt.Sprint(dstPort))
-
-	// Log debug information about the handshake and connection process
-	logger.Debug("The Gordafarid handshake result received")
-
-	// Establish a connection to the target server with a timeout
-	logger.Debug("Connecting to: ", dstAddr)
-	tconn, err := net.DialTimeout("tcp", targetAddr, time.Duration(s.cfg.Timeout.DialTimeout)*time.Second)
-	if err != nil {
-		// Log a warning if unable to connect to the target server
-		logger.Warn(errors.Join(shared_error.ErrServerDialFailed, err))
-		return
+	if err := s.stopMetrics(); err != nil {
+		errs = append(errs, err)
 	}
-	// Close the target server connection when the function returns
-	defer tconn.Close()
-
-	// Log the target server address, handling domain names separately
-	if handshakeResult.Atyp == protocol.AtypDomain {
-		logger.Debug(fmt.Sprintf("Connected to: %s(%s)", dstAddr, tconn.RemoteAddr()))
-	} else {
-		logger.Debug("Connected to: ", tconn.RemoteAddr())
+	if s.proxy != nil {
+		if err := s.proxy.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	s.cancel()
 
-	// Set up bidirectional data transfer
-	wg := sync.WaitGroup{}
-	wg.Add(2)
-	errChan := make(chan error, 2)
+	// The connections are closed now, so are their access log records written
+	if err := s.closeAccessLog(); err != nil {
+		errs = append(errs, err)
+	}
 
-	// Log the proxying information
-	logger.Debug(fmt.Sprintf("Proxying between %s/%s", gc.RemoteAddr(), tconn.RemoteAddr()))
+	// Save the final traffic counters and bans, the connections are closed now.
+	// After an upgrade, the new process owns the state files, so they aren't overwritten;
+	// the traffic of the drained connections is saved to a quota delta file, merged by the new process.
+	if s.handedOff.Load() {
+		if s.quotaStore != nil {
+			if err := s.quotaStore.Save(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	} else if err := s.saveState(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
 
-	// Start a goroutine to copy data from client to remote server
-	go utils.DataTransfering(&wg, errChan, tconn, gc)
-	// Start a goroutine to copy data from remote server to client
-	go utils.DataTransfering(&wg, errChan, gc, tconn)
+// saveState saves the traffic counters and the bans to their state files.
+func (s *Server) saveState() error {
+	var errs []error
+	if s.quotaStore != nil {
+		if err := s.quotaStore.Save(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.banList != nil {
+		if err := s.banList.Save(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	// Close the error channel after both data transfer goroutines are finished
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
+// Upgrade spawns a new copy of the server process (the same executable and arguments), and hands the listening socket off to it.
+// Once it returns successfully, the new process accepts the connections, and the caller should Shutdown this server to drain its own ones.
+// If the new process fails to start or isn't ready in time (e.g. its config is invalid), this server keeps serving.
+//
+// The state files are saved before spawning, so the new process starts with the latest traffic counters and bans,
+// and they're owned by the new process afterwards; the traffic of the connections drained by this server is saved
+// to a quota delta file on Shutdown, which the new process merges into its traffic counters (see quota.Store.HandOff).
+// The admin API and the metrics endpoint are stopped, so the new process can listen on their addresses;
+// the runtime account changes and the metrics aren't handed off.
+func (s *Server) Upgrade() error {
+	if s.listener == nil {
+		return shared_error.ErrListenerIsNotInitialized
+	}
+	if s.quotaStore != nil {
+		if err := s.quotaStore.HandOff(); err != nil {
+			logger.Warn(err)
+		}
+	}
+	if s.banList != nil {
+		if err := s.banList.Save(); err != nil {
+			logger.Warn(err)
+		}
+	}
+	// The addresses of the admin API and the metrics endpoint aren't handed off, free them for the new process
+	if err := s.stopAdmin(); err != nil {
+		logger.Warn(err)
+	}
+	if err := s.stopMetrics(); err != nil {
+		logger.Warn(err)
+	}
 
-	// Handle and log errors from the data transfer goroutines
-	for err := range errChan {
-		// Ignore EOF errors as they are expected when connections close
-		if !errors.Is(err, io.EOF) {
-			logger.Error(err)
+	pid, err := upgrade.Spawn(s.listener, upgrade.ReadyTimeout)
+	if err != nil {
+		// This server keeps serving, and so do its admin API, metrics endpoint and state files
+		if s.quotaStore != nil {
+			s.quotaStore.CancelHandOff()
+		}
+		if adminErr := s.startAdmin(); adminErr != nil {
+			logger.Warn(adminErr)
 		}
+		if metricsErr := s.startMetrics(); metricsErr != nil {
+			logger.Warn(metricsErr)
+		}
+		return err
 	}
+	// The new process owns the state files now, stop the background routines saving them
+	s.handedOff.Store(true)
+	s.cancel()
+	logger.Info("Handed the listening socket off to the new server process: ", pid)
+	return nil
 }
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/acl"
+	"github.com/Iam54r1n4/Gordafarid/internal/config"
+	"github.com/Iam54r1n4/Gordafarid/internal/shared_error"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
+)
+
+const (
+	testUsername = "alice"
+
+	// testGordafaridVersion is the version of the Gordafarid protocol the test tunnels speak.
+	testGordafaridVersion = 1
+)
+
+// newTestServer starts a server on a loopback port, with the alice account allowed to reach the loopback destinations.
+// The configure function adjusts the config before the server is started (OPTIONAL).
+// The server is shut down when the test ends.
+func newTestServer(t *testing.T, configure func(cfg *config.ServerConfig)) (*Server, string) {
+	t.Helper()
+	cfg := &config.ServerConfig{
+		CryptoAlgorithm: testAlgorithm,
+		Credentials:     []config.Credential{{Account: config.Account{Username: testUsername, Password: testPassword}}},
+		Policies: map[string]config.PolicyConfig{
+			"loopback": {DefaultAction: acl.ActionAllow, AllowSpecialRanges: []string{"127.0.0.0/8"}},
+		},
+		DefaultPolicy: "loopback",
+	}
+	cfg.Server.InitPassword = testInitPassword
+	cfg.Server.InitCryptoAlgorithm = testAlgorithm
+	cfg.Timeout.GordafaridHandshakeTimeout = 2
+	cfg.Timeout.DialTimeout = 2
+	if configure != nil {
+		configure(cfg)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s := NewServer(cfg)
+	if err = s.ListenOn(ln); err != nil {
+		ln.Close()
+		t.Fatalf("ListenOn: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Start()
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+		<-served
+	})
+	return s, ln.Addr().String()
+}
+
+// listenEcho starts a TCP listener on the loopback address, echoing what its connections send.
+// The accepted connections are sent on the returned channel, so the tests can end them from the destination's side.
+func listenEcho(t *testing.T) (uint16, <-chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	conns := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), conns
+}
+
+// endRelay ends the relay of the tunnel as it does normally, once the client and the destination have both closed the connection.
+func (c *testTunnel) endRelay(destinations <-chan net.Conn) {
+	c.t.Helper()
+	select {
+	case conn := <-destinations:
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		c.t.Fatal("the destination didn't accept the tunnel's connection")
+	}
+	c.Close()
+}
+
+// testTunnel is the client side of a tunnel through the test server, driven by hand.
+// Its messages are sealed with fresh nonces, in the cipher_conn frames once the greeting is sent.
+type testTunnel struct {
+	net.Conn
+	t      *testing.T
+	aead   cipher.AEAD
+	length []byte // The length of the next frame, if it was read along with the greeting reply
+	buffer []byte // The plaintext of the last frame not read yet
+}
+
+// dialTunnel connects to the server with the account, and requests a tunnel to the destination.
+// It returns the tunnel, and the status of the server's reply, or of its greeting reply if the greeting failed.
+func dialTunnel(t *testing.T, addr, username, password string, destination protocol.AddressHeader) (*testTunnel, byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	c := &testTunnel{Conn: conn, t: t}
+
+	// The greeting is sealed with the init key, and the rest with the account's key
+	hash := gordafarid.DeriveKeyID(username, password)
+	greeting := append([]byte{testGordafaridVersion, protocol.CmdConnect}, hash[:]...)
+	if c.aead, err = aead.NewAEAD(testAlgorithm, []byte(testInitPassword)); err != nil {
+		t.Fatalf("NewAEAD: %v", err)
+	}
+	if _, err = conn.Write(c.seal(greeting)); err != nil {
+		t.Fatalf("writing the greeting: %v", err)
+	}
+	if c.aead, err = aead.NewAEAD(testAlgorithm, []byte(password)); err != nil {
+		t.Fatalf("NewAEAD: %v", err)
+	}
+	// The greeting failure reply is the only plaintext one, the success one is the first frame
+	c.length = make([]byte, 2)
+	if _, err = io.ReadFull(conn, c.length); err != nil {
+		t.Fatalf("reading the greeting reply: %v", err)
+	}
+	if c.length[0] == testGordafaridVersion {
+		return c, c.length[1]
+	}
+	reply := c.readFull(2)
+	if reply[1] != 0 {
+		t.Fatalf("the greeting reply is %x, want a success", reply)
+	}
+
+	if _, err = c.Write(destination.Bytes()); err != nil {
+		t.Fatalf("writing the request: %v", err)
+	}
+	reply = c.readFull(3)
+	if reply[2] == protocol.AtypIPv6 {
+		c.readFull(16 + protocol.DstPortSize)
+	} else {
+		c.readFull(4 + protocol.DstPortSize)
+	}
+	return c, reply[1]
+}
+
+// seal encrypts the plaintext with a fresh nonce, the nonce followed by the ciphertext.
+func (c *testTunnel) seal(plaintext []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		c.t.Fatalf("rand.Read: %v", err)
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+// Write sends the data in a cipher_conn frame.
+func (c *testTunnel) Write(b []byte) (int, error) {
+	packet := c.seal(b)
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(packet)))
+	if _, err := c.Conn.Write(append(frame, packet...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read reads the data of the server's cipher_conn frames.
+func (c *testTunnel) Read(b []byte) (int, error) {
+	if len(c.buffer) < 1 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.buffer)
+	c.buffer = c.buffer[n:]
+	return n, nil
+}
+
+// readFrame reads the next frame into the buffer.
+func (c *testTunnel) readFrame() error {
+	length := c.length
+	c.length = nil
+	if length == nil {
+		length = make([]byte, 2)
+		if _, err := io.ReadFull(c.Conn, length); err != nil {
+			return err
+		}
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(c.Conn, packet); err != nil {
+		return err
+	}
+	nonceSize := c.aead.NonceSize()
+	plaintext, err := c.aead.Open(nil, packet[:nonceSize], packet[nonceSize:], nil)
+	if err != nil {
+		return err
+	}
+	c.buffer = plaintext
+	return nil
+}
+
+// readFull reads n bytes of the server's frames, failing the test if they can't be read.
+func (c *testTunnel) readFull(n int) []byte {
+	c.t.Helper()
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c, b); err != nil {
+		c.t.Fatalf("reading from the server: %v", err)
+	}
+	return b
+}
+
+// echo sends the message through the tunnel to the echo destination, and checks it comes back.
+func (c *testTunnel) echo(message string) {
+	c.t.Helper()
+	if _, err := c.Write([]byte(message)); err != nil {
+		c.t.Fatalf("writing through the tunnel: %v", err)
+	}
+	got := make([]byte, len(message))
+	if _, err := io.ReadFull(c, got); err != nil || string(got) != message {
+		c.t.Fatalf("the tunnel echoed %q (%v), want %q", got, err, message)
+	}
+}
+
+func TestShutdownDrainsActiveRelays(t *testing.T) {
+	s, addr := newTestServer(t, nil)
+	port, destinations := listenEcho(t)
+	c, status := dialTunnel(t, addr, testUsername, testPassword, testDestination(t, "127.0.0.1", port))
+	if status != gordafarid.ReplySuccess {
+		t.Fatalf("the tunnel's reply status is %d", status)
+	}
+	c.echo("ping")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with an active relay", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The active relay keeps working while draining, but no new connection is accepted
+	c.echo("pong")
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("the shutting down server accepted a new connection")
+	}
+
+	// Once the relay ends, the shutdown is done
+	c.endRelay(destinations)
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatalf("Shutdown returned %v after the relay ended", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown didn't return after the relay ended")
+	}
+}
+
+func TestShutdownClosesRelaysAtDeadline(t *testing.T) {
+	s, addr := newTestServer(t, nil)
+	port, _ := listenEcho(t)
+	c, status := dialTunnel(t, addr, testUsername, testPassword, testDestination(t, "127.0.0.1", port))
+	if status != gordafarid.ReplySuccess {
+		t.Fatalf("the tunnel's reply status is %d", status)
+	}
+	c.echo("ping")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.Shutdown(ctx)
+	if !errors.Is(err, shared_error.ErrShutdownDeadlineReached) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown returned %v, want the deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Shutdown returned %v after its deadline", elapsed)
+	}
+	// The relay was closed at the deadline
+	if _, err = c.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Fatalf("reading from the relay closed by the shutdown returned %v", err)
+	}
+}
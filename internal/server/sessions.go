@@ -0,0 +1,214 @@
+package server
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/metrics"
+	net_session "github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+// session is a connection being handled by the server, as it's listed and killed through the admin API.
+type session struct {
+	id            net_session.ID // Generated when the connection is accepted, see gordafarid.Conn.SessionID
+	clientSession net_session.ID // Sent by the client in its greeting (OPTIONAL)
+	account       accountID
+	source        string // The client's address
+	destination   string // The requested destination, host:port
+	start         time.Time
+	upload        atomic.Int64 // Bytes read from the client
+	download      atomic.Int64 // Bytes read from the destination
+
+	mu      sync.Mutex
+	conns   []net.Conn // Connections of the session, closed when it's killed
+	killed  bool
+	expired bool // The session was killed as its account stopped being allowed to authenticate
+}
+
+// addConn adds a connection of the session, it's closed right away if the session is already killed.
+func (ss *session) addConn(c net.Conn) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.killed {
+		c.Close()
+		return
+	}
+	ss.conns = append(ss.conns, c)
+}
+
+// isKilled reports whether the session is killed.
+func (ss *session) isKilled() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.killed
+}
+
+// kill closes the connections of the session, so its relay ends.
+func (ss *session) kill() {
+	ss.mu.Lock()
+	ss.killed = true
+	conns := ss.conns
+	ss.conns = nil
+	ss.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// expireAt kills the session at the given time, when its account stops being allowed to authenticate (see gordafarid.Conn.ValidUntil).
+// It returns the timer, to be stopped once the session ends, or nil if the time is zero.
+func (ss *session) expireAt(until time.Time) *time.Timer {
+	if until.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(until), ss.expire)
+}
+
+// expire kills the session, as its account is no longer allowed to authenticate.
+func (ss *session) expire() {
+	ss.mu.Lock()
+	ss.expired = true
+	ss.mu.Unlock()
+	ss.kill()
+}
+
+// isExpired reports whether the session was killed by expire.
+func (ss *session) isExpired() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.expired
+}
+
+// sessionInfo is a session, as it's shown by the admin API.
+type sessionInfo struct {
+	ID            string    `json:"id"`
+	ClientSession string    `json:"clientSession,omitempty"` // The client's session ID, if it sent one
+	InitKey       string    `json:"initKey"`                 // ID of the init key of the account
+	Username      string    `json:"username"`
+	Source        string    `json:"source"`
+	Destination   string    `json:"destination"`
+	Upload        int64     `json:"upload"`   // Bytes sent by the client
+	Download      int64     `json:"download"` // Bytes sent to the client
+	Start         time.Time `json:"start"`
+	Age           float64   `json:"age"` // In seconds
+}
+
+// info returns the current state of the session.
+func (ss *session) info(now time.Time) sessionInfo {
+	return sessionInfo{
+		ID:            ss.id.String(),
+		ClientSession: ss.clientSession.String(),
+		InitKey:       ss.account.initKey,
+		Username:      ss.account.username,
+		Source:        ss.source,
+		Destination:   ss.destination,
+		Upload:        ss.upload.Load(),
+		Download:      ss.download.Load(),
+		Start:         ss.start,
+		Age:           now.Sub(ss.start).Seconds(),
+	}
+}
+
+// countingConn wraps a net.Conn, and counts the bytes read from it in the session and in the metrics.
+type countingConn struct {
+	net.Conn
+	n     *atomic.Int64
+	total *metrics.Counter
+}
+
+// Read reads data from the connection, and counts the read bytes.
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.n.Add(int64(n))
+		c.total.Add(uint64(n))
+	}
+	return n, err
+}
+
+// sessionRegistry keeps track of the sessions being handled. It's safe for concurrent use.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[net_session.ID]*session
+}
+
+// open registers a new session with the IDs of the accepted connection.
+func (r *sessionRegistry) open(id, clientSession net_session.ID, account accountID, source, destination string) *session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = make(map[net_session.ID]*session)
+	}
+	ss := &session{
+		id:            id,
+		clientSession: clientSession,
+		account:       account,
+		source:        source,
+		destination:   destination,
+		start:         time.Now(),
+	}
+	r.sessions[ss.id] = ss
+	return ss
+}
+
+// close removes the session once it's finished.
+func (r *sessionRegistry) close(ss *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, ss.id)
+}
+
+// list returns the sessions of the account (or all the sessions if its username is empty), the oldest first.
+func (r *sessionRegistry) list(account accountID) []sessionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	infos := make([]sessionInfo, 0, len(r.sessions))
+	for _, ss := range r.sessions {
+		if len(account.username) < 1 || ss.account == account {
+			infos = append(infos, ss.info(now))
+		}
+	}
+	slices.SortFunc(infos, func(a, b sessionInfo) int {
+		return cmp.Or(a.Start.Compare(b.Start), cmp.Compare(a.ID, b.ID))
+	})
+	return infos
+}
+
+// count returns the number of sessions.
+func (r *sessionRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sessions)
+}
+
+// kill kills the session with the given ID, and reports whether it exists.
+func (r *sessionRegistry) kill(id net_session.ID) bool {
+	r.mu.Lock()
+	ss, exists := r.sessions[id]
+	r.mu.Unlock()
+	if exists {
+		ss.kill()
+	}
+	return exists
+}
+
+// killUser kills all the sessions of the account, and returns their number.
+func (r *sessionRegistry) killUser(account accountID) int {
+	r.mu.Lock()
+	var sessions []*session
+	for _, ss := range r.sessions {
+		if ss.account == account {
+			sessions = append(sessions, ss)
+		}
+	}
+	r.mu.Unlock()
+	for _, ss := range sessions {
+		ss.kill()
+	}
+	return len(sessions)
+}
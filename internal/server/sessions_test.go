@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSessionExpireAt(t *testing.T) {
+	if timer := (&session{}).expireAt(time.Time{}); timer != nil {
+		t.Fatal("the session of an account without an expiry has a timer")
+	}
+
+	client, peer := net.Pipe()
+	defer peer.Close()
+	ss := &session{}
+	ss.addConn(client)
+	timer := ss.expireAt(time.Now().Add(50 * time.Millisecond))
+	defer timer.Stop()
+
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Fatalf("the expired session's connection isn't closed: %v", err)
+	}
+	if !ss.isExpired() || !ss.isKilled() {
+		t.Fatal("the session isn't killed once its account expired")
+	}
+}
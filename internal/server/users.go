@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+var (
+	errUnknownInitKey = errors.New("the init key is not defined")
+)
+
+// runtimeUsers holds the account changes made at runtime through the admin API.
+// They're kept in memory only, so they're lost on restart; the persistent changes belong in the config or the user files.
+//
+// Each init key's credential stores are wrapped by a runtimeUserStore, so the changes take precedence over the configured accounts:
+// the added accounts are looked up first and replace the configured accounts of their usernames,
+// the disabled accounts can't authenticate, and the removed accounts aren't found.
+// The changes apply to the accounts of a single init key, the same-named accounts of the other keys aren't affected.
+type runtimeUsers struct {
+	mu            sync.RWMutex
+	added         map[string]*gordafarid.MemoryCredentialStore // Added accounts by init key ID
+	addedAccounts map[accountID]struct{}                       // Added accounts, their configured entries aren't found
+	disabled      map[accountID]struct{}                       // Disabled accounts
+	removed       map[accountID]struct{}                       // Removed accounts
+}
+
+// newRuntimeUsers creates a new runtimeUsers without any changes.
+func newRuntimeUsers() *runtimeUsers {
+	return &runtimeUsers{
+		added:         make(map[string]*gordafarid.MemoryCredentialStore),
+		addedAccounts: make(map[accountID]struct{}),
+		disabled:      make(map[accountID]struct{}),
+		removed:       make(map[accountID]struct{}),
+	}
+}
+
+// wrap returns the credential store of the init key, applying the runtime changes on top of its configured stores.
+func (ru *runtimeUsers) wrap(initKeyID string, configured gordafarid.CredentialStore) gordafarid.CredentialStore {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	added := gordafarid.NewMemoryCredentialStore()
+	ru.added[initKeyID] = added
+	return &runtimeUserStore{users: ru, initKey: initKeyID, added: added, configured: configured}
+}
+
+// hasInitKey reports whether the init key is defined.
+func (ru *runtimeUsers) hasInitKey(initKeyID string) bool {
+	ru.mu.RLock()
+	defer ru.mu.RUnlock()
+	_, exists := ru.added[initKeyID]
+	return exists
+}
+
+// add adds the account to the init key, replacing its previously added and configured entries (e.g. to rotate its password),
+// and lifts any disable or removal of it.
+func (ru *runtimeUsers) add(initKeyID string, credential gordafarid.Credential) error {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	added, exists := ru.added[initKeyID]
+	if !exists {
+		return errUnknownInitKey
+	}
+	added.Remove(credential.Username)
+	added.Add(credential)
+	account := accountID{initKey: initKeyID, username: credential.Username}
+	ru.addedAccounts[account] = struct{}{}
+	delete(ru.disabled, account)
+	delete(ru.removed, account)
+	return nil
+}
+
+// setDisabled disables or enables the account.
+func (ru *runtimeUsers) setDisabled(account accountID, disabled bool) error {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	if _, exists := ru.added[account.initKey]; !exists {
+		return errUnknownInitKey
+	}
+	if disabled {
+		ru.disabled[account] = struct{}{}
+	} else {
+		delete(ru.disabled, account)
+	}
+	return nil
+}
+
+// remove removes the account, both its added and configured entries.
+func (ru *runtimeUsers) remove(account accountID) error {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	added, exists := ru.added[account.initKey]
+	if !exists {
+		return errUnknownInitKey
+	}
+	added.Remove(account.username)
+	delete(ru.addedAccounts, account)
+	delete(ru.disabled, account)
+	ru.removed[account] = struct{}{}
+	return nil
+}
+
+// runtimeUsersState is the runtime changes, as they're shown by the admin API.
+type runtimeUsersState struct {
+	Added    map[string][]string `json:"added"`    // Added usernames by init key ID
+	Disabled map[string][]string `json:"disabled"` // Disabled usernames by init key ID
+	Removed  map[string][]string `json:"removed"`  // Removed usernames by init key ID
+}
+
+// state returns the runtime changes.
+func (ru *runtimeUsers) state() runtimeUsersState {
+	ru.mu.RLock()
+	defer ru.mu.RUnlock()
+	state := runtimeUsersState{
+		Added:    make(map[string][]string, len(ru.added)),
+		Disabled: usernamesByInitKey(ru.disabled),
+		Removed:  usernamesByInitKey(ru.removed),
+	}
+	for initKeyID, added := range ru.added {
+		usernames := []string{}
+		for _, credential := range added.List() {
+			usernames = append(usernames, credential.Username)
+		}
+		slices.Sort(usernames)
+		state.Added[initKeyID] = usernames
+	}
+	return state
+}
+
+// usernamesByInitKey returns the sorted usernames of the accounts, by init key ID.
+func usernamesByInitKey(accounts map[accountID]struct{}) map[string][]string {
+	usernames := make(map[string][]string)
+	for account := range accounts {
+		usernames[account.initKey] = append(usernames[account.initKey], account.username)
+	}
+	for _, initKeyUsernames := range usernames {
+		slices.Sort(initKeyUsernames)
+	}
+	return usernames
+}
+
+// runtimeUserStore is the credential store of an init key, with the runtime changes applied on top of its configured stores.
+type runtimeUserStore struct {
+	users      *runtimeUsers
+	initKey    string // ID of the init key of the store
+	added      *gordafarid.MemoryCredentialStore
+	configured gordafarid.CredentialStore
+}
+
+// Lookup returns the added account with the given hash, or the configured one if it's neither removed nor replaced by an added account.
+// The disabled accounts are returned disabled, so their authentication fails.
+func (s *runtimeUserStore) Lookup(ctx context.Context, hash gordafarid.Hash) (gordafarid.Credential, error) {
+	credential, err := s.added.Lookup(ctx, hash)
+	if errors.Is(err, gordafarid.ErrCredentialNotFound) {
+		if credential, err = s.configured.Lookup(ctx, hash); err != nil {
+			return gordafarid.Credential{}, err
+		}
+		account := accountID{initKey: s.initKey, username: credential.Username}
+		s.users.mu.RLock()
+		_, removed := s.users.removed[account]
+		_, replaced := s.users.addedAccounts[account]
+		s.users.mu.RUnlock()
+		if removed || replaced {
+			return gordafarid.Credential{}, gordafarid.ErrCredentialNotFound
+		}
+	} else if err != nil {
+		return gordafarid.Credential{}, err
+	}
+
+	s.users.mu.RLock()
+	_, disabled := s.users.disabled[accountID{initKey: s.initKey, username: credential.Username}]
+	s.users.mu.RUnlock()
+	if disabled {
+		credential.Disabled = true
+	}
+	return credential, nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	net_session "github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+// newTestRuntimeUsers returns the runtime users of the default and tenant-b init keys, both configured with an "alice" account.
+func newTestRuntimeUsers() (ru *runtimeUsers, defaultStore, tenantStore gordafarid.CredentialStore, defaultAlice, tenantAlice gordafarid.Credential) {
+	defaultAlice = gordafarid.NewCredential("alice", "alice000000000000000000000000000")
+	tenantAlice = gordafarid.NewCredential("alice", "alice111111111111111111111111111")
+	ru = newRuntimeUsers()
+	defaultStore = ru.wrap(gordafarid.DefaultInitKeyID, gordafarid.NewMemoryCredentialStore(defaultAlice))
+	tenantStore = ru.wrap("tenant-b", gordafarid.NewMemoryCredentialStore(tenantAlice))
+	return ru, defaultStore, tenantStore, defaultAlice, tenantAlice
+}
+
+// keyIDOf returns the account hash the client of the credential sends.
+func keyIDOf(credential gordafarid.Credential) gordafarid.Hash {
+	return gordafarid.DeriveKeyID(credential.Username, credential.Password)
+}
+
+func TestRuntimeUsersDisablePerInitKey(t *testing.T) {
+	ru, defaultStore, tenantStore, defaultAlice, tenantAlice := newTestRuntimeUsers()
+	ctx := context.Background()
+
+	if err := ru.setDisabled(accountID{initKey: gordafarid.DefaultInitKeyID, username: "alice"}, true); err != nil {
+		t.Fatalf("setDisabled: %v", err)
+	}
+	credential, err := defaultStore.Lookup(ctx, keyIDOf(defaultAlice))
+	if err != nil || !credential.Disabled {
+		t.Fatalf("the disabled account: disabled = %v, err = %v", credential.Disabled, err)
+	}
+	credential, err = tenantStore.Lookup(ctx, keyIDOf(tenantAlice))
+	if err != nil || credential.Disabled {
+		t.Fatalf("the same-named account of another init key: disabled = %v, err = %v", credential.Disabled, err)
+	}
+
+	if err = ru.setDisabled(accountID{initKey: "tenant-c", username: "alice"}, true); !errors.Is(err, errUnknownInitKey) {
+		t.Fatalf("setDisabled of an unknown init key: err = %v, want %v", err, errUnknownInitKey)
+	}
+}
+
+func TestRuntimeUsersRemovePerInitKey(t *testing.T) {
+	ru, defaultStore, tenantStore, defaultAlice, tenantAlice := newTestRuntimeUsers()
+	ctx := context.Background()
+
+	if err := ru.remove(accountID{initKey: "tenant-b", username: "alice"}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := tenantStore.Lookup(ctx, keyIDOf(tenantAlice)); !errors.Is(err, gordafarid.ErrCredentialNotFound) {
+		t.Fatalf("the removed account: err = %v, want %v", err, gordafarid.ErrCredentialNotFound)
+	}
+	if _, err := defaultStore.Lookup(ctx, keyIDOf(defaultAlice)); err != nil {
+		t.Fatalf("the same-named account of another init key: %v", err)
+	}
+
+	// Adding the account back lifts its removal
+	if err := ru.add("tenant-b", tenantAlice); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := tenantStore.Lookup(ctx, keyIDOf(tenantAlice)); err != nil {
+		t.Fatalf("the added account: %v", err)
+	}
+}
+
+func TestRuntimeUsersRotatePassword(t *testing.T) {
+	ru, defaultStore, tenantStore, defaultAlice, tenantAlice := newTestRuntimeUsers()
+	ctx := context.Background()
+
+	// The added account replaces the configured one of the same username
+	rotated := gordafarid.NewCredential("alice", "alice222222222222222222222222222")
+	if err := ru.add(gordafarid.DefaultInitKeyID, rotated); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := defaultStore.Lookup(ctx, keyIDOf(rotated)); err != nil {
+		t.Fatalf("the rotated password: %v", err)
+	}
+	if _, err := defaultStore.Lookup(ctx, keyIDOf(defaultAlice)); !errors.Is(err, gordafarid.ErrCredentialNotFound) {
+		t.Fatalf("the configured password: err = %v, want %v", err, gordafarid.ErrCredentialNotFound)
+	}
+	if _, err := tenantStore.Lookup(ctx, keyIDOf(tenantAlice)); err != nil {
+		t.Fatalf("the same-named account of another init key: %v", err)
+	}
+
+	// Rotating it again replaces the added one
+	again := gordafarid.NewCredential("alice", "alice333333333333333333333333333")
+	if err := ru.add(gordafarid.DefaultInitKeyID, again); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := defaultStore.Lookup(ctx, keyIDOf(rotated)); !errors.Is(err, gordafarid.ErrCredentialNotFound) {
+		t.Fatalf("the previously rotated password: err = %v, want %v", err, gordafarid.ErrCredentialNotFound)
+	}
+
+	// Removing then adding the account doesn't bring the configured password back
+	if err := ru.remove(accountID{initKey: gordafarid.DefaultInitKeyID, username: "alice"}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := ru.add(gordafarid.DefaultInitKeyID, rotated); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := defaultStore.Lookup(ctx, keyIDOf(defaultAlice)); !errors.Is(err, gordafarid.ErrCredentialNotFound) {
+		t.Fatalf("the configured password after remove and add: err = %v, want %v", err, gordafarid.ErrCredentialNotFound)
+	}
+	if _, err := defaultStore.Lookup(ctx, keyIDOf(rotated)); err != nil {
+		t.Fatalf("the added password after remove and add: %v", err)
+	}
+}
+
+func TestSessionRegistryKillsPerInitKey(t *testing.T) {
+	var r sessionRegistry
+	alice := accountID{initKey: gordafarid.DefaultInitKeyID, username: "alice"}
+	tenantAlice := accountID{initKey: "tenant-b", username: "alice"}
+	r.open(net_session.NewID(), net_session.ID{}, alice, "10.0.0.1:1000", "example.com:443")
+	tenantSession := r.open(net_session.NewID(), net_session.ID{}, tenantAlice, "10.0.0.2:1000", "example.com:443")
+
+	if listed := r.list(tenantAlice); len(listed) != 1 || listed[0].InitKey != "tenant-b" {
+		t.Fatalf("list of tenant-b's alice = %+v", listed)
+	}
+	if killed := r.killUser(alice); killed != 1 {
+		t.Fatalf("killUser = %d, want 1", killed)
+	}
+	if tenantSession.isKilled() {
+		t.Fatal("the session of the same-named account of another init key is killed")
+	}
+}
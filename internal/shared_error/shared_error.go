@@ -10,4 +10,7 @@ var (
 	ErrListenerIsNotInitialized = errors.New("listener is not initialized")
 	ErrConnectionClosed         = errors.New("connection unexpectedly closed")
 	ErrConnectionAccepting      = errors.New("failed to accept incoming connection")
+	ErrServerClosed             = errors.New("server is shut down")
+	ErrClientClosed             = errors.New("client is shut down")
+	ErrShutdownDeadlineReached  = errors.New("shutdown deadline reached, the active connections are closed")
 )
@@ -0,0 +1,12 @@
+package upgrade
+
+import "errors"
+
+var (
+	errUnsupported             = errors.New("the upgrade isn't supported on this platform")
+	errListenerNotInheritable  = errors.New("the listener can't be passed to the new process")
+	errFailedToSpawn           = errors.New("failed to spawn the new process")
+	errNewProcessNotReady      = errors.New("the new process isn't ready, the upgrade is aborted")
+	errFailedToInheritListener = errors.New("failed to inherit the listening socket from the old process")
+	errFailedToReportReadiness = errors.New("failed to report the readiness to the old process")
+)
@@ -0,0 +1,58 @@
+// Package upgrade provides the zero-downtime restart of the Gordafarid server.
+//
+// On an upgrade, the server spawns a new copy of its executable (with the same arguments), and passes its listening socket to it.
+// Once the new process is ready, it accepts the connections on the same socket, and the old process drains its own connections and exits.
+// So a config or binary upgrade doesn't drop the clients mid-session, nor refuses the new ones.
+// It's only supported on Unix-like systems.
+package upgrade
+
+import (
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// inheritedEnv is set in the environment of the spawned process, its listening socket is inherited from the old process.
+	inheritedEnv = "GORDAFARID_UPGRADE"
+
+	// File descriptors of the spawned process, passed through ExtraFiles (after stdin, stdout and stderr)
+	listenerFD = 3 // The inherited listening socket
+	readyFD    = 4 // The write end of the pipe the new process reports its readiness through
+
+	// ReadyTimeout is how long the old process waits for the new one to be ready.
+	ReadyTimeout = 30 * time.Second
+)
+
+// Inherited reports whether the process is spawned by an upgrade, i.e. its listening socket is inherited.
+func Inherited() bool {
+	return os.Getenv(inheritedEnv) == "1"
+}
+
+// Listen returns the listening socket inherited from the old process if the process is spawned by an upgrade,
+// or creates a new one on the given address otherwise.
+func Listen(addr string) (net.Listener, error) {
+	if Inherited() {
+		return inheritedListener()
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Ready tells the old process the new one is ready, so it stops accepting and drains its connections.
+// It must be called once the process is listening, and it does nothing if the process isn't spawned by an upgrade.
+func Ready() error {
+	if !Inherited() {
+		return nil
+	}
+	return ready()
+}
+
+// spawnEnv returns the environment of the spawned process.
+func spawnEnv() []string {
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, inheritedEnv+"=")
+	})
+	return append(env, inheritedEnv+"=1")
+}
@@ -0,0 +1,90 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Notify relays the upgrade signal (SIGUSR2) to the channel.
+func Notify(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// Spawn starts a new copy of the executable with the same arguments, passes the listening socket to it,
+// and waits up to the timeout until it's ready. It returns the PID of the new process.
+// If the new process fails to start or isn't ready in time (e.g. its config is invalid), it's killed, and an error is returned,
+// so the caller can keep serving.
+func Spawn(ln net.Listener, timeout time.Duration) (int, error) {
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return 0, errors.Join(errListenerNotInheritable, fmt.Errorf("listener: %T", ln))
+	}
+	// File returns a duplicate of the socket, the listener keeps working
+	lnFile, err := filer.File()
+	if err != nil {
+		return 0, errors.Join(errListenerNotInheritable, err)
+	}
+	defer lnFile.Close()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, errors.Join(errFailedToSpawn, err)
+	}
+	defer readyReader.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyWriter.Close()
+		return 0, errors.Join(errFailedToSpawn, err)
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = spawnEnv()
+	cmd.ExtraFiles = []*os.File{lnFile, readyWriter} // listenerFD, readyFD
+	err = cmd.Start()
+	// Close our copy of the write end, so the read fails once the new process exits without being ready
+	readyWriter.Close()
+	if err != nil {
+		return 0, errors.Join(errFailedToSpawn, err)
+	}
+	// Reap the new process if it exits while we're still running
+	go cmd.Wait()
+
+	readyReader.SetReadDeadline(time.Now().Add(timeout))
+	if _, err = readyReader.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		return 0, errors.Join(errNewProcessNotReady, fmt.Errorf("pid: %d", cmd.Process.Pid), err)
+	}
+	return cmd.Process.Pid, nil
+}
+
+// inheritedListener returns the listening socket passed by the old process.
+func inheritedListener() (net.Listener, error) {
+	f := os.NewFile(listenerFD, "listener")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, errors.Join(errFailedToInheritListener, err)
+	}
+	return ln, nil
+}
+
+// ready writes to the readiness pipe passed by the old process, and closes it.
+func ready() error {
+	f := os.NewFile(readyFD, "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		return errors.Join(errFailedToReportReadiness, err)
+	}
+	return nil
+}
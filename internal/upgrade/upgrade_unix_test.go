@@ -0,0 +1,129 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// helperEnv selects the mode of the spawned test binary, see TestHelperProcess.
+const helperEnv = "GORDAFARID_UPGRADE_HELPER"
+
+// TestHelperProcess isn't a real test, it's the process started by Spawn in spawnHelper.
+// In the "ready" mode, it takes the inherited listener, reports its readiness and serves a greeting on it.
+// In the "fail" mode, it exits before being ready, and in the "hang" mode, it never gets ready.
+func TestHelperProcess(t *testing.T) {
+	switch os.Getenv(helperEnv) {
+	case "ready":
+	case "fail":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+		os.Exit(1)
+	default:
+		return
+	}
+
+	ln, err := Listen("")
+	if err != nil {
+		os.Exit(1)
+	}
+	if err = Ready(); err != nil {
+		os.Exit(1)
+	}
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	if conn, err := ln.Accept(); err == nil {
+		io.WriteString(conn, "hello\n")
+		conn.Close()
+	}
+	ln.Close()
+	os.Exit(0)
+}
+
+// spawnHelper spawns the test binary in the given mode, passing it the listener, as an upgrade does.
+func spawnHelper(t *testing.T, mode string, ln net.Listener, timeout time.Duration) (int, error) {
+	t.Helper()
+	// Spawn passes the process's arguments on, only run the helper in the spawned test binary
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestHelperProcess$"}
+	defer func() { os.Args = args }()
+	t.Setenv(helperEnv, mode)
+	return Spawn(ln, timeout)
+}
+
+// newListener returns a TCP listener on a random local port, closed once the test ends.
+func newListener(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+func TestSpawn(t *testing.T) {
+	ln := newListener(t)
+	pid, err := spawnHelper(t, "ready", ln, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Spawn: %v", err)
+	}
+	if pid <= 0 || pid == os.Getpid() {
+		t.Fatalf("Spawn returned the pid %d", pid)
+	}
+
+	// The old process stops accepting, the new one serves the connections on the same socket
+	addr := ln.Addr().String()
+	ln.Close()
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || greeting != "hello\n" {
+		t.Fatalf("the inherited listener served %q (%v), want the greeting", greeting, err)
+	}
+}
+
+func TestSpawnNotReady(t *testing.T) {
+	for _, tc := range []struct {
+		mode    string
+		timeout time.Duration
+	}{
+		// The exit is reported right away, not once the timeout is reached
+		{"fail", time.Minute},
+		{"hang", 200 * time.Millisecond},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			ln := newListener(t)
+			start := time.Now()
+			if _, err := spawnHelper(t, tc.mode, ln, tc.timeout); !errors.Is(err, errNewProcessNotReady) {
+				t.Fatalf("Spawn: err = %v, want %v", err, errNewProcessNotReady)
+			}
+			if elapsed := time.Since(start); elapsed > 20*time.Second {
+				t.Fatalf("Spawn returned after %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestSpawnNotInheritable(t *testing.T) {
+	if _, err := Spawn(pipeListener{}, time.Second); !errors.Is(err, errListenerNotInheritable) {
+		t.Fatalf("Spawn: err = %v, want %v", err, errListenerNotInheritable)
+	}
+}
+
+// pipeListener is a net.Listener without a file descriptor.
+type pipeListener struct{}
+
+func (pipeListener) Accept() (net.Conn, error) { return nil, net.ErrClosed }
+func (pipeListener) Close() error              { return nil }
+func (pipeListener) Addr() net.Addr            { return &net.TCPAddr{} }
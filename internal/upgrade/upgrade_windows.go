@@ -0,0 +1,25 @@
+package upgrade
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// Notify does nothing, the upgrades aren't supported on Windows.
+func Notify(c chan<- os.Signal) {}
+
+// Spawn returns an error, the upgrades aren't supported on Windows.
+func Spawn(ln net.Listener, timeout time.Duration) (int, error) {
+	return 0, errUnsupported
+}
+
+// inheritedListener returns an error, the upgrades aren't supported on Windows.
+func inheritedListener() (net.Listener, error) {
+	return nil, errUnsupported
+}
+
+// ready returns an error, the upgrades aren't supported on Windows.
+func ready() error {
+	return errUnsupported
+}
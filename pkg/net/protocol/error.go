@@ -0,0 +1,9 @@
+package protocol
+
+import "errors"
+
+var (
+	errInvalidAddress    = errors.New("invalid address, host:port is expected")
+	errInvalidPort       = errors.New("invalid port, 0-65535 is expected")
+	errDomainNameTooLong = errors.New("the domain name is longer than 255 bytes")
+)
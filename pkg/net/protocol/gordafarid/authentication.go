@@ -1,32 +1,51 @@
 package gordafarid
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
 // handleAuthentication manages the authentication process for a Gordafarid connection.
 // This method is responsible for verifying the client's credentials and setting up
 // the account information if the authentication is successful.
-func (c *Conn) handleAuthentication() error {
+func (c *Conn) handleAuthentication(ctx context.Context) error {
 	// Extract the hash from the client's greeting message.
 	// This hash is used as a unique identifier for the client.
 	greetingHash := c.greeting.hash
 
-	// Attempt to retrieve the password associated with the greeting hash
-	// from the server's configuration. The server configuration contains
-	// a map of valid greeting hashes to their corresponding passwords.
-	password, exists := c.config.serverCredentials[greetingHash]
+	// Attempt to retrieve the credential associated with the greeting hash
+	// from the credential stores of the init key the greeting was decrypted with.
+	credential, err := c.initKey.credentials.Lookup(ctx, greetingHash)
 
 	// If the greeting hash doesn't exist in the server's credentials,
 	// it means the client is not recognized or authorized.
-	if !exists {
+	if err != nil {
 		// Return an authentication failure error.
 		// This error should be handled by the caller to take appropriate action,
 		// such as closing the connection or requesting re-authentication.
-		return errAuthFailed
+		if errors.Is(err, ErrCredentialNotFound) {
+			return errAuthFailed
+		}
+		// The store failed (e.g. the external authorizer timed out or is down), it's not the client's fault
+		return errors.Join(errCredentialStoreUnavailable, err)
+	}
+
+	// Check if the account can authenticate at this time (disabled, expired, outside its allowed hours, ...)
+	now := time.Now()
+	if err = credential.checkLifecycle(now); err != nil {
+		return errors.Join(errAuthFailed, fmt.Errorf("user: %s", credential.Username), err)
 	}
 
 	// If the credentials are valid, create an account object for the authenticated client.
 	// This account object stores the client's identifying information.
 	c.account = account{
-		hash:     greetingHash, // Store the unique identifier (hash) for this account
-		password: password,     // Store the password associated with this account
+		hash:       greetingHash,          // Store the unique identifier (hash) for this account
+		username:   credential.Username,   // Store the username of this account
+		password:   credential.key(),      // Store the password associated with this account
+		attributes: credential.Attributes, // Store the policy attributes of this account
+		validUntil: credential.validUntil(now),
 	}
 
 	// Return nil to indicate successful authentication.
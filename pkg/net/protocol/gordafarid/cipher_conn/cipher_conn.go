@@ -19,7 +19,8 @@ const (
 	packetMessageLengthSize = 2
 )
 
-// nonceCache is a cache of nonces used in AEAD encryption to prevent nonce reuse.
+// nonceCache is a cache of the nonces of the received messages, to detect the replays.
+// Only the received nonces are stored, so a server and a client in the same process don't reject each other's messages.
 var nonceCache *nonce_cache.NonceCache
 
 func init() {
@@ -34,6 +35,11 @@ func init() {
 
 }
 
+// NonceCacheSize returns the number of nonces kept by the connections to detect the replays, e.g. for monitoring.
+func NonceCacheSize() int {
+	return nonceCache.Len()
+}
+
 // CipherConn wraps a net.Conn and encrypts/decrypts using an AEAD cipher.
 // It's like a secret decoder ring for your network messages!
 type CipherConn struct {
@@ -75,8 +81,6 @@ func (c *CipherConn) Read(b []byte) (int, error) {
 	if nonceCache.Exists(nonce) {
 		return 0, errServerDuplicatedAEADNonceUsedPossibleReplayAttack
 	}
-	// Store the new nonce
-	nonceCache.Store(nonce)
 
 	// Read ciphertext
 	// This is the actual encrypted secret message
@@ -88,6 +92,10 @@ func (c *CipherConn) Read(b []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	// Store the nonce once the message is authentic, a concurrent read of the same message may have won the race
+	if err = nonceCache.Store(nonce); err != nil {
+		return 0, errServerDuplicatedAEADNonceUsedPossibleReplayAttack
+	}
 
 	// Copy the decrypted data to the buffer
 	// This is like writing down the decoded message in our notepad
@@ -104,18 +112,10 @@ func (c *CipherConn) Read(b []byte) (int, error) {
 func (c *CipherConn) Write(b []byte) (int, error) {
 	// Generate a nonce
 	// This is like creating a unique stamp for our message
+	// It's random, and only the nonces of the received messages are stored in the nonce cache
 	nonce := make([]byte, c.aead.NonceSize())
-	for {
-		if _, err := rand.Read(nonce); err != nil {
-			return 0, err
-		}
-		// Check if the nonce has been used before, if used before replay attack is possible
-		if !nonceCache.Exists(nonce) {
-			// Store the new nonce
-			nonceCache.Store(nonce)
-			break
-		}
-		// If the nonce exists, the loop will continue and generate a new one
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, err
 	}
 
 	// Encrypt the message
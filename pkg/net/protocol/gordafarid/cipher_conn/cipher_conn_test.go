@@ -0,0 +1,54 @@
+package cipher_conn
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// recordingConn records the bytes written to it.
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.written.Write(b)
+	return c.Conn.Write(b)
+}
+
+func TestCipherConnRejectsReplays(t *testing.T) {
+	aead, err := chacha20poly1305.New([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("chacha20poly1305.New: %v", err)
+	}
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+	left.SetDeadline(time.Now().Add(2 * time.Second))
+	right.SetDeadline(time.Now().Add(2 * time.Second))
+	recorder := &recordingConn{Conn: left}
+	sender := WrapConnToCipherConn(recorder, aead)
+	receiver := WrapConnToCipherConn(right, aead)
+
+	// Both sides are in the same process, the receiver accepts the sender's nonces
+	go sender.Write([]byte("data"))
+	data := make([]byte, 4)
+	if _, err = io.ReadFull(receiver, data); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("data = %q, want %q", data, "data")
+	}
+
+	// Replay the recorded packet
+	go left.Write(recorder.written.Bytes())
+	if _, err = receiver.Read(data); !errors.Is(err, errServerDuplicatedAEADNonceUsedPossibleReplayAttack) {
+		t.Fatalf("the replayed packet got %v, want the replay error", err)
+	}
+}
@@ -2,10 +2,14 @@ package gordafarid
 
 import (
 	"context"
+	"errors"
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/utils"
 )
 
@@ -16,24 +20,35 @@ type handshakeFunction func(ctx context.Context) error
 
 // account represents user authentication information.
 type account struct {
-	hash     Hash   // Hash of the account, used for identification
-	password []byte // Password associated with the account
+	hash       Hash              // Hash of the account, used for identification
+	username   string            // Username of the account
+	password   []byte            // Password associated with the account
+	attributes map[string]string // Policy attributes of the account
+	validUntil time.Time         // When the account stops being allowed to authenticate, zero means never
 }
 
 // Conn represents a connection using the Gordafarid protocol.
 // It wraps a standard net.Conn and adds protocol-specific functionality.
 type Conn struct {
-	net.Conn         // Embedded net.Conn for underlying network operations
-	config   *Config // Configuration
-	account  account // Account information for authentication
+	net.Conn          // Embedded net.Conn for underlying network operations
+	config   *Config  // Configuration
+	account  account  // Account information for authentication
+	initKey  *initKey // Server-side init key the client's initial greeting was decrypted with
+
+	sessionID session.ID // ID of the connection, generated at accept time on the server-side, or given by the dialer on the client-side
 
 	// Headers used in the protocol
 	greeting greetingHeader // Greeting header for initial communication
 	request  requestHeader  // Request header for client requests
 	reply    replyHeader    // Reply header for server responses
 
+	replyOnce sync.Once // Ensures the server's reply is sent only once
+	replyErr  error     // Result of sending the server's reply
+
 	handshakeFn         handshakeFunction // Function to perform the handshake
 	isHandshakeComplete atomic.Bool       // Flag to track if handshake is complete
+	handshakeStart      time.Time         // When the handshake started
+	handshakeDuration   atomic.Int64      // How long the handshake took, in nanoseconds
 	isClient            bool              // Indicates whether this is a client connection
 }
 
@@ -47,6 +62,10 @@ func (c *Conn) Read(b []byte) (int, error) {
 			return 0, err
 		}
 	}
+	// Send the success reply, if the server hasn't replied yet
+	if err := c.ensureReplied(); err != nil {
+		return 0, err
+	}
 	// Proceed with reading from the underlying connection
 	return c.Conn.Read(b)
 }
@@ -61,6 +80,10 @@ func (c *Conn) Write(b []byte) (int, error) {
 			return 0, err
 		}
 	}
+	// Send the success reply, if the server hasn't replied yet
+	if err := c.ensureReplied(); err != nil {
+		return 0, err
+	}
 	// Proceed with writing to the underlying connection
 	return c.Conn.Write(b)
 }
@@ -89,3 +112,87 @@ func (c *Conn) GetHandshakeResult() (protocol.AddressHeader, error) {
 	// Return the address header from the request
 	return c.request.AddressHeader, nil
 }
+
+// SendReply sends the server's reply with the given status (ReplySuccess, ReplyFailed, ReplyNotAllowed, ReplyQuotaExceeded, ReplyLimitExceeded) to the client.
+// The server-side handshake ends after reading the client's request, so the server can decide about the request
+// (e.g. check the access control lists, dial the destination) before replying.
+// The reply is sent only once, later calls return the result of the first one.
+// If the server doesn't call it, a ReplySuccess reply is sent on the first Read or Write.
+// The reply is written with the handshake timeout, which replaces the connection's write deadline.
+func (c *Conn) SendReply(status byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.config.handshakeTimeout)*time.Second)
+	defer cancel()
+	return c.sendReply(ctx, status)
+}
+
+// sendReply sends the server's reply with the given status once, see SendReply.
+func (c *Conn) sendReply(ctx context.Context, status byte) error {
+	if c.isClient {
+		return errServerReplyOnClientConn
+	}
+	// Make sure the request is read before replying
+	if !c.GetHandshakeComplete() {
+		if err := c.Handshake(); err != nil {
+			return err
+		}
+	}
+	c.replyOnce.Do(func() {
+		if err := c.serverSendReply(ctx, status); err != nil {
+			c.replyErr = errors.Join(errServerFailedToSendReplyResponse, err)
+		}
+	})
+	return c.replyErr
+}
+
+// ensureReplied sends the success reply on server-side connections, if no reply is sent yet.
+// It's called by Read and Write, so the reply is written under the caller's write deadline (if any), which is kept.
+func (c *Conn) ensureReplied() error {
+	if c.isClient {
+		return nil
+	}
+	return c.sendReply(context.Background(), ReplySuccess)
+}
+
+// Username returns the username of the connection's account.
+// On the server-side, it's the authenticated account, available after the handshake.
+func (c *Conn) Username() string {
+	return c.account.username
+}
+
+// InitKeyID returns the ID of the init key the initial greeting was decrypted with, available after the server-side handshake.
+// The usernames are only unique within an init key, so the accounts are identified by both.
+func (c *Conn) InitKeyID() string {
+	if c.initKey == nil {
+		return ""
+	}
+	return c.initKey.id
+}
+
+// HandshakeDuration returns how long the handshake took, available after the handshake.
+func (c *Conn) HandshakeDuration() time.Duration {
+	return time.Duration(c.handshakeDuration.Load())
+}
+
+// Attributes returns the policy attributes of the authenticated account, available after the server-side handshake.
+func (c *Conn) Attributes() map[string]string {
+	return c.account.attributes
+}
+
+// ValidUntil returns when the authenticated account stops being allowed to authenticate (it expires or its allowed hours end),
+// available after the server-side handshake. The zero time means it doesn't.
+// The lifecycle is only checked by the handshake, so the server closes the connections still open at that time.
+func (c *Conn) ValidUntil() time.Time {
+	return c.account.validUntil
+}
+
+// SessionID returns the ID of the connection.
+// On the server-side, it's generated when the connection is accepted, and on the client-side, it's the one set by dialConnConfig.SetSessionID (zero if not set).
+func (c *Conn) SessionID() session.ID {
+	return c.sessionID
+}
+
+// ClientSessionID returns the session ID the client sent in its initial greeting, available after the server-side handshake.
+// It's zero if the client didn't send one. On the client-side, it's the ID sent to the server.
+func (c *Conn) ClientSessionID() session.ID {
+	return c.greeting.sessionID
+}
@@ -0,0 +1,57 @@
+package gordafarid
+
+import (
+	"maps"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+// ConnectionState records basic details about the Gordafarid connection, in the spirit of tls.ConnectionState.
+// Except for the session IDs, the fields are only set once the handshake is complete.
+type ConnectionState struct {
+	HandshakeComplete bool          // Whether the handshake is complete, the fields below are zero if it isn't
+	HandshakeStart    time.Time     // When the handshake started
+	HandshakeDuration time.Duration // How long the handshake took, up to reading the client's request on the server-side
+	Version           byte          // The protocol version of the client's initial greeting, gordafaridSessionIDVersion if it sent its session ID
+
+	Username   string            // The account of the connection, the authenticated one on the server-side
+	KeyID      Hash              // The account hash sent in the initial greeting, see DeriveKeyID
+	Attributes map[string]string // The policy attributes of the authenticated account, a copy (server-side only)
+
+	Cipher        string // The AEAD algorithm encrypting the connection after the handshake
+	InitAlgorithm string // The AEAD algorithm of the initial greeting
+	InitKeyID     string // ID of the init key the initial greeting was decrypted with (server-side only)
+
+	SessionID       session.ID // ID of the connection, see Conn.SessionID
+	ClientSessionID session.ID // The session ID sent by the client in its initial greeting, zero if it didn't send one
+	IsClient        bool       // Whether this is the client's end of the connection
+}
+
+// ConnectionState returns basic details about the connection, e.g. for the policy, logging or accounting decisions.
+// It's safe to call concurrently with the handshake, the handshake details are only returned once it's complete.
+func (c *Conn) ConnectionState() ConnectionState {
+	state := ConnectionState{
+		SessionID: c.sessionID,
+		IsClient:  c.isClient,
+	}
+	if !c.GetHandshakeComplete() {
+		return state
+	}
+	state.HandshakeComplete = true
+	state.HandshakeStart = c.handshakeStart
+	state.HandshakeDuration = c.HandshakeDuration()
+	state.Version = c.greeting.Version
+	state.Username = c.account.username
+	state.KeyID = c.account.hash
+	state.Attributes = maps.Clone(c.account.attributes)
+	state.Cipher = c.config.encryptionAlgorithm
+	state.ClientSessionID = c.greeting.sessionID
+	if c.isClient {
+		state.InitAlgorithm = c.config.initAlgorithm
+	} else if c.initKey != nil {
+		state.InitAlgorithm = c.initKey.algorithm
+		state.InitKeyID = c.initKey.id
+	}
+	return state
+}
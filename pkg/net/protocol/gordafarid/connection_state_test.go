@@ -0,0 +1,91 @@
+package gordafarid
+
+import (
+	"testing"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+func TestConnectionState(t *testing.T) {
+	credential := NewCredential(testUsername, testPassword)
+	credential.Attributes = map[string]string{"plan": "basic"}
+	l := listenTest(t, NewServerConfig([]Credential{credential}, testAlgorithm, testAlgorithm, testInitPassword, 2))
+
+	id := session.NewID()
+	accept := acceptNext(t, l)
+	c := dialTestClient(t, l)
+	greeting := seal(t, testAlgorithm, []byte(testInitPassword), testGreeting(gordafaridSessionIDVersion, testUsername, testPassword))
+	if _, err := c.Write(append(greeting, seal(t, testAlgorithm, []byte(testInitPassword), id[:])...)); err != nil {
+		t.Fatalf("writing the greeting: %v", err)
+	}
+	if status := c.readGreetingReply(testPassword); status != greetingSuccess {
+		t.Fatalf("the greeting got status %d", status)
+	}
+	c.sendRequest(testPassword)
+	conn, err := accept()
+	if err != nil {
+		t.Fatalf("the handshake failed: %v", err)
+	}
+
+	state := conn.ConnectionState()
+	if !state.HandshakeComplete || state.HandshakeStart.IsZero() || state.HandshakeDuration <= 0 {
+		t.Fatalf("the handshake details aren't set: %+v", state)
+	}
+	// The client sent its session ID, so it greeted with the session ID version
+	if state.Version != gordafaridSessionIDVersion || state.IsClient {
+		t.Fatalf("got version %d and client %v, want %d on the server-side", state.Version, state.IsClient, gordafaridSessionIDVersion)
+	}
+	if state.Username != testUsername || state.KeyID != DeriveKeyID(testUsername, testPassword) {
+		t.Fatalf("got the account %q (%x), want %q", state.Username, state.KeyID, testUsername)
+	}
+	if state.Cipher != testAlgorithm || state.InitAlgorithm != testAlgorithm || state.InitKeyID != DefaultInitKeyID {
+		t.Fatalf("got the cipher %q, the init algorithm %q and the init key %q", state.Cipher, state.InitAlgorithm, state.InitKeyID)
+	}
+	if state.SessionID.IsZero() || state.SessionID != conn.SessionID() || state.ClientSessionID != id {
+		t.Fatalf("got the session %s and the client's session %s, want the accepted one and %s", state.SessionID, state.ClientSessionID, id)
+	}
+	if state.Attributes["plan"] != "basic" {
+		t.Fatalf("got the attributes %v", state.Attributes)
+	}
+	// The attributes are a copy
+	state.Attributes["plan"] = "premium"
+	if conn.Attributes()["plan"] != "basic" {
+		t.Fatal("changing the state's attributes changed the connection's")
+	}
+}
+
+func TestConnectionStateVersionWithoutSessionID(t *testing.T) {
+	l := listenTest(t, NewServerConfig([]Credential{NewCredential(testUsername, testPassword)}, testAlgorithm, testAlgorithm, testInitPassword, 2))
+
+	accept := acceptNext(t, l)
+	c := dialTestClient(t, l)
+	if _, err := c.Write(seal(t, testAlgorithm, []byte(testInitPassword), testGreeting(gordafaridVersion, testUsername, testPassword))); err != nil {
+		t.Fatalf("writing the greeting: %v", err)
+	}
+	if status := c.readGreetingReply(testPassword); status != greetingSuccess {
+		t.Fatalf("the greeting got status %d", status)
+	}
+	c.sendRequest(testPassword)
+	conn, err := accept()
+	if err != nil {
+		t.Fatalf("the handshake failed: %v", err)
+	}
+
+	state := conn.ConnectionState()
+	if state.Version != gordafaridVersion || !state.ClientSessionID.IsZero() {
+		t.Fatalf("got version %d and the client's session %s, want %d without a session", state.Version, state.ClientSessionID, gordafaridVersion)
+	}
+}
+
+func TestConnectionStateBeforeHandshake(t *testing.T) {
+	c := buildServerConn(nil, NewServerConfig(nil, testAlgorithm, testAlgorithm, testInitPassword, 2).convertToRealConfig())
+	c.sessionID = session.NewID()
+
+	state := c.ConnectionState()
+	if state.HandshakeComplete || state.Username != "" || state.Cipher != "" {
+		t.Fatalf("the handshake details of an incomplete handshake are set: %+v", state)
+	}
+	if state.SessionID != c.sessionID {
+		t.Fatalf("got the session %s, want %s", state.SessionID, c.sessionID)
+	}
+}
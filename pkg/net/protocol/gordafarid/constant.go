@@ -7,17 +7,30 @@ const (
 	// gordafaridVersion represents the current version of the Gordafarid protocol.
 	gordafaridVersion = 1
 
+	// gordafaridSessionIDVersion is the version of the initial greetings followed by the client's encrypted session ID.
+	// It marks the extension in the greeting itself, so the server knows it before reading the rest of the handshake.
+	gordafaridSessionIDVersion = 2
+
 	// greetingSuccess indicates a successful greeting in the protocol.
 	greetingSuccess = 0
 
 	// greetingFailed indicates a failed greeting in the protocol.
 	greetingFailed = 1
 
-	// replySuccess indicates a successful reply in the protocol.
-	replySuccess = 0
+	// ReplySuccess indicates a successful reply in the protocol.
+	ReplySuccess = 0
+
+	// ReplyFailed indicates a general failure reply in the protocol, e.g. the destination is unreachable.
+	ReplyFailed = 1
+
+	// ReplyNotAllowed indicates the destination is not allowed for the account.
+	ReplyNotAllowed = 2
+
+	// ReplyQuotaExceeded indicates the account has exceeded its traffic quota.
+	ReplyQuotaExceeded = 3
 
-	// replyFailed indicates a failed reply in the protocol.
-	replyFailed = 1
+	// ReplyLimitExceeded indicates a concurrent connection limit (per account or per client IP) is reached.
+	ReplyLimitExceeded = 4
 
 	// HashSize defines the size of the hash used in the greeting header.
 	// It is set to the size of SHA-256 hash, which is 32 bytes.
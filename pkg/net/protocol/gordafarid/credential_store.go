@@ -0,0 +1,286 @@
+package gordafarid
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CredentialStore provides the server-side accounts for the Gordafarid authentication.
+// It is queried with the account hash the client sends in its initial greeting.
+type CredentialStore interface {
+	// Lookup returns the credential whose account hash matches the given hash.
+	// It returns ErrCredentialNotFound if there is no such credential.
+	Lookup(ctx context.Context, hash Hash) (Credential, error)
+}
+
+// ParseCredential creates a Credential from its textual representation, as it's stored in config and user files.
+// Either the plaintext password, or the base64-encoded key (and optionally its base64-encoded keyID) must be set.
+// If the keyID is empty, it is derived from the username and the key;
+// otherwise it must match the derived one, since that's the account hash the clients send.
+func ParseCredential(username, password, keyID, key string) (Credential, error) {
+	if len(key) < 1 {
+		if len(password) < 1 {
+			return Credential{}, errCredentialHasNoKeyMaterial
+		}
+		return NewCredential(username, password), nil
+	}
+
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return Credential{}, errors.Join(errCredentialInvalidKey, err)
+	}
+	hash := DeriveKeyID(username, string(decodedKey))
+	if len(keyID) > 0 {
+		decodedKeyID, err := base64.StdEncoding.DecodeString(keyID)
+		if err != nil || len(decodedKeyID) != sha256.Size {
+			return Credential{}, errors.Join(errCredentialInvalidKeyID, err)
+		}
+		if !bytes.Equal(hash[:], decodedKeyID) {
+			return Credential{}, errCredentialKeyIDMismatch
+		}
+	}
+	return NewDerivedCredential(username, hash, decodedKey), nil
+}
+
+// MemoryCredentialStore is a CredentialStore that keeps the credentials in memory.
+// It's safe for concurrent use, and the credentials can be added or removed at runtime.
+type MemoryCredentialStore struct {
+	mu          sync.RWMutex
+	credentials map[Hash]Credential
+}
+
+// NewMemoryCredentialStore creates a new MemoryCredentialStore holding the given credentials.
+func NewMemoryCredentialStore(credentials ...Credential) *MemoryCredentialStore {
+	s := &MemoryCredentialStore{}
+	s.Replace(credentials)
+	return s
+}
+
+// Lookup returns the credential whose account hash matches the given hash.
+func (s *MemoryCredentialStore) Lookup(_ context.Context, hash Hash) (Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	credential, exists := s.credentials[hash]
+	if !exists {
+		return Credential{}, ErrCredentialNotFound
+	}
+	return credential, nil
+}
+
+// Add adds the credential to the store, replacing any credential with the same account hash.
+func (s *MemoryCredentialStore) Add(credential Credential) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials[credential.hash()] = credential
+}
+
+// Remove removes all the credentials of the given username from the store.
+// It returns the number of removed credentials.
+func (s *MemoryCredentialStore) Remove(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for hash, credential := range s.credentials {
+		if credential.Username == username {
+			delete(s.credentials, hash)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Replace replaces all the credentials of the store with the given ones.
+func (s *MemoryCredentialStore) Replace(credentials []Credential) {
+	newCredentials := make(map[Hash]Credential, len(credentials))
+	for _, credential := range credentials {
+		newCredentials[credential.hash()] = credential
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials = newCredentials
+}
+
+// List returns a copy of all the credentials in the store.
+func (s *MemoryCredentialStore) List() []Credential {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	credentials := make([]Credential, 0, len(s.credentials))
+	for _, credential := range s.credentials {
+		credentials = append(credentials, credential)
+	}
+	return credentials
+}
+
+// ChainCredentialStore is a CredentialStore that queries several credential stores in order,
+// and returns the first credential found.
+type ChainCredentialStore []CredentialStore
+
+// Lookup returns the first credential found in the chained stores.
+// Errors other than ErrCredentialNotFound stop the lookup, so an unavailable store fails closed.
+func (cs ChainCredentialStore) Lookup(ctx context.Context, hash Hash) (Credential, error) {
+	for _, store := range cs {
+		credential, err := store.Lookup(ctx, hash)
+		if err == nil {
+			return credential, nil
+		}
+		if !errors.Is(err, ErrCredentialNotFound) {
+			return Credential{}, err
+		}
+	}
+	return Credential{}, ErrCredentialNotFound
+}
+
+// cachedLookup is a cached result of a credential lookup.
+type cachedLookup struct {
+	credential Credential
+	found      bool
+	expiresAt  time.Time
+}
+
+// pendingLookup is a lookup of the underlying store in flight, whose result is shared by the concurrent lookups of the same hash.
+type pendingLookup struct {
+	done       chan struct{} // Closed once the result is set
+	credential Credential
+	err        error
+}
+
+// DefaultMaxCachedNotFound is the default maximum number of not found results a CachedCredentialStore caches.
+const DefaultMaxCachedNotFound = 10000
+
+// CachedCredentialStore is a CredentialStore that caches the lookup results of another store for a TTL.
+// Both found and not found results are cached, errors are not, so a failing store is queried again.
+// The concurrent lookups of the same hash share a single query of the store.
+// The not found results are cached up to a maximum number (DefaultMaxCachedNotFound by default),
+// so the random hashes of the failed handshakes can't grow the cache without bounds.
+// It's meant for slow stores, like the external authorizers.
+type CachedCredentialStore struct {
+	store       CredentialStore
+	ttl         time.Duration
+	maxNotFound int // Maximum number of cached not found results
+
+	mu       sync.Mutex
+	cache    map[Hash]cachedLookup
+	notFound int                     // Number of cached not found results
+	pending  map[Hash]*pendingLookup // Lookups of the underlying store in flight
+}
+
+// NewCachedCredentialStore creates a new CachedCredentialStore caching the results of the given store for ttl.
+func NewCachedCredentialStore(store CredentialStore, ttl time.Duration) *CachedCredentialStore {
+	return &CachedCredentialStore{
+		store:       store,
+		ttl:         ttl,
+		maxNotFound: DefaultMaxCachedNotFound,
+		cache:       make(map[Hash]cachedLookup),
+		pending:     make(map[Hash]*pendingLookup),
+	}
+}
+
+// SetMaxNotFound sets the maximum number of cached not found results, 0 means they aren't cached.
+// The already cached results are kept until they expire.
+func (s *CachedCredentialStore) SetMaxNotFound(maxNotFound int) *CachedCredentialStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxNotFound = maxNotFound
+	return s
+}
+
+// Lookup returns the cached lookup result if it's not expired, otherwise it queries the underlying store.
+// If a query of the same hash is already in flight, it waits for its result instead (including its error, which isn't cached).
+func (s *CachedCredentialStore) Lookup(ctx context.Context, hash Hash) (Credential, error) {
+	now := time.Now()
+	s.mu.Lock()
+	cached, exists := s.cache[hash]
+	if exists && now.Before(cached.expiresAt) {
+		s.mu.Unlock()
+		if !cached.found {
+			return Credential{}, ErrCredentialNotFound
+		}
+		return cached.credential, nil
+	}
+	if pending, exists := s.pending[hash]; exists {
+		s.mu.Unlock()
+		select {
+		case <-pending.done:
+			return pending.credential, pending.err
+		case <-ctx.Done():
+			return Credential{}, ctx.Err()
+		}
+	}
+	pending := &pendingLookup{done: make(chan struct{})}
+	s.pending[hash] = pending
+	s.mu.Unlock()
+
+	credential, err := s.store.Lookup(ctx, hash)
+	if err != nil && !errors.Is(err, ErrCredentialNotFound) {
+		credential = Credential{}
+	}
+
+	s.mu.Lock()
+	if err == nil || errors.Is(err, ErrCredentialNotFound) {
+		s.cacheResult(hash, cachedLookup{
+			credential: credential,
+			found:      err == nil,
+			expiresAt:  now.Add(s.ttl),
+		})
+	}
+	delete(s.pending, hash)
+	s.mu.Unlock()
+	pending.credential, pending.err = credential, err
+	close(pending.done)
+	return credential, err
+}
+
+// cacheResult caches the lookup result of the hash, unless it's a not found result over the maximum. The caller must hold the lock.
+func (s *CachedCredentialStore) cacheResult(hash Hash, result cachedLookup) {
+	if previous, exists := s.cache[hash]; exists {
+		if !previous.found {
+			s.notFound--
+		}
+		delete(s.cache, hash)
+	}
+	if !result.found {
+		if s.notFound >= s.maxNotFound {
+			return
+		}
+		s.notFound++
+	}
+	s.cache[hash] = result
+}
+
+// CleanupExpired removes the expired lookup results from the cache.
+func (s *CachedCredentialStore) CleanupExpired() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, cached := range s.cache {
+		if !now.Before(cached.expiresAt) {
+			if !cached.found {
+				s.notFound--
+			}
+			delete(s.cache, hash)
+		}
+	}
+}
+
+// StartCleanupRoutine starts a background routine to periodically remove the expired lookup results.
+// It runs the cleanup every cleanupInterval, and listens for cancellation via context.
+func (s *CachedCredentialStore) StartCleanupRoutine(ctx context.Context, cleanupInterval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.CleanupExpired()
+			case <-ctx.Done():
+				// Stop the goroutine when the context is cancelled
+				return
+			}
+		}
+	}()
+}
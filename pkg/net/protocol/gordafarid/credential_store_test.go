@@ -0,0 +1,133 @@
+package gordafarid
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeriveKey(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	key, err := DeriveKey("passphrase", salt, 32)
+	if err != nil {
+		t.Fatalf("DeriveKey: %v", err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("key size = %d, want 32", len(key))
+	}
+	if bytes.Contains(key, []byte("passphrase")) {
+		t.Fatal("the key contains the passphrase")
+	}
+
+	again, err := DeriveKey("passphrase", salt, 32)
+	if err != nil || !bytes.Equal(key, again) {
+		t.Fatalf("DeriveKey isn't deterministic for the same passphrase and salt")
+	}
+	otherSalt, err := DeriveKey("passphrase", []byte("fedcba9876543210"), 32)
+	if err != nil || bytes.Equal(key, otherSalt) {
+		t.Fatalf("DeriveKey returned the same key for another salt")
+	}
+	if _, err = DeriveKey("passphrase", nil, 32); !errors.Is(err, errKeyDerivationEmptySalt) {
+		t.Fatalf("DeriveKey without a salt: err = %v, want %v", err, errKeyDerivationEmptySalt)
+	}
+}
+
+func TestParseCredentialKeyID(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+	keyID := DeriveKeyID("alice", string(key))
+
+	credential, err := ParseCredential("alice", "", base64.StdEncoding.EncodeToString(keyID[:]), encodedKey)
+	if err != nil {
+		t.Fatalf("ParseCredential with the matching keyId: %v", err)
+	}
+	if credential.hash() != keyID {
+		t.Fatalf("hash = %x, want %x", credential.hash(), keyID)
+	}
+
+	credential, err = ParseCredential("alice", "", "", encodedKey)
+	if err != nil || credential.hash() != keyID {
+		t.Fatalf("ParseCredential without keyId: hash = %x, err = %v, want %x", credential.hash(), err, keyID)
+	}
+
+	otherKeyID := DeriveKeyID("bob", string(key))
+	if _, err = ParseCredential("alice", "", base64.StdEncoding.EncodeToString(otherKeyID[:]), encodedKey); !errors.Is(err, errCredentialKeyIDMismatch) {
+		t.Fatalf("ParseCredential with a mismatched keyId: err = %v, want %v", err, errCredentialKeyIDMismatch)
+	}
+}
+
+// countingStore is a CredentialStore counting its lookups, which wait for the release channel (if set) to be closed.
+type countingStore struct {
+	store   CredentialStore
+	release chan struct{}
+	lookups atomic.Int32
+}
+
+func (s *countingStore) Lookup(ctx context.Context, hash Hash) (Credential, error) {
+	s.lookups.Add(1)
+	if s.release != nil {
+		<-s.release
+	}
+	return s.store.Lookup(ctx, hash)
+}
+
+func TestCachedCredentialStoreCoalescesLookups(t *testing.T) {
+	alice := NewCredential(testUsername, testPassword)
+	store := &countingStore{store: NewMemoryCredentialStore(alice), release: make(chan struct{})}
+	cached := NewCachedCredentialStore(store, time.Minute)
+
+	// The concurrent lookups of the same hash wait for the first one's query
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if credential, err := cached.Lookup(context.Background(), alice.hash()); err != nil || credential.Username != testUsername {
+				t.Errorf("Lookup = %q, %v, want the account", credential.Username, err)
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(store.release)
+	wg.Wait()
+	if lookups := store.lookups.Load(); lookups != 1 {
+		t.Fatalf("the store is queried %d times, want once", lookups)
+	}
+
+	// A waiting lookup gives up once its context is done
+	store = &countingStore{store: NewMemoryCredentialStore(), release: make(chan struct{})}
+	cached = NewCachedCredentialStore(store, time.Minute)
+	go cached.Lookup(context.Background(), alice.hash())
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cached.Lookup(ctx, alice.hash()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("the waiting lookup returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(store.release)
+}
+
+func TestCachedCredentialStoreMaxNotFound(t *testing.T) {
+	store := &countingStore{store: NewMemoryCredentialStore()}
+	cached := NewCachedCredentialStore(store, time.Minute).SetMaxNotFound(2)
+	hashes := []Hash{DeriveKeyID("a", "a"), DeriveKeyID("b", "b"), DeriveKeyID("c", "c")}
+	for range 2 {
+		for _, hash := range hashes {
+			if _, err := cached.Lookup(context.Background(), hash); !errors.Is(err, ErrCredentialNotFound) {
+				t.Fatalf("Lookup: err = %v, want %v", err, ErrCredentialNotFound)
+			}
+		}
+	}
+	// The first two results are cached, the third hash is queried both times
+	if lookups := store.lookups.Load(); lookups != 4 {
+		t.Fatalf("the store is queried %d times, want 4", lookups)
+	}
+	if len(cached.cache) != 2 || cached.notFound != 2 {
+		t.Fatalf("%d results are cached, %d not found, want 2", len(cached.cache), cached.notFound)
+	}
+}
@@ -2,12 +2,36 @@
 package aead
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
+	"time"
 
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/nonce_cache"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
+// nonceCache is a cache of the nonces accepted by Decrypt to detect the replays.
+// Only the received nonces are stored, so a server and a client in the same process don't reject each other's messages.
+var nonceCache *nonce_cache.NonceCache
+
+func init() {
+	// nonceExpiryTime is the duration after which a nonce is considered expired.
+	nonceExpiryTime := time.Minute * 60
+	nonceCache = nonce_cache.NewNonceCache(nonceExpiryTime)
+
+	// cleanupInterval is the duration between nonce cleanup operations.
+	cleanupInterval := time.Minute * 20
+	// Start the cleanup routine in the background that periodically cleans up old nonces.
+	nonceCache.StartCleanupRoutine(context.Background(), cleanupInterval)
+}
+
+// NonceCacheSize returns the number of nonces kept by Decrypt to detect the replays, e.g. for monitoring.
+func NonceCacheSize() int {
+	return nonceCache.Len()
+}
+
 // aeadConstructor is a function type that creates a new AEAD (Authenticated Encryption with Associated Data) cipher.
 type aeadConstructor func([]byte) (cipher.AEAD, error)
 
@@ -49,10 +73,10 @@ func IsCryptoSupported(algoName, password string) error {
 
 // GetAlgorithmKeySize returns the key size in bytes for the given algorithm name.
 func GetAlgorithmKeySize(algoName string) (int, error) {
-	if err := IsCryptoSupported(algoName, ""); err != nil {
-		return 0, err
+	aeadMeta, ok := supportedAEADs[algoName]
+	if !ok {
+		return 0, errCryptoAlgorithmUnsupported
 	}
-	aeadMeta := supportedAEADs[algoName]
 	return aeadMeta.KeySize, nil
 }
 
@@ -70,3 +94,73 @@ func NewAEAD(algoName string, key []byte) (cipher.AEAD, error) {
 	aead, err := aeadMeta.Constructor(key)
 	return aead, err
 }
+
+// Overhead returns the number of bytes Encrypt adds to a plaintext for the given algorithm,
+// which is the nonce size plus the authentication tag size.
+func Overhead(algoName string) (int, error) {
+	keySize, err := GetAlgorithmKeySize(algoName)
+	if err != nil {
+		return 0, err
+	}
+	aead, err := NewAEAD(algoName, make([]byte, keySize))
+	if err != nil {
+		return 0, err
+	}
+	return aead.NonceSize() + aead.Overhead(), nil
+}
+
+// Encrypt encrypts the plaintext using the given algorithm and key.
+// It returns the ciphertext (nonce + encrypted data) and any error encountered.
+// The nonce is random, it isn't stored in the nonce cache, which only keeps the nonces received by Decrypt.
+func Encrypt(algoName string, plaintext, key []byte) ([]byte, error) {
+	aead, err := NewAEAD(algoName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a random nonce (Number used ONCE) with the size required by the cipher
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// Encrypt and authenticate the plaintext
+	// The nonce is prepended to the ciphertext
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts the ciphertext (nonce + encrypted data) using the given algorithm and key.
+// It returns the plaintext and any error encountered.
+// The nonce is only stored after a successful decryption, so the same ciphertext
+// can be tried against several keys, but never accepted twice.
+func Decrypt(algoName string, ciphertext, key []byte) ([]byte, error) {
+	aead, err := NewAEAD(algoName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the ciphertext is long enough to contain a nonce
+	nonceSize := aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errCiphertextIsTooShortToDecryption
+	}
+
+	// Split the nonce and the encrypted data
+	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	// Check if the nonce has been used before, if used before replay attack is possible
+	if nonceCache.Exists(nonce) {
+		return nil, ErrDuplicatedNonceUsed
+	}
+
+	// Decrypt and verify the ciphertext
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the nonce, a concurrent decryption of the same ciphertext may have won the race
+	if err = nonceCache.Store(nonce); err != nil {
+		return nil, ErrDuplicatedNonceUsed
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,41 @@
+package aead
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecryptRejectsReplays(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	ciphertext, err := Encrypt("chacha20-poly1305", []byte("greeting"), key)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	// The sent nonces aren't stored, so the peer in the same process accepts the message
+	plaintext, err := Decrypt("chacha20-poly1305", ciphertext, key)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(plaintext, []byte("greeting")) {
+		t.Fatalf("plaintext = %q, want %q", plaintext, "greeting")
+	}
+	if _, err = Decrypt("chacha20-poly1305", ciphertext, key); !errors.Is(err, ErrDuplicatedNonceUsed) {
+		t.Fatalf("the replayed message got %v, want ErrDuplicatedNonceUsed", err)
+	}
+}
+
+func TestDecryptWrongKeyDoesNotStoreNonce(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	ciphertext, err := Encrypt("chacha20-poly1305", []byte("greeting"), key)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err = Decrypt("chacha20-poly1305", ciphertext, []byte("fedcba9876543210fedcba9876543210")); err == nil {
+		t.Fatal("decrypted with the wrong key")
+	}
+	// The message can still be tried against the right key
+	if _, err = Decrypt("chacha20-poly1305", ciphertext, key); err != nil {
+		t.Fatalf("Decrypt after a wrong key: %v", err)
+	}
+}
@@ -5,4 +5,7 @@ import "errors"
 var (
 	errCryptoAlgorithmUnsupported = errors.New("crypto.algorithm is not supported")
 	errAccountPasswordInvalid     = errors.New("account.password length is invalid, must sync to selected crypto algorithm key length")
+
+	errCiphertextIsTooShortToDecryption = errors.New("ciphertext is too short to decryption")
+	ErrDuplicatedNonceUsed              = errors.New("duplicate nonce used for AEAD decryption")
 )
@@ -1,14 +1,14 @@
 // Package aes_gcm provides encryption and decryption functions using AES-GCM.
+//
+// Deprecated: Use the aead package, which supports the other AEAD algorithms as well.
+// The functions of this package are thin wrappers of aead.Encrypt and aead.Decrypt,
+// picking the AES-GCM algorithm of the key's size, and share the aead package's nonce cache.
 package aes_gcm
 
 import (
-	"context"
 	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"time"
 
-	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/nonce_cache"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
 )
 
 // AES_GCM_NonceSize is the size of the nonce used in AES-GCM encryption.
@@ -19,105 +19,50 @@ const AES_GCM_NonceSize = 12
 // It is set to 16 bytes, which provides strong integrity protection.
 const AES_GCM_AuthTagSize = 16
 
-// NonceCache is a cache of nonces used in AES-GCM encryption to prevent nonce reuse.
-var nonceCache *nonce_cache.NonceCache
-
-func init() {
-	// nonceExpiryTime is the duration after which a nonce is considered expired.
-	nonceExpiryTime := time.Minute * 60
-	nonceCache = nonce_cache.NewNonceCache(nonceExpiryTime)
-
-	// cleanupInterval is the duration between nonce cleanup operations.
-	cleanupInterval := time.Minute * 20
-	// Start the cleanup routine in the background that periodically cleans up old nonces.
-	nonceCache.StartCleanupRoutine(context.Background(), cleanupInterval)
+// algorithmOf returns the aead package's AES-GCM algorithm of the key's size.
+func algorithmOf(key []byte) (string, error) {
+	switch len(key) {
+	case 16:
+		return "aes-128-gcm", nil
+	case 24:
+		return "aes-192-gcm", nil
+	case 32:
+		return "aes-256-gcm", nil
+	default:
+		return "", aes.KeySizeError(len(key))
+	}
 }
 
 // Encrypt_AES_GCM encrypts the plaintext using AES-GCM with the provided key.
 // It returns the ciphertext (nonce + encrypted data) and any error encountered.
+//
+// Deprecated: Use aead.Encrypt.
 func Encrypt_AES_GCM(plaintext []byte, key []byte) ([]byte, error) {
-	// Create a new AES cipher block using the provided key
-	aes, err := aes.NewCipher([]byte(key))
-	if err != nil {
-		return nil, err
-	}
-
-	// Wrap the cipher block in Galois Counter Mode
-	gcm, err := cipher.NewGCM(aes)
+	algorithm, err := algorithmOf(key)
 	if err != nil {
 		return nil, err
 	}
-
-	// Create a nonce (Number used ONCE) with the size required by GCM
-	nonce := make([]byte, gcm.NonceSize())
-	for {
-		// Generate a random nonce
-		if _, err := rand.Read(nonce); err != nil {
-			return nil, err
-		}
-
-		// Check if the nonce has been used before
-		if !nonceCache.Exists(nonce) {
-			// Store the new nonce
-			nonceCache.Store(nonce)
-			break
-		}
-		// If the nonce exists, the loop will continue and generate a new one
-	}
-
-	// Encrypt and authenticate the plaintext
-	// The nonce is prepended to the ciphertext
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-
-	return ciphertext, nil
+	return aead.Encrypt(algorithm, plaintext, key)
 }
 
 // Decrypt_AES_GCM decrypts the ciphertext using AES-GCM with the provided key.
 // It returns the plaintext and any error encountered.
+//
+// Deprecated: Use aead.Decrypt.
 func Decrypt_AES_GCM(ciphertext []byte, key []byte) ([]byte, error) {
-	// Create a new AES cipher block using the provided key
-	aes, err := aes.NewCipher([]byte(key))
+	algorithm, err := algorithmOf(key)
 	if err != nil {
 		return nil, err
 	}
-
-	// Wrap the cipher block in Galois Counter Mode
-	gcm, err := cipher.NewGCM(aes)
-	if err != nil {
-		return nil, err
-	}
-
-	// Ensure the ciphertext is long enough to contain a nonce
-	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, errCiphertextIsTooShortToDecrypttion
-	}
-
-	// Split the nonce and the encrypted data
-	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	// Check if the nonce has been used before, if used before replay attack is possible
-	if nonceCache.Exists(nonce) {
-		return nil, ErrDuplicatedNonceUsed
-	}
-	// Store the new nonce
-	nonceCache.Store(nonce)
-
-	// Decrypt and verify the ciphertext
-	plaintext, err := gcm.Open(nil, []byte(nonce), []byte(ciphertext), nil)
-	if err != nil {
-		return nil, err
-	}
-	return plaintext, nil
+	return aead.Decrypt(algorithm, ciphertext, key)
 }
 
 // IsAESPasswordSupported checks if the given password is suitable for AES encryption.
 // It returns true if the password length is 16, 24, or 32 bytes (128, 192, or 256 bits),
 // which are the supported key sizes for AES.
+//
+// Deprecated: Use aead.IsCryptoSupported.
 func IsAESPasswordSupported(password string) bool {
-	switch len(password) {
-	case 16, 24, 32:
-		return true
-	default:
-		return false
-	}
+	_, err := algorithmOf([]byte(password))
+	return err == nil
 }
@@ -0,0 +1,42 @@
+package aes_gcm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+	"testing"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
+)
+
+func TestEncryptDecryptWrapAEAD(t *testing.T) {
+	for _, key := range []string{"0123456789abcdef", "0123456789abcdef01234567", "0123456789abcdef0123456789abcdef"} {
+		if !IsAESPasswordSupported(key) {
+			t.Fatalf("the %d bytes key isn't supported", len(key))
+		}
+		ciphertext, err := Encrypt_AES_GCM([]byte("greeting"), []byte(key))
+		if err != nil {
+			t.Fatalf("Encrypt_AES_GCM of a %d bytes key: %v", len(key), err)
+		}
+		if len(ciphertext) != AES_GCM_NonceSize+len("greeting")+AES_GCM_AuthTagSize {
+			t.Fatalf("the ciphertext has %d bytes", len(ciphertext))
+		}
+		// The ciphertexts are the aead package's, and so is the replay detection
+		algorithm, _ := algorithmOf([]byte(key))
+		plaintext, err := aead.Decrypt(algorithm, ciphertext, []byte(key))
+		if err != nil || !bytes.Equal(plaintext, []byte("greeting")) {
+			t.Fatalf("aead.Decrypt of a %d bytes key = %q, %v", len(key), plaintext, err)
+		}
+		if _, err = Decrypt_AES_GCM(ciphertext, []byte(key)); !errors.Is(err, ErrDuplicatedNonceUsed) {
+			t.Fatalf("the replayed ciphertext got %v, want %v", err, ErrDuplicatedNonceUsed)
+		}
+	}
+
+	if IsAESPasswordSupported("short") {
+		t.Fatal("the 5 bytes key is supported")
+	}
+	var keySizeErr aes.KeySizeError
+	if _, err := Encrypt_AES_GCM([]byte("greeting"), []byte("short")); !errors.As(err, &keySizeErr) {
+		t.Fatalf("Encrypt_AES_GCM of a 5 bytes key: err = %v, want a key size error", err)
+	}
+}
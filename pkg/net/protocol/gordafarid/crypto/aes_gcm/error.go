@@ -1,8 +1,8 @@
 package aes_gcm
 
-import "errors"
+import "github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
 
 var (
-	errCiphertextIsTooShortToDecrypttion = errors.New("ciphertext is too short to decryption")
-	ErrDuplicatedNonceUsed               = errors.New("duplicate nonce used for AES/GCM decryption")
+	// Deprecated: Use aead.ErrDuplicatedNonceUsed, which is the same error.
+	ErrDuplicatedNonceUsed = aead.ErrDuplicatedNonceUsed
 )
@@ -4,21 +4,26 @@ import "errors"
 
 var (
 	// General errors
-	errHandshakeFailed = errors.New("the Gordafarid handshake failed: protocol mismatch or authentication error")
+	ErrHandshakeFailed       = errors.New("the Gordafarid handshake failed: protocol mismatch or authentication error") // Returned by the Dialer if the TCP connection is established, but the handshake fails
+	errHandshakeLimitReached = errors.New("the Gordafarid handshakes in flight limit is reached, the connection is closed")
+	errConnectionNotAdmitted = errors.New("the connection is not admitted, it's closed before its handshake")
+	errMissingDialConfig     = errors.New("the Gordafarid dialer needs an account config and a connection config")
 
 	// Initial greeting errors
-	errServerFailedToHandleInitialGreeting                 = errors.New("failed to send the Gordafarid initial greeting")
-	errServerFailedToSendGreetingFailedResponse            = errors.New("failed to send the Gordafarid initial greeting failed response")
-	errServerFailedToSendGreetingSuccessResponse           = errors.New("failed to send the Gordafarid initial greeting succeeded response")
-	errServerFailedToReadEncryptedInitialGreeting          = errors.New("failed to read the Gordafarid client's encrypted initial greeting")
-	errServerFailedToDecryptInitialGreeting                = errors.New("failed to decrypt the Gordafarid client's initial greeting")
-	errServerDuplicatedAESGCMNonceUsedPossibleReplayAttack = errors.New("duplicated nonce used for client's initial greeting, replay attack is possible")
-	errClientFailedToSendInitialGreeting                   = errors.New("failed to send the Gordafarid initial greeting")
-	errClientFailedToHandleInitialGreetingResponse         = errors.New("failed to handle the Gordafarid greeting response")
-	errClientFailedToEncryptInitialGreeting                = errors.New("failed to encrypt the Gordafarid initial greeting")
+	errServerFailedToHandleInitialGreeting               = errors.New("failed to send the Gordafarid initial greeting")
+	errServerFailedToSendGreetingFailedResponse          = errors.New("failed to send the Gordafarid initial greeting failed response")
+	errServerFailedToSendGreetingSuccessResponse         = errors.New("failed to send the Gordafarid initial greeting succeeded response")
+	errServerFailedToReadEncryptedInitialGreeting        = errors.New("failed to read the Gordafarid client's encrypted initial greeting")
+	errServerFailedToDecryptInitialGreeting              = errors.New("failed to decrypt the Gordafarid client's initial greeting")
+	errServerDuplicatedInitNonceUsedPossibleReplayAttack = errors.New("duplicated nonce used for client's initial greeting, replay attack is possible")
+	errServerNoValidInitKey                              = errors.New("no init key is valid at this time to decrypt the Gordafarid client's initial greeting")
+	errClientFailedToSendInitialGreeting                 = errors.New("failed to send the Gordafarid initial greeting")
+	errClientFailedToHandleInitialGreetingResponse       = errors.New("failed to handle the Gordafarid greeting response")
+	errClientFailedToEncryptInitialGreeting              = errors.New("failed to encrypt the Gordafarid initial greeting")
 
 	// Crypto errors
 	errFailedToBuildAEADCipher = errors.New("failed to build the Gordafarid AEAD cipher")
+	errFailedToBuildInitCipher = errors.New("failed to build the Gordafarid initial greeting cipher")
 
 	// Request errors
 	errServerFailedToHandleRequest = errors.New("failed to handle the Gordafarid request")
@@ -26,6 +31,7 @@ var (
 
 	// Reply errors
 	errServerFailedToSendReplyResponse   = errors.New("failed to send the Gordafarid reply response")
+	errServerReplyOnClientConn           = errors.New("the Gordafarid reply can only be sent by the server")
 	errClientFailedToHandleReplyResponse = errors.New("failed to handle the Gordafarid reply response")
 
 	// Address type error
@@ -42,12 +48,43 @@ var (
 	// Greeting errors
 	errGreetingFailed = errors.New("the Gordafarid greeting failed")
 
+	// Session ID errors
+	errUnableToReadSessionID          = errors.New("unable to read the Gordafarid client's session ID")
+	errServerFailedToDecryptSessionID = errors.New("failed to decrypt the Gordafarid client's session ID")
+
 	// Cmd errors
 	errUnableToReadCmd = errors.New("unable to read the Gordafarid cmd")
 	errUnsupportedCmd  = errors.New("unsupported Gordafarid cmd")
 
 	// Authentication errors
-	errAuthFailed = errors.New("the Gordafarid authentication failed")
+	errAuthFailed                 = errors.New("the Gordafarid authentication failed")
+	errCredentialStoreUnavailable = errors.New("the Gordafarid credential store is unavailable, the account can't be looked up")
+
+	// Account lifecycle errors
+	errAccountDisabled            = errors.New("the Gordafarid account is disabled")
+	errAccountNotYetValid         = errors.New("the Gordafarid account is not valid yet")
+	errAccountExpired             = errors.New("the Gordafarid account is expired")
+	errAccountOutsideAllowedHours = errors.New("the Gordafarid account is outside its allowed hours")
+	errInvalidAccessWindow        = errors.New("invalid Gordafarid account allowed hours, the format is HH:MM-HH:MM")
+
+	// Credential store errors
+	ErrCredentialNotFound         = errors.New("the Gordafarid credential is not found")
+	errCredentialHasNoKeyMaterial = errors.New("the Gordafarid credential has neither password nor key")
+	errCredentialInvalidKey       = errors.New("the Gordafarid credential key is not valid base64")
+	errCredentialInvalidKeyID     = errors.New("the Gordafarid credential keyId is not valid base64 of 32 bytes")
+	errCredentialKeyIDMismatch    = errors.New("the Gordafarid credential keyId doesn't match its username and key, the clients can't send it")
+	errKeyDerivationEmptySalt     = errors.New("the Gordafarid key derivation salt is empty")
+	errFailedToLoadCredentialFile = errors.New("failed to load the Gordafarid credential file")
+
+	// External authorizer errors
+	errAuthorizerFailed           = errors.New("the Gordafarid external authorizer failed")
+	errAuthorizerEmptyCommand     = errors.New("the Gordafarid external authorizer command is empty")
+	errAuthorizerInvalidResponse  = errors.New("invalid Gordafarid external authorizer response")
+	errAuthorizerResponseTooLarge = errors.New("the Gordafarid external authorizer response is too large")
 
-	errReplyFailed = errors.New("the reply response from the server indicates failure")
+	// Reply status errors, returned by the client-side handshake according to the server's reply status
+	ErrReplyFailed        = errors.New("the reply response from the server indicates failure")
+	ErrReplyNotAllowed    = errors.New("the reply response from the server indicates the destination is not allowed")
+	ErrReplyQuotaExceeded = errors.New("the reply response from the server indicates the account's quota is exceeded")
+	ErrReplyLimitExceeded = errors.New("the reply response from the server indicates a connection limit is reached")
 )
@@ -0,0 +1,226 @@
+package gordafarid
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
+)
+
+/*
+External authorizers:
+
+The server delegates the account lookup to an external authorizer, which is either a local executable or an HTTP endpoint.
+It is called with the base64-encoded account hash (key ID) the client sent in its initial greeting,
+and responds with the account's key material and policy attributes as JSON:
+
+	{
+		"username": "alice",
+		"password": "...",             (either the plaintext password)
+		"key": "...",                  (or the base64-encoded key material)
+		"attributes": {"plan": "gold"} (OPTIONAL)
+		"expiresAt": "2025-01-01T00:00:00Z", "notBefore": "...", "disabled": false,
+		"allowedHours": ["09:00-18:00"], "timezone": "Europe/Berlin" (OPTIONAL)
+	}
+
+Executable: it's run with the key ID as its last argument (also set in the GORDAFARID_KEY_ID environment variable).
+Exit status 0 with the JSON on stdout means found, exit status 1 means not found, anything else is an error.
+
+HTTP endpoint: a GET request is sent with the key ID in the "keyId" query parameter,
+and the "Authorization: Bearer <token>" header if a token is configured.
+Status 200 with the JSON body means found, status 404 means not found, anything else is an error.
+
+Since every handshake would call the authorizer, wrap these stores with a CachedCredentialStore.
+*/
+
+// authorizerNotFoundExitCode is the exit status of an executable authorizer that didn't find the account.
+const authorizerNotFoundExitCode = 1
+
+// authorizerMaxResponseSize is the maximum size of an authorizer response in bytes.
+const authorizerMaxResponseSize = 64 * 1024
+
+// authorizerResponse is the JSON response of an external authorizer.
+type authorizerResponse struct {
+	Username   string            `json:"username"`
+	Password   string            `json:"password"`
+	Key        string            `json:"key"`
+	Attributes map[string]string `json:"attributes"`
+	lifecycleRecord
+}
+
+// parseAuthorizerResponse decodes and validates an external authorizer response for the given account hash.
+func parseAuthorizerResponse(data []byte, hash Hash, cryptoAlgorithm string) (Credential, error) {
+	var response authorizerResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return Credential{}, errors.Join(errAuthorizerInvalidResponse, err)
+	}
+	if len(response.Username) < 1 {
+		return Credential{}, errors.Join(errAuthorizerInvalidResponse, fmt.Errorf("empty username"))
+	}
+
+	// The key is looked up by the hash, so it must be the key ID of the returned key material
+	var keyID string
+	if len(response.Key) > 0 {
+		keyID = base64.StdEncoding.EncodeToString(hash[:])
+	}
+	credential, err := ParseCredential(response.Username, response.Password, keyID, response.Key)
+	if err != nil {
+		return Credential{}, errors.Join(errAuthorizerInvalidResponse, err)
+	}
+	// A plaintext password must belong to the requested account
+	if credential.hash() != hash {
+		return Credential{}, errors.Join(errAuthorizerInvalidResponse, fmt.Errorf("the returned account doesn't match the requested key ID"))
+	}
+	if err = aead.IsCryptoSupported(cryptoAlgorithm, string(credential.key())); err != nil {
+		return Credential{}, errors.Join(errAuthorizerInvalidResponse, err)
+	}
+	credential.Attributes = response.Attributes
+	if err = response.lifecycleRecord.apply(&credential); err != nil {
+		return Credential{}, errors.Join(errAuthorizerInvalidResponse, err)
+	}
+	return credential, nil
+}
+
+// ExecCredentialStore is a CredentialStore that delegates the account lookup to a local executable.
+type ExecCredentialStore struct {
+	command         []string // The executable and its arguments, the key ID is appended
+	cryptoAlgorithm string   // Used to validate the returned key material
+}
+
+// NewExecCredentialStore creates a new ExecCredentialStore running the given command.
+// The command is the path of the executable followed by its arguments.
+func NewExecCredentialStore(command []string, cryptoAlgorithm string) *ExecCredentialStore {
+	return &ExecCredentialStore{
+		command:         command,
+		cryptoAlgorithm: cryptoAlgorithm,
+	}
+}
+
+// Lookup runs the executable for the given account hash, and parses its output.
+// The context controls the lifetime of the executable.
+func (s *ExecCredentialStore) Lookup(ctx context.Context, hash Hash) (Credential, error) {
+	if len(s.command) < 1 {
+		return Credential{}, errAuthorizerEmptyCommand
+	}
+	keyID := base64.StdEncoding.EncodeToString(hash[:])
+
+	// The executable is killed once its response exceeds the maximum size
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	args := append(append([]string{}, s.command[1:]...), keyID)
+	cmd := exec.CommandContext(ctx, s.command[0], args...)
+	cmd.Env = append(os.Environ(), "GORDAFARID_KEY_ID="+keyID)
+	stdout := &limitedBuffer{limit: authorizerMaxResponseSize, onExceeded: cancel}
+	cmd.Stdout = stdout
+
+	err := cmd.Run()
+	if stdout.exceeded {
+		return Credential{}, errors.Join(errAuthorizerInvalidResponse, fmt.Errorf("the response is larger than %d bytes", authorizerMaxResponseSize))
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == authorizerNotFoundExitCode {
+			return Credential{}, ErrCredentialNotFound
+		}
+		return Credential{}, errors.Join(errAuthorizerFailed, err)
+	}
+	return parseAuthorizerResponse(stdout.Bytes(), hash, s.cryptoAlgorithm)
+}
+
+// HTTPCredentialStore is a CredentialStore that delegates the account lookup to an HTTP endpoint.
+type HTTPCredentialStore struct {
+	endpoint        string       // The endpoint URL, the key ID is added as the "keyId" query parameter
+	token           string       // Bearer token sent in the Authorization header (OPTIONAL)
+	cryptoAlgorithm string       // Used to validate the returned key material
+	client          *http.Client // The HTTP client used for the requests
+}
+
+// NewHTTPCredentialStore creates a new HTTPCredentialStore calling the given endpoint.
+// If client is nil, http.DefaultClient is used; the request lifetime is controlled by the Lookup context.
+func NewHTTPCredentialStore(endpoint, token, cryptoAlgorithm string, client *http.Client) *HTTPCredentialStore {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPCredentialStore{
+		endpoint:        endpoint,
+		token:           token,
+		cryptoAlgorithm: cryptoAlgorithm,
+		client:          client,
+	}
+}
+
+// Lookup calls the HTTP endpoint for the given account hash, and parses its response.
+func (s *HTTPCredentialStore) Lookup(ctx context.Context, hash Hash) (Credential, error) {
+	endpoint, err := url.Parse(s.endpoint)
+	if err != nil {
+		return Credential{}, errors.Join(errAuthorizerFailed, err)
+	}
+	query := endpoint.Query()
+	query.Set("keyId", base64.StdEncoding.EncodeToString(hash[:]))
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return Credential{}, errors.Join(errAuthorizerFailed, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(s.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return Credential{}, errors.Join(errAuthorizerFailed, err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Credential{}, ErrCredentialNotFound
+	default:
+		return Credential{}, errors.Join(errAuthorizerFailed, fmt.Errorf("unexpected status: %s", res.Status))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, authorizerMaxResponseSize))
+	if err != nil {
+		return Credential{}, errors.Join(errAuthorizerFailed, err)
+	}
+	return parseAuthorizerResponse(data, hash, s.cryptoAlgorithm)
+}
+
+// limitedBuffer is a buffer refusing the writes past its limit.
+// It's only written by the goroutine copying the executable's stdout, and read once the executable exits.
+// The bytes.Buffer isn't embedded, so io.Copy can't bypass the limit through its ReadFrom.
+type limitedBuffer struct {
+	buffer     bytes.Buffer
+	limit      int    // The maximum number of bytes kept
+	exceeded   bool   // The writes went past the limit
+	onExceeded func() // Called once the limit is exceeded, e.g. to kill the writer
+}
+
+// Write appends p to the buffer, or fails without keeping any of it once the buffer would go past its limit.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.exceeded || b.buffer.Len()+len(p) > b.limit {
+		if !b.exceeded {
+			b.exceeded = true
+			b.onExceeded()
+		}
+		return 0, errAuthorizerResponseTooLarge
+	}
+	return b.buffer.Write(p)
+}
+
+// Bytes returns the buffered bytes.
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buffer.Bytes()
+}
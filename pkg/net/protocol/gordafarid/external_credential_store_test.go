@@ -0,0 +1,70 @@
+package gordafarid
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// shellCommand returns the command running the script with sh, the key ID is passed as $1.
+func shellCommand(t *testing.T, script string) []string {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh isn't available")
+	}
+	return []string{sh, "-c", script, "authorizer"}
+}
+
+func TestExecCredentialStoreLookup(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 32)
+	hash := DeriveKeyID("alice", string(key))
+	store := NewExecCredentialStore(shellCommand(t, `echo '{"username": "alice", "key": "`+base64.StdEncoding.EncodeToString(key)+`"}'`), "chacha20-poly1305")
+
+	credential, err := store.Lookup(context.Background(), hash)
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if credential.Username != "alice" || !bytes.Equal(credential.key(), key) {
+		t.Fatalf("Lookup returned %q with key %x", credential.Username, credential.key())
+	}
+
+	store = NewExecCredentialStore(shellCommand(t, `exit 1`), "chacha20-poly1305")
+	if _, err = store.Lookup(context.Background(), hash); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("Lookup of an unknown account: err = %v, want %v", err, ErrCredentialNotFound)
+	}
+}
+
+func TestExecCredentialStoreResponseLimit(t *testing.T) {
+	// The authorizer writes forever, it must be killed once the response is too large
+	store := NewExecCredentialStore(shellCommand(t, `while :; do echo aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa; done`), "chacha20-poly1305")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := store.Lookup(ctx, Hash{})
+	if !errors.Is(err, errAuthorizerInvalidResponse) {
+		t.Fatalf("err = %v, want %v", err, errAuthorizerInvalidResponse)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the authorizer wasn't killed once its response exceeded the limit")
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	exceeded := 0
+	b := &limitedBuffer{limit: 4, onExceeded: func() { exceeded++ }}
+	if n, err := b.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if _, err := b.Write([]byte("de")); !errors.Is(err, errAuthorizerResponseTooLarge) {
+		t.Fatalf("Write past the limit: err = %v", err)
+	}
+	b.Write([]byte("f"))
+	if string(b.Bytes()) != "abc" || !b.exceeded || exceeded != 1 {
+		t.Fatalf("buffer = %q, exceeded = %v, onExceeded called %d times", b.Bytes(), b.exceeded, exceeded)
+	}
+}
@@ -0,0 +1,166 @@
+package gordafarid
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
+)
+
+// credentialRecord is an account entry of a user file.
+type credentialRecord struct {
+	Username   string            `toml:"username" json:"username"`
+	Password   string            `toml:"password" json:"password"`
+	KeyID      string            `toml:"keyId" json:"keyId"`           // Base64-encoded account hash (OPTIONAL)
+	Key        string            `toml:"key" json:"key"`               // Base64-encoded key material, an alternative to the password
+	Attributes map[string]string `toml:"attributes" json:"attributes"` // Policy attributes of the account (OPTIONAL)
+	lifecycleRecord
+}
+
+// credentialFile is the content of a user file.
+type credentialFile struct {
+	Credentials []credentialRecord `toml:"credentials" json:"credentials"`
+}
+
+// FileCredentialStore is a CredentialStore backed by a TOML or JSON user file.
+// The format is chosen by the file extension (".json" for JSON, TOML otherwise), and the file looks like:
+//
+//	credentials = [
+//	    { username = "alice", password = "..." },
+//	    { username = "bob", keyId = "...", key = "...", attributes = { policy = "restricted" } },
+//	    { username = "carol", password = "...", expiresAt = 2025-01-01T00:00:00Z, allowedHours = ["09:00-18:00"] },
+//	]
+//
+// The file can be watched for changes, so accounts can be added or revoked without restarting the server.
+type FileCredentialStore struct {
+	path            string
+	cryptoAlgorithm string
+	store           *MemoryCredentialStore
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// NewFileCredentialStore creates a new FileCredentialStore and loads the given user file.
+// The cryptoAlgorithm is used to validate the key material of the accounts.
+func NewFileCredentialStore(path, cryptoAlgorithm string) (*FileCredentialStore, error) {
+	s := &FileCredentialStore{
+		path:            path,
+		cryptoAlgorithm: cryptoAlgorithm,
+		store:           NewMemoryCredentialStore(),
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Lookup returns the credential whose account hash matches the given hash.
+func (s *FileCredentialStore) Lookup(ctx context.Context, hash Hash) (Credential, error) {
+	return s.store.Lookup(ctx, hash)
+}
+
+// Reload reads the user file and replaces the credentials of the store.
+// If the file is invalid, the previously loaded credentials are kept.
+func (s *FileCredentialStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return errors.Join(errFailedToLoadCredentialFile, err)
+	}
+	// Remember the file version even if it's invalid, so a broken file is reported once per change
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+
+	credentials, err := s.load()
+	if err != nil {
+		return errors.Join(errFailedToLoadCredentialFile, fmt.Errorf("file: %s", s.path), err)
+	}
+	s.store.Replace(credentials)
+	return nil
+}
+
+// load reads, decodes and validates the user file.
+func (s *FileCredentialStore) load() ([]Credential, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file credentialFile
+	if strings.EqualFold(filepath.Ext(s.path), ".json") {
+		err = json.Unmarshal(data, &file)
+	} else {
+		err = toml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]Credential, 0, len(file.Credentials))
+	for i, record := range file.Credentials {
+		if len(record.Username) < 1 {
+			return nil, fmt.Errorf("element at index %d has empty username", i)
+		}
+		credential, err := ParseCredential(record.Username, record.Password, record.KeyID, record.Key)
+		if err != nil {
+			return nil, fmt.Errorf("element at index %d is invalid: %w", i, err)
+		}
+		if err = aead.IsCryptoSupported(s.cryptoAlgorithm, string(credential.key())); err != nil {
+			return nil, fmt.Errorf("element at index %d is invalid: %w", i, err)
+		}
+		credential.Attributes = record.Attributes
+		if err = record.lifecycleRecord.apply(&credential); err != nil {
+			return nil, fmt.Errorf("element at index %d is invalid: %w", i, err)
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, nil
+}
+
+// isModified reports whether the user file has changed since it was last loaded.
+func (s *FileCredentialStore) isModified() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !info.ModTime().Equal(s.modTime) || info.Size() != s.size, nil
+}
+
+// StartWatchRoutine starts a background routine that checks the user file for changes every checkInterval,
+// and reloads it when it has changed. Reload errors are passed to onError (if not nil),
+// and the previously loaded credentials are kept. The routine stops when the context is cancelled.
+func (s *FileCredentialStore) StartWatchRoutine(ctx context.Context, checkInterval time.Duration, onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				modified, err := s.isModified()
+				if err == nil && modified {
+					err = s.Reload()
+				}
+				if err != nil && onError != nil {
+					onError(err)
+				}
+			case <-ctx.Done():
+				// Stop the goroutine when the context is cancelled
+				return
+			}
+		}
+	}()
+}
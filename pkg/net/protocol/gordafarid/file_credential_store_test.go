@@ -0,0 +1,104 @@
+package gordafarid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeUsersFile writes a TOML user file of the accounts, all with testPassword.
+func writeUsersFile(t *testing.T, path string, usernames ...string) {
+	t.Helper()
+	content := "credentials = [\n"
+	for _, username := range usernames {
+		content += fmt.Sprintf("    { username = %q, password = %q },\n", username, testPassword)
+	}
+	content += "]\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+// waitFor polls the condition until it's true, failing the test after 2 seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileCredentialStoreHotReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.toml")
+	writeUsersFile(t, path, "alice")
+	store, err := NewFileCredentialStore(path, testAlgorithm)
+	if err != nil {
+		t.Fatalf("NewFileCredentialStore: %v", err)
+	}
+	var mu sync.Mutex
+	var reloadErrs []error
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.StartWatchRoutine(ctx, 10*time.Millisecond, func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reloadErrs = append(reloadErrs, err)
+	})
+
+	l := listenTest(t, NewServerConfig(nil, testAlgorithm, testAlgorithm, testInitPassword, 2).AddCredentialStores(store))
+	// authenticates reports whether the account authenticates through the listener
+	authenticates := func(username string) bool {
+		t.Helper()
+		accept := acceptNext(t, l)
+		c := dialTestClient(t, l)
+		if status := c.sendGreeting(testAlgorithm, testInitPassword, testGreeting(gordafaridVersion, username, testPassword), testPassword); status == greetingSuccess {
+			c.sendRequest(testPassword)
+		}
+		_, err := accept()
+		return err == nil
+	}
+	lookup := func(username string) error {
+		_, err := store.Lookup(context.Background(), DeriveKeyID(username, testPassword))
+		return err
+	}
+	if !authenticates("alice") || authenticates("bob") {
+		t.Fatal("the accounts of the loaded file aren't the ones authenticating")
+	}
+
+	// bob and carol replace alice in the edited file
+	writeUsersFile(t, path, "bob", "carol")
+	waitFor(t, "the edited file to be reloaded", func() bool { return lookup("bob") == nil })
+	if err := lookup("alice"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("the removed account's lookup returned %v, want %v", err, ErrCredentialNotFound)
+	}
+	if !authenticates("bob") || authenticates("alice") {
+		t.Fatal("the accounts of the edited file aren't the ones authenticating")
+	}
+
+	// A broken file is reported, and the previously loaded accounts are kept
+	if err := os.WriteFile(path, []byte("credentials = [ { username = "), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	waitFor(t, "the broken file to be reported", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reloadErrs) > 0
+	})
+	mu.Lock()
+	reloadErr := reloadErrs[0]
+	mu.Unlock()
+	if !errors.Is(reloadErr, errFailedToLoadCredentialFile) {
+		t.Fatalf("the broken file was reported with %v, want %v", reloadErr, errFailedToLoadCredentialFile)
+	}
+	if err := lookup("bob"); err != nil {
+		t.Fatalf("the accounts were dropped along with the broken file: %v", err)
+	}
+}
@@ -5,27 +5,128 @@ import (
 	"context"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"net"
+	"os"
 	"time"
 
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/utils"
+	"golang.org/x/crypto/scrypt"
 )
 
 // Hash represents a SHA-256 hash value.
 type Hash [HashSize]byte
 
-const InitPasswordSize = 32
-
 // Listener wraps a net.Listener with Gordafarid-specific functionality.
+// The handshakes of the accepted connections are performed concurrently, so a slow client doesn't block the others.
+// It satisfies net.Listener (e.g. for http.Serve), its Accept only returns the handshaked connections.
+// Its AcceptConn returns the errors of the failed handshakes as *HandshakeError.
 type Listener struct {
-	net.Listener
+	*utils.HandshakeListener[*Conn]
 	config *Config
 }
 
-// Credential represents a username and password pair for authentication.
+// HandshakeError is returned by Listener.AcceptConn when the handshake of an accepted connection fails.
+// It carries the client's address, so the caller can count the failures per client (e.g. to ban probing clients).
+type HandshakeError struct {
+	RemoteAddr net.Addr   // Address of the client
+	SessionID  session.ID // ID of the connection, generated when it was accepted
+	Err        error      // The handshake error
+}
+
+// Error implements the error interface.
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("the Gordafarid handshake with %s (session %s) failed: %v", e.RemoteAddr, e.SessionID, e.Err)
+}
+
+// Unwrap returns the handshake error.
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// IsReplayAttack reports whether the client sent an initial greeting nonce that was already used,
+// i.e. a recorded greeting was replayed, which is how the active probes identify the protocol.
+func (e *HandshakeError) IsReplayAttack() bool {
+	return errors.Is(e.Err, errServerDuplicatedInitNonceUsedPossibleReplayAttack)
+}
+
+// IsAccountRejected reports whether the client authenticated successfully, but its account
+// can't authenticate at this time (disabled, expired or outside its allowed hours).
+// Unlike the other failures, these don't indicate a probing or misbehaving client.
+func (e *HandshakeError) IsAccountRejected() bool {
+	return errors.Is(e.Err, errAccountDisabled) ||
+		errors.Is(e.Err, errAccountNotYetValid) ||
+		errors.Is(e.Err, errAccountExpired) ||
+		errors.Is(e.Err, errAccountOutsideAllowedHours)
+}
+
+// IsCredentialStoreUnavailable reports whether the account couldn't be looked up, as its credential store failed
+// (e.g. the external authorizer timed out, exited with an error or responded with a server error).
+// Unlike the other failures, these don't indicate a probing or misbehaving client.
+func (e *HandshakeError) IsCredentialStoreUnavailable() bool {
+	return errors.Is(e.Err, errCredentialStoreUnavailable)
+}
+
+// Reason returns the reason of the handshake failure, see HandshakeFailureReason.
+func (e *HandshakeError) Reason() string {
+	return HandshakeFailureReason(e.Err)
+}
+
+// Handshake failure reasons, returned by HandshakeFailureReason, e.g. to label the failure counters.
+const (
+	HandshakeFailureReplay          = "replay"            // The initial greeting was replayed
+	HandshakeFailureAccountRejected = "account_rejected"  // The account is disabled, expired or outside its allowed hours
+	HandshakeFailureVersion         = "version"           // The peer speaks an unsupported protocol version
+	HandshakeFailureAuth            = "auth_failed"       // The init key, the account or its key is wrong
+	HandshakeFailureStore           = "store_unavailable" // The credential store failed to look up the account
+	HandshakeFailureTimeout         = "timeout"           // The handshake didn't finish in time
+	HandshakeFailureLimit           = "limit_reached"     // A limit (e.g. the handshakes in flight) was reached, the connection was rejected before its handshake
+	HandshakeFailureOther           = "other"
+)
+
+// HandshakeFailureReason classifies a server-side or client-side handshake error into one of the HandshakeFailure* reasons.
+func HandshakeFailureReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errServerDuplicatedInitNonceUsedPossibleReplayAttack):
+		return HandshakeFailureReplay
+	case errors.Is(err, errAccountDisabled), errors.Is(err, errAccountNotYetValid),
+		errors.Is(err, errAccountExpired), errors.Is(err, errAccountOutsideAllowedHours):
+		return HandshakeFailureAccountRejected
+	case errors.Is(err, errCredentialStoreUnavailable):
+		return HandshakeFailureStore
+	case errors.Is(err, errUnsupportedVersion):
+		return HandshakeFailureVersion
+	case errors.Is(err, errHandshakeLimitReached), errors.Is(err, errConnectionNotAdmitted):
+		return HandshakeFailureLimit
+	case errors.Is(err, errAuthFailed), errors.Is(err, errGreetingFailed), errors.Is(err, errInvalidAccountHash),
+		errors.Is(err, errServerFailedToDecryptInitialGreeting), errors.Is(err, errServerNoValidInitKey):
+		return HandshakeFailureAuth
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return HandshakeFailureTimeout
+	default:
+		return HandshakeFailureOther
+	}
+}
+
+// Credential represents an account used for authentication.
+// It is either a username and password pair, or a username with pre-derived key material
+// (KeyID and Key), so the plaintext password doesn't have to be kept around.
 type Credential struct {
-	Username string
-	Password string
+	Username   string
+	Password   string
+	KeyID      Hash              // Pre-derived account hash, only used when Key is set
+	Key        []byte            // Pre-derived key material for the AEAD cipher, takes precedence over Password
+	Attributes map[string]string // Policy attributes of the account, e.g. returned by an external authorizer (OPTIONAL)
+
+	// Lifecycle of the account, checked during the authentication
+	NotBefore    time.Time      // The account can't authenticate before this time (OPTIONAL)
+	ExpiresAt    time.Time      // The account can't authenticate after this time (OPTIONAL)
+	Disabled     bool           // The account can't authenticate (OPTIONAL)
+	AllowedHours []AccessWindow // Daily windows the account can authenticate in (OPTIONAL, any time if empty)
 }
 
 // NewCredential creates a new Credential instance with the given username and password.
@@ -36,73 +137,164 @@ func NewCredential(username, password string) Credential {
 	}
 }
 
+// NewDerivedCredential creates a new Credential instance from pre-derived key material.
+// The keyID is the account hash the client sends in its initial greeting, see DeriveKeyID.
+func NewDerivedCredential(username string, keyID Hash, key []byte) Credential {
+	return Credential{
+		Username: username,
+		KeyID:    keyID,
+		Key:      key,
+	}
+}
+
+// DeriveKeyID returns the account hash of the given username and password (or key material).
+// This is the value the client sends in its initial greeting to identify the account.
+func DeriveKeyID(username, password string) Hash {
+	return sha256.Sum256([]byte(username + password))
+}
+
+// KeySaltSize is the size in bytes of the random salts generated for DeriveKey.
+const KeySaltSize = 16
+
+// The scrypt cost parameters of DeriveKey, the recommended interactive-login settings.
+const (
+	keyDerivationN = 1 << 15
+	keyDerivationR = 8
+	keyDerivationP = 1
+)
+
+// DeriveKey derives the key material of the given size (the key size of the crypto algorithm) from a passphrase
+// and its salt with scrypt, so neither the server's credentials nor the client's key give the passphrase away.
+// The salt should be KeySaltSize random bytes, generated once per account, e.g. by the keygen command.
+func DeriveKey(passphrase string, salt []byte, keySize int) ([]byte, error) {
+	if len(salt) < 1 {
+		return nil, errKeyDerivationEmptySalt
+	}
+	return scrypt.Key([]byte(passphrase), salt, keyDerivationN, keyDerivationR, keyDerivationP, keySize)
+}
+
+// hash returns the account hash used to identify the credential.
+func (c Credential) hash() Hash {
+	if c.Key != nil {
+		return c.KeyID
+	}
+	return DeriveKeyID(c.Username, c.Password)
+}
+
+// key returns the key material used to build the AEAD cipher.
+func (c Credential) key() []byte {
+	if c.Key != nil {
+		return c.Key
+	}
+	return []byte(c.Password)
+}
+
 // ServerConfig holds the configuration options for a Gordafarid server.
 type ServerConfig struct {
-	Credentials         []Credential // Server-side credentials for authentication
-	EncryptionAlgorithm string       // Encryption algorithm to be used
-	InitPassword        string       // Initial password for decrypting the client's initial greeting
-	HandshakeTimeout    int          // Server handshake timeout in seconds
+	Credentials         []Credential      // Server-side credentials for authentication through the InitPassword
+	CredentialStores    []CredentialStore // Additional server-side account stores for the InitPassword, queried in order after Credentials
+	EncryptionAlgorithm string            // Encryption algorithm to be used
+	InitAlgorithm       string            // AEAD algorithm used for the client's initial greeting
+	InitPassword        string            // Initial password for decrypting the client's initial greeting (OPTIONAL if InitKeys is set)
+	InitKeys            []InitKey         // Additional init keys, tried in order after the InitPassword
+	HandshakeTimeout    int               // Server handshake timeout in seconds
+	MaxHandshakes       int               // Maximum handshakes in flight, the excess connections are closed (OPTIONAL, 0 means unlimited)
+
+	// Admit is called with each accepted connection before its handshake, e.g. to enforce a per-client IP limit (OPTIONAL).
+	// It returns the connection to handshake (e.g. wrapped to count it until it's closed), or an error to reject the connection
+	// with the greeting failure reply. It's called from the accept loop, so it mustn't block.
+	Admit func(c net.Conn) (net.Conn, error)
 }
 
 // NewServerConfig creates a new ServerConfig instance with the provided parameters.
-func NewServerConfig(credentials []Credential, encryptionAlgorithm, initPassword string, handshakeTimeout int) *ServerConfig {
+func NewServerConfig(credentials []Credential, encryptionAlgorithm, initAlgorithm, initPassword string, handshakeTimeout int) *ServerConfig {
 	return &ServerConfig{
 		Credentials:         credentials,
 		EncryptionAlgorithm: encryptionAlgorithm,
+		InitAlgorithm:       initAlgorithm,
 		InitPassword:        initPassword,
 		HandshakeTimeout:    handshakeTimeout,
 	}
 }
 
+// AddCredentialStores appends the given account stores of the InitPassword to the ServerConfig, and returns it for chaining.
+func (scc *ServerConfig) AddCredentialStores(credentialStores ...CredentialStore) *ServerConfig {
+	scc.CredentialStores = append(scc.CredentialStores, credentialStores...)
+	return scc
+}
+
+// AddInitKeys appends the given init keys to the ServerConfig, and returns it for chaining.
+func (scc *ServerConfig) AddInitKeys(initKeys ...InitKey) *ServerConfig {
+	scc.InitKeys = append(scc.InitKeys, initKeys...)
+	return scc
+}
+
 // convertToRealConfig transforms the ServerConfig into an internal serverConfig structure.
 func (scc *ServerConfig) convertToRealConfig() *Config {
 	var realConfig Config
-	realConfig.serverCredentials = make(serverCredentials, len(scc.Credentials))
 
-	for _, item := range scc.Credentials {
-		hash := sha256.Sum256([]byte(item.Username + item.Password))
-		realConfig.serverCredentials[hash] = []byte(item.Password)
+	// The InitPassword (if set) is the first init key, followed by the additional init keys in order
+	if len(scc.InitPassword) > 0 {
+		defaultInitKey := NewInitKey(DefaultInitKeyID, scc.InitAlgorithm, scc.InitPassword, time.Time{}, time.Time{}, scc.Credentials, scc.CredentialStores...)
+		realConfig.initKeys = append(realConfig.initKeys, defaultInitKey.convertToRealInitKey())
+	}
+	for _, item := range scc.InitKeys {
+		realConfig.initKeys = append(realConfig.initKeys, item.convertToRealInitKey())
 	}
 	realConfig.encryptionAlgorithm = scc.EncryptionAlgorithm
-	copy(realConfig.initPassword[:], []byte(scc.InitPassword))
 	realConfig.handshakeTimeout = scc.HandshakeTimeout
+	realConfig.maxHandshakes = scc.MaxHandshakes
+	realConfig.admit = scc.Admit
 	return &realConfig
 }
 
-// serverCredentials is a map of hashed credentials to passwords.
-type serverCredentials map[Hash][]byte
-
 // Config holds the internal connection's configuration.
 type Config struct {
-	serverCredentials   serverCredentials
+	initKeys            []*initKey // Server-side init keys, tried in order for decrypting the client's initial greeting
 	encryptionAlgorithm string
-	initPassword        [InitPasswordSize]byte // Initial password for decrypting the client's initial greeting
-	handshakeTimeout    int                    // Server handshake timeout in seconds
+	initAlgorithm       string                             // Client-side AEAD algorithm used for the initial greeting
+	initPassword        []byte                             // Client-side initial password for encrypting the client's initial greeting
+	handshakeTimeout    int                                // Server handshake timeout in seconds
+	maxHandshakes       int                                // Server maximum handshakes in flight, 0 means unlimited
+	admit               func(c net.Conn) (net.Conn, error) // Server admission of the accepted connections, nil means they're all admitted
 }
 
 // NewListener creates a new Gordafarid Listener wrapping the provided net.Listener.
+// Once the handshakes in flight reach the config's MaxHandshakes, or the config's Admit rejects a connection,
+// the new connections get the greeting failure reply and are closed.
 func NewListener(underlyingListener net.Listener, config *ServerConfig) *Listener {
-	return &Listener{
-		Listener: underlyingListener,
-		config:   config.convertToRealConfig(),
+	l := &Listener{
+		config: config.convertToRealConfig(),
 	}
+	l.HandshakeListener = utils.NewHandshakeListener(underlyingListener, l.config.maxHandshakes, l.handshake,
+		[]byte{gordafaridVersion, greetingFailed}, errHandshakeLimitReached)
+	if l.config.admit != nil {
+		l.HandshakeListener.SetAdmission(l.admit)
+	}
+	return l
 }
 
-// Accept waits for and returns the next connection to the listener.
-func (l *Listener) Accept() (*Conn, error) {
-	c, err := l.Listener.Accept()
+// admit admits the accepted connection by the config's Admit, its rejections are reported as errConnectionNotAdmitted.
+func (l *Listener) admit(c net.Conn) (net.Conn, error) {
+	admitted, err := l.config.admit(c)
 	if err != nil {
-		return nil, err
+		return nil, errors.Join(errConnectionNotAdmitted, err)
 	}
+	return admitted, nil
+}
 
+// handshake performs the handshake of the accepted connection with the handshake timeout.
+// The connection is closed if the handshake fails.
+func (l *Listener) handshake(c net.Conn) (*Conn, error) {
 	gc := buildServerConn(c, l.config)
-	handshakeCtx, cancel := context.WithTimeout(context.Background(), time.Duration(l.config.handshakeTimeout)*time.Second)
+	// The session ID is generated at accept time, so the handshake failures can be correlated too
+	gc.sessionID = session.NewID()
+	handshakeCtx, cancel := context.WithTimeout(session.NewContext(context.Background(), gc.sessionID), time.Duration(l.config.handshakeTimeout)*time.Second)
 	defer cancel()
-	if err = gc.handshakeContext(handshakeCtx); err != nil {
+	if err := gc.handshakeContext(handshakeCtx); err != nil {
 		gc.Close()
-		return nil, err
+		return nil, &HandshakeError{RemoteAddr: c.RemoteAddr(), SessionID: gc.sessionID, Err: err}
 	}
-
 	return gc, nil
 }
 
@@ -118,23 +310,33 @@ func Listen(laddr string, config *ServerConfig) (*Listener, error) {
 // dialAccountConfig holds the configuration for client-side authentication.
 type dialAccountConfig struct {
 	Account         Credential
-	InitPassword    [InitPasswordSize]byte // Client side init password for encrypting the client's initial greeting
+	InitPassword    []byte // Client side init password for encrypting the client's initial greeting
+	InitAlgorithm   string // AEAD algorithm used for the client's initial greeting
 	CryptoAlgorithm string
 }
 
 // NewDialAccountConfig creates a new DialAccountConfig instance.
-func NewDialAccountConfig(account Credential, initPassword, cryptoAlgorithm string) *dialAccountConfig {
-	d := &dialAccountConfig{
+func NewDialAccountConfig(account Credential, initPassword, initAlgorithm, cryptoAlgorithm string) *dialAccountConfig {
+	return &dialAccountConfig{
 		Account:         account,
+		InitPassword:    []byte(initPassword),
+		InitAlgorithm:   initAlgorithm,
 		CryptoAlgorithm: cryptoAlgorithm,
 	}
-	copy(d.InitPassword[:], []byte(initPassword))
-	return d
 }
 
 // dialConnConfig holds the configuration for the connection destination.
 type dialConnConfig struct {
 	protocol.AddressHeader
+	sessionID session.ID // Sent to the server after the initial greeting if it isn't zero (OPTIONAL)
+}
+
+// SetSessionID sets the session ID of the connection, and returns the dialConnConfig for chaining.
+// If it isn't zero, it's sent to the server (encrypted) after the initial greeting, so the logs of both sides can be correlated.
+// The servers before the session IDs reject the greeting's version that announces it, so it should only be set if the server supports it.
+func (dcc *dialConnConfig) SetSessionID(id session.ID) *dialConnConfig {
+	dcc.sessionID = id
+	return dcc
 }
 
 // NewDialConnConfig creates a new DialConnConfig instance.
@@ -168,22 +370,32 @@ func (d *Dialer) dialTCP(ctx context.Context, addr string) (net.Conn, error) {
 	return tcpConn, nil
 }
 
-// dial performs the Gordafarid handshake over an established TCP connection.
-func (d *Dialer) dial(ctx context.Context, dialConnConfig *dialConnConfig, tcpConn net.Conn) (net.Conn, error) {
-	var conn *Conn
-	if dialConnConfig != nil {
-		conn = buildClientConn(tcpConn, d.accountConfig, dialConnConfig)
-	} else {
-		conn = buildClientConn(tcpConn, d.accountConfig, d.connConfig)
+// connConfigOf returns the connection config of a dial, the given one or the Dialer's one if it's nil.
+// It returns errMissingDialConfig if the Dialer has no account config, or neither connection config is set.
+func (d *Dialer) connConfigOf(connConfig *dialConnConfig) (*dialConnConfig, error) {
+	if connConfig == nil {
+		connConfig = d.connConfig
+	}
+	if d.accountConfig == nil || connConfig == nil {
+		return nil, errMissingDialConfig
 	}
+	return connConfig, nil
+}
 
-	// TODO: it's for debugging, logically, it's not necessary
-	if conn == nil {
-		panic("the connection is nil in the Gordafarid Dialer's dial method")
+// dial performs the Gordafarid handshake over an established TCP connection.
+// The TCP connection is closed if the handshake can't be performed or fails.
+func (d *Dialer) dial(ctx context.Context, dialConnConfig *dialConnConfig, tcpConn net.Conn) (net.Conn, error) {
+	connConfig, err := d.connConfigOf(dialConnConfig)
+	if err != nil {
+		tcpConn.Close()
+		return nil, err
 	}
+	conn := buildClientConn(tcpConn, d.accountConfig, connConfig)
 
+	// The TCP connection is closed if the handshake fails, including the server's failure replies
 	if err := conn.HandshakeContext(ctx); err != nil {
-		return nil, errors.Join(errHandshakeFailed, err)
+		tcpConn.Close()
+		return nil, errors.Join(ErrHandshakeFailed, err)
 	}
 
 	return conn, nil
@@ -196,6 +408,9 @@ func (d *Dialer) Dial(dialConnConfig *dialConnConfig, addr string) (net.Conn, er
 
 // DialContext establishes a Gordafarid connection to the specified address with the given context.
 func (d *Dialer) DialContext(ctx context.Context, dialConnConfig *dialConnConfig, addr string) (net.Conn, error) {
+	if _, err := d.connConfigOf(dialConnConfig); err != nil {
+		return nil, err
+	}
 	tcpConn, err := d.dialTCP(ctx, addr)
 	if err != nil {
 		return nil, err
@@ -204,11 +419,13 @@ func (d *Dialer) DialContext(ctx context.Context, dialConnConfig *dialConnConfig
 }
 
 // WrapTCPContext wraps an existing TCP connection with Gordafarid protocol.
+// The connection is closed if the handshake fails.
 func (d *Dialer) WrapTCPContext(ctx context.Context, dialConnConfig *dialConnConfig, conn net.Conn) (net.Conn, error) {
 	return d.dial(ctx, dialConnConfig, conn)
 }
 
 // WrapTCP wraps an existing TCP connection with Gordafarid protocol using the background context.
+// The connection is closed if the handshake fails.
 func (d *Dialer) WrapTCP(dialConnConfig *dialConnConfig, conn net.Conn) (net.Conn, error) {
 	return d.WrapTCPContext(context.Background(), dialConnConfig, conn)
 }
@@ -216,6 +433,9 @@ func (d *Dialer) WrapTCP(dialConnConfig *dialConnConfig, conn net.Conn) (net.Con
 // DialContext establishes a Gordafarid connection with the given context and configuration.
 func DialContext(ctx context.Context, addr string, dialAccountConfig *dialAccountConfig, dialConnConfig *dialConnConfig) (net.Conn, error) {
 	d := NewDialer(dialAccountConfig, dialConnConfig)
+	if _, err := d.connConfigOf(nil); err != nil {
+		return nil, err
+	}
 	tcpConn, err := d.dialTCP(ctx, addr)
 	if err != nil {
 		return nil, err
@@ -229,35 +449,46 @@ func Dial(addr string, dialAccountConfig *dialAccountConfig, dialConnConfig *dia
 }
 
 // WrapTCPContext wraps an existing TCP connection with Gordafarid protocol using the given context.
+// The connection is closed if the handshake fails.
 func WrapTCPContext(ctx context.Context, conn net.Conn, dialAccountConfig *dialAccountConfig, dialConnConfig *dialConnConfig) (net.Conn, error) {
 	d := NewDialer(dialAccountConfig, dialConnConfig)
 	return d.dial(ctx, nil, conn)
 }
 
 // WrapTCP wraps an existing TCP connection with Gordafarid protocol using the background context.
+// The connection is closed if the handshake fails.
 func WrapTCP(conn net.Conn, dialAccountConfig *dialAccountConfig, dialConnConfig *dialConnConfig) (net.Conn, error) {
 	return WrapTCPContext(context.Background(), conn, dialAccountConfig, dialConnConfig)
 }
 
 // buildClientConn creates a new Gordafarid client connection from an underlying TCP connection.
 func buildClientConn(underlyingConn net.Conn, dialAccountConfig *dialAccountConfig, dialConnConfig *dialConnConfig) *Conn {
-	accountHash := sha256.Sum256([]byte(dialAccountConfig.Account.Username + dialAccountConfig.Account.Password))
+	accountHash := dialAccountConfig.Account.hash()
+	// The greetings followed by the session ID have a version of their own
+	version := byte(gordafaridVersion)
+	if !dialConnConfig.sessionID.IsZero() {
+		version = gordafaridSessionIDVersion
+	}
 
 	c := &Conn{
 		Conn:     underlyingConn,
 		isClient: true,
 		config: &Config{
 			encryptionAlgorithm: dialAccountConfig.CryptoAlgorithm,
+			initAlgorithm:       dialAccountConfig.InitAlgorithm,
 			initPassword:        dialAccountConfig.InitPassword,
 		},
 		account: account{
 			hash:     accountHash,
-			password: []byte(dialAccountConfig.Account.Password),
+			username: dialAccountConfig.Account.Username,
+			password: dialAccountConfig.Account.key(),
 		},
+		sessionID: dialConnConfig.sessionID,
 		greeting: greetingHeader{
-			hash: accountHash,
+			hash:      accountHash,
+			sessionID: dialConnConfig.sessionID,
 			BasicHeader: protocol.BasicHeader{
-				Version: gordafaridVersion,
+				Version: version,
 				Cmd:     protocol.CmdConnect,
 			},
 		},
@@ -0,0 +1,107 @@
+package gordafarid
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+)
+
+// dialTest dials the listener with the Gordafarid dialer of the account, requesting 127.0.0.1:80.
+func dialTest(t *testing.T, l *Listener, username, password string) (net.Conn, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	accountConfig := NewDialAccountConfig(NewCredential(username, password), testInitPassword, testAlgorithm, testAlgorithm)
+	addr := protocol.NewAddressHeader(protocol.AtypIPv4, []byte{127, 0, 0, 1}, [protocol.DstPortSize]byte{0, 80})
+	conn, err := NewDialer(accountConfig, NewDialConnConfig(addr)).DialContext(ctx, nil, l.Addr().String())
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, err
+}
+
+func TestListenerHandshakeLimit(t *testing.T) {
+	config := NewServerConfig([]Credential{NewCredential(testUsername, testPassword)}, testAlgorithm, testAlgorithm, testInitPassword, 5)
+	config.MaxHandshakes = 1
+	l := listenTest(t, config)
+	accept := acceptNext(t, l)
+
+	// The silent connection holds the only handshake slot
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer silent.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// The rejected client sees the greeting failure
+	if _, err = dialTest(t, l, testUsername, testPassword); !errors.Is(err, errGreetingFailed) {
+		t.Fatalf("the rejected client got %v, want %v", err, errGreetingFailed)
+	}
+	_, err = accept()
+	if !errors.Is(err, errHandshakeLimitReached) {
+		t.Fatalf("AcceptConn returned %v, want the handshakes limit error", err)
+	}
+	if reason := HandshakeFailureReason(err); reason != HandshakeFailureLimit {
+		t.Fatalf("the failure reason is %q, want %q", reason, HandshakeFailureLimit)
+	}
+}
+
+func TestDialerGreetingResponse(t *testing.T) {
+	l := listenTest(t, NewServerConfig([]Credential{NewCredential(testUsername, testPassword)}, testAlgorithm, testAlgorithm, testInitPassword, 2))
+
+	// The unknown account's greeting failure is read in plaintext
+	accept := acceptNext(t, l)
+	if _, err := dialTest(t, l, "mallory", testPassword); !errors.Is(err, errGreetingFailed) {
+		t.Fatalf("the unknown account got %v, want %v", err, errGreetingFailed)
+	}
+	if _, err := accept(); HandshakeFailureReason(err) != HandshakeFailureAuth {
+		t.Fatalf("the unknown account's handshake got %v, want an auth failure", err)
+	}
+
+	// The encrypted greeting success is read through the cipher, after its start was read in plaintext
+	accept = acceptNext(t, l)
+	dialed := make(chan error, 1)
+	go func() {
+		_, err := dialTest(t, l, testUsername, testPassword)
+		dialed <- err
+	}()
+	conn, err := accept()
+	if err != nil {
+		t.Fatalf("the account's handshake failed: %v", err)
+	}
+	if err = conn.SendReply(ReplySuccess); err != nil {
+		t.Fatalf("SendReply: %v", err)
+	}
+	if err = <-dialed; err != nil {
+		t.Fatalf("the account's dial failed: %v", err)
+	}
+}
+
+func TestDialerMissingConfig(t *testing.T) {
+	accountConfig := NewDialAccountConfig(NewCredential("alice", "abcdef0123456789abcdef0123456789"), "0123456789abcdef0123456789abcdef", "chacha20-poly1305", "chacha20-poly1305")
+	for name, d := range map[string]*Dialer{
+		"no connection config": NewDialer(accountConfig, nil),
+		"no account config":    NewDialer(nil, nil),
+	} {
+		client, server := net.Pipe()
+		defer server.Close()
+		if _, err := d.WrapTCP(nil, client); !errors.Is(err, errMissingDialConfig) {
+			t.Fatalf("%s: WrapTCP returned %v, want %v", name, err, errMissingDialConfig)
+		}
+		// The connection is closed, as on a failed handshake
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := server.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+			t.Fatalf("%s: the wrapped connection isn't closed: %v", name, err)
+		}
+
+		if _, err := d.DialContext(context.Background(), nil, "127.0.0.1:1"); !errors.Is(err, errMissingDialConfig) {
+			t.Fatalf("%s: DialContext returned %v, want %v", name, err, errMissingDialConfig)
+		}
+	}
+}
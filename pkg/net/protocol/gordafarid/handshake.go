@@ -1,21 +1,25 @@
 // Package gordafarid implements the Gordafarid protocol for secure communication.
 package gordafarid
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 /*
 Gordafarid Handshake Process:
 
 Client -> Server: Initial Greeting
-+----+------------+
-|VER | CMD | HASH |
-+----+------------+
-| 1  |  1  | 32   |
-+----+------------+
++----+------------------------+
+|VER | CMD | HASH | SESSION   |
++----+------------------------+
+| 1  |  1  | 32   | 0 or 8    |
++----+------------------------+
 
 VER: Gordafarid protocol version (0x01 for Gordafarid)
 CMD: Command (0x01 for CONNECT, 0x02 for BIND, 0x03 for UDP ASSOCIATE)
 HASH: Hash value used for authentication
+SESSION: The client's session ID (OPTIONAL), the server tries the greeting without it first
 
 
 Server -> Client: Greeting Response
@@ -50,15 +54,21 @@ Server -> Client: Reply
 +----+--------+------+----------+----------+
 
 VER: Gordafarid protocol version (0x01 for Gordafarid)
-STATUS: Status of the handshake (0x00 for success, 0x01 for failure)
+STATUS: Status of the request (0x00 for success, 0x01 for failure, 0x02 for destination not allowed, 0x03 for quota exceeded, 0x04 for connection limit reached)
 ATYP: Address type (0x01 for IPv4, 0x03 for domain name, 0x04 for IPv6)
 BND.ADDR: Bound address
 BND.PORT: Bound port
+
+The server sends the reply after it has decided about the request (e.g. checked the access control lists and dialed the destination).
 */
 
 // SetHandshakeComplete marks the handshake as complete for the connection.
 // This method is used to indicate that the initial handshake process has finished successfully.
+// The handshake duration is recorded before the flag is set, so it's available once GetHandshakeComplete returns true.
 func (c *Conn) SetHandshakeComplete() {
+	if !c.handshakeStart.IsZero() {
+		c.handshakeDuration.Store(int64(time.Since(c.handshakeStart)))
+	}
 	c.isHandshakeComplete.Store(true)
 }
 
@@ -86,5 +96,6 @@ func (c *Conn) handshakeContext(ctx context.Context) error {
 	if c.GetHandshakeComplete() {
 		return nil // Handshake already completed, no need to perform it again
 	}
+	c.handshakeStart = time.Now()
 	return c.handshakeFn(ctx) // Execute the handshake function with the provided context
 }
@@ -3,10 +3,10 @@ package gordafarid
 import (
 	"context"
 	"errors"
+	"net"
 
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/cipher_conn"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
-	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aes_gcm"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/utils"
 )
 
@@ -15,8 +15,8 @@ import (
 //
 // The handshake process involves the following steps:
 // 1. Send a greeting to the server
-// 2. Handle the server's greeting response
-// 3. Set up encryption using the agreed-upon algorithm
+// 2. Check the server's greeting response for the plaintext failure
+// 3. Set up encryption using the agreed-upon algorithm, and handle the encrypted greeting response
 // 4. Send a request to the server
 // 5. Mark the handshake as complete
 //
@@ -39,13 +39,19 @@ func (c *Conn) clientHandshake(ctx context.Context) error {
 	if err = c.clientSendGreeting(ctx); err != nil {
 		return errors.Join(errClientFailedToSendInitialGreeting, err)
 	}
-	// Step 2: Set up encryption using the client's password
+	// Step 2: Read the start of the greeting response before the connection is encrypted, it may be the plaintext failure
+	responseStart, err := c.clientReadGreetingFailure(ctx)
+	if err != nil {
+		return errors.Join(errClientFailedToHandleInitialGreetingResponse, err)
+	}
+	// Set up encryption using the client's password
 	aead, err := aead.NewAEAD(c.config.encryptionAlgorithm, c.account.password)
 	if err != nil {
 		return errors.Join(errFailedToBuildAEADCipher, err)
 	}
-	// Wrap the existing connection with the newly created cipher for secure communication
-	c.Conn = cipher_conn.WrapConnToCipherConn(c.Conn, aead)
+	// Wrap the existing connection with the newly created cipher for secure communication,
+	// the already read start of the encrypted greeting response is read again by the cipher
+	c.Conn = cipher_conn.WrapConnToCipherConn(&prefixedConn{Conn: c.Conn, prefix: responseStart}, aead)
 
 	// Step 3: Handle the server's response to the greeting
 	if err = c.clientHandleGreetingResponse(ctx); err != nil {
@@ -77,10 +83,18 @@ func (c *Conn) clientHandshake(ctx context.Context) error {
 // Returns:
 // - error: An error if the greeting couldn't be sent, nil otherwise
 func (c *Conn) clientSendGreeting(ctx context.Context) error {
-	cipher_greeting, err := aes_gcm.Encrypt_AES_GCM(c.greeting.Bytes(), c.config.initPassword[:])
+	cipher_greeting, err := aead.Encrypt(c.config.initAlgorithm, c.greeting.Bytes(), c.config.initPassword)
 	if err != nil {
 		return errClientFailedToEncryptInitialGreeting
 	}
+	// The greeting's version tells the server the session ID follows it, encrypted separately with the same init key
+	if c.greeting.Version == gordafaridSessionIDVersion {
+		cipherSessionID, err := aead.Encrypt(c.config.initAlgorithm, c.greeting.sessionID[:], c.config.initPassword)
+		if err != nil {
+			return errClientFailedToEncryptInitialGreeting
+		}
+		cipher_greeting = append(cipher_greeting, cipherSessionID...)
+	}
 
 	_, err = utils.WriteWithContext(ctx, c.Conn, cipher_greeting)
 	return err
@@ -99,6 +113,27 @@ func (c *Conn) clientSendRequest(ctx context.Context) error {
 	return err
 }
 
+// clientReadGreetingFailure reads the first 2 bytes of the server's greeting response from the connection before it's encrypted.
+// The server sends the greeting failure in plaintext, because it couldn't pick the account's key (or it didn't start the handshake at all),
+// while it encrypts the greeting success; the first 2 bytes of the encrypted one are its cipher_conn packet's length, never the failure's bytes.
+//
+// Parameters:
+// - ctx: A context.Context for handling timeouts and cancellations
+//
+// Returns:
+// - []byte: The read bytes, the start of the encrypted greeting response
+// - error: errGreetingFailed if the server sent the greeting failure, or the read error
+func (c *Conn) clientReadGreetingFailure(ctx context.Context) ([]byte, error) {
+	buf := make([]byte, 2)
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] == gordafaridVersion && buf[1] == greetingFailed {
+		return nil, errGreetingFailed
+	}
+	return buf, nil
+}
+
 // clientHandleGreetingResponse processes the server's response to the client's initial greeting.
 // This function verifies that the server supports the correct protocol version and that the greeting was successful.
 //
@@ -116,7 +151,7 @@ func (c *Conn) clientHandleGreetingResponse(ctx context.Context) error {
 	buf := make([]byte, 2)
 
 	// Read the response from the server
-	if _, err := utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return err
 	}
 
@@ -133,10 +168,18 @@ func (c *Conn) clientHandleGreetingResponse(ctx context.Context) error {
 	return nil
 }
 
+// clientHandleReplyResponse processes the server's reply to the client's request.
+// A reply status other than ReplySuccess is returned as its matching error (ErrReplyNotAllowed, ErrReplyQuotaExceeded, ErrReplyLimitExceeded, ErrReplyFailed).
+//
+// Parameters:
+// - ctx: A context.Context for handling timeouts and cancellations
+//
+// Returns:
+// - error: An error if the reply is invalid or indicates a failure, nil otherwise
 func (c *Conn) clientHandleReplyResponse(ctx context.Context) error {
 	var err error
 	buf := make([]byte, 1)
-	if _, err = utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err = utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return err
 	}
 	if buf[0] != gordafaridVersion {
@@ -144,14 +187,23 @@ func (c *Conn) clientHandleReplyResponse(ctx context.Context) error {
 	}
 	c.reply.Version = buf[0]
 
-	if _, err = utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err = utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return err
 	}
-	if buf[0] != replySuccess {
-		return errReplyFailed
+	c.reply.Status = buf[0]
+	switch c.reply.Status {
+	case ReplySuccess:
+	case ReplyNotAllowed:
+		return ErrReplyNotAllowed
+	case ReplyQuotaExceeded:
+		return ErrReplyQuotaExceeded
+	case ReplyLimitExceeded:
+		return ErrReplyLimitExceeded
+	default:
+		return ErrReplyFailed
 	}
 
-	if _, err = utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err = utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return errors.Join(errUnableToReadAddressType, err)
 	}
 	c.reply.Bind.Atyp = buf[0]
@@ -163,3 +215,19 @@ func (c *Conn) clientHandleReplyResponse(ctx context.Context) error {
 
 	return err
 }
+
+// prefixedConn is a net.Conn whose reads return the prefix before reading from the connection.
+type prefixedConn struct {
+	net.Conn
+	prefix []byte // Bytes already read from the connection, returned by the next reads
+}
+
+// Read reads the remaining prefix first, then from the underlying connection.
+func (c *prefixedConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
@@ -4,18 +4,21 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
+	"time"
 
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/cipher_conn"
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
-	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aes_gcm"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
 
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/utils"
 )
 
 // serverHandshake performs the server-side handshake process for the Gordafarid protocol.
-// It handles the initial greeting, authentication, and connection setup.
+// It handles the initial greeting, authentication, and reading the client's request.
 // This function is called when a new client connects to the server.
+// The server's reply is not sent here, see SendReply.
 //
 // Parameters:
 // - ctx: The context for handling timeouts and cancellations.
@@ -56,11 +59,7 @@ func (c *Conn) serverHandshake(ctx context.Context) error {
 		return errors.Join(errServerFailedToHandleRequest, err)
 	}
 
-	// Step 5: Send the server's reply to the client
-	if err = c.serverSendReply(ctx); err != nil {
-		return errors.Join(errServerFailedToSendReplyResponse, err)
-	}
-
+	// The reply is sent after the server has decided about the request, see SendReply
 	c.SetHandshakeComplete()
 	return nil
 }
@@ -77,34 +76,38 @@ func (c *Conn) serverHandshake(ctx context.Context) error {
 func (c *Conn) serverHandleGreeting(ctx context.Context) error {
 	var err error
 
-	// Step 1: Read the greeting data ciphertext and decrypt it
-	greetingCipherSize := aes_gcm.AES_GCM_NonceSize + aes_gcm.AES_GCM_AuthTagSize + c.greeting.Size()
-	greetingCipher := make([]byte, greetingCipherSize)
-	if _, err := utils.ReadWithContext(ctx, c.Conn, greetingCipher); err != nil {
+	// Step 1: Read the greeting data ciphertext and decrypt it using the init keys
+	initKeys := c.validInitKeys(time.Now())
+	if len(initKeys) < 1 {
+		return errServerNoValidInitKey
+	}
+	// All the supported AEAD algorithms have the same overhead, so the first key determines the size
+	greetingCipherOverhead, err := aead.Overhead(initKeys[0].algorithm)
+	if err != nil {
+		return errors.Join(errFailedToBuildInitCipher, err)
+	}
+	greetingCipher := make([]byte, greetingCipherOverhead+c.greeting.Size())
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, greetingCipher); err != nil {
 		return errors.Join(errServerFailedToReadEncryptedInitialGreeting, err)
 	}
-	greetingPlaintext, err := aes_gcm.Decrypt_AES_GCM(greetingCipher, c.config.initPassword[:])
+	greetingPlaintext, err := c.decryptGreeting(initKeys, greetingCipher)
 	if err != nil {
-		if errors.Is(err, aes_gcm.ErrDuplicatedNonceUsed) {
-			return errors.Join(errServerDuplicatedAESGCMNonceUsedPossibleReplayAttack, err)
-		}
-		return errServerFailedToDecryptInitialGreeting
+		return err
 	}
 	greetingPlaintextReader := bytes.NewReader(greetingPlaintext)
 
 	// Step 2: Read and validate the protocol version
 	buf := make([]byte, 1)
-	if _, err = utils.ReadWithContext(ctx, greetingPlaintextReader, buf); err != nil {
+	if _, err = utils.ReadFullWithContext(ctx, greetingPlaintextReader, buf); err != nil {
 		return errors.Join(errUnableToReadVersion, err)
 	}
-	if buf[0] != gordafaridVersion {
+	if buf[0] != gordafaridVersion && buf[0] != gordafaridSessionIDVersion {
 		return errUnsupportedVersion
 	}
 	c.greeting.Version = buf[0]
 
 	// Step 3: Read and validate the command
-	buf = make([]byte, 1)
-	if _, err = utils.ReadWithContext(ctx, greetingPlaintextReader, buf); err != nil {
+	if _, err = utils.ReadFullWithContext(ctx, greetingPlaintextReader, buf); err != nil {
 		return errors.Join(errUnableToReadCmd, err)
 	}
 	if buf[0] != protocol.CmdConnect {
@@ -113,24 +116,93 @@ func (c *Conn) serverHandleGreeting(ctx context.Context) error {
 	c.greeting.Cmd = buf[0]
 
 	// Step 4: Read and validate the account hash
-	buf = make([]byte, HashSize)
-	n, err := utils.ReadWithContext(ctx, greetingPlaintextReader, buf)
-	if err != nil {
+	if _, err = utils.ReadFullWithContext(ctx, greetingPlaintextReader, c.greeting.hash[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return errInvalidAccountHash
+		}
 		return errors.Join(errUnableToReadAccountHash, err)
 	}
-	if n < HashSize {
-		return errInvalidAccountHash
+
+	// Step 5: Read the client's session ID, if the greeting's version says it follows the greeting
+	if c.greeting.Version == gordafaridSessionIDVersion {
+		if err = c.readClientSessionID(ctx, greetingCipherOverhead); err != nil {
+			return err
+		}
 	}
-	copy(c.greeting.hash[:], buf)
 
-	// Step 5: Perform authentication
-	if err = c.handleAuthentication(); err != nil {
+	// Step 6: Perform authentication
+	if err = c.handleAuthentication(ctx); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// validInitKeys returns the server's init keys that are accepted at the given time, in order.
+func (c *Conn) validInitKeys(now time.Time) []*initKey {
+	var initKeys []*initKey
+	for _, ik := range c.config.initKeys {
+		if ik.isValidAt(now) {
+			initKeys = append(initKeys, ik)
+		}
+	}
+	return initKeys
+}
+
+// decryptGreeting tries to decrypt the client's initial greeting with the given init keys in order.
+// The first key that decrypts the greeting is stored in the connection, and used for the authentication.
+//
+// Parameters:
+// - initKeys: The init keys to try.
+// - greetingCipher: The encrypted initial greeting.
+//
+// Returns:
+// - []byte: The decrypted initial greeting.
+// - error: Any error that occurred during the decryption, or if no key could decrypt the greeting.
+func (c *Conn) decryptGreeting(initKeys []*initKey, greetingCipher []byte) ([]byte, error) {
+	for _, ik := range initKeys {
+		greetingPlaintext, err := aead.Decrypt(ik.algorithm, greetingCipher, ik.password)
+		if err != nil {
+			// The nonce cache is shared by all keys, so a duplicated nonce is final
+			if errors.Is(err, aead.ErrDuplicatedNonceUsed) {
+				return nil, errors.Join(errServerDuplicatedInitNonceUsedPossibleReplayAttack, err)
+			}
+			continue
+		}
+		c.initKey = ik
+		return greetingPlaintext, nil
+	}
+	return nil, errServerFailedToDecryptInitialGreeting
+}
+
+// readClientSessionID reads the client's session ID that follows the initial greeting,
+// and decrypts it with the init key that decrypted the greeting.
+//
+// Parameters:
+// - ctx: The context for handling timeouts and cancellations.
+// - cipherOverhead: The overhead of the init key's AEAD algorithm.
+//
+// Returns:
+// - error: Any error that occurred during reading or decrypting the session ID.
+func (c *Conn) readClientSessionID(ctx context.Context, cipherOverhead int) error {
+	sessionIDCipher := make([]byte, cipherOverhead+session.IDSize)
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, sessionIDCipher); err != nil {
+		return errors.Join(errUnableToReadSessionID, err)
+	}
+	sessionID, err := aead.Decrypt(c.initKey.algorithm, sessionIDCipher, c.initKey.password)
+	if err != nil {
+		if errors.Is(err, aead.ErrDuplicatedNonceUsed) {
+			return errors.Join(errServerDuplicatedInitNonceUsedPossibleReplayAttack, err)
+		}
+		return errors.Join(errServerFailedToDecryptSessionID, err)
+	}
+	if len(sessionID) != session.IDSize {
+		return errUnableToReadSessionID
+	}
+	copy(c.greeting.sessionID[:], sessionID)
+	return nil
+}
+
 // handleRequest processes the client's request after the initial handshake.
 // It reads the address type, destination address, and destination port.
 //
@@ -144,7 +216,7 @@ func (c *Conn) handleRequest(ctx context.Context) error {
 	buf := make([]byte, 1)
 
 	// Step 1: Read the address type
-	if _, err = utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err = utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return errors.Join(errUnableToReadAddressType, err)
 	}
 	c.request.Atyp = buf[0]
@@ -167,11 +239,14 @@ func (c *Conn) handleRequest(ctx context.Context) error {
 // buildReplyResponse constructs the reply message to be sent back to the client.
 // It sets the protocol version, status, and copies the request details into the reply.
 //
+// Parameters:
+// - status: The status of the reply.
+//
 // Returns:
 // - error: Any error that occurred during the reply construction process.
-func (c *Conn) buildReplyResponse() error {
+func (c *Conn) buildReplyResponse(status byte) error {
 	c.reply.Version = gordafaridVersion
-	c.reply.Status = replySuccess
+	c.reply.Status = status
 	c.reply.Bind.Atyp = c.request.Atyp
 	c.reply.Bind.DstAddr = c.request.DstAddr
 	c.reply.Bind.DstPort = c.request.DstPort
@@ -183,12 +258,13 @@ func (c *Conn) buildReplyResponse() error {
 //
 // Parameters:
 // - ctx: The context for handling timeouts and cancellations.
+// - status: The status of the reply.
 //
 // Returns:
 // - error: Any error that occurred during the reply sending process.
-func (c *Conn) serverSendReply(ctx context.Context) error {
+func (c *Conn) serverSendReply(ctx context.Context, status byte) error {
 	var err error
-	if err = c.buildReplyResponse(); err != nil {
+	if err = c.buildReplyResponse(status); err != nil {
 		return err
 	}
 	if _, err = utils.WriteWithContext(ctx, c.Conn, c.reply.Bytes()); err != nil {
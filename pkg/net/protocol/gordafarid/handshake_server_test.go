@@ -0,0 +1,256 @@
+package gordafarid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid/crypto/aead"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+const (
+	testAlgorithm    = "chacha20-poly1305"
+	testInitPassword = "0123456789abcdef0123456789abcdef"
+	testUsername     = "alice"
+	testPassword     = "abcdef0123456789abcdef0123456789"
+)
+
+// testClient is the client side of a handshake, driven by hand over a TCP connection to the listener.
+// It seals the messages itself, so the greetings can be crafted (e.g. with another init key or algorithm).
+type testClient struct {
+	net.Conn
+	t *testing.T
+}
+
+// listenTest starts a listener of the server config, closed when the test ends.
+func listenTest(t *testing.T, config *ServerConfig) *Listener {
+	t.Helper()
+	l, err := Listen("127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// dialTestClient connects a testClient to the listener.
+func dialTestClient(t *testing.T, l *Listener) *testClient {
+	t.Helper()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	return &testClient{Conn: c, t: t}
+}
+
+// seal encrypts the plaintext with a fresh nonce, in the layout of aead.Encrypt: the nonce followed by the ciphertext.
+func seal(t *testing.T, algorithm string, key, plaintext []byte) []byte {
+	t.Helper()
+	c, err := aead.NewAEAD(algorithm, key)
+	if err != nil {
+		t.Fatalf("NewAEAD: %v", err)
+	}
+	nonce := make([]byte, c.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	return c.Seal(nonce, nonce, plaintext, nil)
+}
+
+// testGreeting returns the plaintext initial greeting of the account.
+func testGreeting(version byte, username, password string) []byte {
+	hash := DeriveKeyID(username, password)
+	return append([]byte{version, protocol.CmdConnect}, hash[:]...)
+}
+
+// sendGreeting sends the initial greeting sealed with the init key, and returns the status of the server's greeting reply.
+// The success reply is sealed with the account's key, the failure one is plaintext.
+func (c *testClient) sendGreeting(algorithm, initPassword string, greeting []byte, password string) byte {
+	c.t.Helper()
+	if _, err := c.Write(seal(c.t, algorithm, []byte(initPassword), greeting)); err != nil {
+		c.t.Fatalf("writing the greeting: %v", err)
+	}
+	return c.readGreetingReply(password)
+}
+
+// readGreetingReply returns the status of the server's greeting reply.
+func (c *testClient) readGreetingReply(password string) byte {
+	c.t.Helper()
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		c.t.Fatalf("reading the greeting reply: %v", err)
+	}
+	if reply[0] == gordafaridVersion && reply[1] == greetingFailed {
+		return greetingFailed
+	}
+	reply = c.readSealedReply(password)
+	if len(reply) != 2 || reply[0] != gordafaridVersion {
+		c.t.Fatalf("the greeting reply is %x, want the version and the status", reply)
+	}
+	return reply[1]
+}
+
+// readSealedReply reads the rest of the two bytes reply's cipher_conn frame, whose length was already read,
+// and opens it with the account's key.
+func (c *testClient) readSealedReply(password string) []byte {
+	c.t.Helper()
+	cipher, err := aead.NewAEAD(testAlgorithm, []byte(password))
+	if err != nil {
+		c.t.Fatalf("NewAEAD: %v", err)
+	}
+	packet := make([]byte, cipher.NonceSize()+2+cipher.Overhead())
+	if _, err := io.ReadFull(c, packet); err != nil {
+		c.t.Fatalf("reading the greeting reply: %v", err)
+	}
+	plaintext, err := cipher.Open(nil, packet[:cipher.NonceSize()], packet[cipher.NonceSize():], nil)
+	if err != nil {
+		c.t.Fatalf("opening the greeting reply: %v", err)
+	}
+	return plaintext
+}
+
+// sendRequest sends a request to 127.0.0.1:80 in a cipher_conn frame sealed with the account's key.
+func (c *testClient) sendRequest(password string) {
+	c.t.Helper()
+	request := protocol.NewAddressHeader(protocol.AtypIPv4, []byte{127, 0, 0, 1}, [protocol.DstPortSize]byte{0, 80}).Bytes()
+	packet := seal(c.t, testAlgorithm, []byte(password), request)
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(packet)))
+	if _, err := c.Write(append(frame, packet...)); err != nil {
+		c.t.Fatalf("writing the request: %v", err)
+	}
+}
+
+// acceptNext starts accepting the next handshaked connection of the listener,
+// and returns a function waiting for it (or its handshake error).
+func acceptNext(t *testing.T, l *Listener) func() (*Conn, error) {
+	t.Helper()
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := l.AcceptConn()
+		results <- result{conn, err}
+	}()
+	return func() (*Conn, error) {
+		t.Helper()
+		select {
+		case r := <-results:
+			if r.conn != nil {
+				t.Cleanup(func() { r.conn.Close() })
+			}
+			return r.conn, r.err
+		case <-time.After(2 * time.Second):
+			t.Fatal("the listener didn't return the handshake's result in time")
+			return nil, nil
+		}
+	}
+}
+
+func TestHandshakeGreetingCipher(t *testing.T) {
+	credentials := []Credential{NewCredential(testUsername, testPassword)}
+	for _, tc := range []struct {
+		algorithm    string
+		initPassword string
+		other        string // Another algorithm of the same key size
+	}{
+		{"aes-128-gcm", "0123456789abcdef", ""},
+		{"aes-192-gcm", "0123456789abcdef01234567", ""},
+		{"chacha20-poly1305", testInitPassword, "aes-256-gcm"},
+		{"aes-256-gcm", testInitPassword, "chacha20-poly1305"},
+	} {
+		t.Run(tc.algorithm, func(t *testing.T) {
+			l := listenTest(t, NewServerConfig(credentials, testAlgorithm, tc.algorithm, tc.initPassword, 2))
+
+			accept := acceptNext(t, l)
+			c := dialTestClient(t, l)
+			if status := c.sendGreeting(tc.algorithm, tc.initPassword, testGreeting(gordafaridVersion, testUsername, testPassword), testPassword); status != greetingSuccess {
+				t.Fatalf("the greeting sealed with %s got status %d", tc.algorithm, status)
+			}
+			c.sendRequest(testPassword)
+			if _, err := accept(); err != nil {
+				t.Fatalf("the handshake with the %s greeting failed: %v", tc.algorithm, err)
+			}
+
+			if tc.other == "" {
+				return
+			}
+			// A greeting sealed with another algorithm doesn't decrypt, even with the same key
+			accept = acceptNext(t, l)
+			c = dialTestClient(t, l)
+			if status := c.sendGreeting(tc.other, tc.initPassword, testGreeting(gordafaridVersion, testUsername, testPassword), testPassword); status != greetingFailed {
+				t.Fatalf("the greeting sealed with %s instead of %s got status %d", tc.other, tc.algorithm, status)
+			}
+			if _, err := accept(); HandshakeFailureReason(err) != HandshakeFailureAuth {
+				t.Fatalf("the %s greeting got %v, want an auth failure", tc.other, err)
+			}
+		})
+	}
+}
+
+func TestHandshakeSessionID(t *testing.T) {
+	l := listenTest(t, NewServerConfig([]Credential{NewCredential(testUsername, testPassword)}, testAlgorithm, testAlgorithm, testInitPassword, 2))
+
+	// Without the session ID
+	accept := acceptNext(t, l)
+	c := dialTestClient(t, l)
+	if status := c.sendGreeting(testAlgorithm, testInitPassword, testGreeting(gordafaridVersion, testUsername, testPassword), testPassword); status != greetingSuccess {
+		t.Fatalf("the greeting got status %d", status)
+	}
+	c.sendRequest(testPassword)
+	conn, err := accept()
+	if err != nil {
+		t.Fatalf("the handshake without the session ID failed: %v", err)
+	}
+	if !conn.ClientSessionID().IsZero() {
+		t.Fatalf("the client's session ID is %s, want zero", conn.ClientSessionID())
+	}
+
+	// With the session ID, sealed on its own after the greeting
+	id := session.NewID()
+	accept = acceptNext(t, l)
+	c = dialTestClient(t, l)
+	greeting := seal(t, testAlgorithm, []byte(testInitPassword), testGreeting(gordafaridSessionIDVersion, testUsername, testPassword))
+	if _, err := c.Write(append(greeting, seal(t, testAlgorithm, []byte(testInitPassword), id[:])...)); err != nil {
+		t.Fatalf("writing the greeting: %v", err)
+	}
+	if status := c.readGreetingReply(testPassword); status != greetingSuccess {
+		t.Fatalf("the greeting with the session ID got status %d", status)
+	}
+	c.sendRequest(testPassword)
+	if conn, err = accept(); err != nil {
+		t.Fatalf("the handshake with the session ID failed: %v", err)
+	}
+	if conn.ClientSessionID() != id {
+		t.Fatalf("the client's session ID is %s, want %s", conn.ClientSessionID(), id)
+	}
+}
+
+func TestHandshakeWrongInitKeyFailsRightAway(t *testing.T) {
+	const handshakeTimeout = 5 * time.Second
+	l := listenTest(t, NewServerConfig([]Credential{NewCredential(testUsername, testPassword)}, testAlgorithm, testAlgorithm, testInitPassword, int(handshakeTimeout/time.Second)))
+
+	for _, version := range []byte{gordafaridVersion, gordafaridSessionIDVersion} {
+		start := time.Now()
+		accept := acceptNext(t, l)
+		c := dialTestClient(t, l)
+		// The server must neither wait for more bytes nor try to decrypt the greeting again
+		if status := c.sendGreeting(testAlgorithm, "fedcba9876543210fedcba9876543210", testGreeting(version, testUsername, testPassword), testPassword); status != greetingFailed {
+			t.Fatalf("the greeting sealed with a wrong init key got status %d", status)
+		}
+		if _, err := accept(); HandshakeFailureReason(err) != HandshakeFailureAuth {
+			t.Fatalf("the greeting sealed with a wrong init key got %v, want an auth failure", err)
+		}
+		if elapsed := time.Since(start); elapsed > handshakeTimeout/5 {
+			t.Fatalf("the wrong init key failed after %v, want right away", elapsed)
+		}
+	}
+}
@@ -1,20 +1,24 @@
 // Package gordafarid implements the Gordafarid protocol, a custom network protocol for secure communication.
 package gordafarid
 
-import "github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+import (
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
 
 // greetingHeader represents the header structure for the greeting message in the Gordafarid protocol.
 type greetingHeader struct {
 	protocol.BasicHeader                // Embedded BasicHeader from the protocol package
 	hash                 [HashSize]byte // Hash value used for authentication or integrity checking
+	sessionID            session.ID     // The client's session ID, sent encrypted after the greeting if it isn't zero (OPTIONAL)
 }
 
-// Size returns the total size of the greeting header in bytes.
+// Size returns the total size of the greeting header in bytes, the session ID isn't part of it.
 func (gh *greetingHeader) Size() int {
 	return gh.BasicHeader.Size() + HashSize
 }
 
-// Bytes serializes the greeting header into a byte slice.
+// Bytes serializes the greeting header into a byte slice, the session ID isn't part of it.
 func (gh *greetingHeader) Bytes() []byte {
 	return append(gh.BasicHeader.Bytes(), gh.hash[:]...)
 }
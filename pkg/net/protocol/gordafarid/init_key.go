@@ -0,0 +1,69 @@
+package gordafarid
+
+import "time"
+
+// DefaultInitKeyID is the ID of the init key built from ServerConfig.InitPassword.
+const DefaultInitKeyID = "default"
+
+// InitKey is a pre-shared key for decrypting the client's initial greeting,
+// along with the credentials that are allowed to authenticate through it.
+// Having several init keys allows rotating them without downtime,
+// and serving several tenants with isolated user bases on one listener.
+type InitKey struct {
+	ID               string            // Identifier of the key, used for logging
+	Algorithm        string            // AEAD algorithm used for the initial greeting
+	Password         string            // Pre-shared key for the initial greeting
+	NotBefore        time.Time         // The key is not accepted before this time (OPTIONAL)
+	NotAfter         time.Time         // The key is not accepted after this time (OPTIONAL)
+	Credentials      []Credential      // Accounts that can authenticate through this key
+	CredentialStores []CredentialStore // Additional account stores, queried in order after Credentials (OPTIONAL)
+}
+
+// NewInitKey creates a new InitKey instance with the provided parameters.
+// Zero notBefore/notAfter values mean the key has no validity bound on that side.
+func NewInitKey(id, algorithm, password string, notBefore, notAfter time.Time, credentials []Credential, credentialStores ...CredentialStore) InitKey {
+	return InitKey{
+		ID:               id,
+		Algorithm:        algorithm,
+		Password:         password,
+		NotBefore:        notBefore,
+		NotAfter:         notAfter,
+		Credentials:      credentials,
+		CredentialStores: credentialStores,
+	}
+}
+
+// initKey is the internal representation of an InitKey.
+type initKey struct {
+	id          string
+	algorithm   string
+	password    []byte
+	notBefore   time.Time
+	notAfter    time.Time
+	credentials CredentialStore // The Credentials followed by the CredentialStores
+}
+
+// convertToRealInitKey transforms the InitKey into an internal initKey structure.
+func (ik *InitKey) convertToRealInitKey() *initKey {
+	credentials := ChainCredentialStore{NewMemoryCredentialStore(ik.Credentials...)}
+	credentials = append(credentials, ik.CredentialStores...)
+	return &initKey{
+		id:          ik.ID,
+		algorithm:   ik.Algorithm,
+		password:    []byte(ik.Password),
+		notBefore:   ik.NotBefore,
+		notAfter:    ik.NotAfter,
+		credentials: credentials,
+	}
+}
+
+// isValidAt reports whether the key is accepted at the given time.
+func (ik *initKey) isValidAt(t time.Time) bool {
+	if !ik.notBefore.IsZero() && t.Before(ik.notBefore) {
+		return false
+	}
+	if !ik.notAfter.IsZero() && t.After(ik.notAfter) {
+		return false
+	}
+	return true
+}
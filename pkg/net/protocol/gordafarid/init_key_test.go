@@ -0,0 +1,85 @@
+package gordafarid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInitKeyIsValidAt(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name                string
+		notBefore, notAfter time.Time
+		want                bool
+	}{
+		{"unbounded", time.Time{}, time.Time{}, true},
+		{"within", now.Add(-time.Hour), now.Add(time.Hour), true},
+		{"not yet valid", now.Add(time.Hour), time.Time{}, false},
+		{"expired", time.Time{}, now.Add(-time.Hour), false},
+	} {
+		ik := NewInitKey("key", testAlgorithm, testInitPassword, tc.notBefore, tc.notAfter, nil)
+		if got := ik.convertToRealInitKey().isValidAt(now); got != tc.want {
+			t.Errorf("%s: isValidAt = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHandshakeInitKeys(t *testing.T) {
+	const (
+		expiredPassword = "expired0123456789abcdef012345678"
+		futurePassword  = "future0123456789abcdef0123456789"
+		tenantPassword  = "tenant0123456789abcdef0123456789"
+		tenantAccount   = "tenantalice0123456789abcdef01234"
+	)
+	now := time.Now()
+	credentials := []Credential{NewCredential(testUsername, testPassword)}
+	config := NewServerConfig(credentials, testAlgorithm, testAlgorithm, testInitPassword, 2).AddInitKeys(
+		NewInitKey("expired", testAlgorithm, expiredPassword, now.Add(-2*time.Hour), now.Add(-time.Hour), credentials),
+		NewInitKey("future", testAlgorithm, futurePassword, now.Add(time.Hour), time.Time{}, credentials),
+		NewInitKey("tenant-b", testAlgorithm, tenantPassword, time.Time{}, time.Time{}, []Credential{NewCredential(testUsername, tenantAccount)}),
+	)
+	l := listenTest(t, config)
+
+	// handshake performs a handshake of the account through the init key, and returns the server-side result
+	handshake := func(initPassword, password string) (*Conn, error) {
+		t.Helper()
+		accept := acceptNext(t, l)
+		c := dialTestClient(t, l)
+		if status := c.sendGreeting(testAlgorithm, initPassword, testGreeting(gordafaridVersion, testUsername, password), password); status == greetingSuccess {
+			c.sendRequest(password)
+		}
+		return accept()
+	}
+
+	for _, tc := range []struct {
+		name         string
+		initPassword string
+		password     string
+		wantInitKey  string // Empty if the handshake must fail
+	}{
+		{"default key", testInitPassword, testPassword, DefaultInitKeyID},
+		{"tenant key", tenantPassword, tenantAccount, "tenant-b"},
+		{"expired key", expiredPassword, testPassword, ""},
+		{"not yet valid key", futurePassword, testPassword, ""},
+		// The accounts of a key can't authenticate through another one, even with the same username
+		{"default account through the tenant key", tenantPassword, testPassword, ""},
+		{"tenant account through the default key", testInitPassword, tenantAccount, ""},
+	} {
+		conn, err := handshake(tc.initPassword, tc.password)
+		if tc.wantInitKey == "" {
+			if err == nil {
+				t.Errorf("%s: the handshake succeeded through the init key %q", tc.name, conn.initKey.id)
+			} else if reason := HandshakeFailureReason(err); reason != HandshakeFailureAuth {
+				t.Errorf("%s: the failure reason is %q, want %q (%v)", tc.name, reason, HandshakeFailureAuth, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: the handshake failed: %v", tc.name, err)
+			continue
+		}
+		if conn.initKey.id != tc.wantInitKey {
+			t.Errorf("%s: the greeting was decrypted with the init key %q, want %q", tc.name, conn.initKey.id, tc.wantInitKey)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package gordafarid
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AccessWindow is a daily time-of-day range an account can authenticate in.
+// If End is before Start, the window wraps around midnight (e.g. 22:00-06:00), and if they're equal, it's the whole day.
+type AccessWindow struct {
+	Start    time.Duration  // Start of the window since midnight, inclusive
+	End      time.Duration  // End of the window since midnight, exclusive
+	Location *time.Location // Time zone of the window
+}
+
+// ParseAccessWindow parses an "HH:MM-HH:MM" time-of-day range in the given time zone.
+// An empty timezone means the server's local time zone.
+func ParseAccessWindow(window, timezone string) (AccessWindow, error) {
+	location := time.Local
+	if len(timezone) > 0 {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return AccessWindow{}, errors.Join(errInvalidAccessWindow, err)
+		}
+	}
+
+	start, end, found := strings.Cut(window, "-")
+	if !found {
+		return AccessWindow{}, errors.Join(errInvalidAccessWindow, fmt.Errorf("window: %q", window))
+	}
+	startTime, err := time.Parse("15:04", strings.TrimSpace(start))
+	if err != nil {
+		return AccessWindow{}, errors.Join(errInvalidAccessWindow, fmt.Errorf("window: %q", window), err)
+	}
+	endTime, err := time.Parse("15:04", strings.TrimSpace(end))
+	if err != nil {
+		return AccessWindow{}, errors.Join(errInvalidAccessWindow, fmt.Errorf("window: %q", window), err)
+	}
+	return AccessWindow{
+		Start:    sinceMidnight(startTime),
+		End:      sinceMidnight(endTime),
+		Location: location,
+	}, nil
+}
+
+// ParseAccessWindows parses several "HH:MM-HH:MM" time-of-day ranges in the given time zone, see ParseAccessWindow.
+func ParseAccessWindows(windows []string, timezone string) ([]AccessWindow, error) {
+	accessWindows := make([]AccessWindow, 0, len(windows))
+	for _, window := range windows {
+		accessWindow, err := ParseAccessWindow(window, timezone)
+		if err != nil {
+			return nil, err
+		}
+		accessWindows = append(accessWindows, accessWindow)
+	}
+	return accessWindows, nil
+}
+
+// sinceMidnight returns the time of the day as a duration since midnight.
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// Contains reports whether the given time is in the window.
+func (w AccessWindow) Contains(t time.Time) bool {
+	if w.Location != nil {
+		t = t.In(w.Location)
+	}
+	now := sinceMidnight(t)
+	if w.Start == w.End {
+		return true
+	}
+	if w.Start < w.End {
+		return now >= w.Start && now < w.End
+	}
+	// The window wraps around midnight
+	return now >= w.Start || now < w.End
+}
+
+// end returns the end of the window's occurrence containing t.
+// The window must contain t, and not be the whole day.
+func (w AccessWindow) end(t time.Time) time.Time {
+	if w.Location != nil {
+		t = t.In(w.Location)
+	}
+	year, month, day := t.Date()
+	end := time.Date(year, month, day, 0, 0, 0, 0, t.Location()).Add(w.End)
+	if !end.After(t) {
+		// The window wraps around midnight, or ends at midnight
+		end = time.Date(year, month, day+1, 0, 0, 0, 0, t.Location()).Add(w.End)
+	}
+	return end
+}
+
+// allowedHoursEnd returns when the given time stops being in any of the windows, or the zero time if it never does.
+// The adjoining and overlapping windows extend each other, so a chain of windows covering the whole day never ends.
+func allowedHoursEnd(windows []AccessWindow, t time.Time) time.Time {
+	if len(windows) < 1 {
+		return time.Time{}
+	}
+	// Each step moves t to a window's end, so t has gone around the whole day once a window is used twice
+	for i := 0; i <= len(windows); i++ {
+		var end time.Time
+		for _, window := range windows {
+			if !window.Contains(t) {
+				continue
+			}
+			if window.Start == window.End {
+				return time.Time{}
+			}
+			if windowEnd := window.end(t); windowEnd.After(end) {
+				end = windowEnd
+			}
+		}
+		if end.IsZero() {
+			return t
+		}
+		t = end
+	}
+	return time.Time{}
+}
+
+// validUntil returns when the account, authenticated at the given time, stops being allowed to authenticate:
+// its expiry or the end of its allowed hours, whichever comes first. The zero time means it doesn't.
+func (c Credential) validUntil(now time.Time) time.Time {
+	until := c.ExpiresAt
+	if end := allowedHoursEnd(c.AllowedHours, now); !end.IsZero() && (until.IsZero() || end.Before(until)) {
+		until = end
+	}
+	return until
+}
+
+// checkLifecycle checks whether the account can authenticate at the given time,
+// and returns the reason if it can't.
+func (c Credential) checkLifecycle(now time.Time) error {
+	if c.Disabled {
+		return errAccountDisabled
+	}
+	if !c.NotBefore.IsZero() && now.Before(c.NotBefore) {
+		return errors.Join(errAccountNotYetValid, fmt.Errorf("notBefore: %s", c.NotBefore.Format(time.RFC3339)))
+	}
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt) {
+		return errors.Join(errAccountExpired, fmt.Errorf("expiresAt: %s", c.ExpiresAt.Format(time.RFC3339)))
+	}
+	if len(c.AllowedHours) > 0 {
+		for _, window := range c.AllowedHours {
+			if window.Contains(now) {
+				return nil
+			}
+		}
+		return errAccountOutsideAllowedHours
+	}
+	return nil
+}
+
+// lifecycleRecord holds the lifecycle fields of an account entry, as they're stored in user files and authorizer responses.
+type lifecycleRecord struct {
+	NotBefore    time.Time `toml:"notBefore" json:"notBefore"`       // The account can't authenticate before this time (OPTIONAL)
+	ExpiresAt    time.Time `toml:"expiresAt" json:"expiresAt"`       // The account can't authenticate after this time (OPTIONAL)
+	Disabled     bool      `toml:"disabled" json:"disabled"`         // The account can't authenticate (OPTIONAL)
+	AllowedHours []string  `toml:"allowedHours" json:"allowedHours"` // "HH:MM-HH:MM" windows the account can authenticate in (OPTIONAL)
+	Timezone     string    `toml:"timezone" json:"timezone"`         // IANA time zone of the allowedHours (OPTIONAL, default: the server's local time zone)
+}
+
+// apply sets the lifecycle fields of the credential.
+func (lr *lifecycleRecord) apply(credential *Credential) error {
+	allowedHours, err := ParseAccessWindows(lr.AllowedHours, lr.Timezone)
+	if err != nil {
+		return err
+	}
+	credential.NotBefore = lr.NotBefore
+	credential.ExpiresAt = lr.ExpiresAt
+	credential.Disabled = lr.Disabled
+	credential.AllowedHours = allowedHours
+	return nil
+}
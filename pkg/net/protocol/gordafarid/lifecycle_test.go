@@ -0,0 +1,39 @@
+package gordafarid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCredentialValidUntil(t *testing.T) {
+	now := time.Date(2025, 3, 10, 10, 30, 0, 0, time.UTC)
+	windows := func(windows ...string) []AccessWindow {
+		accessWindows, err := ParseAccessWindows(windows, "UTC")
+		if err != nil {
+			t.Fatalf("ParseAccessWindows: %v", err)
+		}
+		return accessWindows
+	}
+
+	tests := []struct {
+		name       string
+		credential Credential
+		want       time.Time
+	}{
+		{"unbounded", Credential{}, time.Time{}},
+		{"expiry", Credential{ExpiresAt: now.Add(time.Hour)}, now.Add(time.Hour)},
+		{"window end", Credential{AllowedHours: windows("09:00-18:00")}, time.Date(2025, 3, 10, 18, 0, 0, 0, time.UTC)},
+		{"expiry before the window end", Credential{ExpiresAt: now.Add(time.Hour), AllowedHours: windows("09:00-18:00")}, now.Add(time.Hour)},
+		{"window wrapping midnight", Credential{AllowedHours: windows("22:00-11:00")}, time.Date(2025, 3, 10, 11, 0, 0, 0, time.UTC)},
+		{"window ending at midnight", Credential{AllowedHours: windows("10:00-00:00")}, time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"adjoining windows", Credential{AllowedHours: windows("09:00-12:00", "12:00-14:00")}, time.Date(2025, 3, 10, 14, 0, 0, 0, time.UTC)},
+		{"overlapping windows", Credential{AllowedHours: windows("08:00-11:00", "10:00-13:00")}, time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC)},
+		{"whole day", Credential{AllowedHours: windows("00:00-00:00")}, time.Time{}},
+		{"windows covering the whole day", Credential{AllowedHours: windows("00:00-12:00", "12:00-00:00")}, time.Time{}},
+	}
+	for _, test := range tests {
+		if got := test.credential.validUntil(now); !got.Equal(test.want) {
+			t.Errorf("%s: validUntil = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,6 +17,7 @@ var errNonceReuseDetected = errors.New("nonce reuse detected")
 // NonceCache manages nonce storage and checks for replay attacks.
 type NonceCache struct {
 	storage    sync.Map      // Nonce storage with timestamps
+	size       atomic.Int64  // Number of the stored nonces
 	expiryTime time.Duration // How long nonces should be kept
 }
 
@@ -27,17 +29,23 @@ func NewNonceCache(expiryTime time.Duration) *NonceCache {
 }
 
 // Store stores a nonce in the cache. If the nonce already exists, it returns an error.
+// The check and the store are done atomically, so two concurrent callers can't both store the same nonce.
 func (nc *NonceCache) Store(nonce []byte) error {
 	nonceKey := string(nonce) // Store nonce as a string to be used as a key
-	if _, exists := nc.storage.Load(nonceKey); exists {
+
+	// Store the nonce with the current timestamp, unless it has been used before
+	if _, exists := nc.storage.LoadOrStore(nonceKey, time.Now().Unix()); exists {
 		return errNonceReuseDetected // Nonce has been used before
 	}
-
-	// Store the nonce with the current timestamp
-	nc.storage.Store(nonceKey, time.Now().Unix())
+	nc.size.Add(1)
 	return nil
 }
 
+// Len returns the number of nonces in the cache.
+func (nc *NonceCache) Len() int {
+	return int(nc.size.Load())
+}
+
 // Load loads a nonce from the cache.
 func (nc *NonceCache) Load(nonce []byte) (any, bool) {
 	nonceKey := string(nonce) // Store nonce as a string to be used as a key
@@ -61,7 +69,9 @@ func (nc *NonceCache) CleanupExpiredNonces() {
 		nonceTimestamp := value.(int64)
 		// If the nonce is older than the expiry time, delete it
 		if (nowTimestamp - nonceTimestamp) > nonceExpirySeconds {
-			nc.storage.Delete(key)
+			if _, loaded := nc.storage.LoadAndDelete(key); loaded {
+				nc.size.Add(-1)
+			}
 		}
 		return true
 	})
@@ -1,6 +1,15 @@
 // Package protocol defines constants, types, and structures for SOCKS5-like protocols.
 package protocol
 
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
 // Constants for SOCKS5-like protocols
 const (
 	CmdConnect = 1 // Command for TCP/IP stream connection
@@ -40,6 +49,41 @@ func NewAddressHeader(atyp byte, dstAddr []byte, dstPort [DstPortSize]byte) *Add
 	}
 }
 
+// ParseAddressHeader parses a host:port address (e.g. "example.com:443" or "[::1]:80") into an AddressHeader.
+// The IP addresses are encoded as such, and the other hosts as domain names, so they're resolved by the peer.
+//
+// Returns:
+//   - *AddressHeader: The parsed address.
+//   - error: If the address isn't host:port, the port is out of range, or the domain name is too long.
+func ParseAddressHeader(addr string) (*AddressHeader, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Join(errInvalidAddress, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errors.Join(errInvalidPort, fmt.Errorf("port: %q", portStr))
+	}
+	var dstPort [DstPortSize]byte
+	binary.BigEndian.PutUint16(dstPort[:], uint16(port))
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			return NewAddressHeader(AtypIPv4, ip.AsSlice(), dstPort), nil
+		}
+		// The zone of the link-local addresses can't be sent
+		return NewAddressHeader(AtypIPv6, ip.WithZone("").AsSlice(), dstPort), nil
+	}
+	if len(host) < 1 {
+		return nil, errors.Join(errInvalidAddress, fmt.Errorf("address: %q", addr))
+	}
+	if len(host) > 255 {
+		return nil, errDomainNameTooLong
+	}
+	return NewAddressHeader(AtypDomain, []byte(host), dstPort), nil
+}
+
 // Size returns the total size of the AddressHeader in bytes
 func (ah *AddressHeader) Size() int {
 	size := 1 + len(ah.DstAddr) + DstPortSize
@@ -49,6 +93,27 @@ func (ah *AddressHeader) Size() int {
 	return size
 }
 
+// Host returns the destination host as text, either the domain name or the IP address
+func (ah *AddressHeader) Host() string {
+	if ah.Atyp == AtypDomain {
+		return string(ah.DstAddr)
+	}
+	if addr, ok := netip.AddrFromSlice(ah.DstAddr); ok {
+		return addr.String()
+	}
+	return string(ah.DstAddr)
+}
+
+// Port returns the destination port
+func (ah *AddressHeader) Port() uint16 {
+	return binary.BigEndian.Uint16(ah.DstPort[:])
+}
+
+// String returns the destination as host:port
+func (ah *AddressHeader) String() string {
+	return net.JoinHostPort(ah.Host(), strconv.Itoa(int(ah.Port())))
+}
+
 // Bytes returns the byte representation of the AddressHeader
 func (ah *AddressHeader) Bytes() []byte {
 	result := make([]byte, 0, ah.Size())
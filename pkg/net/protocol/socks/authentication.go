@@ -70,7 +70,7 @@ func (c *Conn) selectPreferredSocks5AuthMethod() (byte, error) {
 func (c *Conn) serverParseUserPassAuthMethodHeaders(ctx context.Context) error {
 	// Read authentication version
 	buf := make([]byte, 1)
-	if _, err := utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return errors.Join(errUnableToReadUserPassAuthVersion, err)
 	}
 	if buf[0] != userPassAuthVersion {
@@ -79,22 +79,22 @@ func (c *Conn) serverParseUserPassAuthMethodHeaders(ctx context.Context) error {
 	c.userPassAuth.version = buf[0]
 
 	// Read username length and username
-	if _, err := utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return errors.Join(errUnableToReadUserPassAuthUsernameLength, err)
 	}
 	c.userPassAuth.uLen = buf[0]
 	c.userPassAuth.username = make([]byte, c.userPassAuth.uLen)
-	if _, err := utils.ReadWithContext(ctx, c.Conn, c.userPassAuth.username); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, c.userPassAuth.username); err != nil {
 		return errors.Join(errUnableToReadUserPassAuthUsername, err)
 	}
 
 	// Read password length and password
-	if _, err := utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return errors.Join(errUnableToReadUserPassAuthPasswordLength, err)
 	}
 	c.userPassAuth.pLen = buf[0]
 	c.userPassAuth.password = make([]byte, c.userPassAuth.pLen)
-	if _, err := utils.ReadWithContext(ctx, c.Conn, c.userPassAuth.password); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, c.userPassAuth.password); err != nil {
 		return errors.Join(errUnableToReadUserPassAuthPassword, err)
 	}
 	return nil
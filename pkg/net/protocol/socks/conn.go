@@ -7,6 +7,7 @@ import (
 	"sync/atomic"
 
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
 )
 
 // handshakeFunction is a type that represents a function to perform a handshake.
@@ -35,6 +36,9 @@ type Conn struct {
 
 	// isClient indicates whether this Conn is operating in client mode (true) or server mode (false)
 	isClient bool
+
+	// sessionID identifies the connection, it's generated when the connection is accepted
+	sessionID session.ID
 }
 
 // Read reads data from the connection.
@@ -69,3 +73,14 @@ func (c *Conn) GetHandshakeResult() (protocol.AddressHeader, error) {
 	}
 	return c.request.AddressHeader, nil
 }
+
+// Username returns the username the client authenticated with, available after the handshake.
+// It's empty if the username/password authentication wasn't used.
+func (c *Conn) Username() string {
+	return string(c.userPassAuth.username)
+}
+
+// SessionID returns the ID of the connection, generated when it was accepted.
+func (c *Conn) SessionID() session.ID {
+	return c.sessionID
+}
@@ -15,5 +15,8 @@ const (
 	userPassAuthSuccess = 0x00 // Authentication success
 	userPassAuthFailed  = 0x01 // Authentication failed
 
+	// Reply codes
+	replyGeneralFailure = 0x01 // General SOCKS server failure
+
 	MaxInitialGreetingSize = 1 + 1 + 256 // Max size of initial greeting
 )
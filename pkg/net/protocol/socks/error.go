@@ -6,6 +6,7 @@ var (
 	// General errors
 	errUnableToReadRequest     = errors.New("unable to read the SOCKS5 request")
 	errUnableToReadAddressType = errors.New("unable to read the SOCKS5 address type")
+	errHandshakeLimitReached   = errors.New("the SOCKS5 handshakes in flight limit is reached, the connection is closed")
 
 	// Initial greeting errors
 	errFailedToHandleInitialGreeting       = errors.New("failed to handle the initial greeting")
@@ -45,7 +45,7 @@ func (c *Conn) serverHandshake(ctx context.Context) error {
 func (c *Conn) serverParseInitialGreetingHeaders(ctx context.Context) error {
 	// Read SOCKS version and number of methods
 	buf := make([]byte, 2)
-	if _, err := utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		c.greeting.methods = []byte{noAcceptableMethod}
 		return errors.Join(errUnableToReadVersion, err)
 	}
@@ -67,7 +67,7 @@ func (c *Conn) serverParseInitialGreetingHeaders(ctx context.Context) error {
 
 	// Read authentication methods
 	methods := make([]byte, nMethods)
-	if _, err := utils.ReadWithContext(ctx, c.Conn, methods); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, methods); err != nil {
 		c.greeting.methods = []byte{noAcceptableMethod}
 		return fmt.Errorf("%w: sent nmethods: %d, error: %v", errInvalidNMethodsValue, nMethods, err)
 	}
@@ -122,7 +122,7 @@ func (c *Conn) serverHandleInitialGreeting(ctx context.Context) error {
 func (c *Conn) serverParseRequestHeaders(ctx context.Context) error {
 	// Read version, command, and reserved byte
 	buf := make([]byte, 3)
-	if _, err := utils.ReadWithContext(ctx, c.Conn, buf); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, buf); err != nil {
 		return errors.Join(errUnableToReadRequest, err)
 	}
 	if buf[0] != socks5Version || buf[1] != 1 {
@@ -134,7 +134,7 @@ func (c *Conn) serverParseRequestHeaders(ctx context.Context) error {
 	c.request.rsv = buf[2]
 
 	// Read address type
-	if _, err := utils.ReadWithContext(ctx, c.Conn, buf[:1]); err != nil {
+	if _, err := utils.ReadFullWithContext(ctx, c.Conn, buf[:1]); err != nil {
 		return errors.Join(errUnableToReadAddressType, err)
 	}
 	c.request.Atyp = buf[0]
@@ -3,8 +3,14 @@ package socks
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"os"
 	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/utils"
 )
 
 // ServerCredentials is a map that stores username-password pairs for authentication.
@@ -14,6 +20,7 @@ type ServerCredentials map[string]string
 type ServerConfig struct {
 	credentials      ServerCredentials
 	handshakeTimeout int // In seconds
+	maxHandshakes    int // Maximum handshakes in flight, 0 means unlimited
 }
 
 // NewServerConfig creates and returns a new ServerConfig with the given credentials and handshake timeout.
@@ -24,9 +31,19 @@ func NewServerConfig(credentials ServerCredentials, handshakeTimeout int) *Serve
 	}
 }
 
+// SetMaxHandshakes sets the maximum handshakes in flight, and returns the ServerConfig for chaining.
+// Once the limit is reached, the new connections get a general failure reply and are closed. 0 (the default) means unlimited.
+func (sc *ServerConfig) SetMaxHandshakes(n int) *ServerConfig {
+	sc.maxHandshakes = n
+	return sc
+}
+
 // Listener wraps a net.Listener and associates it with a ServerConfig.
+// The handshakes of the accepted connections are performed concurrently, so a slow client doesn't block the others.
+// It satisfies net.Listener, its Accept only returns the handshaked connections.
+// Its AcceptConn returns the errors of the failed handshakes as *HandshakeError.
 type Listener struct {
-	net.Listener
+	*utils.HandshakeListener[*Conn]
 	config *ServerConfig
 }
 
@@ -36,37 +53,88 @@ func NewListener(laddr string, config *ServerConfig) (*Listener, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Listener{
-		Listener: ln,
-		config:   config,
-	}, nil
+	return NewWrapListener(ln, config), nil
 }
 
 // NewWrapListener wraps an existing net.Listener with a ServerConfig.
+// Once the handshakes in flight reach the config's limit, the new connections get a general failure reply and are closed.
 func NewWrapListener(inner net.Listener, config *ServerConfig) *Listener {
-	return &Listener{
-		Listener: inner,
-		config:   config,
+	l := &Listener{
+		config: config,
 	}
+	l.HandshakeListener = utils.NewHandshakeListener(inner, config.maxHandshakes, l.handshake, rejectReply, errHandshakeLimitReached)
+	return l
 }
 
-// Accept waits for and returns the next connection to the listener.
-// It performs the SOCKS5 handshake before returning the connection.
-func (l *Listener) Accept() (*Conn, error) {
-	c, err := l.Listener.Accept()
-	if err != nil {
-		return nil, err
-	}
+// rejectReply is the reply sent to the connections over the handshakes limit:
+// a general failure with an unspecified IPv4 bound address.
+var rejectReply = []byte{socks5Version, replyGeneralFailure, 0, 1, 0, 0, 0, 0, 0, 0}
+
+// HandshakeError is returned by Listener.AcceptConn when the SOCKS5 handshake of an accepted connection fails.
+type HandshakeError struct {
+	RemoteAddr net.Addr   // Address of the client
+	SessionID  session.ID // ID of the connection, generated when it was accepted
+	Err        error      // The handshake error
+}
+
+// Error implements the error interface.
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("the SOCKS5 handshake with %s (session %s) failed: %v", e.RemoteAddr, e.SessionID, e.Err)
+}
+
+// Unwrap returns the handshake error.
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// handshake performs the SOCKS5 handshake of the accepted connection with the handshake timeout.
+// The connection is closed if the handshake fails.
+func (l *Listener) handshake(c net.Conn) (*Conn, error) {
 	sc := buildServerConn(c, l.config)
-	handshakeCtx, cancel := context.WithTimeout(context.Background(), time.Duration(l.config.handshakeTimeout)*time.Second)
+	// The session ID is generated at accept time, so the handshake failures can be correlated too
+	sc.sessionID = session.NewID()
+	handshakeCtx, cancel := context.WithTimeout(session.NewContext(context.Background(), sc.sessionID), time.Duration(l.config.handshakeTimeout)*time.Second)
 	defer cancel()
-	if err = sc.handshakeContext(handshakeCtx); err != nil {
+	if err := sc.handshakeContext(handshakeCtx); err != nil {
 		sc.Close()
-		return nil, err
+		return nil, &HandshakeError{RemoteAddr: c.RemoteAddr(), SessionID: sc.sessionID, Err: err}
 	}
 	return sc, nil
 }
 
+// Handshake failure reasons, returned by HandshakeFailureReason, e.g. to label the failure counters.
+const (
+	HandshakeFailureAuth               = "auth_failed"          // The username/password authentication failed
+	HandshakeFailureNoAcceptableMethod = "no_acceptable_method" // The client offered no acceptable authentication method
+	HandshakeFailureVersion            = "version"              // The client speaks an unsupported SOCKS version
+	HandshakeFailureCommand            = "command"              // The client requested an unsupported command
+	HandshakeFailureTimeout            = "timeout"              // The handshake didn't finish in time
+	HandshakeFailureLimit              = "limit_reached"        // The handshakes in flight limit was reached, the connection was rejected before its handshake
+	HandshakeFailureOther              = "other"
+)
+
+// HandshakeFailureReason classifies an error returned by Listener.AcceptConn into one of the HandshakeFailure* reasons.
+func HandshakeFailureReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errAuthenticationFailed), errors.Is(err, errAuthIncorrectUsername), errors.Is(err, errAuthIncorrectPassword):
+		return HandshakeFailureAuth
+	case errors.Is(err, errNoAcceptableMethod):
+		return HandshakeFailureNoAcceptableMethod
+	case errors.Is(err, errUnsupportedVersion), errors.Is(err, errUnsupportedUserPassAuthVersion):
+		return HandshakeFailureVersion
+	case errors.Is(err, errUnsupportedVersionOrCommand):
+		return HandshakeFailureCommand
+	case errors.Is(err, errHandshakeLimitReached):
+		return HandshakeFailureLimit
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return HandshakeFailureTimeout
+	default:
+		return HandshakeFailureOther
+	}
+}
+
 // buildServerConn creates a new Conn instance for the server side of the SOCKS5 connection.
 func buildServerConn(c net.Conn, serverConfig *ServerConfig) *Conn {
 	sc := &Conn{
@@ -0,0 +1,57 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenerHandshakeLimit(t *testing.T) {
+	l, err := NewListener("127.0.0.1:0", NewServerConfig(nil, 5).SetMaxHandshakes(1))
+	if err != nil {
+		t.Fatalf("NewListener: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.AcceptConn()
+		accepted <- err
+	}()
+
+	// The silent connection holds the only handshake slot
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer silent.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	rejected, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply, err := io.ReadAll(rejected)
+	if err != nil {
+		t.Fatalf("reading the reply of the rejected connection: %v", err)
+	}
+	if !bytes.Equal(reply, rejectReply) || reply[1] != replyGeneralFailure {
+		t.Fatalf("the rejected connection got %x, want the general failure reply", reply)
+	}
+
+	select {
+	case err = <-accepted:
+		if !errors.Is(err, errHandshakeLimitReached) {
+			t.Fatalf("AcceptConn returned %v, want the handshakes limit error", err)
+		}
+		if reason := HandshakeFailureReason(err); reason != HandshakeFailureLimit {
+			t.Fatalf("the failure reason is %q, want %q", reason, HandshakeFailureLimit)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the rejected connection wasn't reported by AcceptConn")
+	}
+}
@@ -0,0 +1,7 @@
+package session
+
+import "errors"
+
+var (
+	errInvalidID = errors.New("invalid session ID, it must be 16 hexadecimal digits")
+)
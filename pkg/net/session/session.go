@@ -0,0 +1,68 @@
+// Package session provides the IDs of the proxied connections, and carries them in a context.Context,
+// so the logs and errors of a connection can be correlated across the listeners, the handshakes, the dials and the relay,
+// and across the client and the server (the client may send its ID in the Gordafarid initial greeting).
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// IDSize is the size of a session ID in bytes.
+const IDSize = 8
+
+// ID identifies a proxied connection. It's random, so the IDs of different processes (e.g. the client and the server,
+// or the old and the new server process during an upgrade) don't collide in practice. The zero value means no ID.
+type ID [IDSize]byte
+
+// NewID returns a new random session ID.
+func NewID() ID {
+	var id ID
+	// crypto/rand.Read never returns an error on the supported platforms
+	rand.Read(id[:])
+	return id
+}
+
+// ParseID parses the hexadecimal form of a session ID, as returned by ID.String.
+func ParseID(s string) (ID, error) {
+	var id ID
+	if len(s) != hex.EncodedLen(IDSize) {
+		return ID{}, errInvalidID
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return ID{}, errors.Join(errInvalidID, err)
+	}
+	return id, nil
+}
+
+// String returns the hexadecimal form of the session ID, or an empty string for the zero value.
+func (id ID) String() string {
+	if id.IsZero() {
+		return ""
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// IsZero reports whether the session ID is the zero value, i.e. no ID.
+func (id ID) IsZero() bool {
+	return id == ID{}
+}
+
+// contextKey is the context key of the session ID.
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the session ID.
+func NewContext(ctx context.Context, id ID) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the session ID carried by the context, and reports whether it carries one.
+func FromContext(ctx context.Context) (ID, bool) {
+	if ctx == nil {
+		return ID{}, false
+	}
+	id, ok := ctx.Value(contextKey{}).(ID)
+	return id, ok && !id.IsZero()
+}
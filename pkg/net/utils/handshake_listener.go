@@ -0,0 +1,254 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// acceptErrorDelay is the delay after a failed accept of the underlying listener.
+	acceptErrorDelay = 50 * time.Millisecond
+
+	// rejectWriteTimeout is the write deadline of the failure reply sent to the connections over the handshakes limit.
+	rejectWriteTimeout = 100 * time.Millisecond
+)
+
+// HandshakeListener wraps a net.Listener, and performs the handshakes of the accepted connections concurrently,
+// so a slow or silent client doesn't block the others. It's shared by the protocol listeners (e.g. Gordafarid and SOCKS5).
+// It satisfies net.Listener (e.g. for http.Serve), its Accept only returns the handshaked connections.
+type HandshakeListener[C net.Conn] struct {
+	net.Listener
+
+	handshake   func(c net.Conn) (C, error) // Performs the handshake of an accepted connection, closes it on failure
+	rejectReply []byte                      // Sent to the connections over the handshakes limit before they're closed (OPTIONAL)
+	limitErr    error                       // Returned for the connections over the handshakes limit
+
+	errorHandler func(err error)                    // Called with the errors skipped by Accept, nil means they're dropped
+	admit        func(c net.Conn) (net.Conn, error) // Admits the accepted connections before their handshakes, nil means they're all admitted
+
+	startOnce  sync.Once               // Starts the accept loop on the first Accept
+	results    chan handshakeResult[C] // Handshaked connections and errors, delivered to AcceptConn
+	handshakes chan struct{}           // Semaphore of the handshakes in flight, nil means unlimited
+	done       chan struct{}           // Closed when the listener is closed
+	closeOnce  sync.Once
+
+	mu          sync.Mutex
+	handshaking map[net.Conn]struct{} // Connections being handshaked, closed by Close
+}
+
+// handshakeResult is the result of accepting a connection and performing its handshake.
+type handshakeResult[C net.Conn] struct {
+	conn C
+	err  error
+}
+
+// NewHandshakeListener creates a HandshakeListener wrapping the provided net.Listener.
+//
+// Parameters:
+//   - inner: The listener accepting the connections.
+//   - maxHandshakes: The maximum handshakes in flight, 0 means unlimited.
+//   - handshake: Performs the handshake of an accepted connection (with its own timeout), it must close the connection on failure.
+//   - rejectReply: The protocol failure reply sent to the connections over the handshakes limit, nil means they're just closed.
+//   - limitErr: The error returned by AcceptConn for the connections over the handshakes limit.
+//
+// Returns:
+//   - *HandshakeListener[C]: The listener, its accept loop starts on the first Accept.
+func NewHandshakeListener[C net.Conn](inner net.Listener, maxHandshakes int, handshake func(c net.Conn) (C, error), rejectReply []byte, limitErr error) *HandshakeListener[C] {
+	l := &HandshakeListener[C]{
+		Listener:    inner,
+		handshake:   handshake,
+		rejectReply: rejectReply,
+		limitErr:    limitErr,
+		results:     make(chan handshakeResult[C]),
+		done:        make(chan struct{}),
+	}
+	if maxHandshakes > 0 {
+		l.handshakes = make(chan struct{}, maxHandshakes)
+	}
+	return l
+}
+
+// AcceptConn waits for and returns the next handshaked connection to the listener.
+// The errors of the failed handshakes are returned too, so the caller can log them and call AcceptConn again.
+// It returns net.ErrClosed once the listener is closed.
+func (l *HandshakeListener[C]) AcceptConn() (C, error) {
+	l.startOnce.Do(func() {
+		go l.acceptLoop()
+	})
+	select {
+	case result := <-l.results:
+		return result.conn, result.err
+	case <-l.done:
+		var zero C
+		return zero, net.ErrClosed
+	}
+}
+
+// Accept implements net.Listener, it waits for and returns the next handshaked connection to the listener.
+// Unlike AcceptConn, the handshake and the other non-fatal accept errors are passed to the error handler (see SetErrorHandler)
+// instead of being returned, so the net.Listener consumers (e.g. http.Serve) keep serving.
+// It returns net.ErrClosed once the listener is closed.
+func (l *HandshakeListener[C]) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptConn()
+		if err == nil {
+			return conn, nil
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return nil, err
+		}
+		if l.errorHandler != nil {
+			l.errorHandler(err)
+		}
+	}
+}
+
+// SetErrorHandler sets the function called with the errors skipped by Accept (e.g. a handshake error), to log or count them.
+// It must be set before Accept is called, and is called from the goroutines calling Accept.
+func (l *HandshakeListener[C]) SetErrorHandler(handler func(err error)) {
+	l.errorHandler = handler
+}
+
+// SetAdmission sets the function called with each accepted connection before its handshake takes a slot, e.g. to enforce a per-client IP limit.
+// It returns the connection to handshake (e.g. wrapped to count it until it's closed), or an error to reject the connection:
+// it gets the reject reply and is closed, and the error is returned by AcceptConn.
+// It must be set before Accept is called, and is called from the accept loop, so it mustn't block.
+func (l *HandshakeListener[C]) SetAdmission(admit func(c net.Conn) (net.Conn, error)) {
+	l.admit = admit
+}
+
+// Close closes the listener, the connections being handshaked and waiting for Accept are closed too.
+func (l *HandshakeListener[C]) Close() error {
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		close(l.done)
+		handshaking := l.handshaking
+		l.handshaking = nil
+		l.mu.Unlock()
+		for c := range handshaking {
+			c.Close()
+		}
+	})
+	return l.Listener.Close()
+}
+
+// acceptLoop accepts the connections, and performs their handshakes concurrently.
+// If a connection isn't admitted, or the handshakes in flight reach the limit, it gets the reject reply and is closed right away.
+func (l *HandshakeListener[C]) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				l.Close()
+				return
+			}
+			l.deliver(handshakeResult[C]{err: err})
+			// Avoid spinning on persistent errors, e.g. too many open files
+			time.Sleep(acceptErrorDelay)
+			continue
+		}
+
+		remoteAddr := c.RemoteAddr()
+		if l.admit != nil {
+			admitted, err := l.admit(c)
+			if err != nil {
+				l.reject(c)
+				l.deliver(handshakeResult[C]{err: errors.Join(err, fmt.Errorf("remote: %s", remoteAddr))})
+				continue
+			}
+			c = admitted
+		}
+		if !l.acquireHandshake() {
+			l.reject(c)
+			l.deliver(handshakeResult[C]{err: errors.Join(l.limitErr, fmt.Errorf("remote: %s", remoteAddr))})
+			continue
+		}
+		go l.serve(c)
+	}
+}
+
+// reject sends the reject reply to the connection, and closes it.
+// The reply is written without waiting for the client, with a short deadline so the accept loop isn't held up.
+func (l *HandshakeListener[C]) reject(c net.Conn) {
+	if len(l.rejectReply) > 0 {
+		c.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+		c.Write(l.rejectReply)
+	}
+	c.Close()
+}
+
+// serve performs the handshake of the accepted connection, and delivers the result to AcceptConn.
+// The connection is closed by Close while it's being handshaked.
+func (l *HandshakeListener[C]) serve(c net.Conn) {
+	defer l.releaseHandshake()
+
+	if !l.track(c) {
+		c.Close()
+		return
+	}
+	conn, err := l.handshake(c)
+	l.untrack(c)
+	if err != nil {
+		l.deliver(handshakeResult[C]{err: err})
+		return
+	}
+	if !l.deliver(handshakeResult[C]{conn: conn}) {
+		conn.Close()
+	}
+}
+
+// deliver passes the result to AcceptConn, and reports whether it was delivered before the listener was closed.
+func (l *HandshakeListener[C]) deliver(result handshakeResult[C]) bool {
+	select {
+	case l.results <- result:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// track adds the connection to the ones being handshaked, and reports whether the listener is still open.
+func (l *HandshakeListener[C]) track(c net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.done:
+		return false
+	default:
+	}
+	if l.handshaking == nil {
+		l.handshaking = make(map[net.Conn]struct{})
+	}
+	l.handshaking[c] = struct{}{}
+	return true
+}
+
+// untrack removes the connection from the ones being handshaked, once its handshake is done.
+func (l *HandshakeListener[C]) untrack(c net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.handshaking, c)
+}
+
+// acquireHandshake takes a handshake slot, and reports whether a slot was available.
+func (l *HandshakeListener[C]) acquireHandshake() bool {
+	if l.handshakes == nil {
+		return true
+	}
+	select {
+	case l.handshakes <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseHandshake frees the handshake slot taken by acquireHandshake.
+func (l *HandshakeListener[C]) releaseHandshake() {
+	if l.handshakes != nil {
+		<-l.handshakes
+	}
+}
@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testHandshakeTimeout is the handshake timeout of the test listeners.
+const testHandshakeTimeout = 5 * time.Second
+
+var errTestLimitReached = errors.New("test handshakes limit reached")
+
+// testPreamble is the handshake of the test listeners: the client sends it, the server checks it.
+const testPreamble = 'G'
+
+// testHandshake reads the preamble from the accepted connection, with testHandshakeTimeout.
+func testHandshake(c net.Conn) (net.Conn, error) {
+	c.SetReadDeadline(time.Now().Add(testHandshakeTimeout))
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(c, b); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if b[0] != testPreamble {
+		c.Close()
+		return nil, errors.New("unexpected preamble")
+	}
+	c.SetReadDeadline(time.Time{})
+	return c, nil
+}
+
+func newTestHandshakeListener(t *testing.T, maxHandshakes int) *HandshakeListener[net.Conn] {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	l := NewHandshakeListener(ln, maxHandshakes, testHandshake, []byte("rejected"), errTestLimitReached)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// dialPreamble dials the listener and sends the preamble.
+func dialPreamble(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, err := c.Write([]byte{testPreamble}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return c
+}
+
+func TestHandshakeListenerSilentClientDoesntBlockAccept(t *testing.T) {
+	l := newTestHandshakeListener(t, 0)
+
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond)
+	client := dialPreamble(t, l.Addr().String())
+
+	start := time.Now()
+	c, err := l.AcceptConn()
+	if err != nil {
+		t.Fatalf("AcceptConn: %v", err)
+	}
+	defer c.Close()
+	if elapsed := time.Since(start); elapsed > testHandshakeTimeout/5 {
+		t.Fatalf("the handshaked client was accepted after %v, the silent client blocked it", elapsed)
+	}
+	if c.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Fatalf("accepted %s, want the handshaked client %s", c.RemoteAddr(), client.LocalAddr())
+	}
+}
+
+func TestHandshakeListenerLimit(t *testing.T) {
+	l := newTestHandshakeListener(t, 1)
+	results := make(chan error, 4)
+	go func() {
+		for {
+			c, err := l.AcceptConn()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if c != nil {
+				c.Close()
+			}
+			results <- err
+		}
+	}()
+
+	// The silent connection holds the only handshake slot
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	rejected, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply, err := io.ReadAll(rejected)
+	if err != nil {
+		t.Fatalf("reading the reply of the rejected connection: %v", err)
+	}
+	if string(reply) != "rejected" {
+		t.Fatalf("the rejected connection got %q, want the reject reply", reply)
+	}
+	select {
+	case err := <-results:
+		if !errors.Is(err, errTestLimitReached) {
+			t.Fatalf("AcceptConn returned %v, want the limit error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the rejected connection wasn't reported by AcceptConn")
+	}
+
+	// Once the silent handshake fails, its slot is freed
+	silent.Close()
+	select {
+	case err := <-results:
+		if err == nil || errors.Is(err, errTestLimitReached) {
+			t.Fatalf("AcceptConn returned %v, want the silent connection's handshake error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the silent connection's handshake failure wasn't reported by AcceptConn")
+	}
+	dialPreamble(t, l.Addr().String())
+	select {
+	case err := <-results:
+		if err != nil {
+			t.Fatalf("the connection after the slot was freed got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection after the slot was freed wasn't accepted")
+	}
+}
+
+func TestHandshakeListenerCloseClosesHandshakingConns(t *testing.T) {
+	l := newTestHandshakeListener(t, 0)
+	handshakeErrs := make(chan error, 1)
+	go func() {
+		_, err := l.AcceptConn()
+		handshakeErrs <- err
+	}()
+
+	// The silent client is blocked mid-handshake, until the handshake timeout
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	l.Close()
+	silent.SetReadDeadline(time.Now().Add(testHandshakeTimeout / 5))
+	if _, err = silent.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("the connection being handshaked got %v, want it closed by Close", err)
+	}
+	if elapsed := time.Since(start); elapsed > testHandshakeTimeout/5 {
+		t.Fatalf("the connection being handshaked was closed after %v", elapsed)
+	}
+	if err = <-handshakeErrs; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("AcceptConn returned %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestHandshakeListenerHTTPServe(t *testing.T) {
+	l := newTestHandshakeListener(t, 0)
+	handlerErrs := make(chan error, 1)
+	l.SetErrorHandler(func(err error) {
+		select {
+		case handlerErrs <- err:
+		default:
+		}
+	})
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	// A failed handshake is passed to the error handler, http.Serve keeps serving
+	bad, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	bad.Write([]byte("X"))
+	bad.Close()
+	select {
+	case <-handlerErrs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the failed handshake wasn't passed to the error handler")
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := c.Write([]byte{testPreamble}); err != nil {
+					c.Close()
+					return nil, err
+				}
+				return c, nil
+			},
+		},
+		Timeout: 2 * time.Second,
+	}
+	resp, err := client.Get("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "ok" {
+		t.Fatalf("got %q, want the handler's response", body)
+	}
+}
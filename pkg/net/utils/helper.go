@@ -6,12 +6,31 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"time"
 
 	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
 )
 
+// readDeadliner is implemented by the readers supporting read deadlines, e.g. net.Conn.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// writeDeadliner is implemented by the writers supporting write deadlines, e.g. net.Conn.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// aLongTimeAgo is a deadline in the past, it interrupts the blocked reads and writes right away.
+var aLongTimeAgo = time.Unix(1, 0)
+
 // ReadWithContext reads data from a net.Conn with context support.
-// It allows for cancellation and timeout handling using the provided context.
+// The context's deadline and cancellation are applied through the reader's read deadline (if it supports one, like net.Conn),
+// so the read is interrupted without leaving a goroutine behind; the read deadline is cleared once it returns.
+// A read deadline set by the caller is replaced, so it must be passed as the context's deadline instead;
+// it's only kept if the context can never be done (e.g. context.Background()), as the reader's deadline isn't touched then.
+// The readers without deadlines (e.g. a bytes.Reader) are read directly, once the context is checked.
 //
 // Parameters:
 //   - ctx: The context for cancellation and timeout control.
@@ -20,35 +39,36 @@ import (
 //
 // Returns:
 //   - int: The number of bytes read.
-//   - error: Any error that occurred during the read operation or context cancellation.
+//   - error: Any error that occurred during the read operation, or the context's error if it interrupted the read.
 func ReadWithContext(ctx context.Context, r io.Reader, buf []byte) (int, error) {
-	readChan := make(chan struct {
-		n   int
-		err error
+	return withReadContext(ctx, r, func() (int, error) {
+		return r.Read(buf)
 	})
+}
 
-	go func() {
-		defer close(readChan)
-		n, err := r.Read(buf)
-		readChan <- struct {
-			n   int
-			err error
-		}{
-			n:   n,
-			err: err,
-		}
-	}()
-
-	select {
-	case <-ctx.Done():
-		return 0, ctx.Err()
-	case v := <-readChan:
-		return v.n, v.err
-	}
+// ReadFullWithContext reads exactly len(buf) bytes from a net.Conn with context support, like io.ReadFull.
+// The context is applied the same way as ReadWithContext, a single deadline covers all the reads.
+//
+// Parameters:
+//   - ctx: The context for cancellation and timeout control.
+//   - r: An io.Reader to read from, usually a net.Conn.
+//   - buf: The buffer to fill.
+//
+// Returns:
+//   - int: The number of bytes read, len(buf) if the error is nil.
+//   - error: io.EOF if no bytes were read, io.ErrUnexpectedEOF if the stream ended in the middle,
+//     the context's error if it interrupted the reads, or the read error.
+func ReadFullWithContext(ctx context.Context, r io.Reader, buf []byte) (int, error) {
+	return withReadContext(ctx, r, func() (int, error) {
+		return io.ReadFull(r, buf)
+	})
 }
 
 // WriteWithContext writes data to a net.Conn with context support.
-// It allows for cancellation and timeout handling using the provided context.
+// The context's deadline and cancellation are applied through the writer's write deadline (if it supports one, like net.Conn),
+// so the write is interrupted without leaving a goroutine behind; the write deadline is cleared once it returns.
+// A write deadline set by the caller is replaced, so it must be passed as the context's deadline instead;
+// it's only kept if the context can never be done (e.g. context.Background()), as the writer's deadline isn't touched then.
 //
 // Parameters:
 //   - ctx: The context for cancellation and timeout control.
@@ -57,31 +77,67 @@ func ReadWithContext(ctx context.Context, r io.Reader, buf []byte) (int, error)
 //
 // Returns:
 //   - int: The number of bytes written.
-//   - error: Any error that occurred during the write operation or context cancellation.
+//   - error: Any error that occurred during the write operation, or the context's error if it interrupted the write.
 func WriteWithContext(ctx context.Context, w io.Writer, buf []byte) (int, error) {
-	writeChan := make(chan struct {
-		n   int
-		err error
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	wd, ok := w.(writeDeadliner)
+	if !ok {
+		return w.Write(buf)
+	}
+	return withDeadline(ctx, wd.SetWriteDeadline, func() (int, error) {
+		return w.Write(buf)
 	})
+}
 
-	go func() {
-		defer close(writeChan)
-		n, err := w.Write(buf)
-		writeChan <- struct {
-			n   int
-			err error
-		}{
-			n:   n,
-			err: err,
-		}
-	}()
+// withReadContext runs the reads of fn with the context applied through the reader's read deadline, if it supports one.
+func withReadContext(ctx context.Context, r io.Reader, fn func() (int, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	rd, ok := r.(readDeadliner)
+	if !ok {
+		return fn()
+	}
+	return withDeadline(ctx, rd.SetReadDeadline, fn)
+}
 
-	select {
-	case <-ctx.Done():
-		return 0, ctx.Err()
-	case v := <-writeChan:
-		return v.n, v.err
+// withDeadline runs fn with the context's deadline set by setDeadline, which is moved to the past if the context is cancelled earlier.
+// The deadline is cleared once fn returns. If the context interrupted fn, its error is returned instead of the timeout error.
+// The deadlines can't be read back from a net.Conn, so the caller's deadline can't be restored; it's left alone
+// if the context can never be done, as there's nothing to apply then.
+func withDeadline(ctx context.Context, setDeadline func(time.Time) error, fn func() (int, error)) (int, error) {
+	if ctx.Done() == nil {
+		return fn()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		setDeadline(deadline)
+	}
+	// Interrupt fn once the context is cancelled
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(interrupted)
+		setDeadline(aLongTimeAgo)
+	})
+
+	n, err := fn()
+
+	// Wait for the interruption if it has started, so the deadline isn't moved to the past after it's cleared
+	if !stop() {
+		<-interrupted
+	}
+	setDeadline(time.Time{})
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return n, ctxErr
+		}
+		// The connection's deadline may expire right before the context's one does
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return n, context.DeadlineExceeded
+		}
 	}
+	return n, err
 }
 
 // ReadAddress reads the address based on the address type
@@ -91,22 +147,22 @@ func ReadAddress(ctx context.Context, conn net.Conn, atyp byte) ([]byte, error)
 	switch atyp {
 	case protocol.AtypIPv4:
 		buf = make([]byte, net.IPv4len)
-		if _, err := ReadWithContext(ctx, conn, buf); err != nil {
+		if _, err := ReadFullWithContext(ctx, conn, buf); err != nil {
 			return nil, errors.Join(errUnableToReadIpv4, err)
 		}
 	case protocol.AtypIPv6:
 		buf = make([]byte, net.IPv6len)
-		if _, err := ReadWithContext(ctx, conn, buf); err != nil {
+		if _, err := ReadFullWithContext(ctx, conn, buf); err != nil {
 			return nil, errors.Join(errUnableToReadIpv6, err)
 		}
 	case protocol.AtypDomain:
 		buf = make([]byte, 1)
-		if _, err := ReadWithContext(ctx, conn, buf); err != nil {
+		if _, err := ReadFullWithContext(ctx, conn, buf); err != nil {
 			return nil, errors.Join(errUnableToReadDomain, err)
 		}
 		domainLen := buf[0]
 		buf = make([]byte, domainLen)
-		if _, err := ReadWithContext(ctx, conn, buf); err != nil {
+		if _, err := ReadFullWithContext(ctx, conn, buf); err != nil {
 			return nil, errors.Join(errUnableToReadDomain, err)
 		}
 	default:
@@ -118,7 +174,7 @@ func ReadAddress(ctx context.Context, conn net.Conn, atyp byte) ([]byte, error)
 // ReadPort reads the port number from the connection
 func ReadPort(ctx context.Context, conn net.Conn) ([2]byte, error) {
 	var port [2]byte
-	if _, err := ReadWithContext(ctx, conn, port[:]); err != nil {
+	if _, err := ReadFullWithContext(ctx, conn, port[:]); err != nil {
 		return [2]byte{}, errors.Join(errUnableToReadPort, err)
 	}
 	return port, nil
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadWithContextInterrupted(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ReadWithContext(ctx, conn, make([]byte, 1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("the read past the context's deadline returned %v, want context.DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := ReadWithContext(ctx, conn, make([]byte, 1)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("the read of the cancelled context returned %v, want context.Canceled", err)
+	}
+
+	// The interrupted reads don't leave a deadline behind
+	go peer.Write([]byte{1})
+	if _, err := ReadWithContext(context.Background(), conn, make([]byte, 1)); err != nil {
+		t.Fatalf("the read after the interrupted ones failed: %v", err)
+	}
+}
+
+func TestWithContextKeepsCallerDeadline(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	go peer.Write([]byte{1})
+	if _, err := ReadFullWithContext(context.Background(), conn, make([]byte, 1)); err != nil {
+		t.Fatalf("ReadFullWithContext: %v", err)
+	}
+
+	// The caller's deadline still applies to the next reads
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("the read past the caller's deadline returned %v, want os.ErrDeadlineExceeded", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the caller's read deadline was cleared")
+	}
+}
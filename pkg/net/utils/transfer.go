@@ -7,8 +7,26 @@ import (
 	"sync"
 )
 
+// Transfer copies the data from src to dst until src ends.
+// It uses io.Copy to efficiently copy the data, neither connection is closed.
+//
+// Parameters:
+//   - dst: The net.Conn where the data is written.
+//   - src: The net.Conn from which the data is read.
+//
+// Returns:
+//   - int64: The number of copied bytes.
+//   - error: The copy error wrapped with errTransfererror, or nil if src ended normally.
+func Transfer(dst, src net.Conn) (int64, error) {
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return n, errors.Join(errTransfererror, err)
+	}
+	return n, nil
+}
+
 // DataTransfering transfers data between two network connections.
-// It uses io.Copy to efficiently copy data from the right connection to the left connection.
+// It copies the data from the right connection to the left connection, see Transfer.
 //
 // Parameters:
 //   - wg: A pointer to a sync.WaitGroup, used to signal when the function has completed.
@@ -21,8 +39,7 @@ import (
 // wrapped with the errTransfererror.
 func DataTransfering(wg *sync.WaitGroup, errChan chan error, left net.Conn, right net.Conn) {
 	defer wg.Done()
-	if _, err := io.Copy(left, right); err != nil {
-		errChan <- errors.Join(errTransfererror, err)
-		return
+	if _, err := Transfer(left, right); err != nil {
+		errChan <- err
 	}
 }
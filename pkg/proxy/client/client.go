@@ -0,0 +1,354 @@
+// Package client provides an embeddable Gordafarid proxy client: it accepts the SOCKS5 connections of the local applications,
+// and relays them to their requested destinations through a Gordafarid server.
+//
+// The behavior is extended through the Hooks (authorization, server transport, traffic accounting, ...),
+// the gordafarid client binary is built on it, adding its config file, access log and metrics.
+//
+// Example usage:
+//
+//	credential := gordafarid.NewDerivedCredential(username, gordafarid.DeriveKeyID(username, password), []byte(password))
+//	dialer := gordafarid.NewDialer(gordafarid.NewDialAccountConfig(credential, initPassword, "aes-256-gcm", "chacha20-poly1305"), nil)
+//	cli, err := client.NewClient(client.Options{Dialer: dialer, ServerAddress: "example.com:9090"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	ln, err := net.Listen("tcp", "127.0.0.1:1080")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go cli.Serve(context.Background(), ln)
+//	...
+//	cli.Shutdown(shutdownCtx)
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/drain"
+	"github.com/Iam54r1n4/Gordafarid/internal/shared_error"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/socks"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+	"github.com/Iam54r1n4/Gordafarid/pkg/proxy"
+)
+
+// Defaults of the Options.
+const (
+	DefaultHandshakeTimeout       = 10 * time.Second // The timeout of dialing the server, including the Gordafarid handshake
+	DefaultSocks5HandshakeTimeout = 10               // The timeout of the SOCKS5 handshakes in seconds, if Options.Socks isn't set
+)
+
+// Options holds the settings of the Client.
+type Options struct {
+	Dialer           *gordafarid.Dialer  // The Gordafarid dialer, with the account and the algorithms (REQUIRED)
+	ServerAddress    string              // The address of the Gordafarid server, host:port (REQUIRED)
+	Socks            *socks.ServerConfig // The SOCKS5 credentials and handshake timeout (OPTIONAL, default: no authentication)
+	HandshakeTimeout time.Duration       // The timeout of dialing the server (OPTIONAL, default: DefaultHandshakeTimeout)
+	SendSessionID    bool                // Send the session IDs to the server, only the servers supporting them accept it (OPTIONAL)
+	Logger           *slog.Logger        // The logger of the client (OPTIONAL, default: slog.Default())
+	Hooks            Hooks               // The extension points of the client (OPTIONAL)
+}
+
+// Client is an embeddable Gordafarid proxy client. It's safe for concurrent use.
+type Client struct {
+	opts Options
+	log  *slog.Logger
+
+	mu        sync.Mutex
+	listeners map[*socks.Listener]struct{} // The listeners being served
+	closed    bool                         // Shutdown or Close is called, no more listeners are served
+
+	drain  drain.Group // Active connections, drained on shutdown
+	dialer *Dialer     // Dials the destinations through the server
+}
+
+// NewClient creates a new Client with the given options.
+//
+// Returns:
+//   - *Client: The client, serving the listeners passed to Serve.
+//   - error: If the options are invalid, e.g. the Dialer is missing.
+func NewClient(opts Options) (*Client, error) {
+	if opts.Dialer == nil {
+		return nil, errMissingDialer
+	}
+	if len(opts.ServerAddress) < 1 {
+		return nil, errMissingServerAddress
+	}
+	if opts.Socks == nil {
+		opts.Socks = socks.NewServerConfig(nil, DefaultSocks5HandshakeTimeout)
+	}
+	if opts.HandshakeTimeout <= 0 {
+		opts.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Client{
+		opts:      opts,
+		log:       log,
+		listeners: make(map[*socks.Listener]struct{}),
+		dialer: &Dialer{
+			Dialer:           opts.Dialer,
+			ServerAddress:    opts.ServerAddress,
+			HandshakeTimeout: opts.HandshakeTimeout,
+			DialServer:       opts.Hooks.DialServer,
+		},
+	}, nil
+}
+
+// Serve accepts the SOCKS5 connections on the listener, and handles each one in its own goroutine.
+// It can be called for several listeners concurrently.
+//
+// The values of ctx are passed on to the contexts of the connections (and so to the hooks).
+// Cancelling ctx stops serving the listener, the active connections are left running until Shutdown or Close.
+//
+// Parameters:
+//   - ctx: The context of the listener.
+//   - ln: The listener of the TCP connections, closed once Serve returns.
+//
+// Returns:
+//   - ErrClientClosed once the client is shut down, ctx's error once it's done, or the listener's error.
+func (c *Client) Serve(ctx context.Context, ln net.Listener) error {
+	sl := socks.NewWrapListener(ln, c.opts.Socks)
+	if !c.addListener(sl) {
+		sl.Close()
+		return ErrClientClosed
+	}
+	defer c.removeListener(sl)
+	defer sl.Close()
+	stop := context.AfterFunc(ctx, func() {
+		sl.Close()
+	})
+	defer stop()
+
+	// The connections outlive the listener, they're only cancelled at the shutdown deadline
+	connCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	context.AfterFunc(c.drain.Context(), cancel)
+
+	for {
+		// Accept incoming SOCKS5 connections, the SOCKS5 handshake is performed automatically
+		conn, err := sl.AcceptConn()
+		if err != nil {
+			// A failed handshake carries the session ID of the connection, the listener is still open
+			var handshakeErr *socks.HandshakeError
+			if errors.As(err, &handshakeErr) {
+				c.log.WarnContext(ctx, errors.Join(shared_error.ErrConnectionAccepting, err).Error(), "session", handshakeErr.SessionID.String())
+				if c.opts.Hooks.OnHandshakeError != nil {
+					c.opts.Hooks.OnHandshakeError(err)
+				}
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				if c.isClosed() {
+					return ErrClientClosed
+				}
+				return err
+			}
+			// The other accept errors (e.g. too many open files) aren't fatal
+			c.log.WarnContext(ctx, errors.Join(shared_error.ErrConnectionAccepting, err).Error())
+			if c.opts.Hooks.OnHandshakeError != nil {
+				c.opts.Hooks.OnHandshakeError(err)
+			}
+			continue
+		}
+		// The client is shutting down, don't handle new connections
+		if !c.drain.Enter() {
+			conn.Close()
+			continue
+		}
+		c.log.InfoContext(ctx, fmt.Sprint("Accepted SOCKS5 connection from:", conn.RemoteAddr()), "session", conn.SessionID().String())
+		go func() {
+			defer c.drain.Leave()
+			c.handleConnection(connCtx, conn)
+		}()
+	}
+}
+
+// Shutdown gracefully shuts down the client.
+// It closes the listeners, so no new connections are accepted (Serve returns ErrClientClosed),
+// waits for the active connections to finish, and closes them once the context is done.
+//
+// Parameters:
+//   - ctx: The deadline of the active connections.
+//
+// Returns:
+//   - The context's error (joined with ErrShutdownDeadlineReached) if the active connections were closed before finishing,
+//     along with the errors of closing the listeners.
+func (c *Client) Shutdown(ctx context.Context) error {
+	errs := []error{c.closeListeners()}
+	if err := c.drain.Drain(ctx); err != nil {
+		errs = append(errs, errors.Join(ErrShutdownDeadlineReached, err))
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the listeners and the active connections right away.
+func (c *Client) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := c.closeListeners()
+	c.drain.Drain(ctx)
+	return err
+}
+
+// Addrs returns the addresses of the listeners being served.
+func (c *Client) Addrs() []net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(c.listeners))
+	for sl := range c.listeners {
+		addrs = append(addrs, sl.Addr())
+	}
+	return addrs
+}
+
+// addListener adds a listener being served, and reports whether it's added (the client isn't closed).
+func (c *Client) addListener(sl *socks.Listener) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.listeners[sl] = struct{}{}
+	return true
+}
+
+// removeListener removes a listener once it's not served anymore.
+func (c *Client) removeListener(sl *socks.Listener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.listeners, sl)
+}
+
+// isClosed reports whether Shutdown or Close is called.
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// closeListeners marks the client as closed, and closes the listeners being served.
+func (c *Client) closeListeners() error {
+	c.mu.Lock()
+	c.closed = true
+	listeners := make([]*socks.Listener, 0, len(c.listeners))
+	for sl := range c.listeners {
+		listeners = append(listeners, sl)
+	}
+	c.mu.Unlock()
+	var errs []error
+	for _, sl := range listeners {
+		if err := sl.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// handleConnection handles a handshaked SOCKS5 connection of a local application.
+//
+// The function performs the following steps:
+// 1. Gets the requested destination from the SOCKS5 handshake result.
+// 2. Calls the Authorize hook, and closes the connection if it's rejected.
+// 3. Dials the server (over the DialServer hook's transport, if any) and performs the Gordafarid handshake.
+// 4. Relays the data between the application and the server (wrapped by the Wrap hook, if any).
+// 5. Calls the OnClose hook with the outcome.
+//
+// Parameters:
+//   - ctx: The context of the connection, cancelled at the shutdown deadline.
+//   - conn: The handshaked SOCKS5 connection of the application.
+func (c *Client) handleConnection(ctx context.Context, conn *socks.Conn) {
+	// Close the SOCKS5 connection when the function returns, it's closed by Shutdown too if it's still open at the deadline
+	defer conn.Close()
+	c.drain.Track(conn)
+	defer c.drain.Untrack(conn)
+
+	// The context carries the session ID through the hooks, and the logger attaches it to every line of the connection
+	ctx = session.NewContext(ctx, conn.SessionID())
+	log := c.log.With("session", conn.SessionID().String(), "client", conn.RemoteAddr().String())
+	if socksUser := conn.Username(); len(socksUser) > 0 {
+		log = log.With("socksUser", socksUser)
+	}
+
+	// Get the SOCKS5 handshake result from the SOCKS5 connection
+	log.DebugContext(ctx, "Getting the SOCKS5 handshake result...")
+	destination, err := conn.GetHandshakeResult()
+	if err != nil {
+		log.ErrorContext(ctx, errors.Join(errUnableToGetSocks5HandshakeResult, err).Error())
+		return
+	}
+	log.DebugContext(ctx, "The SOCKS5 handshake result received")
+
+	req := &Request{Conn: conn, Destination: destination}
+	var result Result
+	hookCtx := ctx
+	if c.opts.Hooks.OnClose != nil {
+		defer func() {
+			c.opts.Hooks.OnClose(hookCtx, req, result)
+		}()
+	}
+
+	// Let the Authorize hook decide about the request
+	if c.opts.Hooks.Authorize != nil {
+		authorizedCtx, err := c.opts.Hooks.Authorize(ctx, req)
+		if authorizedCtx != nil {
+			hookCtx = authorizedCtx
+		}
+		if err != nil {
+			log.WarnContext(ctx, err.Error())
+			result.Err = err
+			return
+		}
+	}
+
+	// Dial the server using the Gordafarid protocol, with a timeout
+	log.DebugContext(ctx, "Dialing to remote server using Gordafarid protocol...")
+	grc, err := c.dialServer(hookCtx, req)
+	if err != nil {
+		result.Err = errors.Join(ErrDialFailed, err)
+		log.WarnContext(ctx, result.Err.Error())
+		return
+	}
+	// Close the server connection when the function returns
+	defer grc.Close()
+	c.drain.Track(grc)
+	defer c.drain.Untrack(grc)
+	result.Server = grc
+	log.DebugContext(ctx, "Connection established with remote server using Gordafarid protocol")
+
+	// Relay the data between the application and the server
+	appConn, serverConn := net.Conn(conn), grc
+	if c.opts.Hooks.Wrap != nil {
+		appConn, serverConn = c.opts.Hooks.Wrap(hookCtx, req, conn, grc)
+		defer appConn.Close()
+		defer serverConn.Close()
+	}
+	log.DebugContext(ctx, fmt.Sprintf("Proxying between %s/%s", conn.RemoteAddr(), grc.RemoteAddr()))
+	result.Upload, result.Download, err = proxy.Relay(appConn, serverConn)
+	// The EOF errors are expected when the connections close
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.ErrorContext(ctx, err.Error())
+		result.Err = errors.Join(proxy.ErrRelayFailed, err)
+	}
+}
+
+// dialServer dials the server and performs the Gordafarid handshake, requesting the destination of the request.
+func (c *Client) dialServer(ctx context.Context, req *Request) (net.Conn, error) {
+	// Send the session ID to the server, so both sides log the connection under the same ID (the older servers reject it)
+	var sessionID session.ID
+	if c.opts.SendSessionID {
+		sessionID = req.Conn.SessionID()
+	}
+	return c.dialer.dial(ctx, &req.Destination, sessionID)
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+)
+
+// Dialer dials the destinations through a Gordafarid server, configured once with the server and the account.
+// Its DialContext has the signature of net.Dialer.DialContext, so it can be plugged into http.Transport
+// and the other libraries taking a dial function. It's safe for concurrent use.
+//
+// Example usage:
+//
+//	d := client.NewDialer(gordafaridDialer, "example.com:9090")
+//	conn, err := d.DialContext(ctx, "tcp", "example.org:443")
+//	...
+//	httpClient := &http.Client{Transport: d.Transport()}
+type Dialer struct {
+	Dialer           *gordafarid.Dialer // The Gordafarid dialer, with the account and the algorithms (REQUIRED)
+	ServerAddress    string             // The address of the Gordafarid server, host:port (REQUIRED)
+	HandshakeTimeout time.Duration      // The timeout of dialing the server, if the context has no earlier deadline (OPTIONAL, default: DefaultHandshakeTimeout)
+	SendSessionID    bool               // Send the session IDs to the server, only the servers supporting them accept it (OPTIONAL)
+
+	// DialServer dials the TCP connection to the server, e.g. through an upstream proxy or over a custom transport (OPTIONAL).
+	// The Gordafarid handshake is performed over the returned connection. If it's nil, the Dialer dials the server.
+	DialServer func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// NewDialer creates a new Dialer tunneling to the destinations through the server.
+//
+// Parameters:
+//   - dialer: The Gordafarid dialer, with the account and the algorithms.
+//   - serverAddress: The address of the Gordafarid server, host:port.
+func NewDialer(dialer *gordafarid.Dialer, serverAddress string) *Dialer {
+	return &Dialer{
+		Dialer:        dialer,
+		ServerAddress: serverAddress,
+	}
+}
+
+// Dial is like DialContext, with the background context.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext dials the destination through the server, and returns the tunnel to it once the server has dialed it.
+// The destination's domain name is resolved by the server.
+//
+// If SendSessionID is set, the session ID of the context (see session.NewContext) is sent to the server, or a new one otherwise.
+//
+// Parameters:
+//   - ctx: The context of the dial, the tunnel isn't affected by it once it's returned.
+//   - network: "tcp", "tcp4" or "tcp6"; the network of the destination is chosen by the server.
+//   - addr: The destination, host:port.
+//
+// Returns:
+//   - net.Conn: The tunnel to the destination, a *gordafarid.Conn.
+//   - error: If the network isn't supported, the address is invalid, or the dial failed;
+//     the server's failure replies can be checked with errors.Is, e.g. gordafarid.ErrReplyNotAllowed.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errors.Join(errUnsupportedNetwork, fmt.Errorf("network: %q", network))
+	}
+	if d.Dialer == nil {
+		return nil, errMissingDialer
+	}
+	if len(d.ServerAddress) < 1 {
+		return nil, errMissingServerAddress
+	}
+	destination, err := protocol.ParseAddressHeader(addr)
+	if err != nil {
+		return nil, err
+	}
+	var sessionID session.ID
+	if d.SendSessionID {
+		var ok bool
+		if sessionID, ok = session.FromContext(ctx); !ok {
+			sessionID = session.NewID()
+		}
+	}
+	return d.dial(ctx, destination, sessionID)
+}
+
+// Transport returns an http.Transport sending its requests through the tunnels of the Dialer,
+// with the settings of http.DefaultTransport otherwise. The environment's proxy settings aren't used.
+//
+// Example usage:
+//
+//	httpClient := &http.Client{Transport: d.Transport()}
+//	resp, err := httpClient.Get("https://example.org/")
+func (d *Dialer) Transport() *http.Transport {
+	var transport *http.Transport
+	if defaultTransport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = defaultTransport.Clone()
+	} else {
+		transport = &http.Transport{}
+	}
+	transport.Proxy = nil
+	transport.DialContext = d.DialContext
+	return transport
+}
+
+// dial dials the server and performs the Gordafarid handshake, requesting the destination.
+// The TCP connection is dialed by DialServer if it's set, by the Gordafarid dialer otherwise.
+// The session ID is sent to the server if it isn't zero.
+func (d *Dialer) dial(ctx context.Context, destination *protocol.AddressHeader, sessionID session.ID) (net.Conn, error) {
+	timeout := d.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialConnConfig := gordafarid.NewDialConnConfig(destination)
+	if !sessionID.IsZero() {
+		dialConnConfig.SetSessionID(sessionID)
+	}
+	if d.DialServer == nil {
+		return d.Dialer.DialContext(ctx, dialConnConfig, d.ServerAddress)
+	}
+	tcpConn, err := d.DialServer(ctx, "tcp", d.ServerAddress)
+	if err != nil {
+		return nil, err
+	}
+	// The connection is closed by WrapTCPContext if the handshake fails
+	return d.Dialer.WrapTCPContext(ctx, dialConnConfig, tcpConn)
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	proxy_server "github.com/Iam54r1n4/Gordafarid/pkg/proxy/server"
+)
+
+const (
+	testAlgorithm    = "chacha20-poly1305"
+	testInitPassword = "0123456789abcdef0123456789abcdef"
+	testUsername     = "alice"
+	testPassword     = "abcdef0123456789abcdef0123456789"
+)
+
+// startEchoServer starts a TCP server echoing the data of its connections, and returns its address.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startProxyServer starts a Gordafarid proxy server in the same process, and returns its address.
+func startProxyServer(t *testing.T, config *gordafarid.ServerConfig) string {
+	t.Helper()
+	server, err := proxy_server.NewServer(proxy_server.Options{Config: config})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go server.Serve(context.Background(), ln)
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+// testDialer returns a Dialer of the test account through the given server.
+func testDialer(serverAddress, password string) *Dialer {
+	accountConfig := gordafarid.NewDialAccountConfig(gordafarid.NewCredential(testUsername, password), testInitPassword, testAlgorithm, testAlgorithm)
+	d := NewDialer(gordafarid.NewDialer(accountConfig, nil), serverAddress)
+	d.HandshakeTimeout = 2 * time.Second
+	return d
+}
+
+// The server and the client share the nonce caches of the process, so they mustn't reject each other's messages.
+func TestDialerThroughServerInProcess(t *testing.T) {
+	echoAddress := startEchoServer(t)
+	serverConfig := gordafarid.NewServerConfig([]gordafarid.Credential{gordafarid.NewCredential(testUsername, testPassword)}, testAlgorithm, testAlgorithm, testInitPassword, 2)
+	serverAddress := startProxyServer(t, serverConfig)
+	d := testDialer(serverAddress, testPassword)
+
+	for i := 0; i < 3; i++ {
+		conn, err := d.Dial("tcp", echoAddress)
+		if err != nil {
+			t.Fatalf("Dial #%d: %v", i, err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		message := []byte("hello through the tunnel")
+		for j := 0; j < 3; j++ {
+			if _, err = conn.Write(message); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			echo := make([]byte, len(message))
+			if _, err = io.ReadFull(conn, echo); err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if string(echo) != string(message) {
+				t.Fatalf("echo = %q, want %q", echo, message)
+			}
+		}
+		conn.Close()
+	}
+}
+
+// closeTrackingConn records whether it's closed.
+type closeTrackingConn struct {
+	net.Conn
+	closed atomic.Bool
+}
+
+func (c *closeTrackingConn) Close() error {
+	c.closed.Store(true)
+	return c.Conn.Close()
+}
+
+func TestDialerClosesServerConnOnFailure(t *testing.T) {
+	echoAddress := startEchoServer(t)
+	credentials := []gordafarid.Credential{gordafarid.NewCredential(testUsername, testPassword)}
+	rejectingServer, err := proxy_server.NewServer(proxy_server.Options{
+		Config: gordafarid.NewServerConfig(credentials, testAlgorithm, testAlgorithm, testInitPassword, 2),
+		Hooks: proxy_server.Hooks{
+			Authorize: func(ctx context.Context, req *proxy_server.Request) (context.Context, error) {
+				return ctx, proxy_server.Reject(gordafarid.ReplyNotAllowed, errors.New("not allowed"))
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go rejectingServer.Serve(context.Background(), ln)
+	defer rejectingServer.Close()
+
+	tests := []struct {
+		name          string
+		serverAddress string
+		password      string
+		wantErr       error
+	}{
+		{"failed authentication", startProxyServer(t, gordafarid.NewServerConfig(credentials, testAlgorithm, testAlgorithm, testInitPassword, 2)), "fedcba9876543210fedcba9876543210", gordafarid.ErrHandshakeFailed},
+		{"failure reply", ln.Addr().String(), testPassword, gordafarid.ErrReplyNotAllowed},
+	}
+	for _, test := range tests {
+		var serverConn *closeTrackingConn
+		d := testDialer(test.serverAddress, test.password)
+		d.DialServer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			serverConn = &closeTrackingConn{Conn: conn}
+			return serverConn, nil
+		}
+		if _, err := d.Dial("tcp", echoAddress); !errors.Is(err, test.wantErr) {
+			t.Fatalf("%s: Dial returned %v, want %v", test.name, err, test.wantErr)
+		}
+		if serverConn == nil || !serverConn.closed.Load() {
+			t.Fatalf("%s: the connection to the server isn't closed", test.name)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/shared_error"
+)
+
+var (
+	ErrClientClosed            = shared_error.ErrClientClosed             // Returned by Serve once the client is shut down by Shutdown or Close
+	ErrShutdownDeadlineReached = shared_error.ErrShutdownDeadlineReached  // Returned by Shutdown once its context is done before the active connections finish
+	ErrDialFailed              = shared_error.ErrClientToServerDialFailed // Joined with the errors of the failed server dials
+)
+
+var (
+	errMissingDialer                    = errors.New("the Gordafarid dialer is required")
+	errMissingServerAddress             = errors.New("the server address is required")
+	errUnableToGetSocks5HandshakeResult = errors.New("failed to get SOCKS5 handshake result")
+	errUnsupportedNetwork               = errors.New("unsupported network, only TCP is tunneled")
+)
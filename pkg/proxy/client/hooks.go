@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/socks"
+)
+
+// Request is a handshaked SOCKS5 connection of a local application, along with its requested destination.
+type Request struct {
+	Conn        *socks.Conn            // The application's connection, see its Username for the authenticated SOCKS5 user
+	Destination protocol.AddressHeader // The requested destination
+}
+
+// Result is the outcome of a request, passed to the OnClose hook.
+type Result struct {
+	Server   net.Conn // The Gordafarid connection to the server, nil if it wasn't dialed
+	Upload   int64    // Bytes relayed from the application to the server
+	Download int64    // Bytes relayed from the server to the application
+	Err      error    // Why the request failed (the rejection, ErrDialFailed or proxy.ErrRelayFailed), nil if the relay ended normally
+}
+
+// Hooks are the extension points of the client, all of them are optional.
+// They're called concurrently for the different connections, so they must be safe for concurrent use.
+type Hooks struct {
+	// OnHandshakeError is called with the error of each failed SOCKS5 handshake (a *socks.HandshakeError, or an accept error).
+	// The error is logged by the client too.
+	OnHandshakeError func(err error)
+
+	// Authorize decides about the request before the server is dialed, e.g. by the SOCKS5 user or the destination.
+	// The SOCKS5 reply is sent by the handshake already, so a returned error just closes the connection.
+	// The returned context (if not nil) is passed to the later hooks of the request, even if it's rejected,
+	// so the hooks can keep the state of the request in it.
+	Authorize func(ctx context.Context, req *Request) (context.Context, error)
+
+	// DialServer dials the TCP connection to the server, e.g. through an upstream proxy or over a custom transport.
+	// The Gordafarid handshake is performed over the returned connection. If it's nil, the Dialer dials the server.
+	DialServer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Wrap wraps the application's and the server's connections once the server is dialed, e.g. to count their traffic.
+	// The returned connections are relayed, and closed once the relay ends.
+	Wrap func(ctx context.Context, req *Request, app, server net.Conn) (net.Conn, net.Conn)
+
+	// OnClose is called once the authorized (or rejected) request ends, with its outcome, e.g. to write an access log.
+	OnClose func(ctx context.Context, req *Request, result Result)
+}
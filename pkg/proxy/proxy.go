@@ -0,0 +1,40 @@
+// Package proxy provides the parts shared by the embeddable Gordafarid server and client,
+// see the pkg/proxy/server and pkg/proxy/client packages.
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/utils"
+)
+
+// ErrRelayFailed is joined with the errors of the relays ended by a read or write error, rather than by the end of the streams.
+var ErrRelayFailed = errors.New("failed to relay the data")
+
+// Relay copies the data between the two connections in both directions, until both streams end, see utils.Transfer.
+// Neither connection is closed, the caller closes them once it returns; closing both (e.g. to kill the relay) ends it.
+//
+// Parameters:
+//   - a, b: The connections to relay, e.g. the client's connection and the destination's one.
+//
+// Returns:
+//   - fromA: The bytes read from a and written to b.
+//   - fromB: The bytes read from b and written to a.
+//   - error: The copy errors of both directions joined, or nil if both streams ended normally.
+func Relay(a, b net.Conn) (fromA, fromB int64, err error) {
+	var wg sync.WaitGroup
+	var errA, errB error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		fromA, errA = utils.Transfer(b, a)
+	}()
+	go func() {
+		defer wg.Done()
+		fromB, errB = utils.Transfer(a, b)
+	}()
+	wg.Wait()
+	return fromA, fromB, errors.Join(errA, errB)
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/shared_error"
+)
+
+var (
+	ErrServerClosed            = shared_error.ErrServerClosed            // Returned by Serve once the server is shut down by Shutdown or Close
+	ErrShutdownDeadlineReached = shared_error.ErrShutdownDeadlineReached // Returned by Shutdown once its context is done before the active connections finish
+	ErrDialFailed              = shared_error.ErrServerDialFailed        // Joined with the errors of the failed destination dials
+	ErrReplyFailed             = errors.New("failed to send the Gordafarid reply")
+)
+
+var (
+	errMissingServerConfig                  = errors.New("the Gordafarid server config is required")
+	errUnableToGetGordafaridHandshakeResult = errors.New("failed to get Gordafarid handshake result")
+)
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+)
+
+// Request is a handshaked connection of a client, along with its requested destination.
+type Request struct {
+	Conn        *gordafarid.Conn       // The client's connection, see its ConnectionState for the authenticated account
+	Destination protocol.AddressHeader // The requested destination
+}
+
+// Result is the outcome of a request, passed to the OnClose hook.
+type Result struct {
+	Reply    byte     // The reply sent to the client, one of the gordafarid.Reply* values
+	Target   net.Addr // The address of the dialed destination, nil if it wasn't dialed
+	Upload   int64    // Bytes relayed from the client to the destination
+	Download int64    // Bytes relayed from the destination to the client
+	Err      error    // Why the request failed (the rejection, ErrDialFailed, ErrReplyFailed or proxy.ErrRelayFailed), nil if the relay ended normally
+}
+
+// Hooks are the extension points of the server, all of them are optional.
+// They're called concurrently for the different connections, so they must be safe for concurrent use.
+type Hooks struct {
+	// OnHandshakeError is called with the error of each failed handshake (a *gordafarid.HandshakeError, or an accept error),
+	// e.g. to ban the client IPs probing the server. The error is logged by the server too.
+	OnHandshakeError func(err error)
+
+	// Authorize decides about the request before its destination is dialed, e.g. by the account's policy or limits.
+	// The clients are authenticated by the credential stores of the gordafarid.ServerConfig, before Authorize is called.
+	// A returned error rejects the request with the reply of the error (see Reject), ReplyFailed by default.
+	// The returned context (if not nil) is passed to the later hooks of the request, even if it's rejected,
+	// so the hooks can keep the state of the request in it.
+	Authorize func(ctx context.Context, req *Request) (context.Context, error)
+
+	// Dial dials the destination of the request, e.g. through an upstream proxy or with a custom resolver.
+	// The context carries the dial timeout. A returned *RejectError rejects the request with its reply,
+	// the other errors are joined with ErrDialFailed. If it's nil, the destination is dialed over TCP.
+	Dial func(ctx context.Context, req *Request) (net.Conn, error)
+
+	// Wrap wraps the client's and the destination's connections once the destination is dialed and the client is replied,
+	// e.g. to count or limit their traffic. The returned connections are relayed, and closed once the relay ends.
+	Wrap func(ctx context.Context, req *Request, client, target net.Conn) (net.Conn, net.Conn)
+
+	// OnClose is called once the authorized (or rejected) request ends, with its outcome, e.g. to write an access log.
+	OnClose func(ctx context.Context, req *Request, result Result)
+}
+
+// RejectError is an error rejecting a request with a specific reply, returned by the Authorize and Dial hooks.
+type RejectError struct {
+	Reply byte  // The reply sent to the client, one of the gordafarid.Reply* failure values
+	Err   error // Why the request is rejected
+}
+
+// Reject returns a *RejectError rejecting the request with the reply, because of err.
+//
+// Example usage:
+//
+//	if !allowed {
+//		return ctx, server.Reject(gordafarid.ReplyNotAllowed, errDestinationNotAllowed)
+//	}
+func Reject(reply byte, err error) error {
+	return &RejectError{Reply: reply, Err: err}
+}
+
+// Error implements the error interface, it's the rejection's reason.
+func (e *RejectError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the rejection's reason.
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// replyOf returns the reply of the request rejected by the error.
+func replyOf(err error) byte {
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		return rejectErr.Reply
+	}
+	return gordafarid.ReplyFailed
+}
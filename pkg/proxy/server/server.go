@@ -0,0 +1,381 @@
+// Package server provides an embeddable Gordafarid proxy server: it accepts the Gordafarid connections of the clients,
+// and relays them to their requested destinations.
+//
+// The behavior is extended through the Hooks (authorization, dialing, traffic accounting, ...),
+// the gordafarid server binary is built on it, adding its config file, policies, quotas, admin API and metrics.
+//
+// Example usage:
+//
+//	config := gordafarid.NewServerConfig(credentials, "chacha20-poly1305", "aes-256-gcm", initPassword, 10)
+//	srv, err := server.NewServer(server.Options{Config: config})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	ln, err := net.Listen("tcp", ":9090")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go srv.Serve(context.Background(), ln)
+//	...
+//	srv.Shutdown(shutdownCtx)
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Iam54r1n4/Gordafarid/internal/drain"
+	"github.com/Iam54r1n4/Gordafarid/internal/shared_error"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/protocol/gordafarid"
+	"github.com/Iam54r1n4/Gordafarid/pkg/net/session"
+	"github.com/Iam54r1n4/Gordafarid/pkg/proxy"
+)
+
+// DefaultDialTimeout is the timeout of dialing the destinations, if Options.DialTimeout isn't set.
+const DefaultDialTimeout = 10 * time.Second
+
+// Options holds the settings of the Server.
+type Options struct {
+	Config      *gordafarid.ServerConfig // The credentials, init keys, algorithms and handshake timeout (REQUIRED)
+	DialTimeout time.Duration            // The timeout of dialing the destinations (OPTIONAL, default: DefaultDialTimeout)
+	Logger      *slog.Logger             // The logger of the server (OPTIONAL, default: slog.Default())
+	Hooks       Hooks                    // The extension points of the server (OPTIONAL)
+}
+
+// Server is an embeddable Gordafarid proxy server. It's safe for concurrent use.
+type Server struct {
+	opts Options
+	log  *slog.Logger
+
+	mu        sync.Mutex
+	listeners map[*gordafarid.Listener]struct{} // The listeners being served
+	closed    bool                              // Shutdown or Close is called, no more listeners are served
+
+	drain drain.Group // Active connections, drained on shutdown
+}
+
+// NewServer creates a new Server with the given options.
+//
+// Returns:
+//   - *Server: The server, serving the listeners passed to Serve.
+//   - error: If the options are invalid, e.g. the Config is missing.
+func NewServer(opts Options) (*Server, error) {
+	if opts.Config == nil {
+		return nil, errMissingServerConfig
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Server{
+		opts:      opts,
+		log:       log,
+		listeners: make(map[*gordafarid.Listener]struct{}),
+	}, nil
+}
+
+// Serve accepts the Gordafarid connections on the listener, and handles each one in its own goroutine.
+// It can be called for several listeners concurrently.
+//
+// The values of ctx are passed on to the contexts of the connections (and so to the hooks).
+// Cancelling ctx stops serving the listener, the active connections are left running until Shutdown or Close.
+//
+// Parameters:
+//   - ctx: The context of the listener.
+//   - ln: The listener of the TCP connections, closed once Serve returns.
+//
+// Returns:
+//   - ErrServerClosed once the server is shut down, ctx's error once it's done, or the listener's error.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	gl := gordafarid.NewListener(ln, s.opts.Config)
+	if !s.addListener(gl) {
+		gl.Close()
+		return ErrServerClosed
+	}
+	defer s.removeListener(gl)
+	defer gl.Close()
+	stop := context.AfterFunc(ctx, func() {
+		gl.Close()
+	})
+	defer stop()
+
+	// The connections outlive the listener, they're only cancelled at the shutdown deadline
+	connCtx := connContext{Context: context.WithoutCancel(ctx), drain: s.drain.Context()}
+
+	for {
+		conn, err := gl.AcceptConn()
+		if err != nil {
+			var handshakeErr *gordafarid.HandshakeError
+			if errors.As(err, &handshakeErr) {
+				// Attach the session ID of the failed handshake, so it can be correlated with the client's logs
+				s.log.WarnContext(ctx, errors.Join(shared_error.ErrConnectionAccepting, err).Error(), "session", handshakeErr.SessionID.String())
+				if s.opts.Hooks.OnHandshakeError != nil {
+					s.opts.Hooks.OnHandshakeError(err)
+				}
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				if s.isClosed() {
+					return ErrServerClosed
+				}
+				return err
+			}
+			// The other accept errors (e.g. too many open files, the handshakes limit) aren't fatal
+			s.log.WarnContext(ctx, errors.Join(shared_error.ErrConnectionAccepting, err).Error())
+			if s.opts.Hooks.OnHandshakeError != nil {
+				s.opts.Hooks.OnHandshakeError(err)
+			}
+			continue
+		}
+		// The server is shutting down, don't handle new connections
+		if !s.drain.Enter() {
+			conn.Close()
+			continue
+		}
+		s.log.InfoContext(ctx, fmt.Sprint("Accepted connection from:", conn.RemoteAddr()), "session", conn.SessionID().String())
+		go func() {
+			defer s.drain.Leave()
+			s.handleConnection(connCtx, conn)
+		}()
+	}
+}
+
+// Shutdown gracefully shuts down the server.
+// It closes the listeners, so no new connections are accepted (Serve returns ErrServerClosed),
+// waits for the active connections to finish, and closes them once the context is done.
+//
+// Parameters:
+//   - ctx: The deadline of the active connections.
+//
+// Returns:
+//   - The context's error (joined with ErrShutdownDeadlineReached) if the active connections were closed before finishing,
+//     along with the errors of closing the listeners.
+func (s *Server) Shutdown(ctx context.Context) error {
+	errs := []error{s.closeListeners()}
+	if err := s.drain.Drain(ctx); err != nil {
+		errs = append(errs, errors.Join(ErrShutdownDeadlineReached, err))
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the listeners and the active connections right away.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := s.closeListeners()
+	s.drain.Drain(ctx)
+	return err
+}
+
+// Addrs returns the addresses of the listeners being served, e.g. to avoid dialing them in the Dial hook.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for gl := range s.listeners {
+		addrs = append(addrs, gl.Addr())
+	}
+	return addrs
+}
+
+// addListener adds a listener being served, and reports whether it's added (the server isn't closed).
+func (s *Server) addListener(gl *gordafarid.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[gl] = struct{}{}
+	return true
+}
+
+// removeListener removes a listener once it's not served anymore.
+func (s *Server) removeListener(gl *gordafarid.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, gl)
+}
+
+// isClosed reports whether Shutdown or Close is called.
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// closeListeners marks the server as closed, and closes the listeners being served.
+func (s *Server) closeListeners() error {
+	s.mu.Lock()
+	s.closed = true
+	listeners := make([]*gordafarid.Listener, 0, len(s.listeners))
+	for gl := range s.listeners {
+		listeners = append(listeners, gl)
+	}
+	s.mu.Unlock()
+	var errs []error
+	for _, gl := range listeners {
+		if err := gl.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// connContext is the context of the connections: it carries the values of Serve's context,
+// and it's cancelled along with the drain at the shutdown deadline, before the connections are closed,
+// so the hooks of the relays ended by the shutdown see it cancelled.
+type connContext struct {
+	context.Context                 // Serve's context without its cancellation, for the values
+	drain           context.Context // The drain's context, cancelled at the shutdown deadline
+}
+
+func (c connContext) Deadline() (time.Time, bool) {
+	return c.drain.Deadline()
+}
+
+func (c connContext) Done() <-chan struct{} {
+	return c.drain.Done()
+}
+
+func (c connContext) Err() error {
+	return c.drain.Err()
+}
+
+// handleConnection handles a handshaked connection of a client.
+//
+// The function performs the following steps:
+// 1. Gets the requested destination from the handshake result.
+// 2. Calls the Authorize hook, and replies the rejection to the client if it's rejected.
+// 3. Dials the destination (through the Dial hook, if any), and replies the result to the client.
+// 4. Relays the data between the client and the destination (wrapped by the Wrap hook, if any).
+// 5. Calls the OnClose hook with the outcome.
+//
+// Parameters:
+//   - ctx: The context of the connection, cancelled at the shutdown deadline.
+//   - gc: The handshaked connection of the client.
+func (s *Server) handleConnection(ctx context.Context, gc *gordafarid.Conn) {
+	// Close the Gordafarid connection when the function returns, it's closed by Shutdown too if it's still open at the deadline
+	defer gc.Close()
+	s.drain.Track(gc)
+	defer s.drain.Untrack(gc)
+
+	// The context carries the session ID through the hooks, and the logger attaches it to every line of the connection
+	ctx = session.NewContext(ctx, gc.SessionID())
+	log := s.log.With("session", gc.SessionID().String(), "user", gc.Username(), "client", gc.RemoteAddr().String())
+	if clientSession := gc.ClientSessionID(); !clientSession.IsZero() {
+		log = log.With("clientSession", clientSession.String())
+	}
+
+	// Get the handshake result from the Gordafarid connection
+	log.DebugContext(ctx, "Getting the Gordafarid handshake result...")
+	destination, err := gc.GetHandshakeResult()
+	if err != nil {
+		log.ErrorContext(ctx, errors.Join(errUnableToGetGordafaridHandshakeResult, err).Error())
+		return
+	}
+	state := gc.ConnectionState()
+	log.DebugContext(ctx, fmt.Sprintf("The Gordafarid handshake result received, handshake: %s, cipher: %s, init key: %s", state.HandshakeDuration, state.Cipher, state.InitKeyID))
+
+	req := &Request{Conn: gc, Destination: destination}
+	result := Result{Reply: gordafarid.ReplyFailed}
+	hookCtx := ctx
+	if s.opts.Hooks.OnClose != nil {
+		defer func() {
+			s.opts.Hooks.OnClose(hookCtx, req, result)
+		}()
+	}
+
+	// Let the Authorize hook decide about the request
+	if s.opts.Hooks.Authorize != nil {
+		authorizedCtx, err := s.opts.Hooks.Authorize(ctx, req)
+		if authorizedCtx != nil {
+			hookCtx = authorizedCtx
+		}
+		if err != nil {
+			log.WarnContext(ctx, err.Error())
+			result.Reply, result.Err = replyOf(err), err
+			s.sendFailureReply(ctx, log, gc, result.Reply)
+			return
+		}
+	}
+
+	// Establish a connection to the destination with a timeout
+	log.DebugContext(ctx, fmt.Sprint("Connecting to: ", destination.Host()))
+	dialCtx, cancel := context.WithTimeout(hookCtx, s.opts.DialTimeout)
+	defer cancel()
+	tconn, err := s.dial(dialCtx, req)
+	if err != nil {
+		var rejectErr *RejectError
+		if !errors.As(err, &rejectErr) {
+			err = errors.Join(ErrDialFailed, err)
+		}
+		log.WarnContext(ctx, err.Error())
+		result.Reply, result.Err = replyOf(err), err
+		s.sendFailureReply(ctx, log, gc, result.Reply)
+		return
+	}
+	// Close the destination connection when the function returns
+	defer tconn.Close()
+	s.drain.Track(tconn)
+	defer s.drain.Untrack(tconn)
+	result.Target = tconn.RemoteAddr()
+
+	// Log the destination address, handling domain names separately
+	if destination.Atyp == protocol.AtypDomain {
+		log.DebugContext(ctx, fmt.Sprintf("Connected to: %s(%s)", destination.Host(), tconn.RemoteAddr()))
+	} else {
+		log.DebugContext(ctx, fmt.Sprint("Connected to: ", tconn.RemoteAddr()))
+	}
+
+	// Tell the client the request succeeded
+	result.Reply = gordafarid.ReplySuccess
+	if err = gc.SendReply(gordafarid.ReplySuccess); err != nil {
+		result.Err = errors.Join(ErrReplyFailed, err)
+		log.WarnContext(ctx, result.Err.Error())
+		return
+	}
+
+	// Relay the data between the client and the destination
+	clientConn, targetConn := net.Conn(gc), tconn
+	if s.opts.Hooks.Wrap != nil {
+		clientConn, targetConn = s.opts.Hooks.Wrap(hookCtx, req, gc, tconn)
+		defer clientConn.Close()
+		defer targetConn.Close()
+	}
+	log.DebugContext(ctx, fmt.Sprintf("Proxying between %s/%s", gc.RemoteAddr(), tconn.RemoteAddr()))
+	result.Upload, result.Download, err = proxy.Relay(clientConn, targetConn)
+	// The EOF errors are expected when the connections close
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.ErrorContext(ctx, err.Error())
+		result.Err = errors.Join(proxy.ErrRelayFailed, err)
+	}
+}
+
+// dial dials the destination of the request, through the Dial hook if any, over TCP otherwise.
+func (s *Server) dial(ctx context.Context, req *Request) (net.Conn, error) {
+	if s.opts.Hooks.Dial != nil {
+		return s.opts.Hooks.Dial(ctx, req)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", req.Destination.String())
+}
+
+// sendFailureReply sends a failure reply to the client, logging the error if it couldn't be sent.
+func (s *Server) sendFailureReply(ctx context.Context, log *slog.Logger, gc *gordafarid.Conn, reply byte) {
+	if err := gc.SendReply(reply); err != nil {
+		log.DebugContext(ctx, errors.Join(ErrReplyFailed, err).Error())
+	}
+}